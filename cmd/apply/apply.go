@@ -0,0 +1,105 @@
+package apply
+
+import (
+	"arlon.io/arlon/pkg/argocd"
+	"arlon.io/arlon/pkg/cliutil"
+	"arlon.io/arlon/pkg/fleet"
+	"arlon.io/arlon/pkg/gitutils"
+	"fmt"
+	"github.com/argoproj/argo-cd/v2/util/cli"
+	"github.com/spf13/cobra"
+	"io"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"os"
+	"text/tabwriter"
+)
+
+func NewCommand() *cobra.Command {
+	var clientConfig clientcmd.ClientConfig
+	var argocdNs string
+	var arlonNs string
+	var fileName string
+	var prune bool
+	var dryRun bool
+	var maxParallel int
+	command := &cobra.Command{
+		Use:   "apply",
+		Short: "Bring the fleet to the state declared by a manifest",
+		Long: "Deploy the clusters declared by a fleet manifest that don't exist " +
+			"yet, update those whose git tree or root application differ from it " +
+			"and, with --prune, delete the clusters it doesn't declare.",
+		DisableAutoGenTag: true,
+		Args:              cobra.NoArgs,
+		RunE: func(c *cobra.Command, args []string) error {
+			ctx, cancel := cliutil.Context(c)
+			defer cancel()
+			var r io.Reader = os.Stdin
+			if fileName != "-" {
+				f, err := os.Open(fileName)
+				if err != nil {
+					return fmt.Errorf("failed to open fleet manifest: %w", err)
+				}
+				defer f.Close()
+				r = f
+			}
+			manifest, err := fleet.ReadManifest(r)
+			if err != nil {
+				return err
+			}
+			config, err := clientConfig.ClientConfig()
+			if err != nil {
+				return fmt.Errorf("failed to get k8s client config: %w", err)
+			}
+			kubeClient := kubernetes.NewForConfigOrDie(config)
+			ctx = cliutil.WithKubeIdentity(ctx, config, kubeClient)
+			argocdClient := argocd.NewArgocdClientOrDie()
+			conn, appIf := argocdClient.NewApplicationClientOrDie()
+			defer conn.Close()
+			projConn, projIf := argocdClient.NewProjectClientOrDie()
+			defer projConn.Close()
+			actions, err := fleet.Plan(ctx, kubeClient, gitutils.NewRepo, appIf, projIf, argocdNs, arlonNs, manifest, prune)
+			if err != nil {
+				return fmt.Errorf("failed to plan fleet changes: %w", err)
+			}
+			for _, action := range actions {
+				fmt.Println(action.String())
+			}
+			if dryRun {
+				return nil
+			}
+			results := fleet.Apply(ctx, kubeClient, gitutils.NewRepo, appIf, argocdNs, arlonNs, actions, maxParallel)
+			return printResults(results)
+		},
+	}
+	clientConfig = cli.AddKubectlFlagsToCmd(command)
+	command.Flags().StringVar(&argocdNs, "argocd-ns", "argocd", "the argocd namespace")
+	command.Flags().StringVar(&arlonNs, "arlon-ns", "arlon", "the arlon namespace")
+	command.Flags().StringVarP(&fileName, "file", "f", "", "the fleet manifest, - for stdin")
+	command.Flags().BoolVar(&prune, "prune", false, "delete deployed clusters missing from the manifest")
+	command.Flags().BoolVar(&dryRun, "dry-run", false, "only print the planned changes")
+	command.Flags().IntVar(&maxParallel, "max-parallel", 4, "the maximum number of repository branches deployed to concurrently")
+	command.MarkFlagRequired("file")
+	return command
+}
+
+// printResults prints the outcome of each applied action and fails if any
+// of them failed.
+func printResults(results []fleet.Result) error {
+	failed := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintf(w, "CLUSTER\tOPERATION\tCOMMIT\tRESULT\n")
+	for _, result := range results {
+		outcome := "ok"
+		if result.Err != nil {
+			outcome = result.Err.Error()
+			failed++
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", result.Cluster, result.Op, result.CommitSha, outcome)
+	}
+	_ = w.Flush()
+	if failed > 0 {
+		return fmt.Errorf("%d of %d cluster operations failed", failed, len(results))
+	}
+	return nil
+}
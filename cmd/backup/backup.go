@@ -0,0 +1,101 @@
+package backup
+
+import (
+	"arlon.io/arlon/pkg/backup"
+	"arlon.io/arlon/pkg/cliutil"
+	"fmt"
+	"github.com/argoproj/argo-cd/v2/util/cli"
+	"github.com/spf13/cobra"
+	"io"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"os"
+)
+
+func NewExportCommand() *cobra.Command {
+	var clientConfig clientcmd.ClientConfig
+	var ns string
+	var outFile string
+	command := &cobra.Command{
+		Use:   "export",
+		Short: "Back up bundles, profiles and clusterspecs",
+		Long: "Write the bundles, profiles and clusterspecs of the management cluster, " +
+			"with their labels and annotations, to a gzipped tar archive that " +
+			"arlon import restores, for e.g. to recover or migrate the management cluster.",
+		DisableAutoGenTag: true,
+		Args:              cobra.NoArgs,
+		RunE: func(c *cobra.Command, args []string) error {
+			ctx, cancel := cliutil.Context(c)
+			defer cancel()
+			config, err := clientConfig.ClientConfig()
+			if err != nil {
+				return fmt.Errorf("failed to get k8s client config: %w", err)
+			}
+			kubeClient := kubernetes.NewForConfigOrDie(config)
+			var w io.Writer = os.Stdout
+			if outFile != "-" {
+				f, err := os.OpenFile(outFile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+				if err != nil {
+					return fmt.Errorf("failed to create archive: %w", err)
+				}
+				defer f.Close()
+				w = f
+			}
+			count, err := backup.Export(ctx, kubeClient.CoreV1(), ns, w)
+			if err != nil {
+				return fmt.Errorf("failed to export: %w", err)
+			}
+			fmt.Fprintf(os.Stderr, "exported %d resources\n", count)
+			return nil
+		},
+	}
+	clientConfig = cli.AddKubectlFlagsToCmd(command)
+	command.Flags().StringVar(&ns, "ns", "", "only export the resources of this namespace, instead of all namespaces")
+	// an unset --ns exports all namespaces, whatever the context
+	command.Flags().SetAnnotation("ns", cliutil.NoContextDefaultAnnotation, []string{"true"})
+	command.Flags().StringVar(&outFile, "out", "", "the archive to write, - for stdout")
+	command.MarkFlagRequired("out")
+	return command
+}
+
+func NewImportCommand() *cobra.Command {
+	var clientConfig clientcmd.ClientConfig
+	var overwrite bool
+	command := &cobra.Command{
+		Use:   "import <archive>",
+		Short: "Restore bundles, profiles and clusterspecs",
+		Long: "Restore the bundles, profiles and clusterspecs of an archive written by " +
+			"arlon export, creating their namespaces if needed. Existing resources are " +
+			"left alone unless --overwrite is given.",
+		DisableAutoGenTag: true,
+		Args:              cobra.ExactArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			ctx, cancel := cliutil.Context(c)
+			defer cancel()
+			config, err := clientConfig.ClientConfig()
+			if err != nil {
+				return fmt.Errorf("failed to get k8s client config: %w", err)
+			}
+			kubeClient := kubernetes.NewForConfigOrDie(config)
+			var r io.Reader = os.Stdin
+			if args[0] != "-" {
+				f, err := os.Open(args[0])
+				if err != nil {
+					return fmt.Errorf("failed to open archive: %w", err)
+				}
+				defer f.Close()
+				r = f
+			}
+			result, err := backup.Import(ctx, kubeClient.CoreV1(), r, overwrite)
+			if err != nil {
+				return fmt.Errorf("failed to import: %w", err)
+			}
+			fmt.Printf("created %d, updated %d, skipped %d existing resources\n",
+				result.Created, result.Updated, result.Skipped)
+			return nil
+		},
+	}
+	clientConfig = cli.AddKubectlFlagsToCmd(command)
+	command.Flags().BoolVar(&overwrite, "overwrite", false, "replace existing resources")
+	return command
+}
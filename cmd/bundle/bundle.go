@@ -15,6 +15,11 @@ func NewCommand() *cobra.Command {
 	command.AddCommand(dumpBundleCommand())
 	command.AddCommand(createBundleCommand())
 	command.AddCommand(deleteBundleCommand())
+	command.AddCommand(verifyBundleCommand())
+	command.AddCommand(importAppCommand())
+	command.AddCommand(diffBundleCommand())
+	command.AddCommand(exportBundleCommand())
+	command.AddCommand(whereUsedCommand())
 	return command
 }
 
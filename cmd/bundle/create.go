@@ -1,6 +1,8 @@
 package bundle
 
 import (
+	bundlepkg "arlon.io/arlon/pkg/bundle"
+	"arlon.io/arlon/pkg/cliutil"
 	"context"
 	"fmt"
 	"github.com/spf13/cobra"
@@ -21,19 +23,28 @@ func createBundleCommand() *cobra.Command {
 	var fromFile string
 	var repoUrl string
 	var repoPath string
+	var repoRevision string
+	var chart string
 	var desc string
 	var tags string
+	var bundleLabels map[string]string
+	var sigFile string
+	var compress bool
+	var sync syncOptions
+	var validate validateOptions
 	command := &cobra.Command{
 		Use:               "create",
 		Short:             "Create configuration bundle",
 		Long:              "Create configuration bundle",
 		Args: cobra.ExactArgs(1),
 		RunE: func(c *cobra.Command, args []string) error {
+			ctx, cancel := cliutil.Context(c)
+			defer cancel()
 			config, err := clientConfig.ClientConfig()
 			if err != nil {
-				return fmt.Errorf("failed to get k8s client config: %s", err)
+				return fmt.Errorf("failed to get k8s client config: %w", err)
 			}
-			return createBundle(config, ns, args[0], fromFile, repoUrl, repoPath, desc, tags)
+			return createBundle(ctx, config, ns, args[0], fromFile, repoUrl, repoPath, repoRevision, chart, desc, tags, bundleLabels, sigFile, compress, &sync, &validate)
 		},
 	}
 	clientConfig = cli.AddKubectlFlagsToCmd(command)
@@ -41,22 +52,32 @@ func createBundleCommand() *cobra.Command {
 	command.Flags().StringVar(&fromFile, "from-file", "", "create inline bundle from this file")
 	command.Flags().StringVar(&repoUrl, "from-repo", "", "create a reference bundle from this repo URL")
 	command.Flags().StringVar(&repoPath, "repo-path", "", "optional path in repo specified by --from-repo")
+	command.Flags().StringVar(&repoRevision, "repo-revision", "", "optional git revision, or chart version if --chart is specified")
+	command.Flags().StringVar(&chart, "chart", "", "create a reference to this chart in the Helm repo or OCI registry (oci://...) specified by --from-repo")
 	command.Flags().StringVar(&desc, "desc", "", "description")
 	command.Flags().StringVar(&tags, "tags", "", "comma separated list of tags")
+	command.Flags().StringToStringVar(&bundleLabels, "labels", nil, "labels classifying the bundle for selectors, for e.g. env=prod,tier=networking")
+	command.Flags().BoolVar(&compress, "compress", false, "compress the --from-file data with gzip (always done when it doesn't fit in a secret)")
+	command.Flags().StringVar(&sigFile, "signature", "", "signature of the --from-file data, as produced by cosign sign-blob")
+	addSyncFlags(command, &sync)
+	addValidateFlags(command, &validate)
 	return command
 }
 
 
-func createBundle(config *restclient.Config, ns string, bundleName string, fromFile string, repoUrl string, repoPath string, desc string, tags string) error {
+func createBundle(ctx context.Context, config *restclient.Config, ns string, bundleName string, fromFile string, repoUrl string, repoPath string, repoRevision string, chart string, desc string, tags string, bundleLabels map[string]string, sigFile string, compress bool, sync *syncOptions, validate *validateOptions) error {
 	kubeClient := kubernetes.NewForConfigOrDie(config)
 	corev1 := kubeClient.CoreV1()
 	secretsApi := corev1.Secrets(ns)
-	_, err := secretsApi.Get(context.Background(), bundleName, metav1.GetOptions{})
+	_, err := secretsApi.Get(ctx, bundleName, metav1.GetOptions{})
 	if err == nil {
 		return fmt.Errorf("a bundle with that name already exists")
 	}
 	if !apierr.IsNotFound(err) {
-		return fmt.Errorf("failed to check for existence of bundle: %s", err)
+		return fmt.Errorf("failed to check for existence of bundle: %w", err)
+	}
+	if err := bundlepkg.CheckLabels(bundleLabels); err != nil {
+		return err
 	}
 	secr := v1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
@@ -72,23 +93,73 @@ func createBundle(config *restclient.Config, ns string, bundleName string, fromF
 			"tags": []byte(tags),
 		},
 	}
+	for key, val := range bundleLabels {
+		secr.Labels[key] = val
+	}
+	if err := sync.apply(&secr); err != nil {
+		return err
+	}
+	var chunks []*v1.Secret
 	if fromFile != "" {
 		data, err := os.ReadFile(fromFile)
 		if err != nil {
-			return fmt.Errorf("failed to read file: %s", err)
+			return fmt.Errorf("failed to read file: %w", err)
+		}
+		// bundle manifests without a namespace are deployed to the default one
+		err = validateData(ctx, config, validate, data, "default", os.Stderr)
+		if err != nil {
+			return err
 		}
 		secr.Labels["bundle-type"] = "inline"
-		secr.Data["data"] = data
+		chunks, err = bundlepkg.Pack(&secr, data, compress)
+		if err != nil {
+			return err
+		}
+		if sigFile != "" {
+			sig, err := os.ReadFile(sigFile)
+			if err != nil {
+				return fmt.Errorf("failed to read signature file: %w", err)
+			}
+			secr.Data[bundlepkg.SignatureKey] = sig
+		}
 	} else if repoUrl != "" {
 		secr.Labels["bundle-type"] = "reference"
 		secr.ObjectMeta.Annotations["repo-url"] = repoUrl
 		secr.ObjectMeta.Annotations["repo-path"] = repoPath
+		secr.ObjectMeta.Annotations["repo-revision"] = repoRevision
+		if bundlepkg.IsOCI(repoUrl) && chart == "" {
+			return fmt.Errorf("a chart must be specified with --chart for an OCI registry")
+		}
+		if chart != "" {
+			if repoRevision == "" {
+				return fmt.Errorf("a chart version must be specified with --repo-revision")
+			}
+			secr.ObjectMeta.Annotations["repo-chart"] = chart
+		}
 	} else {
 		return fmt.Errorf("the bundle must be created from a file or repo URL")
 	}
-	_, err = secretsApi.Create(context.Background(), &secr, metav1.CreateOptions{})
+	created, err := secretsApi.Create(ctx, &secr, metav1.CreateOptions{})
 	if err != nil {
-		return fmt.Errorf("failed to create secret: %s", err)
+		return fmt.Errorf("failed to create secret: %w", err)
+	}
+	// chunks are garbage collected along with the bundle
+	for _, chunk := range chunks {
+		chunk.OwnerReferences = []metav1.OwnerReference{{
+			APIVersion: "v1",
+			Kind:       "Secret",
+			Name:       created.Name,
+			UID:        created.UID,
+		}}
+		_, err = secretsApi.Create(ctx, chunk, metav1.CreateOptions{})
+		if err != nil {
+			// a bundle missing chunks is unusable, so don't leave it behind
+			if delErr := secretsApi.Delete(ctx, created.Name, metav1.DeleteOptions{}); delErr != nil {
+				return fmt.Errorf("failed to create bundle chunk %s: %w (and failed to delete bundle %s: %s)",
+					chunk.Name, err, created.Name, delErr)
+			}
+			return fmt.Errorf("failed to create bundle chunk %s: %w", chunk.Name, err)
+		}
 	}
 	return nil
 }
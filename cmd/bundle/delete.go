@@ -1,46 +1,106 @@
 package bundle
 
 import (
+	"arlon.io/arlon/pkg/argocd"
+	bundlepkg "arlon.io/arlon/pkg/bundle"
+	"arlon.io/arlon/pkg/cliutil"
+	"arlon.io/arlon/pkg/cluster"
 	"context"
 	"fmt"
+	"github.com/argoproj/argo-cd/v2/util/io"
 	"github.com/spf13/cobra"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	restclient "k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"strings"
 )
 
 import "github.com/argoproj/argo-cd/v2/util/cli"
 
 func deleteBundleCommand() *cobra.Command {
 	var clientConfig clientcmd.ClientConfig
+	var argocdNs string
 	var ns string
+	var force bool
 	command := &cobra.Command{
 		Use:               "delete",
 		Short:             "Delete configuration bundle",
-		Long:              "Delete configuration bundle",
+		Long: "Delete configuration bundle. A bundle included in profiles or deployed " +
+			"to clusters is only deleted with --force, see bundle where-used.",
 		Args: cobra.ExactArgs(1),
+		ValidArgsFunction: cliutil.CompleteArgs(cliutil.CompleteBundles),
 		RunE: func(c *cobra.Command, args []string) error {
+			ctx, cancel := cliutil.Context(c)
+			defer cancel()
 			config, err := clientConfig.ClientConfig()
 			if err != nil {
-				return fmt.Errorf("failed to get k8s client config: %s", err)
+				return fmt.Errorf("failed to get k8s client config: %w", err)
 			}
-			return deleteBundle(config, ns, args[0])
+			if !force {
+				err = checkBundleUnused(ctx, config, argocdNs, ns, args[0])
+				if err != nil {
+					return err
+				}
+			}
+			return deleteBundle(ctx, config, ns, args[0])
 		},
 	}
 	clientConfig = cli.AddKubectlFlagsToCmd(command)
+	command.Flags().StringVar(&argocdNs, "argocd-ns", "argocd", "the argocd namespace")
 	command.Flags().StringVar(&ns, "ns", "arlon", "the arlon namespace")
+	command.Flags().BoolVar(&force, "force", false, "delete the bundle even if profiles or clusters use it")
 	return command
 }
 
+// checkBundleUnused returns an error if a bundle is included in profiles or
+// deployed to clusters, which would fail to deploy or lose the bundle's
+// resources on their next deployment once it is deleted.
+func checkBundleUnused(ctx context.Context, config *restclient.Config, argocdNs string, ns string, bundleName string) error {
+	kubeClient := kubernetes.NewForConfigOrDie(config)
+	profiles, err := cluster.BundleProfiles(ctx, kubeClient, argocdNs, ns, bundleName)
+	if err != nil {
+		return err
+	}
+	conn, appIf := argocd.NewArgocdClientOrDie().NewApplicationClientOrDie()
+	defer io.Close(conn)
+	usages, err := cluster.BundleWhereUsed(ctx, kubeClient, appIf, argocdNs, ns, bundleName)
+	if err != nil {
+		return err
+	}
+	var clusters []string
+	for _, usage := range usages {
+		clusters = append(clusters, usage.ClusterName)
+	}
+	if len(profiles) == 0 && len(clusters) == 0 {
+		return nil
+	}
+	var uses []string
+	if len(profiles) > 0 {
+		uses = append(uses, "profiles "+strings.Join(profiles, ", "))
+	}
+	if len(clusters) > 0 {
+		uses = append(uses, "clusters "+strings.Join(clusters, ", "))
+	}
+	return fmt.Errorf("bundle %s is used by %s (--force deletes it anyway)",
+		bundleName, strings.Join(uses, " and "))
+}
+
 
-func deleteBundle(config *restclient.Config, ns string, bundleName string) error {
+func deleteBundle(ctx context.Context, config *restclient.Config, ns string, bundleName string) error {
 	kubeClient := kubernetes.NewForConfigOrDie(config)
 	corev1 := kubeClient.CoreV1()
 	secretsApi := corev1.Secrets(ns)
-	err := secretsApi.Delete(context.Background(), bundleName, metav1.DeleteOptions{})
+	err := secretsApi.Delete(ctx, bundleName, metav1.DeleteOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to delete bundle: %w", err)
+	}
+	// don't wait for the garbage collection of the chunks of large bundles
+	err = secretsApi.DeleteCollection(ctx, metav1.DeleteOptions{}, metav1.ListOptions{
+		LabelSelector: bundlepkg.ChunkLabel + "=" + bundleName,
+	})
 	if err != nil {
-		return fmt.Errorf("failed to delete bundle: %s", err)
+		return fmt.Errorf("failed to delete bundle chunks: %w", err)
 	}
 	return nil
 }
@@ -0,0 +1,84 @@
+package bundle
+
+import (
+	bundlepkg "arlon.io/arlon/pkg/bundle"
+	"arlon.io/arlon/pkg/cliutil"
+	"arlon.io/arlon/pkg/diff"
+	"context"
+	"fmt"
+	"github.com/argoproj/argo-cd/v2/util/cli"
+	"github.com/spf13/cobra"
+	"io"
+	"k8s.io/client-go/kubernetes"
+	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"os"
+)
+
+func diffBundleCommand() *cobra.Command {
+	var clientConfig clientcmd.ClientConfig
+	var ns string
+	var fromFile string
+	var validate validateOptions
+	command := &cobra.Command{
+		Use:   "diff <bundle>",
+		Short: "Compare an inline configuration bundle with a local file",
+		Long: "Print a unified diff between the data of an inline configuration " +
+			"bundle and the content of a local file, for e.g. before updating " +
+			"the bundle with it.",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: cliutil.CompleteArgs(cliutil.CompleteBundles),
+		RunE: func(c *cobra.Command, args []string) error {
+			ctx, cancel := cliutil.Context(c)
+			defer cancel()
+			config, err := clientConfig.ClientConfig()
+			if err != nil {
+				return fmt.Errorf("failed to get k8s client config: %w", err)
+			}
+			return diffBundle(ctx, config, ns, args[0], fromFile, &validate, os.Stdout)
+		},
+	}
+	clientConfig = cli.AddKubectlFlagsToCmd(command)
+	command.Flags().StringVar(&ns, "ns", "arlon", "the arlon namespace")
+	command.Flags().StringVar(&fromFile, "from-file", "", "the file holding the proposed bundle content")
+	addValidateFlags(command, &validate)
+	command.MarkFlagRequired("from-file")
+	return command
+}
+
+func diffBundle(
+	ctx context.Context,
+	config *restclient.Config,
+	ns string,
+	bundleName string,
+	fromFile string,
+	validate *validateOptions,
+	w io.Writer,
+) error {
+	kubeClient := kubernetes.NewForConfigOrDie(config)
+	secret, err := bundlepkg.NewKubeStore(kubeClient.CoreV1()).GetBundle(ctx, ns, bundleName)
+	if err != nil {
+		return fmt.Errorf("failed to get bundle secret: %w", err)
+	}
+	if secret.Labels["arlon-type"] != "config-bundle" {
+		return fmt.Errorf("secret is missing expected label")
+	}
+	if secret.Labels["bundle-type"] != "inline" {
+		return fmt.Errorf("bundle is not of inline type")
+	}
+	proposed, err := os.ReadFile(fromFile)
+	if err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+	destNs := secret.Annotations["destination-namespace"]
+	if destNs == "" {
+		destNs = "default"
+	}
+	err = validateData(ctx, config, validate, proposed, destNs, os.Stderr)
+	if err != nil {
+		return err
+	}
+	_, err = diff.Unified(w, fmt.Sprintf("%s/%s", ns, bundleName), fromFile,
+		secret.Data["data"], proposed)
+	return err
+}
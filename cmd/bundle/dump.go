@@ -1,12 +1,13 @@
 package bundle
 
 import (
+	bundlepkg "arlon.io/arlon/pkg/bundle"
+	"arlon.io/arlon/pkg/cliutil"
 	"bytes"
 	"context"
 	"fmt"
 	"github.com/spf13/cobra"
 	"io"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	restclient "k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
@@ -23,12 +24,15 @@ func dumpBundleCommand() *cobra.Command {
 		Short:             "Dump content of inline configuration bundle",
 		Long:              "Dump content of inline configuration bundle",
 		Args: cobra.ExactArgs(1),
+		ValidArgsFunction: cliutil.CompleteArgs(cliutil.CompleteBundles),
 		RunE: func(c *cobra.Command, args []string) error {
+			ctx, cancel := cliutil.Context(c)
+			defer cancel()
 			config, err := clientConfig.ClientConfig()
 			if err != nil {
-				return fmt.Errorf("failed to get k8s client config: %s", err)
+				return fmt.Errorf("failed to get k8s client config: %w", err)
 			}
-			return dumpBundle(config, ns, args[0])
+			return dumpBundle(ctx, config, ns, args[0])
 		},
 	}
 	clientConfig = cli.AddKubectlFlagsToCmd(command)
@@ -37,13 +41,11 @@ func dumpBundleCommand() *cobra.Command {
 }
 
 
-func dumpBundle(config *restclient.Config, ns string, bundleName string) error {
+func dumpBundle(ctx context.Context, config *restclient.Config, ns string, bundleName string) error {
 	kubeClient := kubernetes.NewForConfigOrDie(config)
-	corev1 := kubeClient.CoreV1()
-	secretsApi := corev1.Secrets(ns)
-	secret, err := secretsApi.Get(context.Background(), bundleName, metav1.GetOptions{})
+	secret, err := bundlepkg.NewKubeStore(kubeClient.CoreV1()).GetBundle(ctx, ns, bundleName)
 	if err != nil {
-		return fmt.Errorf("failed to get bundle secret: %s", err)
+		return fmt.Errorf("failed to get bundle secret: %w", err)
 	}
 	if secret.Labels["arlon-type"] != "config-bundle" {
 		return fmt.Errorf("secret is missing expected label")
@@ -56,7 +58,7 @@ func dumpBundle(config *restclient.Config, ns string, bundleName string) error {
 	}
 	_, err = io.Copy(os.Stdout, bytes.NewReader(secret.Data["data"]))
 	if err != nil {
-		return fmt.Errorf("failed to copy secret data: %s", err)
+		return fmt.Errorf("failed to copy secret data: %w", err)
 	}
 	return nil
 }
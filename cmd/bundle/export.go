@@ -0,0 +1,64 @@
+package bundle
+
+import (
+	"arlon.io/arlon/pkg/cliutil"
+	"arlon.io/arlon/pkg/cluster"
+	"arlon.io/arlon/pkg/gitutils"
+	"fmt"
+	"github.com/argoproj/argo-cd/v2/util/cli"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+func exportBundleCommand() *cobra.Command {
+	var clientConfig clientcmd.ClientConfig
+	var argocdNs string
+	var ns string
+	var repoUrl string
+	var repoBranch string
+	var repoPath string
+	var repoRevision string
+	command := &cobra.Command{
+		Use:   "export <bundle>",
+		Short: "Move an inline bundle's manifests to git",
+		Long: "Move the manifests of an inline bundle to a path of a git repository " +
+			"registered with ArgoCD, and convert the bundle into a reference bundle " +
+			"pointing at them. The clusters using the bundle are updated to the " +
+			"reference bundle the next time they are deployed.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			ctx, cancel := cliutil.Context(c)
+			defer cancel()
+			config, err := clientConfig.ClientConfig()
+			if err != nil {
+				return fmt.Errorf("failed to get k8s client config: %w", err)
+			}
+			kubeClient := kubernetes.NewForConfigOrDie(config)
+			ctx = cliutil.WithKubeIdentity(ctx, config, kubeClient)
+			if repoPath == "" {
+				repoPath = args[0]
+			}
+			repo := gitutils.NewRepo()
+			defer repo.Close()
+			commitSha, err := cluster.ExportBundle(ctx, kubeClient, repo, argocdNs, ns,
+				args[0], repoUrl, repoBranch, repoPath, repoRevision)
+			if err != nil {
+				return fmt.Errorf("failed to export bundle: %w", err)
+			}
+			if commitSha != "" {
+				fmt.Printf("pushed commit %s\n", commitSha)
+			}
+			return nil
+		},
+	}
+	clientConfig = cli.AddKubectlFlagsToCmd(command)
+	command.Flags().StringVar(&argocdNs, "argocd-ns", "argocd", "the argocd namespace")
+	command.Flags().StringVar(&ns, "ns", "arlon", "the arlon namespace")
+	command.Flags().StringVar(&repoUrl, "repo-url", "", "the git repository url")
+	command.Flags().StringVar(&repoBranch, "repo-branch", "main", "the git branch")
+	command.Flags().StringVar(&repoPath, "repo-path", "", "the path of the bundle's manifests in the repository (defaults to the bundle name)")
+	command.Flags().StringVar(&repoRevision, "repo-revision", "", "the git revision the reference bundle tracks (defaults to --repo-branch)")
+	command.MarkFlagRequired("repo-url")
+	return command
+}
@@ -0,0 +1,150 @@
+package bundle
+
+import (
+	"arlon.io/arlon/pkg/argocd"
+	bundlepkg "arlon.io/arlon/pkg/bundle"
+	"arlon.io/arlon/pkg/cliutil"
+	"context"
+	"fmt"
+	applicationpkg "github.com/argoproj/argo-cd/v2/pkg/apiclient/application"
+	argoappv1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	"github.com/argoproj/argo-cd/v2/util/cli"
+	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func importAppCommand() *cobra.Command {
+	var clientConfig clientcmd.ClientConfig
+	var ns string
+	var bundleName string
+	var desc string
+	var tags string
+	command := &cobra.Command{
+		Use:   "import-app <argocd-app-name>",
+		Short: "Create a reference bundle from an existing ArgoCD application",
+		Long: "Create a reference bundle from the source (git repository path or " +
+			"Helm chart) of an existing ArgoCD application, so that it can be " +
+			"added to profiles. The application itself is left unchanged.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			ctx, cancel := cliutil.Context(c)
+			defer cancel()
+			config, err := clientConfig.ClientConfig()
+			if err != nil {
+				return fmt.Errorf("failed to get k8s client config: %w", err)
+			}
+			kubeClient := kubernetes.NewForConfigOrDie(config)
+			conn, appIf := argocd.NewArgocdClientOrDie().NewApplicationClientOrDie()
+			defer conn.Close()
+			appName := args[0]
+			app, err := appIf.Get(ctx, &applicationpkg.ApplicationQuery{Name: &appName})
+			if err != nil {
+				return fmt.Errorf("failed to get application %s: %w", appName, err)
+			}
+			if bundleName == "" {
+				bundleName = appName
+			}
+			if desc == "" {
+				desc = fmt.Sprintf("imported from ArgoCD application %s", appName)
+			}
+			return importApp(ctx, kubeClient, ns, bundleName, app, desc, tags)
+		},
+	}
+	clientConfig = cli.AddKubectlFlagsToCmd(command)
+	command.Flags().StringVar(&ns, "ns", "arlon", "the arlon namespace")
+	command.Flags().StringVar(&bundleName, "name", "", "the bundle name (defaults to the application name)")
+	command.Flags().StringVar(&desc, "desc", "", "description")
+	command.Flags().StringVar(&tags, "tags", "", "comma separated list of tags")
+	return command
+}
+
+func importApp(
+	ctx context.Context,
+	kubeClient kubernetes.Interface,
+	ns string,
+	bundleName string,
+	app *argoappv1.Application,
+	desc string,
+	tags string,
+) error {
+	secretsApi := kubeClient.CoreV1().Secrets(ns)
+	_, err := secretsApi.Get(ctx, bundleName, metav1.GetOptions{})
+	if err == nil {
+		return fmt.Errorf("a bundle with that name already exists")
+	}
+	if !apierr.IsNotFound(err) {
+		return fmt.Errorf("failed to check for existence of bundle: %w", err)
+	}
+	source := app.Spec.Source
+	secr := v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: bundleName,
+			Labels: map[string]string{
+				"managed-by":  "arlon",
+				"arlon-type":  "config-bundle",
+				"bundle-type": "reference",
+			},
+			Annotations: map[string]string{
+				"repo-url":              source.RepoURL,
+				"repo-path":             source.Path,
+				"repo-revision":         source.TargetRevision,
+				"destination-namespace": app.Spec.Destination.Namespace,
+			},
+		},
+		Data: map[string][]byte{
+			"description": []byte(desc),
+			"tags":        []byte(tags),
+		},
+	}
+	if source.Chart != "" {
+		if source.TargetRevision == "" {
+			return fmt.Errorf("application %s does not specify a chart version", app.Name)
+		}
+		secr.Annotations["repo-chart"] = source.Chart
+		delete(secr.Annotations, "repo-path")
+	}
+	if source.Helm != nil {
+		if source.Helm.Values != "" {
+			secr.Data["values"] = []byte(source.Helm.Values)
+		}
+		if len(source.Helm.Parameters) > 0 || len(source.Helm.ValueFiles) > 0 {
+			fmt.Fprintf(os.Stderr, "warning: the helm parameters and value files of %s "+
+				"were not imported, only its values\n", app.Name)
+		}
+	}
+	if syncPolicy := app.Spec.SyncPolicy; syncPolicy != nil {
+		if len(syncPolicy.SyncOptions) > 0 {
+			secr.Annotations[bundlepkg.SyncOptionsAnnotation] = strings.Join(syncPolicy.SyncOptions, ",")
+		}
+		if retry := syncPolicy.Retry; retry != nil {
+			secr.Annotations[bundlepkg.SyncRetryLimitAnnotation] = strconv.FormatInt(retry.Limit, 10)
+			if retry.Backoff != nil {
+				secr.Annotations[bundlepkg.SyncRetryBackoffAnnotation] = retry.Backoff.Duration
+				secr.Annotations[bundlepkg.SyncRetryMaxBackoffAnnotation] = retry.Backoff.MaxDuration
+				if retry.Backoff.Factor != nil {
+					secr.Annotations[bundlepkg.SyncRetryBackoffFactorAnnotation] =
+						strconv.FormatInt(*retry.Backoff.Factor, 10)
+				}
+			}
+		}
+		if _, _, err := bundlepkg.SyncPolicyOf(&secr); err != nil {
+			return err
+		}
+	}
+	if source.Kustomize != nil || source.Directory != nil || source.Plugin != nil {
+		fmt.Fprintf(os.Stderr, "warning: the kustomize, directory and plugin "+
+			"options of %s were not imported\n", app.Name)
+	}
+	_, err = secretsApi.Create(ctx, &secr, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to create secret: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package bundle
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	bundlepkg "arlon.io/arlon/pkg/bundle"
+	argoappv1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestImportApp(t *testing.T) {
+	ctx := context.Background()
+	kubeClient := k8sfake.NewSimpleClientset()
+	factor := int64(2)
+	chartApp := &argoappv1.Application{
+		ObjectMeta: metav1.ObjectMeta{Name: "nginx"},
+		Spec: argoappv1.ApplicationSpec{
+			Source: argoappv1.ApplicationSource{
+				RepoURL:        "https://charts.example.com",
+				Chart:          "nginx",
+				TargetRevision: "1.0",
+				Helm:           &argoappv1.ApplicationSourceHelm{Values: "replicas: 2\n"},
+			},
+			Destination: argoappv1.ApplicationDestination{Namespace: "web"},
+			SyncPolicy: &argoappv1.SyncPolicy{
+				SyncOptions: []string{"CreateNamespace=true"},
+				Retry: &argoappv1.RetryStrategy{
+					Limit:   3,
+					Backoff: &argoappv1.Backoff{Duration: "5s", MaxDuration: "1m", Factor: &factor},
+				},
+			},
+		},
+	}
+	if err := importApp(ctx, kubeClient, "arlon", "nginx", chartApp, "web server", "web"); err != nil {
+		t.Fatal(err)
+	}
+	secret, err := kubeClient.CoreV1().Secrets("arlon").Get(ctx, "nginx", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, expected := range map[string]string{
+		"repo-url":                                 "https://charts.example.com",
+		"repo-chart":                               "nginx",
+		"repo-revision":                            "1.0",
+		"destination-namespace":                    "web",
+		bundlepkg.SyncOptionsAnnotation:            "CreateNamespace=true",
+		bundlepkg.SyncRetryLimitAnnotation:         "3",
+		bundlepkg.SyncRetryBackoffAnnotation:       "5s",
+		bundlepkg.SyncRetryMaxBackoffAnnotation:    "1m",
+		bundlepkg.SyncRetryBackoffFactorAnnotation: "2",
+	} {
+		if actual := secret.Annotations[key]; actual != expected {
+			t.Errorf("expected annotation %s to be %q, got %q", key, expected, actual)
+		}
+	}
+	if _, ok := secret.Annotations["repo-path"]; ok {
+		t.Error("expected a chart bundle to have no repo path")
+	}
+	if secret.Labels["bundle-type"] != "reference" || string(secret.Data["values"]) != "replicas: 2\n" ||
+		string(secret.Data["description"]) != "web server" {
+		t.Errorf("unexpected bundle %v", secret)
+	}
+	err = importApp(ctx, kubeClient, "arlon", "nginx", chartApp, "", "")
+	if err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("expected an existing bundle not to be overwritten, got %v", err)
+	}
+
+	pathApp := &argoappv1.Application{
+		ObjectMeta: metav1.ObjectMeta{Name: "guestbook"},
+		Spec: argoappv1.ApplicationSpec{Source: argoappv1.ApplicationSource{
+			RepoURL:        "https://git.example.com/apps.git",
+			Path:           "guestbook",
+			TargetRevision: "main",
+		}},
+	}
+	if err := importApp(ctx, kubeClient, "arlon", "guestbook", pathApp, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	secret, err = kubeClient.CoreV1().Secrets("arlon").Get(ctx, "guestbook", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Annotations["repo-path"] != "guestbook" || secret.Annotations["repo-chart"] != "" {
+		t.Errorf("expected a git path bundle, got %v", secret.Annotations)
+	}
+
+	unversioned := chartApp.DeepCopy()
+	unversioned.Spec.Source.TargetRevision = ""
+	err = importApp(ctx, kubeClient, "arlon", "unversioned", unversioned, "", "")
+	if err == nil || !strings.Contains(err.Error(), "does not specify a chart version") {
+		t.Errorf("expected a chart without a version to be refused, got %v", err)
+	}
+}
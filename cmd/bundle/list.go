@@ -1,14 +1,20 @@
 package bundle
 
 import (
+	bundlepkg "arlon.io/arlon/pkg/bundle"
+	"arlon.io/arlon/pkg/cliutil"
+	"arlon.io/arlon/pkg/kuberetry"
 	"context"
 	"fmt"
 	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
 	restclient "k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	"os"
+	"strings"
 	"text/tabwriter"
 )
 
@@ -17,41 +23,63 @@ import "github.com/argoproj/argo-cd/v2/util/cli"
 func listBundlesCommand() *cobra.Command {
 	var clientConfig clientcmd.ClientConfig
 	var ns string
+	var allNamespaces bool
+	var selector string
 	command := &cobra.Command{
 		Use:               "list",
 		Short:             "List configuration bundles",
 		Long:              "List configuration bundles",
 		RunE: func(c *cobra.Command, args []string) error {
+			ctx, cancel := cliutil.Context(c)
+			defer cancel()
 			config, err := clientConfig.ClientConfig()
 			if err != nil {
-				return fmt.Errorf("failed to get k8s client config: %s", err)
+				return fmt.Errorf("failed to get k8s client config: %w", err)
 			}
-			return listBundles(config, ns)
+			return listBundles(ctx, config, ns, allNamespaces, selector)
 		},
 	}
 	clientConfig = cli.AddKubectlFlagsToCmd(command)
 	command.Flags().StringVar(&ns, "ns", "arlon", "the arlon namespace")
+	command.Flags().BoolVarP(&allNamespaces, "all-namespaces", "A", false, "list across all namespaces")
+	command.Flags().StringVarP(&selector, "selector", "l", "", "only list the bundles whose labels match this selector, for e.g. env=prod,tier!=data-service")
 	return command
 }
 
 
-func listBundles(config *restclient.Config, ns string) error {
+func listBundles(ctx context.Context, config *restclient.Config, ns string, allNamespaces bool, selector string) error {
 	kubeClient := kubernetes.NewForConfigOrDie(config)
+	if allNamespaces {
+		ns = metav1.NamespaceAll
+	}
 	corev1 := kubeClient.CoreV1()
 	secretsApi := corev1.Secrets(ns)
 	opts := metav1.ListOptions{
 		LabelSelector: "managed-by=arlon,arlon-type=config-bundle",
 	}
-	secrets, err := secretsApi.List(context.Background(), opts)
+	if selector != "" {
+		if _, err := labels.Parse(selector); err != nil {
+			return fmt.Errorf("invalid selector: %w", err)
+		}
+		opts.LabelSelector += "," + selector
+	}
+	var secrets *v1.SecretList
+	err := kuberetry.OnTransient(ctx, func() (err error) {
+		secrets, err = secretsApi.List(ctx, opts)
+		return
+	})
 	if err != nil {
-		return fmt.Errorf("failed to list secrets: %s", err)
+		return fmt.Errorf("failed to list secrets: %w", err)
 	}
 	if len(secrets.Items) == 0 {
 		fmt.Println("no bundles found")
 		return nil
 	}
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
-	_, _ = fmt.Fprintf(w, "NAME\tTYPE\tTAGS\tDESCRIPTION\n")
+	if allNamespaces {
+		_, _ = fmt.Fprintf(w, "NAMESPACE\t")
+	}
+	_, _ = fmt.Fprintf(w, "NAME\tTYPE\tSIZE\tLABELS\tTAGS\tDESCRIPTION\n")
 	for _, secret := range secrets.Items {
 		bundleType := secret.Labels["bundle-type"]
 		if bundleType == "" {
@@ -59,9 +87,59 @@ func listBundles(config *restclient.Config, ns string) error {
 		}
 		tags := string(secret.Data["tags"])
 		desc := string(secret.Data["description"])
-		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", secret.Name, bundleType, tags, desc)
+		if allNamespaces {
+			_, _ = fmt.Fprintf(w, "%s\t", secret.Namespace)
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", secret.Name, bundleType, bundleSize(&secret),
+			userLabels(secret.Labels), tags, desc)
 	}
 	_ = w.Flush()
 	return nil
 }
 
+// userLabels formats the labels classifying a bundle, leaving out the ones
+// arlon sets.
+func userLabels(secretLabels map[string]string) string {
+	set := labels.Set{}
+	for key, val := range secretLabels {
+		switch key {
+		case "managed-by", "arlon-type", "bundle-type":
+		default:
+			set[key] = val
+		}
+	}
+	if len(set) == 0 {
+		return "-"
+	}
+	return set.String()
+}
+
+// bundleSize describes the size of an inline bundle's data and how it is
+// stored.
+func bundleSize(secret *v1.Secret) string {
+	if secret.Labels["bundle-type"] != "inline" {
+		return "-"
+	}
+	size := formatSize(bundlepkg.StoredSize(secret))
+	var storage []string
+	if secret.Annotations[bundlepkg.CompressionAnnotation] != "" {
+		storage = append(storage, secret.Annotations[bundlepkg.CompressionAnnotation])
+	}
+	if chunks := secret.Annotations[bundlepkg.ChunksAnnotation]; chunks != "" {
+		storage = append(storage, chunks+" chunks")
+	}
+	if len(storage) > 0 {
+		size += " (" + strings.Join(storage, ", ") + ")"
+	}
+	return size
+}
+
+func formatSize(size int) string {
+	switch {
+	case size >= 1024*1024:
+		return fmt.Sprintf("%.1fMiB", float64(size)/(1024*1024))
+	case size >= 1024:
+		return fmt.Sprintf("%.1fKiB", float64(size)/1024)
+	}
+	return fmt.Sprintf("%dB", size)
+}
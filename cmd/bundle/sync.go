@@ -0,0 +1,47 @@
+package bundle
+
+import (
+	bundlepkg "arlon.io/arlon/pkg/bundle"
+	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+)
+
+// syncOptions are the options of the commands creating bundles that
+// customize the sync policy of the applications generated for them.
+type syncOptions struct {
+	options       string
+	retryLimit    string
+	backoff       string
+	backoffFactor string
+	maxBackoff    string
+}
+
+func addSyncFlags(command *cobra.Command, opts *syncOptions) {
+	command.Flags().StringVar(&opts.options, "sync-options", "",
+		"comma separated list of argocd sync options of the bundle's applications, for e.g. ServerSideApply,Replace")
+	command.Flags().StringVar(&opts.retryLimit, "sync-retry-limit", "",
+		"retry failed syncs of the bundle's applications up to this number of times (-1 for no limit)")
+	command.Flags().StringVar(&opts.backoff, "sync-retry-backoff", "",
+		"with --sync-retry-limit, the wait before the first retry (default 5s)")
+	command.Flags().StringVar(&opts.backoffFactor, "sync-retry-backoff-factor", "",
+		"with --sync-retry-limit, the factor multiplying the wait between retries (default 2)")
+	command.Flags().StringVar(&opts.maxBackoff, "sync-retry-max-backoff", "",
+		"with --sync-retry-limit, the maximum wait between retries (default 3m)")
+}
+
+// apply sets the bundle's sync annotations, and validates them.
+func (opts *syncOptions) apply(secr *v1.Secret) error {
+	for key, val := range map[string]string{
+		bundlepkg.SyncOptionsAnnotation:            opts.options,
+		bundlepkg.SyncRetryLimitAnnotation:         opts.retryLimit,
+		bundlepkg.SyncRetryBackoffAnnotation:       opts.backoff,
+		bundlepkg.SyncRetryBackoffFactorAnnotation: opts.backoffFactor,
+		bundlepkg.SyncRetryMaxBackoffAnnotation:    opts.maxBackoff,
+	} {
+		if val != "" {
+			secr.Annotations[key] = val
+		}
+	}
+	_, _, err := bundlepkg.SyncPolicyOf(secr)
+	return err
+}
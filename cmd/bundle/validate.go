@@ -0,0 +1,58 @@
+package bundle
+
+import (
+	bundlepkg "arlon.io/arlon/pkg/bundle"
+	"context"
+	"fmt"
+	"github.com/spf13/cobra"
+	"io"
+	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// validateOptions are the options of the commands validating inline bundle
+// content before storing it.
+type validateOptions struct {
+	enabled    bool
+	kubeconfig string
+}
+
+func addValidateFlags(command *cobra.Command, opts *validateOptions) {
+	command.Flags().BoolVar(&opts.enabled, "validate", false,
+		"validate each manifest of the --from-file data with a server-side dry-run")
+	command.Flags().StringVar(&opts.kubeconfig, "validate-kubeconfig", "",
+		"validate against the cluster of this kubeconfig, for e.g. a workload cluster, instead of the management cluster")
+}
+
+// validateData validates inline bundle data if enabled, printing the error
+// of each invalid document to w.
+func validateData(
+	ctx context.Context,
+	config *restclient.Config,
+	opts *validateOptions,
+	data []byte,
+	defaultNs string,
+	w io.Writer,
+) error {
+	if !opts.enabled {
+		return nil
+	}
+	if opts.kubeconfig != "" {
+		var err error
+		config, err = clientcmd.BuildConfigFromFlags("", opts.kubeconfig)
+		if err != nil {
+			return fmt.Errorf("failed to load validation kubeconfig: %w", err)
+		}
+	}
+	docErrs, err := bundlepkg.Validate(ctx, config, data, defaultNs)
+	if err != nil {
+		return fmt.Errorf("failed to validate bundle: %w", err)
+	}
+	for _, docErr := range docErrs {
+		fmt.Fprintln(w, docErr.String())
+	}
+	if len(docErrs) > 0 {
+		return fmt.Errorf("%d invalid bundle document(s)", len(docErrs))
+	}
+	return nil
+}
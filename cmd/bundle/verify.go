@@ -0,0 +1,81 @@
+package bundle
+
+import (
+	bundlepkg "arlon.io/arlon/pkg/bundle"
+	"arlon.io/arlon/pkg/cliutil"
+	"context"
+	"fmt"
+	"github.com/argoproj/argo-cd/v2/util/cli"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"os"
+)
+
+func verifyBundleCommand() *cobra.Command {
+	var clientConfig clientcmd.ClientConfig
+	var ns string
+	var keyFile string
+	command := &cobra.Command{
+		Use:   "verify <bundle>",
+		Short: "Verify the signature of an inline configuration bundle",
+		Long: "Verify the signature of an inline configuration bundle against the " +
+			"keys in the " + bundlepkg.TrustedKeysConfigMapName + " configmap, " +
+			"or against the public key specified by --key",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: cliutil.CompleteArgs(cliutil.CompleteBundles),
+		RunE: func(c *cobra.Command, args []string) error {
+			ctx, cancel := cliutil.Context(c)
+			defer cancel()
+			config, err := clientConfig.ClientConfig()
+			if err != nil {
+				return fmt.Errorf("failed to get k8s client config: %w", err)
+			}
+			return verifyBundle(ctx, config, ns, args[0], keyFile)
+		},
+	}
+	clientConfig = cli.AddKubectlFlagsToCmd(command)
+	command.Flags().StringVar(&ns, "ns", "arlon", "the arlon namespace")
+	command.Flags().StringVar(&keyFile, "key", "", "PEM encoded public key to verify against")
+	return command
+}
+
+func verifyBundle(ctx context.Context, config *restclient.Config, ns string, bundleName string, keyFile string) error {
+	kubeClient := kubernetes.NewForConfigOrDie(config)
+	corev1 := kubeClient.CoreV1()
+	var keys []bundlepkg.TrustedKey
+	if keyFile != "" {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return fmt.Errorf("failed to read key file: %w", err)
+		}
+		key, err := bundlepkg.ParsePublicKey(data)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, bundlepkg.TrustedKey{Name: keyFile, Key: key})
+	} else {
+		var err error
+		keys, err = bundlepkg.LoadTrustedKeys(ctx, corev1, ns)
+		if err != nil {
+			return err
+		}
+		if keys == nil {
+			return fmt.Errorf("no trusted keys configured, use --key")
+		}
+	}
+	secret, err := bundlepkg.NewKubeStore(corev1).GetBundle(ctx, ns, bundleName)
+	if err != nil {
+		return fmt.Errorf("failed to get bundle secret: %w", err)
+	}
+	if secret.Labels["bundle-type"] != "inline" {
+		return fmt.Errorf("bundle is not of inline type")
+	}
+	keyName, err := bundlepkg.Verify(keys, secret.Data["data"], secret.Data[bundlepkg.SignatureKey])
+	if err != nil {
+		return err
+	}
+	fmt.Printf("bundle %s verified with key %s\n", bundleName, keyName)
+	return nil
+}
@@ -0,0 +1,81 @@
+package bundle
+
+import (
+	"arlon.io/arlon/pkg/argocd"
+	"arlon.io/arlon/pkg/cliutil"
+	"arlon.io/arlon/pkg/cluster"
+	"fmt"
+	"github.com/argoproj/argo-cd/v2/util/cli"
+	"github.com/argoproj/argo-cd/v2/util/io"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"os"
+	"text/tabwriter"
+)
+
+func whereUsedCommand() *cobra.Command {
+	var clientConfig clientcmd.ClientConfig
+	var argocdNs string
+	var ns string
+	var changedOnly bool
+	command := &cobra.Command{
+		Use:   "where-used <bundle>",
+		Short: "List the profiles and clusters using a bundle",
+		Long: "List the profiles including a bundle, by name or by their bundle selector, " +
+			"and the clusters it is deployed to, along with whether each runs the " +
+			"bundle's current content, by comparing the content hash recorded on the " +
+			"bundle's application with that of the bundle. With --changed-only, only " +
+			"the clusters to deploy again are listed.",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: cliutil.CompleteArgs(cliutil.CompleteBundles),
+		RunE: func(c *cobra.Command, args []string) error {
+			ctx, cancel := cliutil.Context(c)
+			defer cancel()
+			config, err := clientConfig.ClientConfig()
+			if err != nil {
+				return fmt.Errorf("failed to get k8s client config: %w", err)
+			}
+			kubeClient := kubernetes.NewForConfigOrDie(config)
+			conn, appIf := argocd.NewArgocdClientOrDie().NewApplicationClientOrDie()
+			defer io.Close(conn)
+			profiles, err := cluster.BundleProfiles(ctx, kubeClient, argocdNs, ns, args[0])
+			if err != nil {
+				return err
+			}
+			usages, err := cluster.BundleWhereUsed(ctx, kubeClient, appIf, argocdNs, ns, args[0])
+			if err != nil {
+				return err
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			if !changedOnly {
+				_, _ = fmt.Fprintf(w, "PROFILE\n")
+				for _, profile := range profiles {
+					_, _ = fmt.Fprintf(w, "%s\n", profile)
+				}
+				_, _ = fmt.Fprintf(w, "\n")
+			}
+			_, _ = fmt.Fprintf(w, "CLUSTER\tDEPLOYED HASH\tSTATUS\n")
+			for _, usage := range usages {
+				if changedOnly && !usage.Changed() {
+					continue
+				}
+				deployedHash, status := usage.DeployedHash, "current"
+				if deployedHash == "" {
+					deployedHash = "(unknown)"
+				}
+				if usage.Changed() {
+					status = "changed"
+				}
+				_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n", usage.ClusterName, deployedHash, status)
+			}
+			_ = w.Flush()
+			return nil
+		},
+	}
+	clientConfig = cli.AddKubectlFlagsToCmd(command)
+	command.Flags().StringVar(&argocdNs, "argocd-ns", "argocd", "the argocd namespace")
+	command.Flags().StringVar(&ns, "ns", "arlon", "the arlon namespace")
+	command.Flags().BoolVar(&changedOnly, "changed-only", false, "only list the clusters whose deployed content differs from the bundle's")
+	return command
+}
@@ -12,6 +12,16 @@ func NewCommand() *cobra.Command {
 		},
 	}
 	command.AddCommand(deployClusterCommand())
+	command.AddCommand(renameClusterCommand())
+	command.AddCommand(deleteClusterCommand())
+	command.AddCommand(getKubeconfigCommand())
+	command.AddCommand(registerClusterCommand())
+	command.AddCommand(labelClusterCommand())
+	command.AddCommand(renderClusterCommand())
+	command.AddCommand(diffClusterCommand())
+	command.AddCommand(rollbackClusterCommand())
+	command.AddCommand(attachProfileCommand())
+	command.AddCommand(detachProfileCommand())
 	return command
 }
 
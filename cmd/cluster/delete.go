@@ -0,0 +1,77 @@
+package cluster
+
+import (
+	"arlon.io/arlon/pkg/argocd"
+	"arlon.io/arlon/pkg/cliutil"
+	"arlon.io/arlon/pkg/cluster"
+	"arlon.io/arlon/pkg/gitutils"
+	"arlon.io/arlon/pkg/notify"
+	"arlon.io/arlon/pkg/server"
+	"fmt"
+	"github.com/argoproj/argo-cd/v2/util/cli"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+func deleteClusterCommand() *cobra.Command {
+	var clientConfig clientcmd.ClientConfig
+	var argocdNs string
+	var arlonNs string
+	command := &cobra.Command{
+		Use:   "delete <cluster>",
+		Short: "Delete cluster",
+		Long: "Delete cluster: delete its root application, and with it the " +
+			"cluster's resources, then remove its directory from the git repository.",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: cliutil.CompleteArgs(cliutil.CompleteClusters),
+		RunE: func(c *cobra.Command, args []string) (err error) {
+			ctx, cancel := cliutil.Context(c)
+			defer cancel()
+			ctx, result := cliutil.StartOperation(ctx, "delete", args[0])
+			defer func() { err = result.Finish(err) }()
+			client, err := cliutil.ServerClient(ctx)
+			if err != nil {
+				return err
+			}
+			if client != nil {
+				defer client.Close()
+				res, err := client.DeleteCluster(ctx, &server.DeleteClusterRequest{Name: args[0]})
+				if err == nil {
+					result.CommitSha = res.CommitSha
+				}
+				return err
+			}
+			config, err := clientConfig.ClientConfig()
+			if err != nil {
+				return fmt.Errorf("failed to get k8s client config: %w", err)
+			}
+			kubeClient := kubernetes.NewForConfigOrDie(config)
+			ctx = cliutil.WithKubeIdentity(ctx, config, kubeClient)
+			notifier, err := notify.LoadDispatcher(ctx, kubeClient, arlonNs)
+			if err != nil {
+				return fmt.Errorf("failed to load notification settings: %w", err)
+			}
+			conn, appIf := argocd.NewArgocdClientOrDie().NewApplicationClientOrDie()
+			defer conn.Close()
+			repo := gitutils.NewRepo()
+			defer repo.Close()
+			commitSha, err := cluster.Delete(ctx, kubeClient, repo, appIf, argocdNs, arlonNs, args[0])
+			if err != nil {
+				return fmt.Errorf("failed to delete cluster: %w", err)
+			}
+			result.CommitSha = commitSha
+			notifier.Notify(notify.Event{
+				Type:        notify.EventClusterDeleted,
+				ClusterName: args[0],
+				CommitSha:   commitSha,
+				Message:     fmt.Sprintf("cluster %s deleted", args[0]),
+			})
+			return nil
+		},
+	}
+	clientConfig = cli.AddKubectlFlagsToCmd(command)
+	command.Flags().StringVar(&argocdNs, "argocd-ns", "argocd", "the argocd namespace")
+	command.Flags().StringVar(&arlonNs, "arlon-ns", "arlon", "the arlon namespace")
+	return command
+}
@@ -2,8 +2,12 @@ package cluster
 
 import (
 	"arlon.io/arlon/pkg/argocd"
+	"arlon.io/arlon/pkg/cliutil"
 	"arlon.io/arlon/pkg/cluster"
-	"context"
+	"arlon.io/arlon/pkg/gitutils"
+	"arlon.io/arlon/pkg/notify"
+	"arlon.io/arlon/pkg/progress"
+	"arlon.io/arlon/pkg/server"
 	_ "embed"
 	"fmt"
 	applicationpkg "github.com/argoproj/argo-cd/v2/pkg/apiclient/application"
@@ -14,6 +18,7 @@ import (
 	"k8s.io/apimachinery/pkg/runtime/serializer/json"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
+	"io"
 	"os"
 )
 
@@ -28,47 +33,123 @@ func deployClusterCommand() *cobra.Command {
 	var clusterSpecName string
 	var profileName string
 	var outputYaml bool
+	var createBranch string
+	var project string
+	var destinationServer string
+	var destinationSelector string
+	var clusterLabels map[string]string
 	command := &cobra.Command{
 		Use:               "deploy",
 		Short:             "DeployToGit cluster",
-		Long:              "DeployToGit cluster",
-		RunE: func(c *cobra.Command, args []string) error {
+		Long: "Deploy a cluster, or update a deployed one in place: its git tree is " +
+			"rewritten to match its profile and clusterspec, removing the files of " +
+			"bundles no longer used, and its root application is updated.",
+		RunE: func(c *cobra.Command, args []string) (err error) {
+			ctx, cancel := cliutil.Context(c)
+			defer cancel()
+			ctx, result := cliutil.StartOperation(ctx, "deploy", clusterName)
+			defer func() { err = result.Finish(err) }()
+			if !outputYaml {
+				client, err := cliutil.ServerClient(ctx)
+				if err != nil {
+					return err
+				}
+				if client != nil {
+					defer client.Close()
+					res, err := client.DeployCluster(ctx, &server.DeployClusterRequest{
+						Name:                clusterName,
+						RepoUrl:             repoUrl,
+						RepoBranch:          repoBranch,
+						Path:                basePath,
+						Profile:             profileName,
+						ClusterSpec:         clusterSpecName,
+						CreateBranch:        createBranch,
+						Project:             project,
+						DestinationServer:   destinationServer,
+						DestinationSelector: destinationSelector,
+						Labels:              clusterLabels,
+					})
+					if err == nil {
+						result.CommitSha = res.CommitSha
+					}
+					return err
+				}
+			}
 			config, err := clientConfig.ClientConfig()
 			if err != nil {
-				return fmt.Errorf("failed to get k8s client config: %s", err)
+				return fmt.Errorf("failed to get k8s client config: %w", err)
 			}
 			kubeClient := kubernetes.NewForConfigOrDie(config)
-			rootApp, err := cluster.ConstructRootApp(kubeClient, argocdNs, arlonNs, clusterName, repoUrl, repoBranch, basePath, clusterSpecName)
+			ctx = cliutil.WithKubeIdentity(ctx, config, kubeClient)
+			notifier, err := notify.LoadDispatcher(ctx, kubeClient, arlonNs)
 			if err != nil {
-				return fmt.Errorf("failed to construct root app: %s", err)
+				return fmt.Errorf("failed to load notification settings: %w", err)
 			}
-			err = cluster.DeployToGit(kubeClient, argocdNs, arlonNs, clusterName, repoUrl, repoBranch, basePath, profileName)
+			destinationServer, err := cluster.ResolveDestinationServer(ctx, kubeClient.CoreV1(), argocdNs,
+				destinationServer, destinationSelector)
 			if err != nil {
-				return fmt.Errorf("failed to deploy git tree: %s", err)
+				return err
 			}
-			if outputYaml {
-				scheme := runtime.NewScheme()
-				v1alpha1.AddToScheme(scheme)
-				s := json.NewSerializerWithOptions(json.DefaultMetaFactory, scheme, scheme, json.SerializerOptions{
-					Yaml:   true,
-					Pretty: true,
-					Strict: false,
-				})
-				err = s.Encode(rootApp, os.Stdout)
-				if err != nil {
-					return fmt.Errorf("failed to serialize app resource: %s", err)
-				}
-				return nil
-			} else {
-				conn, appIf := argocd.NewArgocdClientOrDie().NewApplicationClientOrDie()
+			rootApp, err := cluster.ConstructRootApp(ctx, kubeClient, argocdNs, arlonNs, clusterName, repoUrl, repoBranch, basePath, clusterSpecName, profileName, project, destinationServer)
+			if err != nil {
+				return fmt.Errorf("failed to construct root app: %w", err)
+			}
+			if err := cluster.SetClusterLabels(rootApp, clusterLabels); err != nil {
+				return err
+			}
+			var conn io.Closer
+			var appIf applicationpkg.ApplicationServiceClient
+			if !outputYaml {
+				// fail before pushing if the cluster can't be deployed or updated
+				argocdClient := argocd.NewArgocdClientOrDie()
+				conn, appIf = argocdClient.NewApplicationClientOrDie()
 				defer conn.Close()
-				appCreateRequest := applicationpkg.ApplicationCreateRequest{
-					Application: *rootApp,
+				if err := cluster.CheckRootApp(ctx, appIf, rootApp); err != nil {
+					return err
 				}
-				_, err := appIf.Create(context.Background(), &appCreateRequest)
+				projConn, projIf := argocdClient.NewProjectClientOrDie()
+				defer projConn.Close()
+				if err := cluster.CheckProject(ctx, projIf, rootApp); err != nil {
+					return err
+				}
+			}
+			repo := gitutils.NewRepo()
+			defer repo.Close()
+			commitSha, err := cluster.DeployToGit(ctx, kubeClient, repo, argocdNs, arlonNs, clusterName, repoUrl, repoBranch, basePath, profileName, clusterSpecName, createBranch)
+			if err != nil {
+				notifier.Notify(notify.Event{
+					Type:        notify.EventDeployFailed,
+					ClusterName: clusterName,
+					Message:     fmt.Sprintf("failed to deploy git tree: %s", err),
+				})
+				return fmt.Errorf("failed to deploy git tree: %w", err)
+			}
+			result.CommitSha = commitSha
+			if outputYaml {
+				return writeRootApp(rootApp, os.Stdout)
+			} else {
+				progress.Step(ctx, "applying root application %s", clusterName)
+				created, err := cluster.ApplyRootApp(ctx, kubeClient, appIf, rootApp, commitSha)
 				if err != nil {
-					return fmt.Errorf("failed to create ArgoCD root application: %s", err)
+					notifier.Notify(notify.Event{
+						Type:        notify.EventDeployFailed,
+						ClusterName: clusterName,
+						CommitSha:   commitSha,
+						Message:     err.Error(),
+					})
+					return err
+				}
+				msg := fmt.Sprintf("cluster deployed to %s", repoUrl)
+				if !created {
+					msg = "cluster updated in place"
+					result.Operation = "update"
 				}
+				notifier.Notify(notify.Event{
+					Type:        notify.EventClusterDeployed,
+					ClusterName: clusterName,
+					CommitSha:   commitSha,
+					Message:     msg,
+				})
 				return nil
 			}
 		},
@@ -79,12 +160,35 @@ func deployClusterCommand() *cobra.Command {
 	command.Flags().StringVar(&repoUrl, "repo-url", "", "the git repository url")
 	command.Flags().StringVar(&repoBranch, "repo-branch", "main", "the git branch")
 	command.Flags().StringVar(&clusterName, "cluster-name", "", "the cluster name")
-	command.Flags().StringVar(&profileName, "profile", "", "the configuration profile to use")
-	command.Flags().StringVar(&clusterSpecName, "cluster-spec", "", "the clusterspec to use")
+	command.Flags().StringVar(&profileName, "profile", "", "the configuration profile to use, optionally namespace qualified (ns/name)")
+	command.Flags().StringVar(&clusterSpecName, "cluster-spec", "", "the clusterspec to use, optionally namespace qualified (ns/name)")
+	command.RegisterFlagCompletionFunc("profile", cliutil.CompleteProfiles)
+	command.RegisterFlagCompletionFunc("cluster-spec", cliutil.CompleteClusterSpecs)
 	command.Flags().StringVar(&basePath, "path", "arlon", "the git repository base path")
+	command.Flags().StringVar(&createBranch, "create-branch", "", "create the git branch if it doesn't exist, from the default branch (default) or without history (orphan)")
+	command.Flags().StringVar(&project, "project", "default", "the ArgoCD project of the root application, whose restrictions it must satisfy")
+	command.Flags().StringVar(&destinationServer, "destination-server", "", "target the workload cluster by this server URL instead of by its name in ArgoCD")
+	command.Flags().StringVar(&destinationSelector, "destination-selector", "", "target the workload cluster by the server URL of the ArgoCD cluster secret matching this label selector")
+	command.Flags().StringToStringVar(&clusterLabels, "labels", nil, "labels grouping the cluster for fleet commands' selectors, for e.g. env=staging,region=eu (labels already set on a deployed cluster are kept)")
 	command.Flags().BoolVar(&outputYaml, "output-yaml", false, "output root application YAML instead of deploying to ArgoCD")
 	command.MarkFlagRequired("repo-url")
 	command.MarkFlagRequired("cluster-name")
 	return command
 }
 
+
+// writeRootApp serializes the root application as YAML.
+func writeRootApp(rootApp *v1alpha1.Application, w io.Writer) error {
+	scheme := runtime.NewScheme()
+	v1alpha1.AddToScheme(scheme)
+	s := json.NewSerializerWithOptions(json.DefaultMetaFactory, scheme, scheme, json.SerializerOptions{
+		Yaml:   true,
+		Pretty: true,
+		Strict: false,
+	})
+	err := s.Encode(rootApp, w)
+	if err != nil {
+		return fmt.Errorf("failed to serialize app resource: %w", err)
+	}
+	return nil
+}
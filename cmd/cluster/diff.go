@@ -0,0 +1,71 @@
+package cluster
+
+import (
+	"arlon.io/arlon/pkg/argocd"
+	"arlon.io/arlon/pkg/cliutil"
+	"arlon.io/arlon/pkg/cluster"
+	"arlon.io/arlon/pkg/gitutils"
+	"fmt"
+	"github.com/argoproj/argo-cd/v2/util/cli"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"os"
+)
+
+func diffClusterCommand() *cobra.Command {
+	var clientConfig clientcmd.ClientConfig
+	var argocdNs string
+	var arlonNs string
+	var repoUrl string
+	var repoBranch string
+	var basePath string
+	var clusterSpecName string
+	var profileName string
+	command := &cobra.Command{
+		Use:   "diff <name>",
+		Short: "Show how deploying a cluster would change the git repository",
+		Long: "Print a diff between the cluster's directory in the git repository " +
+			"and the files deploy would render from the current profile, bundles " +
+			"and clusterspec, without pushing anything. Without --repo-url, the " +
+			"repository, profile and clusterspec of the deployed cluster are used, " +
+			"unless overridden by --profile and --cluster-spec.",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: cliutil.CompleteArgs(cliutil.CompleteClusters),
+		RunE: func(c *cobra.Command, args []string) error {
+			ctx, cancel := cliutil.Context(c)
+			defer cancel()
+			config, err := clientConfig.ClientConfig()
+			if err != nil {
+				return fmt.Errorf("failed to get k8s client config: %w", err)
+			}
+			kubeClient := kubernetes.NewForConfigOrDie(config)
+			repo := gitutils.NewRepo()
+			defer repo.Close()
+			if repoUrl == "" {
+				conn, appIf := argocd.NewArgocdClientOrDie().NewApplicationClientOrDie()
+				defer conn.Close()
+				_, err = cluster.DiffDeployed(ctx, kubeClient, repo, appIf, argocdNs, arlonNs, args[0], profileName, clusterSpecName, os.Stdout)
+			} else {
+				_, err = cluster.Diff(ctx, kubeClient, repo, argocdNs, arlonNs, args[0], repoUrl, repoBranch, basePath, profileName, clusterSpecName, os.Stdout)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to diff cluster: %w", err)
+			}
+			return nil
+		},
+	}
+	clientConfig = cli.AddKubectlFlagsToCmd(command)
+	command.Flags().StringVar(&argocdNs, "argocd-ns", "argocd", "the argocd namespace")
+	command.Flags().StringVar(&arlonNs, "arlon-ns", "arlon", "the arlon namespace")
+	command.Flags().StringVar(&repoUrl, "repo-url", "", "the git repository url, the deployed cluster's by default")
+	command.Flags().StringVar(&repoBranch, "repo-branch", "main", "the git branch")
+	command.Flags().StringVar(&profileName, "profile", "", "the configuration profile to use, optionally namespace qualified (ns/name)")
+	command.Flags().StringVar(&clusterSpecName, "cluster-spec", "", "the clusterspec to use, optionally namespace qualified (ns/name)")
+	command.RegisterFlagCompletionFunc("profile", cliutil.CompleteProfiles)
+	command.RegisterFlagCompletionFunc("cluster-spec", cliutil.CompleteClusterSpecs)
+	command.Flags().StringVar(&basePath, "path", "arlon", "the git repository base path")
+	// an unset --repo-url diffs the deployed cluster, whatever the context
+	command.Flags().SetAnnotation("repo-url", cliutil.NoContextDefaultAnnotation, []string{"true"})
+	return command
+}
@@ -0,0 +1,62 @@
+package cluster
+
+import (
+	"arlon.io/arlon/pkg/cliutil"
+	"arlon.io/arlon/pkg/cluster"
+	"fmt"
+	"github.com/argoproj/argo-cd/v2/util/cli"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"os"
+)
+
+func getKubeconfigCommand() *cobra.Command {
+	var clientConfig clientcmd.ClientConfig
+	var outputFile string
+	var merge bool
+	var setCurrent bool
+	command := &cobra.Command{
+		Use:   "getkubeconfig <cluster>",
+		Short: "Get the kubeconfig of a workload cluster",
+		Long: "Get the kubeconfig of a provisioned workload cluster and print it, " +
+			"write it to a file, or merge it into your kubeconfig",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: cliutil.CompleteArgs(cliutil.CompleteClusters),
+		RunE: func(c *cobra.Command, args []string) error {
+			ctx, cancel := cliutil.Context(c)
+			defer cancel()
+			if merge && outputFile != "" {
+				return fmt.Errorf("--merge and --output are mutually exclusive")
+			}
+			config, err := clientConfig.ClientConfig()
+			if err != nil {
+				return fmt.Errorf("failed to get k8s client config: %w", err)
+			}
+			kubeClient := kubernetes.NewForConfigOrDie(config)
+			data, err := cluster.GetKubeconfig(ctx, kubeClient, args[0])
+			if err != nil {
+				return err
+			}
+			if merge {
+				configPath := clientcmd.NewDefaultPathOptions().GetDefaultFilename()
+				return cluster.MergeKubeconfig(data, args[0], configPath, setCurrent)
+			}
+			if outputFile != "" {
+				err = os.WriteFile(outputFile, data, 0600)
+				if err != nil {
+					return fmt.Errorf("failed to write kubeconfig file: %w", err)
+				}
+				return nil
+			}
+			_, err = os.Stdout.Write(data)
+			return err
+		},
+	}
+	clientConfig = cli.AddKubectlFlagsToCmd(command)
+	command.Flags().StringVar(&outputFile, "output", "", "write the kubeconfig to this file instead of stdout")
+	command.Flags().SetAnnotation("output", cliutil.NoContextDefaultAnnotation, []string{"true"})
+	command.Flags().BoolVar(&merge, "merge", false, "merge the kubeconfig into your kubeconfig file")
+	command.Flags().BoolVar(&setCurrent, "set-current", false, "with --merge, make the cluster's context the current one")
+	return command
+}
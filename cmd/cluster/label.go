@@ -0,0 +1,41 @@
+package cluster
+
+import (
+	"arlon.io/arlon/pkg/argocd"
+	"arlon.io/arlon/pkg/cliutil"
+	"arlon.io/arlon/pkg/cluster"
+	"fmt"
+	"github.com/argoproj/argo-cd/v2/util/io"
+	"github.com/spf13/cobra"
+	"strings"
+)
+
+func labelClusterCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "label <cluster> <key>=<value>... <key>-...",
+		Short: "Set or remove labels of a cluster",
+		Long: "Set the labels grouping a deployed cluster, which fleet commands " +
+			"select clusters by with -l, or remove those given as <key>-.",
+		Args:              cobra.MinimumNArgs(2),
+		ValidArgsFunction: cliutil.CompleteArgs(cliutil.CompleteClusters),
+		RunE: func(c *cobra.Command, args []string) error {
+			ctx, cancel := cliutil.Context(c)
+			defer cancel()
+			set := make(map[string]string)
+			var remove []string
+			for _, arg := range args[1:] {
+				if kv := strings.SplitN(arg, "=", 2); len(kv) == 2 {
+					set[kv[0]] = kv[1]
+				} else if strings.HasSuffix(arg, "-") {
+					remove = append(remove, strings.TrimSuffix(arg, "-"))
+				} else {
+					return fmt.Errorf("invalid label %s, expected <key>=<value> or <key>-", arg)
+				}
+			}
+			conn, appIf := argocd.NewArgocdClientOrDie().NewApplicationClientOrDie()
+			defer io.Close(conn)
+			return cluster.LabelCluster(ctx, appIf, args[0], set, remove)
+		},
+	}
+	return command
+}
@@ -0,0 +1,84 @@
+package cluster
+
+import (
+	"arlon.io/arlon/pkg/argocd"
+	"arlon.io/arlon/pkg/cliutil"
+	"arlon.io/arlon/pkg/cluster"
+	"arlon.io/arlon/pkg/gitutils"
+	"fmt"
+	"github.com/argoproj/argo-cd/v2/util/cli"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+func attachProfileCommand() *cobra.Command {
+	var clientConfig clientcmd.ClientConfig
+	var argocdNs string
+	var arlonNs string
+	command := &cobra.Command{
+		Use:   "attach-profile <cluster> <profile>",
+		Short: "Attach a profile to a deployed cluster",
+		Long: "Attach a profile to a deployed cluster, replacing its current one: " +
+			"only the bundles of the cluster's git directory are rewritten, the " +
+			"cluster itself is not redeployed. The profile may be namespace " +
+			"qualified (ns/name).",
+		Args:              cobra.ExactArgs(2),
+		ValidArgsFunction: cliutil.CompleteArgs(cliutil.CompleteClusters, cliutil.CompleteProfiles),
+		RunE: func(c *cobra.Command, args []string) error {
+			return setProfile(c, clientConfig, argocdNs, arlonNs, args[0], args[1])
+		},
+	}
+	clientConfig = cli.AddKubectlFlagsToCmd(command)
+	command.Flags().StringVar(&argocdNs, "argocd-ns", "argocd", "the argocd namespace")
+	command.Flags().StringVar(&arlonNs, "arlon-ns", "arlon", "the arlon namespace")
+	return command
+}
+
+func detachProfileCommand() *cobra.Command {
+	var clientConfig clientcmd.ClientConfig
+	var argocdNs string
+	var arlonNs string
+	command := &cobra.Command{
+		Use:   "detach-profile <cluster>",
+		Short: "Detach the profile of a deployed cluster",
+		Long: "Detach the profile of a deployed cluster, removing its bundles " +
+			"from the cluster's git directory without redeploying the cluster.",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: cliutil.CompleteArgs(cliutil.CompleteClusters),
+		RunE: func(c *cobra.Command, args []string) error {
+			return setProfile(c, clientConfig, argocdNs, arlonNs, args[0], "")
+		},
+	}
+	clientConfig = cli.AddKubectlFlagsToCmd(command)
+	command.Flags().StringVar(&argocdNs, "argocd-ns", "argocd", "the argocd namespace")
+	command.Flags().StringVar(&arlonNs, "arlon-ns", "arlon", "the arlon namespace")
+	return command
+}
+
+func setProfile(
+	c *cobra.Command,
+	clientConfig clientcmd.ClientConfig,
+	argocdNs string,
+	arlonNs string,
+	clusterName string,
+	profileName string,
+) error {
+	ctx, cancel := cliutil.Context(c)
+	defer cancel()
+	config, err := clientConfig.ClientConfig()
+	if err != nil {
+		return fmt.Errorf("failed to get k8s client config: %w", err)
+	}
+	kubeClient := kubernetes.NewForConfigOrDie(config)
+	ctx = cliutil.WithKubeIdentity(ctx, config, kubeClient)
+	conn, appIf := argocd.NewArgocdClientOrDie().NewApplicationClientOrDie()
+	defer conn.Close()
+	repo := gitutils.NewRepo()
+	defer repo.Close()
+	_, err = cluster.SetProfile(ctx, kubeClient, repo, appIf, argocdNs, arlonNs, clusterName, profileName)
+	if err != nil {
+		return fmt.Errorf("failed to set profile of cluster %s: %w", clusterName, err)
+	}
+	return nil
+}
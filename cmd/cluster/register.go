@@ -0,0 +1,44 @@
+package cluster
+
+import (
+	"arlon.io/arlon/pkg/cliutil"
+	"arlon.io/arlon/pkg/cluster"
+	"fmt"
+	"github.com/argoproj/argo-cd/v2/util/cli"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+func registerClusterCommand() *cobra.Command {
+	var clientConfig clientcmd.ClientConfig
+	var argocdNs string
+	command := &cobra.Command{
+		Use:   "register <cluster>",
+		Short: "Register a workload cluster with ArgoCD",
+		Long: "Register a provisioned workload cluster with ArgoCD under the cluster's name, " +
+			"using the kubeconfig generated by Cluster API. The arlon controller does this " +
+			"automatically; this command registers clusters it couldn't, or re-registers " +
+			"a cluster whose credentials changed.",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: cliutil.CompleteArgs(cliutil.CompleteClusters),
+		RunE: func(c *cobra.Command, args []string) error {
+			ctx, cancel := cliutil.Context(c)
+			defer cancel()
+			config, err := clientConfig.ClientConfig()
+			if err != nil {
+				return fmt.Errorf("failed to get k8s client config: %w", err)
+			}
+			kubeClient := kubernetes.NewForConfigOrDie(config)
+			server, err := cluster.RegisterCluster(ctx, kubeClient, argocdNs, args[0])
+			if err != nil {
+				return fmt.Errorf("failed to register cluster: %w", err)
+			}
+			fmt.Printf("registered cluster %s (%s) with argocd\n", args[0], server)
+			return nil
+		},
+	}
+	clientConfig = cli.AddKubectlFlagsToCmd(command)
+	command.Flags().StringVar(&argocdNs, "argocd-ns", "argocd", "the argocd namespace")
+	return command
+}
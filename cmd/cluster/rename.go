@@ -0,0 +1,63 @@
+package cluster
+
+import (
+	"arlon.io/arlon/pkg/argocd"
+	"arlon.io/arlon/pkg/cliutil"
+	"arlon.io/arlon/pkg/cluster"
+	"arlon.io/arlon/pkg/gitutils"
+	"arlon.io/arlon/pkg/notify"
+	"fmt"
+	"github.com/argoproj/argo-cd/v2/util/cli"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+func renameClusterCommand() *cobra.Command {
+	var clientConfig clientcmd.ClientConfig
+	var argocdNs string
+	var arlonNs string
+	command := &cobra.Command{
+		Use:   "rename <cluster> <newName>",
+		Short: "Rename cluster",
+		Long: "Rename cluster: move its git directory, re-render its bundle " +
+			"applications and recreate its root application under the new name. " +
+			"Since the cluster chart names the workload cluster's resources after " +
+			"the cluster, only clusters that aren't provisioned yet can be renamed.",
+		Args:              cobra.ExactArgs(2),
+		ValidArgsFunction: cliutil.CompleteArgs(cliutil.CompleteClusters),
+		RunE: func(c *cobra.Command, args []string) error {
+			ctx, cancel := cliutil.Context(c)
+			defer cancel()
+			config, err := clientConfig.ClientConfig()
+			if err != nil {
+				return fmt.Errorf("failed to get k8s client config: %w", err)
+			}
+			kubeClient := kubernetes.NewForConfigOrDie(config)
+			ctx = cliutil.WithKubeIdentity(ctx, config, kubeClient)
+			notifier, err := notify.LoadDispatcher(ctx, kubeClient, arlonNs)
+			if err != nil {
+				return fmt.Errorf("failed to load notification settings: %w", err)
+			}
+			conn, appIf := argocd.NewArgocdClientOrDie().NewApplicationClientOrDie()
+			defer conn.Close()
+			repo := gitutils.NewRepo()
+			defer repo.Close()
+			commitSha, err := cluster.Rename(ctx, kubeClient, repo, appIf, argocdNs, arlonNs, args[0], args[1])
+			if err != nil {
+				return fmt.Errorf("failed to rename cluster: %w", err)
+			}
+			notifier.Notify(notify.Event{
+				Type:        notify.EventClusterRenamed,
+				ClusterName: args[1],
+				CommitSha:   commitSha,
+				Message:     fmt.Sprintf("cluster %s renamed to %s", args[0], args[1]),
+			})
+			return nil
+		},
+	}
+	clientConfig = cli.AddKubectlFlagsToCmd(command)
+	command.Flags().StringVar(&argocdNs, "argocd-ns", "argocd", "the argocd namespace")
+	command.Flags().StringVar(&arlonNs, "arlon-ns", "arlon", "the arlon namespace")
+	return command
+}
@@ -0,0 +1,83 @@
+package cluster
+
+import (
+	"arlon.io/arlon/pkg/cliutil"
+	"arlon.io/arlon/pkg/cluster"
+	"fmt"
+	"github.com/argoproj/argo-cd/v2/util/cli"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"os"
+	"path/filepath"
+)
+
+func renderClusterCommand() *cobra.Command {
+	var clientConfig clientcmd.ClientConfig
+	var argocdNs string
+	var arlonNs string
+	var repoUrl string
+	var repoBranch string
+	var basePath string
+	var clusterSpecName string
+	var profileName string
+	var outDir string
+	var project string
+	var destinationServer string
+	var destinationSelector string
+	command := &cobra.Command{
+		Use:   "render <name>",
+		Short: "Render cluster configuration to a local directory",
+		Long: "Render the files that deploy would commit to the git repository " +
+			"into a local directory, laid out as in the repository, without " +
+			"cloning or pushing. The root application is written to " +
+			"root-app.yaml at the top of the directory.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			ctx, cancel := cliutil.Context(c)
+			defer cancel()
+			config, err := clientConfig.ClientConfig()
+			if err != nil {
+				return fmt.Errorf("failed to get k8s client config: %w", err)
+			}
+			kubeClient := kubernetes.NewForConfigOrDie(config)
+			clusterName := args[0]
+			destinationServer, err := cluster.ResolveDestinationServer(ctx, kubeClient.CoreV1(), argocdNs,
+				destinationServer, destinationSelector)
+			if err != nil {
+				return err
+			}
+			rootApp, err := cluster.ConstructRootApp(ctx, kubeClient, argocdNs, arlonNs, clusterName, repoUrl, repoBranch, basePath, clusterSpecName, profileName, project, destinationServer)
+			if err != nil {
+				return fmt.Errorf("failed to construct root app: %w", err)
+			}
+			err = cluster.Render(ctx, kubeClient, argocdNs, arlonNs, clusterName, repoUrl, repoBranch, basePath, profileName, clusterSpecName, outDir)
+			if err != nil {
+				return fmt.Errorf("failed to render cluster: %w", err)
+			}
+			f, err := os.Create(filepath.Join(outDir, "root-app.yaml"))
+			if err != nil {
+				return fmt.Errorf("failed to create root application file: %w", err)
+			}
+			defer f.Close()
+			return writeRootApp(rootApp, f)
+		},
+	}
+	clientConfig = cli.AddKubectlFlagsToCmd(command)
+	command.Flags().StringVar(&argocdNs, "argocd-ns", "argocd", "the argocd namespace")
+	command.Flags().StringVar(&arlonNs, "arlon-ns", "arlon", "the arlon namespace")
+	command.Flags().StringVar(&repoUrl, "repo-url", "", "the git repository url")
+	command.Flags().StringVar(&repoBranch, "repo-branch", "main", "the git branch")
+	command.Flags().StringVar(&profileName, "profile", "", "the configuration profile to use, optionally namespace qualified (ns/name)")
+	command.Flags().StringVar(&clusterSpecName, "cluster-spec", "", "the clusterspec to use, optionally namespace qualified (ns/name)")
+	command.RegisterFlagCompletionFunc("profile", cliutil.CompleteProfiles)
+	command.RegisterFlagCompletionFunc("cluster-spec", cliutil.CompleteClusterSpecs)
+	command.Flags().StringVar(&basePath, "path", "arlon", "the git repository base path")
+	command.Flags().StringVar(&project, "project", "default", "the ArgoCD project of the root application")
+	command.Flags().StringVar(&destinationServer, "destination-server", "", "target the workload cluster by this server URL instead of by its name in ArgoCD")
+	command.Flags().StringVar(&destinationSelector, "destination-selector", "", "target the workload cluster by the server URL of the ArgoCD cluster secret matching this label selector")
+	command.Flags().StringVar(&outDir, "out", "", "the output directory")
+	command.MarkFlagRequired("repo-url")
+	command.MarkFlagRequired("out")
+	return command
+}
@@ -0,0 +1,71 @@
+package cluster
+
+import (
+	"arlon.io/arlon/pkg/argocd"
+	"arlon.io/arlon/pkg/cliutil"
+	"arlon.io/arlon/pkg/cluster"
+	"arlon.io/arlon/pkg/gitutils"
+	"arlon.io/arlon/pkg/notify"
+	"fmt"
+	"github.com/argoproj/argo-cd/v2/util/cli"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"strings"
+)
+
+func rollbackClusterCommand() *cobra.Command {
+	var clientConfig clientcmd.ClientConfig
+	var argocdNs string
+	var arlonNs string
+	var restoreRootApp bool
+	command := &cobra.Command{
+		Use:   "rollback <cluster>",
+		Short: "Revert the last change to a cluster's git directory",
+		Long: "Rollback cluster: revert the most recent operation of arlon on the " +
+			"cluster's directory in the git repository, all of its commits if it made " +
+			"several, and push the revert. Later commits made by hand are kept. " +
+			"With --restore-root-app, the root application's Helm parameters are " +
+			"also restored from the clusterspec values recorded before that operation.",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: cliutil.CompleteArgs(cliutil.CompleteClusters),
+		RunE: func(c *cobra.Command, args []string) error {
+			ctx, cancel := cliutil.Context(c)
+			defer cancel()
+			config, err := clientConfig.ClientConfig()
+			if err != nil {
+				return fmt.Errorf("failed to get k8s client config: %w", err)
+			}
+			kubeClient := kubernetes.NewForConfigOrDie(config)
+			ctx = cliutil.WithKubeIdentity(ctx, config, kubeClient)
+			notifier, err := notify.LoadDispatcher(ctx, kubeClient, arlonNs)
+			if err != nil {
+				return fmt.Errorf("failed to load notification settings: %w", err)
+			}
+			conn, appIf := argocd.NewArgocdClientOrDie().NewApplicationClientOrDie()
+			defer conn.Close()
+			repo := gitutils.NewRepo()
+			defer repo.Close()
+			reverted, commitSha, err := cluster.Rollback(ctx, kubeClient, repo, appIf, argocdNs, arlonNs, args[0], restoreRootApp)
+			if err != nil {
+				return fmt.Errorf("failed to roll back cluster: %w", err)
+			}
+			for _, sha := range reverted {
+				fmt.Printf("reverted commit %s\n", sha)
+			}
+			notifier.Notify(notify.Event{
+				Type:        notify.EventClusterRolledBack,
+				ClusterName: args[0],
+				CommitSha:   commitSha,
+				Message: fmt.Sprintf("cluster %s rolled back, reverting commits %s", args[0],
+					strings.Join(reverted, ", ")),
+			})
+			return nil
+		},
+	}
+	clientConfig = cli.AddKubectlFlagsToCmd(command)
+	command.Flags().StringVar(&argocdNs, "argocd-ns", "argocd", "the argocd namespace")
+	command.Flags().StringVar(&arlonNs, "arlon-ns", "arlon", "the arlon namespace")
+	command.Flags().BoolVar(&restoreRootApp, "restore-root-app", false, "also restore the root application's Helm parameters")
+	return command
+}
@@ -12,6 +12,8 @@ func NewCommand() *cobra.Command {
 		},
 	}
 	command.AddCommand(listClusterspecsCommand())
+	command.AddCommand(diffClusterspecCommand())
+	command.AddCommand(deleteClusterspecCommand())
 	return command
 }
 
@@ -0,0 +1,76 @@
+package clusterspec
+
+import (
+	"arlon.io/arlon/pkg/argocd"
+	"arlon.io/arlon/pkg/cliutil"
+	"arlon.io/arlon/pkg/cluster"
+	"context"
+	"errors"
+	"fmt"
+	applicationpkg "github.com/argoproj/argo-cd/v2/pkg/apiclient/application"
+	appclientset "github.com/argoproj/argo-cd/v2/pkg/client/clientset/versioned"
+	"github.com/argoproj/argo-cd/v2/util/cli"
+	"github.com/spf13/cobra"
+	"io"
+	"k8s.io/client-go/kubernetes"
+	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+func deleteClusterspecCommand() *cobra.Command {
+	var clientConfig clientcmd.ClientConfig
+	var argocdNs string
+	var ns string
+	var cascade bool
+	command := &cobra.Command{
+		Use:   "delete",
+		Short: "Delete clusterspec",
+		Long: "Delete clusterspec. A clusterspec that deployed clusters use is only deleted " +
+			"with --cascade, which removes it from their root applications' labels: the " +
+			"clusters keep running with their current values, but aren't listed by " +
+			"clusterspec diff anymore and need a clusterspec to be deployed again. A " +
+			"clusterspec that other clusterspecs are based on is never deleted.",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: cliutil.CompleteArgs(cliutil.CompleteClusterSpecs),
+		RunE: func(c *cobra.Command, args []string) error {
+			ctx, cancel := cliutil.Context(c)
+			defer cancel()
+			config, err := clientConfig.ClientConfig()
+			if err != nil {
+				return fmt.Errorf("failed to get k8s client config: %w", err)
+			}
+			return deleteClusterSpec(ctx, config, argocdNs, ns, args[0], cascade)
+		},
+	}
+	clientConfig = cli.AddKubectlFlagsToCmd(command)
+	command.Flags().StringVar(&argocdNs, "argocd-ns", "argocd", "the argocd namespace")
+	command.Flags().StringVar(&ns, "ns", "arlon", "the arlon namespace")
+	command.Flags().BoolVar(&cascade, "cascade", false, "remove the clusterspec from the clusters using it, then delete it")
+	return command
+}
+
+func deleteClusterSpec(
+	ctx context.Context,
+	config *restclient.Config,
+	argocdNs string,
+	ns string,
+	clusterSpecName string,
+	cascade bool,
+) error {
+	kubeClient := kubernetes.NewForConfigOrDie(config)
+	appClient := appclientset.NewForConfigOrDie(config)
+	// only updating the root applications needs an ArgoCD session
+	newAppIf := func() (io.Closer, applicationpkg.ApplicationServiceClient) {
+		return argocd.NewArgocdClientOrDie().NewApplicationClientOrDie()
+	}
+	updated, err := cluster.DeleteClusterSpec(ctx, kubeClient, appClient.ArgoprojV1alpha1().Applications(argocdNs),
+		newAppIf, ns, clusterSpecName, cascade)
+	for _, clusterName := range updated {
+		fmt.Printf("removed clusterspec from cluster %s\n", clusterName)
+	}
+	var inUse *cluster.InUseError
+	if errors.As(err, &inUse) && len(inUse.ClusterSpecs) == 0 {
+		return fmt.Errorf("%w (--cascade removes it from them first)", err)
+	}
+	return err
+}
@@ -0,0 +1,102 @@
+package clusterspec
+
+import (
+	"arlon.io/arlon/pkg/argocd"
+	"arlon.io/arlon/pkg/cliutil"
+	"arlon.io/arlon/pkg/cluster"
+	"fmt"
+	"github.com/argoproj/argo-cd/v2/util/cli"
+	"github.com/spf13/cobra"
+	"io"
+	corev1 "k8s.io/api/core/v1"
+	k8syaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"os"
+	"strings"
+)
+
+func diffClusterspecCommand() *cobra.Command {
+	var clientConfig clientcmd.ClientConfig
+	var ns string
+	var fromFile string
+	command := &cobra.Command{
+		Use:   "diff",
+		Short: "Preview the impact of a clusterspec change",
+		Long: "Compare the clusterspec ConfigMap manifest of --from-file with the stored " +
+			"clusterspec, and list the deployed clusters using it, or a clusterspec " +
+			"based on it, whose root application Helm parameters would change. Changes " +
+			"that replace a cluster, its network or its nodes are flagged as disruptive. " +
+			"Nothing is applied.",
+		Args: cobra.NoArgs,
+		RunE: func(c *cobra.Command, args []string) error {
+			ctx, cancel := cliutil.Context(c)
+			defer cancel()
+			config, err := clientConfig.ClientConfig()
+			if err != nil {
+				return fmt.Errorf("failed to get k8s client config: %w", err)
+			}
+			f, err := os.Open(fromFile)
+			if err != nil {
+				return fmt.Errorf("failed to open clusterspec file: %w", err)
+			}
+			defer f.Close()
+			var proposed corev1.ConfigMap
+			if err := k8syaml.NewYAMLOrJSONDecoder(f, 4096).Decode(&proposed); err != nil {
+				return fmt.Errorf("failed to parse clusterspec file: %w", err)
+			}
+			if proposed.Kind != "ConfigMap" || proposed.Name == "" {
+				return fmt.Errorf("%s is not a named ConfigMap manifest", fromFile)
+			}
+			kubeClient := kubernetes.NewForConfigOrDie(config)
+			conn, appIf := argocd.NewArgocdClientOrDie().NewApplicationClientOrDie()
+			defer conn.Close()
+			specChanges, impacts, err := cluster.ClusterSpecImpact(ctx, kubeClient, appIf, ns, &proposed)
+			if err != nil {
+				return fmt.Errorf("failed to diff clusterspec: %w", err)
+			}
+			fmt.Printf("clusterspec %s/%s:\n", proposed.Namespace, proposed.Name)
+			printChanges(os.Stdout, specChanges)
+			if len(impacts) == 0 {
+				fmt.Println("no deployed cluster would change")
+				return nil
+			}
+			for _, impact := range impacts {
+				fmt.Printf("cluster %s:\n", impact.ClusterName)
+				printChanges(os.Stdout, impact.Changes)
+			}
+			return nil
+		},
+	}
+	clientConfig = cli.AddKubectlFlagsToCmd(command)
+	command.Flags().StringVar(&ns, "ns", "arlon", "the arlon namespace, and that of the clusterspec unless its manifest sets one")
+	command.Flags().StringVarP(&fromFile, "from-file", "f", "", "the ConfigMap manifest of the proposed clusterspec")
+	command.MarkFlagRequired("from-file")
+	return command
+}
+
+func printChanges(w io.Writer, changes []cluster.Change) {
+	if len(changes) == 0 {
+		_, _ = fmt.Fprintln(w, "  (no changes)")
+	}
+	for _, change := range changes {
+		flag := ""
+		if change.Disruptive {
+			flag = " (disruptive)"
+		}
+		_, _ = fmt.Fprintf(w, "  %s: %s -> %s%s\n", change.Name,
+			formatValue(change.Old), formatValue(change.New), flag)
+	}
+}
+
+// formatValue shortens multi-line values, such as helmValues blocks, which
+// can be compared with arlon cluster diff.
+func formatValue(val string) string {
+	if val == "" {
+		return "(unset)"
+	}
+	if strings.Contains(strings.TrimSpace(val), "\n") {
+		return fmt.Sprintf("(%d lines)", strings.Count(strings.TrimRight(val, "\n"), "\n")+1)
+	}
+	return strings.TrimSpace(val)
+}
@@ -0,0 +1,148 @@
+package context
+
+import (
+	"arlon.io/arlon/pkg/cliutil"
+	"arlon.io/arlon/pkg/config"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"text/tabwriter"
+)
+
+func NewCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "context",
+		Short: "Manage the contexts of management clusters",
+		Long: "Manage named contexts, each holding the kubeconfig, namespaces and " +
+			"repository settings of a management cluster. The options of every " +
+			"command default to the settings of the current context.",
+		DisableAutoGenTag: true,
+		Annotations:       map[string]string{cliutil.SkipContextAnnotation: "true"},
+		Run: func(c *cobra.Command, args []string) {
+		},
+	}
+	command.AddCommand(addContextCommand())
+	command.AddCommand(useContextCommand())
+	command.AddCommand(listContextsCommand())
+	command.AddCommand(deleteContextCommand())
+	return command
+}
+
+// updateConfig loads the configuration file, applies update to it and
+// saves it.
+func updateConfig(update func(cfg *config.Config) error) error {
+	path, err := config.DefaultPath()
+	if err != nil {
+		return err
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		return err
+	}
+	if err := update(cfg); err != nil {
+		return err
+	}
+	return cfg.Save(path)
+}
+
+func addContextCommand() *cobra.Command {
+	var ctx config.Context
+	var use bool
+	command := &cobra.Command{
+		Use:   "add <name>",
+		Short: "Add or replace a context",
+		Long:  "Add or replace a context",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(c *cobra.Command, args []string) error {
+			ctx.Name = args[0]
+			return updateConfig(func(cfg *config.Config) error {
+				cfg.Set(ctx)
+				if use || len(cfg.Contexts) == 1 {
+					cfg.CurrentContext = ctx.Name
+				}
+				return nil
+			})
+		},
+	}
+	command.Flags().StringVar(&ctx.Kubeconfig, "kubeconfig", "", "the management cluster's kubeconfig file")
+	command.Flags().StringVar(&ctx.KubeContext, "kube-context", "", "the context in the kubeconfig file")
+	command.Flags().StringVar(&ctx.ArgocdContext, "argocd-context", "", "the argocd CLI context of the cluster's ArgoCD server")
+	command.Flags().StringVar(&ctx.ArgocdNs, "argocd-ns", "", "the argocd namespace")
+	command.Flags().StringVar(&ctx.ArlonNs, "arlon-ns", "", "the arlon namespace")
+	command.Flags().StringVar(&ctx.RepoUrl, "repo-url", "", "the default git repository url")
+	command.Flags().StringVar(&ctx.RepoBranch, "repo-branch", "", "the default git branch")
+	command.Flags().StringVar(&ctx.RepoPath, "path", "", "the default git repository base path")
+	command.Flags().BoolVar(&use, "use", false, "make it the current context")
+	return command
+}
+
+func useContextCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:               "use <name>",
+		Short:             "Set the current context",
+		Long:              "Set the current context",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: cliutil.CompleteArgs(cliutil.CompleteContexts),
+		RunE: func(c *cobra.Command, args []string) error {
+			return updateConfig(func(cfg *config.Config) error {
+				if cfg.Get(args[0]) == nil {
+					return fmt.Errorf("context %s not found", args[0])
+				}
+				cfg.CurrentContext = args[0]
+				return nil
+			})
+		},
+	}
+	return command
+}
+
+func deleteContextCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:               "delete <name>",
+		Short:             "Delete a context",
+		Long:              "Delete a context",
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: cliutil.CompleteArgs(cliutil.CompleteContexts),
+		RunE: func(c *cobra.Command, args []string) error {
+			return updateConfig(func(cfg *config.Config) error {
+				return cfg.Delete(args[0])
+			})
+		},
+	}
+	return command
+}
+
+func listContextsCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:   "list",
+		Short: "List contexts",
+		Long:  "List contexts, marking the current one with *",
+		Args:  cobra.NoArgs,
+		RunE: func(c *cobra.Command, args []string) error {
+			path, err := config.DefaultPath()
+			if err != nil {
+				return err
+			}
+			cfg, err := config.Load(path)
+			if err != nil {
+				return err
+			}
+			if len(cfg.Contexts) == 0 {
+				fmt.Println("no contexts found")
+				return nil
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			_, _ = fmt.Fprintf(w, "CURRENT\tNAME\tKUBECONFIG\tKUBECONTEXT\tARGOCD-NS\tARLON-NS\tREPO-URL\n")
+			for _, ctx := range cfg.Contexts {
+				current := ""
+				if ctx.Name == cfg.CurrentContext {
+					current = "*"
+				}
+				_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", current, ctx.Name,
+					ctx.Kubeconfig, ctx.KubeContext, ctx.ArgocdNs, ctx.ArlonNs, ctx.RepoUrl)
+			}
+			return w.Flush()
+		},
+	}
+	return command
+}
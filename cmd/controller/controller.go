@@ -9,6 +9,7 @@ func NewCommand() *cobra.Command {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var arlonNs string
 
 	command := &cobra.Command{
 		Use:               "controller",
@@ -16,7 +17,7 @@ func NewCommand() *cobra.Command {
 		Long:              "Run the Arlon controller",
 		DisableAutoGenTag: true,
 		Run: func(c *cobra.Command, args []string) {
-			controller.StartController(metricsAddr, probeAddr, enableLeaderElection)
+			controller.StartController(metricsAddr, probeAddr, enableLeaderElection, arlonNs)
 		},
 	}
 	command.Flags().StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
@@ -24,5 +25,7 @@ func NewCommand() *cobra.Command {
 	command.Flags().BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	command.Flags().StringVar(&arlonNs, "arlon-ns", "arlon",
+		"the arlon namespace, whose kubeconfig secrets are watched")
 	return command
 }
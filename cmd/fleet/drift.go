@@ -0,0 +1,89 @@
+package fleet
+
+import (
+	"arlon.io/arlon/pkg/argocd"
+	"arlon.io/arlon/pkg/cliutil"
+	"arlon.io/arlon/pkg/fleet"
+	"arlon.io/arlon/pkg/gitutils"
+	"encoding/json"
+	"fmt"
+	"github.com/argoproj/argo-cd/v2/util/cli"
+	"github.com/argoproj/argo-cd/v2/util/io"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+func driftCommand() *cobra.Command {
+	var clientConfig clientcmd.ClientConfig
+	var argocdNs string
+	var arlonNs string
+	var output string
+	var selector string
+	command := &cobra.Command{
+		Use:   "drift",
+		Short: "Show the clusters whose profile, bundles or clusterspec changed since deployment",
+		Long: "Compare the clusterspec values, profile bundles and bundle content hashes " +
+			"recorded in each cluster's summary when it was deployed with their current " +
+			"state, and list the stale clusters along with the inputs that changed",
+		Args: cobra.NoArgs,
+		RunE: func(c *cobra.Command, args []string) error {
+			ctx, cancel := cliutil.Context(c)
+			defer cancel()
+			config, err := clientConfig.ClientConfig()
+			if err != nil {
+				return fmt.Errorf("failed to get k8s client config: %w", err)
+			}
+			kubeClient := kubernetes.NewForConfigOrDie(config)
+			conn, appIf := argocd.NewArgocdClientOrDie().NewApplicationClientOrDie()
+			defer io.Close(conn)
+			drifts, err := fleet.GetDrift(ctx, kubeClient, gitutils.NewRepo, appIf, argocdNs, arlonNs, selector)
+			if err != nil {
+				return err
+			}
+			switch output {
+			case "json":
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(drifts)
+			case "table":
+				printDriftTable(drifts)
+				return nil
+			default:
+				return fmt.Errorf("unknown output format %s", output)
+			}
+		},
+	}
+	clientConfig = cli.AddKubectlFlagsToCmd(command)
+	command.Flags().StringVar(&argocdNs, "argocd-ns", "argocd", "the argocd namespace")
+	command.Flags().StringVar(&arlonNs, "arlon-ns", "arlon", "the arlon namespace")
+	command.Flags().StringVarP(&output, "output", "o", "table", "output format: table or json")
+	command.Flags().StringVarP(&selector, "selector", "l", "", "only the clusters whose labels match this selector, for e.g. env=staging")
+	return command
+}
+
+func printDriftTable(drifts []fleet.ClusterDrift) {
+	if len(drifts) == 0 {
+		fmt.Println("no clusters found")
+		return
+	}
+	stale := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintf(w, "NAME\tSTATE\tPROFILE\tCLUSTERSPEC\tCHANGES\n")
+	for _, drift := range drifts {
+		if drift.State == fleet.DriftStale {
+			stale++
+		}
+		changes := strings.Join(drift.Changes, "; ")
+		if drift.Error != "" {
+			changes = drift.Error
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", drift.Name, drift.State,
+			drift.Profile, drift.ClusterSpec, changes)
+	}
+	_ = w.Flush()
+	fmt.Printf("\n%d/%d clusters stale\n", stale, len(drifts))
+}
@@ -0,0 +1,17 @@
+package fleet
+
+import "github.com/spf13/cobra"
+
+func NewCommand() *cobra.Command {
+	command := &cobra.Command{
+		Use:               "fleet",
+		Short:             "Manage the fleet of arlon clusters",
+		Long:              "Manage the fleet of arlon clusters",
+		DisableAutoGenTag: true,
+		Run: func(c *cobra.Command, args []string) {
+		},
+	}
+	command.AddCommand(statusCommand())
+	command.AddCommand(driftCommand())
+	return command
+}
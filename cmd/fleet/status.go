@@ -0,0 +1,88 @@
+package fleet
+
+import (
+	"arlon.io/arlon/pkg/argocd"
+	"arlon.io/arlon/pkg/cliutil"
+	"arlon.io/arlon/pkg/fleet"
+	"encoding/json"
+	"fmt"
+	"github.com/argoproj/argo-cd/v2/util/cli"
+	"github.com/argoproj/argo-cd/v2/util/io"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"os"
+	"text/tabwriter"
+)
+
+func statusCommand() *cobra.Command {
+	var clientConfig clientcmd.ClientConfig
+	var output string
+	var selector string
+	command := &cobra.Command{
+		Use:   "status",
+		Short: "Show the status of all arlon clusters",
+		Long: "Show the health of each arlon cluster's root and bundle applications, " +
+			"its Kubernetes version and node counts, and whether it has converged",
+		RunE: func(c *cobra.Command, args []string) error {
+			ctx, cancel := cliutil.Context(c)
+			defer cancel()
+			config, err := clientConfig.ClientConfig()
+			if err != nil {
+				return fmt.Errorf("failed to get k8s client config: %w", err)
+			}
+			kubeClient := kubernetes.NewForConfigOrDie(config)
+			argocdClient := argocd.NewArgocdClientOrDie()
+			appConn, appIf := argocdClient.NewApplicationClientOrDie()
+			defer io.Close(appConn)
+			clusterConn, clusterIf := argocdClient.NewClusterClientOrDie()
+			defer io.Close(clusterConn)
+			statuses, err := fleet.GetStatus(ctx, kubeClient, appIf, clusterIf, selector)
+			if err != nil {
+				return err
+			}
+			switch output {
+			case "json":
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(statuses)
+			case "table":
+				printStatusTable(statuses)
+				return nil
+			default:
+				return fmt.Errorf("unknown output format %s", output)
+			}
+		},
+	}
+	clientConfig = cli.AddKubectlFlagsToCmd(command)
+	command.Flags().StringVarP(&output, "output", "o", "table", "output format: table or json")
+	command.Flags().StringVarP(&selector, "selector", "l", "", "only the clusters whose labels match this selector, for e.g. env=staging")
+	return command
+}
+
+func printStatusTable(statuses []fleet.ClusterStatus) {
+	if len(statuses) == 0 {
+		fmt.Println("no clusters found")
+		return
+	}
+	converged := 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintf(w, "NAME\tHEALTH\tSYNC\tVERSION\tNODES\tBUNDLES\tCONVERGED\n")
+	for _, status := range statuses {
+		healthyBundles := 0
+		for _, bundle := range status.Bundles {
+			if bundle.Ready() {
+				healthyBundles++
+			}
+		}
+		if status.Converged {
+			converged++
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d/%d\t%d/%d\t%t\n", status.Name,
+			status.Health, status.Sync, status.KubernetesVersion,
+			status.ReadyNodes, status.DesiredNodes,
+			healthyBundles, len(status.Bundles), status.Converged)
+	}
+	_ = w.Flush()
+	fmt.Printf("\n%d/%d clusters converged\n", converged, len(statuses))
+}
@@ -2,6 +2,7 @@ package list_clusters
 
 import (
 	"arlon.io/arlon/pkg/argocd"
+	"arlon.io/arlon/pkg/cliutil"
 	"context"
 	"fmt"
 	clusterpkg "github.com/argoproj/argo-cd/v2/pkg/apiclient/cluster"
@@ -20,16 +21,18 @@ func NewCommand() *cobra.Command {
 		Long:              "List the clusters registered with ArgoCD",
 		DisableAutoGenTag: true,
 		Run: func(c *cobra.Command, args []string) {
-			listClusters()
+			ctx, cancel := cliutil.Context(c)
+			defer cancel()
+			listClusters(ctx)
 		},
 	}
 	return command
 }
 
-func listClusters() {
+func listClusters(ctx context.Context) {
 	conn, clusterIf := argocd.NewArgocdClientOrDie().NewClusterClientOrDie()
 	defer io.Close(conn)
-	clusters, err := clusterIf.List(context.Background(), &clusterpkg.ClusterQuery{})
+	clusters, err := clusterIf.List(ctx, &clusterpkg.ClusterQuery{})
 	errors.CheckError(err)
 	printClusterTable(clusters.Items)
 }
@@ -1,15 +1,20 @@
 package profile
 
 import (
+	"arlon.io/arlon/pkg/bundle"
+	"arlon.io/arlon/pkg/cliutil"
+	"arlon.io/arlon/pkg/cluster"
 	"context"
 	"fmt"
 	"github.com/spf13/cobra"
 	v1 "k8s.io/api/core/v1"
 	apierr "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
 	restclient "k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	"os"
 )
 
 import "github.com/argoproj/argo-cd/v2/util/cli"
@@ -19,40 +24,62 @@ func createProfileCommand() *cobra.Command {
 	var ns string
 	var desc string
 	var bundles string
+	var bundleSelector string
 	var tags string
+	var syncWindowsFile string
 	command := &cobra.Command{
 		Use:               "create",
 		Short:             "Create profile",
 		Long:              "Create profile",
 		Args: cobra.ExactArgs(1),
 		RunE: func(c *cobra.Command, args []string) error {
+			ctx, cancel := cliutil.Context(c)
+			defer cancel()
 			config, err := clientConfig.ClientConfig()
 			if err != nil {
-				return fmt.Errorf("failed to get k8s client config: %s", err)
+				return fmt.Errorf("failed to get k8s client config: %w", err)
 			}
-			return createProfile(config, ns, args[0], bundles, desc, tags)
+			if bundles == "" && bundleSelector == "" {
+				return fmt.Errorf("--bundles or --bundle-selector must be specified")
+			}
+			var syncWindows string
+			if syncWindowsFile != "" {
+				data, err := os.ReadFile(syncWindowsFile)
+				if err != nil {
+					return fmt.Errorf("failed to read sync windows file: %w", err)
+				}
+				syncWindows = string(data)
+			}
+			return createProfile(ctx, config, ns, args[0], bundles, bundleSelector, desc, tags, syncWindows)
 		},
 	}
 	clientConfig = cli.AddKubectlFlagsToCmd(command)
 	command.Flags().StringVar(&ns, "ns", "arlon", "the arlon namespace")
 	command.Flags().StringVar(&desc, "desc", "", "description")
-	command.Flags().StringVar(&bundles, "bundles", "", "comma separated list of bundles")
+	command.Flags().StringVar(&bundles, "bundles", "", "comma separated list of bundles, optionally namespace qualified (ns/name)")
+	command.Flags().StringVar(&bundleSelector, "bundle-selector", "", "label selector adding the matching bundles of the profile's namespace, for e.g. tier=networking")
 	command.Flags().StringVar(&tags, "tags", "", "comma separated list of tags")
-	command.MarkFlagRequired("bundles")
+	command.Flags().StringVar(&syncWindowsFile, "sync-windows", "", "YAML file listing the change windows outside of which changes to the profile's deployed clusters are refused")
 	return command
 }
 
 
-func createProfile(config *restclient.Config, ns string, profileName string, bundles string, desc string, tags string) error {
+func createProfile(ctx context.Context, config *restclient.Config, ns string, profileName string, bundles string, bundleSelector string, desc string, tags string, syncWindows string) error {
+	if _, err := labels.Parse(bundleSelector); err != nil {
+		return fmt.Errorf("invalid bundle selector: %w", err)
+	}
+	if _, err := cluster.ParseSyncWindows(syncWindows); err != nil {
+		return err
+	}
 	kubeClient := kubernetes.NewForConfigOrDie(config)
 	corev1 := kubeClient.CoreV1()
 	configMapApi := corev1.ConfigMaps(ns)
-	_, err := configMapApi.Get(context.Background(), profileName, metav1.GetOptions{})
+	_, err := configMapApi.Get(ctx, profileName, metav1.GetOptions{})
 	if err == nil {
 		return fmt.Errorf("a profile with that name already exists")
 	}
 	if !apierr.IsNotFound(err) {
-		return fmt.Errorf("failed to check for existence of profile: %s", err)
+		return fmt.Errorf("failed to check for existence of profile: %w", err)
 	}
 	cm := v1.ConfigMap{
 		ObjectMeta: metav1.ObjectMeta{
@@ -69,9 +96,15 @@ func createProfile(config *restclient.Config, ns string, profileName string, bun
 			"tags": tags,
 		},
 	}
-	_, err = configMapApi.Create(context.Background(), &cm, metav1.CreateOptions{})
+	if bundleSelector != "" {
+		cm.Data[bundle.BundleSelectorKey] = bundleSelector
+	}
+	if syncWindows != "" {
+		cm.Data[cluster.SyncWindowsKey] = syncWindows
+	}
+	_, err = configMapApi.Create(ctx, &cm, metav1.CreateOptions{})
 	if err != nil {
-		return fmt.Errorf("failed to create profile: %s", err)
+		return fmt.Errorf("failed to create profile: %w", err)
 	}
 	return nil
 }
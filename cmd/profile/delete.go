@@ -1,10 +1,17 @@
 package profile
 
 import (
+	"arlon.io/arlon/pkg/argocd"
+	"arlon.io/arlon/pkg/cliutil"
+	"arlon.io/arlon/pkg/cluster"
+	"arlon.io/arlon/pkg/gitutils"
 	"context"
+	"errors"
 	"fmt"
+	applicationpkg "github.com/argoproj/argo-cd/v2/pkg/apiclient/application"
+	appclientset "github.com/argoproj/argo-cd/v2/pkg/client/clientset/versioned"
 	"github.com/spf13/cobra"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"io"
 	"k8s.io/client-go/kubernetes"
 	restclient "k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
@@ -14,35 +21,53 @@ import "github.com/argoproj/argo-cd/v2/util/cli"
 
 func deleteProfileCommand() *cobra.Command {
 	var clientConfig clientcmd.ClientConfig
+	var argocdNs string
 	var ns string
+	var cascade bool
 	command := &cobra.Command{
 		Use:               "delete",
 		Short:             "Delete profile",
-		Long:              "Delete profile",
+		Long: "Delete profile. A profile used by deployed clusters is only deleted with " +
+			"--cascade, which first detaches it from those clusters, removing its " +
+			"bundles from their git trees, as cluster detach-profile does.",
 		Args: cobra.ExactArgs(1),
+		ValidArgsFunction: cliutil.CompleteArgs(cliutil.CompleteProfiles),
 		RunE: func(c *cobra.Command, args []string) error {
+			ctx, cancel := cliutil.Context(c)
+			defer cancel()
 			config, err := clientConfig.ClientConfig()
 			if err != nil {
-				return fmt.Errorf("failed to get k8s client config: %s", err)
+				return fmt.Errorf("failed to get k8s client config: %w", err)
 			}
-			return deleteProfile(config, ns, args[0])
+			return deleteProfile(ctx, config, argocdNs, ns, args[0], cascade)
 		},
 	}
 	clientConfig = cli.AddKubectlFlagsToCmd(command)
+	command.Flags().StringVar(&argocdNs, "argocd-ns", "argocd", "the argocd namespace")
 	command.Flags().StringVar(&ns, "ns", "arlon", "the arlon namespace")
+	command.Flags().BoolVar(&cascade, "cascade", false, "detach the profile from the clusters using it, "+
+		"pushing the removal of its bundles from their git trees without confirmation, then delete it")
 	return command
 }
 
 
-func deleteProfile(config *restclient.Config, ns string, profileName string) error {
+func deleteProfile(ctx context.Context, config *restclient.Config, argocdNs string, ns string, profileName string, cascade bool) error {
 	kubeClient := kubernetes.NewForConfigOrDie(config)
-	corev1 := kubeClient.CoreV1()
-	configMapApi := corev1.ConfigMaps(ns)
-	err := configMapApi.Delete(context.Background(), profileName, metav1.DeleteOptions{})
-	if err != nil {
-		return fmt.Errorf("failed to delete profile: %s", err)
+	ctx = cliutil.WithKubeIdentity(ctx, config, kubeClient)
+	appClient := appclientset.NewForConfigOrDie(config)
+	// only detaching the profile needs an ArgoCD session
+	newAppIf := func() (io.Closer, applicationpkg.ApplicationServiceClient) {
+		return argocd.NewArgocdClientOrDie().NewApplicationClientOrDie()
 	}
-	return nil
+	detached, err := cluster.DeleteProfile(ctx, kubeClient, appClient.ArgoprojV1alpha1().Applications(argocdNs),
+		gitutils.NewRepo, newAppIf, argocdNs, ns, profileName, cascade)
+	for _, clusterName := range detached {
+		fmt.Printf("detached profile from cluster %s\n", clusterName)
+	}
+	var inUse *cluster.InUseError
+	if errors.As(err, &inUse) {
+		return fmt.Errorf("%w (--cascade detaches it from them first)", err)
+	}
+	return err
 }
 
-
@@ -1,9 +1,13 @@
 package profile
 
 import (
+	"arlon.io/arlon/pkg/bundle"
+	"arlon.io/arlon/pkg/cliutil"
+	"arlon.io/arlon/pkg/kuberetry"
 	"context"
 	"fmt"
 	"github.com/spf13/cobra"
+	corev1api "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	restclient "k8s.io/client-go/rest"
@@ -17,40 +21,54 @@ import "github.com/argoproj/argo-cd/v2/util/cli"
 func listProfilesCommand() *cobra.Command {
 	var clientConfig clientcmd.ClientConfig
 	var ns string
+	var allNamespaces bool
 	command := &cobra.Command{
 		Use:               "list",
 		Short:             "List configuration profiles",
 		Long:              "List configuration profiles",
 		RunE: func(c *cobra.Command, args []string) error {
+			ctx, cancel := cliutil.Context(c)
+			defer cancel()
 			config, err := clientConfig.ClientConfig()
 			if err != nil {
-				return fmt.Errorf("failed to get k8s client config: %s", err)
+				return fmt.Errorf("failed to get k8s client config: %w", err)
 			}
-			return listProfiles(config, ns)
+			return listProfiles(ctx, config, ns, allNamespaces)
 		},
 	}
 	clientConfig = cli.AddKubectlFlagsToCmd(command)
 	command.Flags().StringVar(&ns, "ns", "arlon", "the arlon namespace")
+	command.Flags().BoolVarP(&allNamespaces, "all-namespaces", "A", false, "list across all namespaces")
 	return command
 }
 
 
-func listProfiles(config *restclient.Config, ns string) error {
+func listProfiles(ctx context.Context, config *restclient.Config, ns string, allNamespaces bool) error {
 	kubeClient := kubernetes.NewForConfigOrDie(config)
+	if allNamespaces {
+		ns = metav1.NamespaceAll
+	}
 	corev1 := kubeClient.CoreV1()
 	configMapsApi := corev1.ConfigMaps(ns)
 	opts := metav1.ListOptions{
 		LabelSelector: "managed-by=arlon,arlon-type=profile",
 	}
-	configMaps, err := configMapsApi.List(context.Background(), opts)
+	var configMaps *corev1api.ConfigMapList
+	err := kuberetry.OnTransient(ctx, func() (err error) {
+		configMaps, err = configMapsApi.List(ctx, opts)
+		return
+	})
 	if err != nil {
-		return fmt.Errorf("failed to list configMaps: %s", err)
+		return fmt.Errorf("failed to list configMaps: %w", err)
 	}
 	if len(configMaps.Items) == 0 {
 		fmt.Println("no profiles found")
 		return nil
 	}
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if allNamespaces {
+		_, _ = fmt.Fprintf(w, "NAMESPACE\t")
+	}
 	_, _ = fmt.Fprintf(w, "NAME\tTYPE\tBUNDLES\tTAGS\tDESCRIPTION\n")
 	for _, configMap := range configMaps.Items {
 		profileType := configMap.Labels["profile-type"]
@@ -58,8 +76,17 @@ func listProfiles(config *restclient.Config, ns string) error {
 			profileType = "(undefined)"
 		}
 		bundles := configMap.Data["bundles"]
+		if selector := configMap.Data[bundle.BundleSelectorKey]; selector != "" {
+			if bundles != "" {
+				bundles += ","
+			}
+			bundles += "(" + selector + ")"
+		}
 		tags := string(configMap.Data["tags"])
 		desc := string(configMap.Data["description"])
+		if allNamespaces {
+			_, _ = fmt.Fprintf(w, "%s\t", configMap.Namespace)
+		}
 		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", configMap.Name, profileType, bundles, tags, desc)
 	}
 	_ = w.Flush()
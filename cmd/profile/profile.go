@@ -14,6 +14,8 @@ func NewCommand() *cobra.Command {
 	command.AddCommand(listProfilesCommand())
 	command.AddCommand(createProfileCommand())
 	command.AddCommand(deleteProfileCommand())
+	command.AddCommand(syncProfileCommand())
+	command.AddCommand(renderProfileCommand())
 	return command
 }
 
@@ -0,0 +1,76 @@
+package profile
+
+import (
+	"arlon.io/arlon/pkg/cliutil"
+	"arlon.io/arlon/pkg/cluster"
+	"fmt"
+	"github.com/argoproj/argo-cd/v2/util/cli"
+	"github.com/spf13/cobra"
+	"io"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"os"
+	"sort"
+	"strings"
+)
+
+func renderProfileCommand() *cobra.Command {
+	var clientConfig clientcmd.ClientConfig
+	var argocdNs string
+	var arlonNs string
+	var repoUrl string
+	var basePath string
+	command := &cobra.Command{
+		Use:   "render <profile> <cluster>",
+		Short: "Print the files a profile would generate for a cluster",
+		Long: "Print the bundle applications and inline bundle workload files that " +
+			"the profile would add to the git tree of a cluster of the given name, " +
+			"which doesn't need to exist, so that the profile can be reviewed " +
+			"before any cluster uses it. Each file is preceded by a comment " +
+			"naming its path in the repository.",
+		DisableAutoGenTag: true,
+		Args:              cobra.ExactArgs(2),
+		ValidArgsFunction: cliutil.CompleteArgs(cliutil.CompleteProfiles),
+		RunE: func(c *cobra.Command, args []string) error {
+			ctx, cancel := cliutil.Context(c)
+			defer cancel()
+			config, err := clientConfig.ClientConfig()
+			if err != nil {
+				return fmt.Errorf("failed to get k8s client config: %w", err)
+			}
+			kubeClient := kubernetes.NewForConfigOrDie(config)
+			files, err := cluster.RenderProfile(ctx, kubeClient, argocdNs, arlonNs, args[1],
+				repoUrl, basePath, args[0])
+			if err != nil {
+				return fmt.Errorf("failed to render profile: %w", err)
+			}
+			return printFiles(files, os.Stdout)
+		},
+	}
+	clientConfig = cli.AddKubectlFlagsToCmd(command)
+	command.Flags().StringVar(&argocdNs, "argocd-ns", "argocd", "the argocd namespace")
+	command.Flags().StringVar(&arlonNs, "arlon-ns", "arlon", "the arlon namespace")
+	command.Flags().StringVar(&repoUrl, "repo-url", "", "the git repository url the inline bundles would be deployed from")
+	command.Flags().StringVar(&basePath, "path", "arlon", "the git repository base path")
+	command.MarkFlagRequired("repo-url")
+	return command
+}
+
+// printFiles prints the files ordered by path as a stream of YAML documents.
+func printFiles(files map[string][]byte, w io.Writer) error {
+	var paths []string
+	for p := range files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		data := strings.TrimPrefix(string(files[p]), "\n")
+		if !strings.HasSuffix(data, "\n") {
+			data += "\n"
+		}
+		if _, err := fmt.Fprintf(w, "---\n# Source: %s\n%s", p, data); err != nil {
+			return fmt.Errorf("failed to write %s: %w", p, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package profile
+
+import (
+	"arlon.io/arlon/pkg/argocd"
+	"arlon.io/arlon/pkg/cliutil"
+	"arlon.io/arlon/pkg/cluster"
+	"arlon.io/arlon/pkg/fleet"
+	"arlon.io/arlon/pkg/gitutils"
+	"errors"
+	"fmt"
+	"github.com/argoproj/argo-cd/v2/util/cli"
+	"github.com/argoproj/argo-cd/v2/util/io"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"os"
+	"text/tabwriter"
+)
+
+func syncProfileCommand() *cobra.Command {
+	var clientConfig clientcmd.ClientConfig
+	var argocdNs string
+	var arlonNs string
+	var dryRun bool
+	var maxParallel int
+	var selector string
+	command := &cobra.Command{
+		Use:   "sync <profile>",
+		Short: "Render a profile again for every cluster deployed with it",
+		Long: "Find the clusters deployed with the profile and render its current " +
+			"bundles into the git tree of each, so that changes made to the profile " +
+			"reach the clusters already using it.",
+		DisableAutoGenTag: true,
+		Args:              cobra.ExactArgs(1),
+		ValidArgsFunction: cliutil.CompleteArgs(cliutil.CompleteProfiles),
+		RunE: func(c *cobra.Command, args []string) error {
+			ctx, cancel := cliutil.Context(c)
+			defer cancel()
+			ctx, operation := cliutil.StartOperation(ctx, "profile-sync", "")
+			config, err := clientConfig.ClientConfig()
+			if err != nil {
+				return fmt.Errorf("failed to get k8s client config: %w", err)
+			}
+			kubeClient := kubernetes.NewForConfigOrDie(config)
+			ctx = cliutil.WithKubeIdentity(ctx, config, kubeClient)
+			conn, appIf := argocd.NewArgocdClientOrDie().NewApplicationClientOrDie()
+			defer io.Close(conn)
+			results, err := fleet.SyncProfile(ctx, kubeClient, gitutils.NewRepo, appIf,
+				argocdNs, arlonNs, args[0], selector, dryRun, maxParallel)
+			if err != nil {
+				return err
+			}
+			if !dryRun {
+				// each cluster's error is reported by printSyncResults
+				for _, result := range results {
+					_ = operation.FinishCluster(result.Cluster, result.CommitSha, result.Err)
+				}
+			}
+			return printSyncResults(results, dryRun)
+		},
+	}
+	clientConfig = cli.AddKubectlFlagsToCmd(command)
+	command.Flags().StringVar(&argocdNs, "argocd-ns", "argocd", "the argocd namespace")
+	command.Flags().StringVar(&arlonNs, "arlon-ns", "arlon", "the arlon namespace")
+	command.Flags().BoolVar(&dryRun, "dry-run", false, "only report the clusters that would change, without pushing")
+	command.Flags().IntVar(&maxParallel, "max-parallel", 4, "the maximum number of repository branches synced concurrently")
+	command.Flags().StringVarP(&selector, "selector", "l", "", "only sync the clusters whose labels match this selector, for e.g. env=staging")
+	return command
+}
+
+// printSyncResults prints the outcome for each cluster and fails if any of
+// them failed.
+func printSyncResults(results []fleet.SyncResult, dryRun bool) error {
+	if len(results) == 0 {
+		fmt.Println("no clusters use this profile")
+		return nil
+	}
+	failed, refused := 0, 0
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintf(w, "CLUSTER\tRESULT\tCOMMIT\n")
+	for _, result := range results {
+		outcome := "unchanged"
+		var windowErr *cluster.SyncWindowClosedError
+		switch {
+		case errors.As(result.Err, &windowErr):
+			outcome = "refused (outside change window)"
+			refused++
+		case result.Err != nil:
+			outcome = result.Err.Error()
+			failed++
+		case result.Changed && dryRun:
+			outcome = "would change"
+		case result.Changed:
+			outcome = "synced"
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n", result.Cluster, outcome, result.CommitSha)
+	}
+	_ = w.Flush()
+	if refused > 0 {
+		fmt.Printf("\n%d clusters refused, sync again once a change window of their profile opens\n", refused)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d clusters failed to sync", failed, len(results))
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"arlon.io/arlon/pkg/argocd"
+	"arlon.io/arlon/pkg/cliutil"
+	"arlon.io/arlon/pkg/kubecache"
+	"arlon.io/arlon/pkg/server"
+	"fmt"
+	"github.com/argoproj/argo-cd/v2/util/cli"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func NewCommand() *cobra.Command {
+	var clientConfig clientcmd.ClientConfig
+	var argocdNs string
+	var arlonNs string
+	var opts server.Options
+	var qps float32
+	var burst int
+	command := &cobra.Command{
+		Use:   "server",
+		Short: "Run the arlon API server",
+		Long: "Serve the bundle, profile, clusterspec and cluster operations over " +
+			"gRPC and a REST gateway. Callers authenticate with a Kubernetes bearer " +
+			"token, such as a service account token.",
+		DisableAutoGenTag: true,
+		Args:              cobra.NoArgs,
+		RunE: func(c *cobra.Command, args []string) error {
+			// the server runs until it is stopped, --timeout bounding each
+			// of the calls it serves rather than the server itself
+			ctx, stop := signal.NotifyContext(cliutil.ServeContext(c), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			opts.CallTimeout = cliutil.Timeout()
+			config, err := clientConfig.ClientConfig()
+			if err != nil {
+				return fmt.Errorf("failed to get k8s client config: %w", err)
+			}
+			config.QPS, config.Burst = qps, burst
+			kubeClient := kubernetes.NewForConfigOrDie(config)
+			// deployments read bundles, profiles and clusterspecs from
+			// informers rather than getting them from the API server
+			cachedClient, err := kubecache.NewClient(ctx, kubeClient)
+			if err != nil {
+				return err
+			}
+			service := server.NewService(cachedClient, argocd.NewArgocdClientOrDie(), argocdNs, arlonNs)
+			return server.Run(ctx, service, server.NewAuthenticator(kubeClient), opts)
+		},
+	}
+	clientConfig = cli.AddKubectlFlagsToCmd(command)
+	command.Flags().StringVar(&argocdNs, "argocd-ns", "argocd", "the argocd namespace")
+	command.Flags().StringVar(&arlonNs, "arlon-ns", "arlon", "the arlon namespace")
+	command.Flags().StringVar(&opts.GRPCAddr, "grpc-addr", ":8090", "the address to serve gRPC on")
+	command.Flags().StringVar(&opts.HTTPAddr, "http-addr", ":8091", "the address to serve the REST gateway on")
+	command.Flags().StringVar(&opts.TLSCertFile, "tls-cert", "", "the TLS certificate file")
+	command.Flags().StringVar(&opts.TLSKeyFile, "tls-key", "", "the TLS private key file")
+	command.Flags().BoolVar(&opts.Insecure, "insecure", false,
+		"serve plaintext without a TLS certificate, exposing the bearer tokens of callers")
+	command.Flags().Float32Var(&qps, "kube-qps", 20, "the maximum rate of requests to the Kubernetes API server, per second")
+	command.Flags().IntVar(&burst, "kube-burst", 40, "the maximum burst of requests to the Kubernetes API server")
+	return command
+}
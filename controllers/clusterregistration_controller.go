@@ -36,9 +36,13 @@ import (
 	"k8s.io/client-go/tools/clientcmd"
 	"sigs.k8s.io/cluster-api/util/patch"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 	"time"
 )
 
@@ -48,11 +52,19 @@ var argocdclient apiclient.Client
 type ClusterRegistrationReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+	// SecretCache holds the Secrets of SecretNamespace, the arlon namespace.
+	// The kubeconfig secrets of the registrations of that namespace are read
+	// from it, and watched so that a registration waiting for its secret is
+	// reconciled once the secret is created. Secrets of other namespaces are
+	// read directly and polled for.
+	SecretCache     cache.Cache
+	SecretNamespace string
 }
 
 //+kubebuilder:rbac:groups=arlon.io,resources=clusterregistrations,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=arlon.io,resources=clusterregistrations/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=arlon.io,resources=clusterregistrations/finalizers,verbs=update
+//+kubebuilder:rbac:groups="",namespace=arlon,resources=secrets,verbs=get;list;watch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -126,7 +138,18 @@ func (r *ClusterRegistrationReconciler) Reconcile(ctx context.Context, req ctrl.
 		Namespace: req.NamespacedName.Namespace,
 		Name:      cr.Spec.KubeconfigSecretName,
 	}
-	if err := r.Get(ctx, secretNamespacedName, &secret); err != nil {
+	var secretReader client.Reader = r.Client
+	watched := secretNamespacedName.Namespace == r.SecretNamespace
+	if watched {
+		secretReader = r.SecretCache
+	}
+	if err := secretReader.Get(ctx, secretNamespacedName, &secret); err != nil {
+		if apierrors.IsNotFound(err) && watched {
+			// the secret watch reconciles the registration once it's created
+			msg := fmt.Sprintf("kubeconfig secret %s does not exist yet, waiting for it",
+				cr.Spec.KubeconfigSecretName)
+			return updateState(r, log, &cr, "retrying", msg, ctrl.Result{})
+		}
 		if apierrors.IsNotFound(err) {
 			msg := fmt.Sprintf("kubeconfig secret %s does not exist yet, retrying in 10 seconds",
 				cr.Spec.KubeconfigSecretName)
@@ -190,13 +213,41 @@ func (r *ClusterRegistrationReconciler) Reconcile(ctx context.Context, req ctrl.
 	return updateState(r, log, &cr, "complete","successfully added cluster to argocd", ctrl.Result{})
 }
 
-// SetupWithManager sets up the controller with the Manager.
+// SetupWithManager sets up the controller with the Manager. Besides
+// ClusterRegistrations, it watches the Secrets of SecretCache, so that a
+// registration of the arlon namespace waiting for its kubeconfig secret is
+// reconciled as soon as the secret is created or updated, rather than
+// polling for it.
 func (r *ClusterRegistrationReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&arlonv1.ClusterRegistration{}).
+		Watches(source.NewKindWithCache(&corev1.Secret{}, r.SecretCache),
+			handler.EnqueueRequestsFromMapFunc(r.registrationsForSecret)).
 		Complete(r)
 }
 
+// registrationsForSecret returns the requests to reconcile the
+// ClusterRegistrations whose kubeconfig is held by a Secret.
+func (r *ClusterRegistrationReconciler) registrationsForSecret(secret client.Object) []reconcile.Request {
+	var list arlonv1.ClusterRegistrationList
+	err := r.List(context.Background(), &list, client.InNamespace(secret.GetNamespace()))
+	if err != nil {
+		ctrl.Log.WithName("clusterregistration").Error(err, "failed to list clusterregistrations",
+			"namespace", secret.GetNamespace())
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, cr := range list.Items {
+		if cr.Spec.KubeconfigSecretName == secret.GetName() {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{
+				Namespace: cr.Namespace,
+				Name:      cr.Name,
+			}})
+		}
+	}
+	return requests
+}
+
 func init() {
 	argocdclient = argocd.NewArgocdClientOrDie()
 }
@@ -4,17 +4,26 @@ go 1.16
 
 require (
 	github.com/argoproj/argo-cd/v2 v2.2.0-rc1
+	github.com/argoproj/gitops-engine v0.4.1-0.20211103220110-c7bab2eeca22
+	github.com/evanphx/json-patch v4.11.0+incompatible
+	github.com/go-git/go-billy/v5 v5.3.1
 	github.com/go-git/go-git/v5 v5.4.2
 	github.com/go-logr/logr v0.4.0
+	github.com/modern-go/reflect2 v1.0.2 // indirect
 	github.com/onsi/ginkgo v1.16.4
 	github.com/onsi/gomega v1.16.0
+	github.com/open-policy-agent/opa v0.34.2
+	github.com/pmezard/go-difflib v1.0.0
 	github.com/spf13/cobra v1.2.1
+	github.com/spf13/pflag v1.0.5
+	google.golang.org/grpc v1.40.0
 	gopkg.in/yaml.v2 v2.4.0
 	k8s.io/api v0.22.2
 	k8s.io/apimachinery v0.22.2
 	k8s.io/client-go v11.0.1-0.20190816222228-6d55c1b1f1ca+incompatible
 	sigs.k8s.io/cluster-api v1.0.1
 	sigs.k8s.io/controller-runtime v0.10.3
+	sigs.k8s.io/yaml v1.3.0
 )
 
 replace (
@@ -17,21 +17,28 @@ limitations under the License.
 package main
 
 import (
+	"arlon.io/arlon/cmd/apply"
+	"arlon.io/arlon/cmd/backup"
 	"arlon.io/arlon/cmd/bundle"
 	"arlon.io/arlon/cmd/cluster"
 	"arlon.io/arlon/cmd/clusterspec"
+	arloncontext "arlon.io/arlon/cmd/context"
 	"arlon.io/arlon/cmd/controller"
+	"arlon.io/arlon/cmd/fleet"
 	"arlon.io/arlon/cmd/list_clusters"
 	"arlon.io/arlon/cmd/profile"
-	"flag"
+	"arlon.io/arlon/cmd/server"
+	"arlon.io/arlon/pkg/cliutil"
+	clusterpkg "arlon.io/arlon/pkg/cluster"
+	"arlon.io/arlon/pkg/log"
+	"context"
+	"fmt"
 	"github.com/spf13/cobra"
 	"os"
+	"os/signal"
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
-
-	ctrl "sigs.k8s.io/controller-runtime"
-	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -45,27 +52,43 @@ func main() {
 			c.Println(c.UsageString())
 		},
 	}
-	// don't display usage upon error
+	// don't display usage upon error, and print errors redacted below
 	command.SilenceUsage = true
+	command.SilenceErrors = true
+	cliutil.AddLogFlags(command)
+	cliutil.AddTimeoutFlag(command)
+	cliutil.AddProgressFlag(command)
+	cliutil.AddSkipLFSFlag(command)
+	cliutil.AddCommitStrategyFlag(command)
+	cliutil.AddIgnoreSyncWindowsFlag(command)
+	cliutil.AddAllowCommandProcessorsFlag(command)
+	cliutil.AddResultJSONFlag(command)
+	cliutil.AddServerFlags(command)
+	cliutil.AddContextFlag(command)
 	command.AddCommand(controller.NewCommand())
 	command.AddCommand(list_clusters.NewCommand())
 	command.AddCommand(bundle.NewCommand())
 	command.AddCommand(profile.NewCommand())
 	command.AddCommand(clusterspec.NewCommand())
 	command.AddCommand(cluster.NewCommand())
+	command.AddCommand(fleet.NewCommand())
+	command.AddCommand(apply.NewCommand())
+	command.AddCommand(server.NewCommand())
+	command.AddCommand(arloncontext.NewCommand())
+	command.AddCommand(backup.NewExportCommand())
+	command.AddCommand(backup.NewImportCommand())
 
-	opts := zap.Options{
-		Development: true,
-	}
-	opts.BindFlags(flag.CommandLine)
-	// override default log level, which is initially set to 'debug'
-	flag.Set("zap-log-level", "info")
-	flag.Parse()
-	logger := zap.New(zap.UseFlagOptions(&opts))
-	ctrl.SetLogger(logger)
-	args := flag.Args()
-	command.SetArgs(args)
-	if err := command.Execute(); err != nil {
+	// cancel in-flight API and git calls on interrupt
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := command.ExecuteContext(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", log.Redact(err.Error()))
+		stop()
+		if clusterpkg.IsRetryable(err) {
+			// EX_TEMPFAIL, so that scripts can tell to retry the command
+			fmt.Fprintln(os.Stderr, "The git repository was updated concurrently, retry the command")
+			os.Exit(75)
+		}
 		os.Exit(1)
 	}
 }
@@ -12,5 +12,6 @@ func NewArgocdClientOrDie() apiclient.Client {
 	errors.CheckError(err)
 	var argocdCliOpts apiclient.ClientOptions
 	argocdCliOpts.ConfigPath = defaultLocalConfigPath
+	argocdCliOpts.Context = contextName
 	return argocdclient.NewClientOrDie(&argocdCliOpts)
 }
@@ -0,0 +1,9 @@
+package argocd
+
+var contextName string
+
+// SetContext selects the context of the argocd CLI configuration that
+// clients connect with, instead of its current context.
+func SetContext(name string) {
+	contextName = name
+}
@@ -0,0 +1,290 @@
+package backup
+
+import (
+	"archive/tar"
+	"arlon.io/arlon/pkg/bundle"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	corev1api "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8syaml "k8s.io/apimachinery/pkg/util/yaml"
+	corev1types "k8s.io/client-go/kubernetes/typed/core/v1"
+	"path"
+	"sort"
+)
+
+// Label selectors of the arlon resources that are backed up. Bundle chunks
+// are backed up as is, along with their bundle, so that bundles are restored
+// byte for byte, signatures included.
+const (
+	secretSelector    = "managed-by=arlon,arlon-type in (config-bundle,config-bundle-chunk)"
+	configMapSelector = "managed-by=arlon,arlon-type in (profile,clusterspec)"
+)
+
+// Export writes the bundles, profiles and clusterspecs of namespace ns, or of
+// all namespaces if ns is empty, to w as a gzipped tar archive of JSON
+// manifests. Only their name, namespace, labels, annotations and data are
+// kept, so that they can be restored to another management cluster. It
+// returns the number of exported resources.
+func Export(ctx context.Context, corev1 corev1types.CoreV1Interface, ns string, w io.Writer) (int, error) {
+	var objs []runtime.Object
+	secrets, err := corev1.Secrets(ns).List(ctx, metav1.ListOptions{LabelSelector: secretSelector})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list bundles: %w", err)
+	}
+	for _, secret := range secrets.Items {
+		objs = append(objs, &corev1api.Secret{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+			ObjectMeta: exportedMeta(&secret.ObjectMeta),
+			Type:       secret.Type,
+			Data:       secret.Data,
+		})
+	}
+	configMaps, err := corev1.ConfigMaps(ns).List(ctx, metav1.ListOptions{LabelSelector: configMapSelector})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list profiles and clusterspecs: %w", err)
+	}
+	for _, cm := range configMaps.Items {
+		objs = append(objs, &corev1api.ConfigMap{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+			ObjectMeta: exportedMeta(&cm.ObjectMeta),
+			Data:       cm.Data,
+		})
+	}
+	zw := gzip.NewWriter(w)
+	tw := tar.NewWriter(zw)
+	for _, obj := range objs {
+		data, err := json.MarshalIndent(obj, "", "  ")
+		if err != nil {
+			return 0, fmt.Errorf("failed to encode %s: %s", entryName(obj), err)
+		}
+		err = tw.WriteHeader(&tar.Header{
+			Name: entryName(obj),
+			Mode: 0600,
+			Size: int64(len(data)),
+		})
+		if err == nil {
+			_, err = tw.Write(data)
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to write archive: %w", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return 0, fmt.Errorf("failed to write archive: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return 0, fmt.Errorf("failed to write archive: %w", err)
+	}
+	return len(objs), nil
+}
+
+// ImportResult counts the resources restored by Import.
+type ImportResult struct {
+	Created int
+	Updated int
+	Skipped int
+}
+
+// Import restores the resources of an archive written by Export, creating
+// their namespaces if needed. Existing resources are left alone unless
+// overwrite is set, in which case they are replaced. The archive may only
+// hold arlon bundles, profiles and clusterspecs.
+func Import(
+	ctx context.Context,
+	corev1 corev1types.CoreV1Interface,
+	r io.Reader,
+	overwrite bool,
+) (*ImportResult, error) {
+	objs, err := readArchive(r)
+	if err != nil {
+		return nil, err
+	}
+	// chunks are created last, since they're owned by their bundle
+	sort.SliceStable(objs, func(i, j int) bool {
+		return !isChunk(objs[i]) && isChunk(objs[j])
+	})
+	result := &ImportResult{}
+	namespaces := make(map[string]bool)
+	for _, obj := range objs {
+		meta := objectMeta(obj)
+		if !namespaces[meta.Namespace] {
+			if err := ensureNamespace(ctx, corev1, meta.Namespace); err != nil {
+				return nil, err
+			}
+			namespaces[meta.Namespace] = true
+		}
+		var existing metav1.Object
+		switch o := obj.(type) {
+		case *corev1api.Secret:
+			if isChunk(o) {
+				owner, err := corev1.Secrets(o.Namespace).Get(ctx, o.Labels[bundle.ChunkLabel], metav1.GetOptions{})
+				if err != nil {
+					return nil, fmt.Errorf("failed to get bundle of chunk %s: %w", o.Name, err)
+				}
+				o.OwnerReferences = []metav1.OwnerReference{{
+					APIVersion: "v1",
+					Kind:       "Secret",
+					Name:       owner.Name,
+					UID:        owner.UID,
+				}}
+			}
+			secretsApi := corev1.Secrets(o.Namespace)
+			existing, err = secretsApi.Get(ctx, o.Name, metav1.GetOptions{})
+			if err == nil && overwrite {
+				o.ResourceVersion = existing.GetResourceVersion()
+				_, err = secretsApi.Update(ctx, o, metav1.UpdateOptions{})
+			} else if apierr.IsNotFound(err) {
+				existing = nil
+				_, err = secretsApi.Create(ctx, o, metav1.CreateOptions{})
+			}
+		case *corev1api.ConfigMap:
+			configMapsApi := corev1.ConfigMaps(o.Namespace)
+			existing, err = configMapsApi.Get(ctx, o.Name, metav1.GetOptions{})
+			if err == nil && overwrite {
+				o.ResourceVersion = existing.GetResourceVersion()
+				_, err = configMapsApi.Update(ctx, o, metav1.UpdateOptions{})
+			} else if apierr.IsNotFound(err) {
+				existing = nil
+				_, err = configMapsApi.Create(ctx, o, metav1.CreateOptions{})
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to restore %s: %s", entryName(obj), err)
+		}
+		switch {
+		case existing == nil:
+			result.Created++
+		case overwrite:
+			result.Updated++
+		default:
+			result.Skipped++
+		}
+	}
+	return result, nil
+}
+
+// -----------------------------------------------------------------------------
+
+func exportedMeta(meta *metav1.ObjectMeta) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:        meta.Name,
+		Namespace:   meta.Namespace,
+		Labels:      meta.Labels,
+		Annotations: meta.Annotations,
+	}
+}
+
+func objectMeta(obj runtime.Object) *metav1.ObjectMeta {
+	switch o := obj.(type) {
+	case *corev1api.Secret:
+		return &o.ObjectMeta
+	case *corev1api.ConfigMap:
+		return &o.ObjectMeta
+	}
+	return nil
+}
+
+func entryName(obj runtime.Object) string {
+	meta := objectMeta(obj)
+	kind := "configmaps"
+	if _, ok := obj.(*corev1api.Secret); ok {
+		kind = "secrets"
+	}
+	return path.Join(kind, meta.Namespace, meta.Name+".json")
+}
+
+func isChunk(obj runtime.Object) bool {
+	secret, ok := obj.(*corev1api.Secret)
+	return ok && secret.Labels["arlon-type"] == "config-bundle-chunk"
+}
+
+// readArchive decodes the manifests of an archive, refusing anything but the
+// resources Export writes.
+func readArchive(r io.Reader) ([]runtime.Object, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive: %w", err)
+	}
+	tr := tar.NewReader(zr)
+	var objs []runtime.Object
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read archive: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s from archive: %w", hdr.Name, err)
+		}
+		obj, err := decodeObject(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %w", hdr.Name, err)
+		}
+		meta := objectMeta(obj)
+		if meta == nil || meta.Name == "" || meta.Namespace == "" ||
+			meta.Labels["managed-by"] != "arlon" {
+			return nil, fmt.Errorf("%s is not an arlon resource", hdr.Name)
+		}
+		arlonType := meta.Labels["arlon-type"]
+		switch obj.(type) {
+		case *corev1api.Secret:
+			if arlonType != "config-bundle" && arlonType != "config-bundle-chunk" {
+				return nil, fmt.Errorf("%s is not a bundle", hdr.Name)
+			}
+		case *corev1api.ConfigMap:
+			if arlonType != "profile" && arlonType != "clusterspec" {
+				return nil, fmt.Errorf("%s is not a profile or clusterspec", hdr.Name)
+			}
+		}
+		objs = append(objs, obj)
+	}
+	return objs, nil
+}
+
+// decodeObject decodes a Secret or ConfigMap manifest.
+func decodeObject(data []byte) (runtime.Object, error) {
+	var typeMeta metav1.TypeMeta
+	if err := k8syaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), 4096).Decode(&typeMeta); err != nil {
+		return nil, err
+	}
+	var obj runtime.Object
+	switch {
+	case typeMeta.APIVersion == "v1" && typeMeta.Kind == "Secret":
+		obj = &corev1api.Secret{}
+	case typeMeta.APIVersion == "v1" && typeMeta.Kind == "ConfigMap":
+		obj = &corev1api.ConfigMap{}
+	default:
+		return nil, fmt.Errorf("unexpected kind %s %s", typeMeta.APIVersion, typeMeta.Kind)
+	}
+	if err := k8syaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), 4096).Decode(obj); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+func ensureNamespace(ctx context.Context, corev1 corev1types.CoreV1Interface, ns string) error {
+	_, err := corev1.Namespaces().Get(ctx, ns, metav1.GetOptions{})
+	if apierr.IsNotFound(err) {
+		_, err = corev1.Namespaces().Create(ctx, &corev1api.Namespace{
+			ObjectMeta: metav1.ObjectMeta{Name: ns},
+		}, metav1.CreateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to ensure namespace %s: %w", ns, err)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"arlon.io/arlon/pkg/bundle"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func arlonMeta(ns string, name string, labels map[string]string) metav1.ObjectMeta {
+	labels["managed-by"] = "arlon"
+	return metav1.ObjectMeta{Name: name, Namespace: ns, Labels: labels}
+}
+
+func TestExportImport(t *testing.T) {
+	guestbook := &corev1.Secret{
+		ObjectMeta: arlonMeta("arlon", "guestbook", map[string]string{"arlon-type": "config-bundle", "bundle-type": "static"}),
+		Data:       map[string][]byte{"data": []byte("kind: ConfigMap\n")},
+	}
+	guestbook.Annotations = map[string]string{"arlon.io/signature": "c2lnbmF0dXJl"}
+	guestbook.ResourceVersion, guestbook.UID = "42", "uid-of-the-source"
+	chunk := &corev1.Secret{
+		ObjectMeta: arlonMeta("arlon", "guestbook-1", map[string]string{"arlon-type": "config-bundle-chunk",
+			bundle.ChunkLabel: "guestbook"}),
+		Data: map[string][]byte{"data": []byte("kind: Secret\n")},
+	}
+	source := fake.NewSimpleClientset(
+		guestbook,
+		chunk,
+		&corev1.ConfigMap{
+			ObjectMeta: arlonMeta("arlon", "dev", map[string]string{"arlon-type": "profile"}),
+			Data:       map[string]string{"bundles": "guestbook"},
+		},
+		&corev1.ConfigMap{
+			ObjectMeta: arlonMeta("team-a", "eks", map[string]string{"arlon-type": "clusterspec"}),
+			Data:       map[string]string{"region": "us-west-2"},
+		},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "repo-creds", Namespace: "arlon"}},
+	)
+	ctx := context.Background()
+	var archive bytes.Buffer
+	count, err := Export(ctx, source.CoreV1(), "", &archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 4 {
+		t.Errorf("expected the 4 arlon resources to be exported, got %d", count)
+	}
+	var teamA bytes.Buffer
+	if count, err := Export(ctx, source.CoreV1(), "team-a", &teamA); err != nil || count != 1 {
+		t.Errorf("expected the clusterspec of team-a to be exported, got %d (%v)", count, err)
+	}
+
+	target := fake.NewSimpleClientset()
+	result, err := Import(ctx, target.CoreV1(), bytes.NewReader(archive.Bytes()), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result, &ImportResult{Created: 4}) {
+		t.Errorf("expected the resources to be created, got %+v", result)
+	}
+	for _, ns := range []string{"arlon", "team-a"} {
+		if _, err := target.CoreV1().Namespaces().Get(ctx, ns, metav1.GetOptions{}); err != nil {
+			t.Errorf("expected namespace %s to be created: %s", ns, err)
+		}
+	}
+	restored, err := target.CoreV1().Secrets("arlon").Get(ctx, "guestbook", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.ResourceVersion == "42" || restored.UID == guestbook.UID ||
+		!reflect.DeepEqual(restored.Labels, guestbook.Labels) ||
+		!reflect.DeepEqual(restored.Annotations, guestbook.Annotations) ||
+		!reflect.DeepEqual(restored.Data, guestbook.Data) {
+		t.Errorf("expected the bundle to be restored without its server metadata, got %+v", restored)
+	}
+	restoredChunk, err := target.CoreV1().Secrets("arlon").Get(ctx, "guestbook-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owners := restoredChunk.OwnerReferences; len(owners) != 1 || owners[0].Name != "guestbook" {
+		t.Errorf("expected the chunk to be owned by its bundle, got %+v", owners)
+	}
+
+	profile, err := target.CoreV1().ConfigMaps("arlon").Get(ctx, "dev", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile.Data["bundles"] = "guestbook,redis"
+	if _, err := target.CoreV1().ConfigMaps("arlon").Update(ctx, profile, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	result, err = Import(ctx, target.CoreV1(), bytes.NewReader(archive.Bytes()), false)
+	if err != nil || !reflect.DeepEqual(result, &ImportResult{Skipped: 4}) {
+		t.Errorf("expected existing resources to be left alone, got %+v (%v)", result, err)
+	}
+	result, err = Import(ctx, target.CoreV1(), bytes.NewReader(archive.Bytes()), true)
+	if err != nil || !reflect.DeepEqual(result, &ImportResult{Updated: 4}) {
+		t.Errorf("expected existing resources to be overwritten, got %+v (%v)", result, err)
+	}
+	profile, err = target.CoreV1().ConfigMaps("arlon").Get(ctx, "dev", metav1.GetOptions{})
+	if err != nil || profile.Data["bundles"] != "guestbook" {
+		t.Errorf("expected the profile to be restored, got %+v (%v)", profile, err)
+	}
+}
+
+// writeArchive writes an archive of manifests keyed by entry name.
+func writeArchive(t *testing.T, entries map[string]string) *bytes.Buffer {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	for name, data := range entries {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(data))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestImportRefusesOtherResources(t *testing.T) {
+	for _, tc := range []struct {
+		manifest string
+		err      string
+	}{
+		{`{"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "p", "namespace": "arlon"}}`,
+			"unexpected kind v1 Pod"},
+		{`{"apiVersion": "v1", "kind": "Secret", "metadata": {"name": "repo-creds", "namespace": "argocd"}}`,
+			"is not an arlon resource"},
+		{`{"apiVersion": "v1", "kind": "Secret", "metadata": {"name": "dev", "namespace": "arlon",
+			"labels": {"managed-by": "arlon", "arlon-type": "profile"}}}`, "is not a bundle"},
+		{`{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "c1", "namespace": "arlon",
+			"labels": {"managed-by": "arlon", "arlon-type": "cluster"}}}`, "is not a profile or clusterspec"},
+	} {
+		target := fake.NewSimpleClientset()
+		archive := writeArchive(t, map[string]string{"resource.json": tc.manifest})
+		_, err := Import(context.Background(), target.CoreV1(), archive, true)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("expected %s to be refused with %q, got %v", tc.manifest, tc.err, err)
+		}
+		if len(target.Actions()) != 0 {
+			t.Errorf("expected nothing to be restored from a refused archive, got %v", target.Actions())
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package bundle
+
+import (
+	"arlon.io/arlon/pkg/kuberetry"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	corev1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1types "k8s.io/client-go/kubernetes/typed/core/v1"
+	"strconv"
+)
+
+// Annotations of inline bundle Secrets describing how their data is stored.
+const (
+	// CompressionAnnotation is gzip when the data is compressed
+	CompressionAnnotation = "arlon.io/compression"
+	// ChunksAnnotation holds the number of chunk Secrets the data is split
+	// across, in which case the bundle Secret holds no data itself
+	ChunksAnnotation = "arlon.io/chunks"
+	// SizeAnnotation holds the size of the original data
+	SizeAnnotation = "arlon.io/size"
+)
+
+// ChunkLabel labels the chunk Secrets of a bundle with the bundle's name.
+const ChunkLabel = "arlon-bundle"
+
+// MaxChunkSize is the most data stored in one Secret, which keeps Secrets
+// below the 1MiB limit of Kubernetes objects.
+const MaxChunkSize = 900 * 1024
+
+// ChunkName returns the name of the i-th chunk Secret of a bundle. The
+// "chunk" infix keeps chunks from colliding with bundles named like them.
+func ChunkName(bundleName string, i int) string {
+	return fmt.Sprintf("%s-chunk-%d", bundleName, i)
+}
+
+// legacyChunkName is the name of a chunk Secret created by earlier versions.
+func legacyChunkName(bundleName string, i int) string {
+	return fmt.Sprintf("%s-%d", bundleName, i)
+}
+
+// Pack stores data in an inline bundle Secret. The data is compressed with
+// gzip if compress is set or it doesn't fit in a Secret, and then split
+// across chunk Secrets if it still doesn't. Pack returns the chunk Secrets,
+// which must be created along with the bundle Secret.
+func Pack(secret *corev1.Secret, data []byte, compress bool) ([]*corev1.Secret, error) {
+	if secret.Annotations == nil {
+		secret.Annotations = make(map[string]string)
+	}
+	secret.Annotations[SizeAnnotation] = strconv.Itoa(len(data))
+	stored := data
+	if compress || len(data) > MaxChunkSize {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(data); err != nil {
+			return nil, fmt.Errorf("failed to compress bundle data: %w", err)
+		}
+		if err := zw.Close(); err != nil {
+			return nil, fmt.Errorf("failed to compress bundle data: %w", err)
+		}
+		stored = buf.Bytes()
+		secret.Annotations[CompressionAnnotation] = "gzip"
+	}
+	if len(stored) <= MaxChunkSize {
+		secret.Data["data"] = stored
+		return nil, nil
+	}
+	var chunks []*corev1.Secret
+	for i := 0; len(stored) > 0; i++ {
+		n := MaxChunkSize
+		if n > len(stored) {
+			n = len(stored)
+		}
+		chunks = append(chunks, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      ChunkName(secret.Name, i),
+				Namespace: secret.Namespace,
+				Labels: map[string]string{
+					"managed-by": "arlon",
+					"arlon-type": "config-bundle-chunk",
+					ChunkLabel:   secret.Name,
+				},
+			},
+			Data: map[string][]byte{"data": stored[:n]},
+		})
+		stored = stored[n:]
+	}
+	secret.Annotations[ChunksAnnotation] = strconv.Itoa(len(chunks))
+	return chunks, nil
+}
+
+// Unpack restores the original data of an inline bundle Secret read from the
+// cluster, reassembling its chunks and decompressing it.
+func Unpack(ctx context.Context, corev1 corev1types.CoreV1Interface, secret *corev1.Secret) error {
+	data := secret.Data["data"]
+	if n := secret.Annotations[ChunksAnnotation]; n != "" {
+		count, err := strconv.Atoi(n)
+		if err != nil {
+			return fmt.Errorf("bundle %s has an invalid chunk count %s", secret.Name, n)
+		}
+		data = nil
+		for i := 0; i < count; i++ {
+			chunk, err := getChunk(ctx, corev1.Secrets(secret.Namespace), ChunkName(secret.Name, i))
+			if apierr.IsNotFound(err) {
+				chunk, err = getChunk(ctx, corev1.Secrets(secret.Namespace), legacyChunkName(secret.Name, i))
+			}
+			if err != nil {
+				return fmt.Errorf("failed to get chunk %d of bundle %s: %w", i, secret.Name, err)
+			}
+			if chunk.Labels[ChunkLabel] != secret.Name {
+				return fmt.Errorf("secret %s is not a chunk of bundle %s", chunk.Name, secret.Name)
+			}
+			data = append(data, chunk.Data["data"]...)
+		}
+	}
+	if secret.Annotations[CompressionAnnotation] == "gzip" {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("failed to decompress bundle %s: %w", secret.Name, err)
+		}
+		data, err = io.ReadAll(zr)
+		if err != nil {
+			return fmt.Errorf("failed to decompress bundle %s: %w", secret.Name, err)
+		}
+	}
+	if data != nil {
+		if secret.Data == nil {
+			secret.Data = make(map[string][]byte)
+		}
+		secret.Data["data"] = data
+	}
+	return nil
+}
+
+// StoredSize returns the size of an inline bundle's original data, which is
+// known without reading its chunks.
+func StoredSize(secret *corev1.Secret) int {
+	if size, err := strconv.Atoi(secret.Annotations[SizeAnnotation]); err == nil {
+		return size
+	}
+	return len(secret.Data["data"])
+}
+
+func getChunk(ctx context.Context, secretsApi corev1types.SecretInterface, name string) (*corev1.Secret, error) {
+	var chunk *corev1.Secret
+	err := kuberetry.OnTransient(ctx, func() (err error) {
+		chunk, err = secretsApi.Get(ctx, name, metav1.GetOptions{})
+		return
+	})
+	return chunk, err
+}
@@ -0,0 +1,121 @@
+package bundle
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+)
+
+func newBundleSecret(name string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "arlon",
+			Labels:    map[string]string{"managed-by": "arlon", "arlon-type": "config-bundle", "bundle-type": "inline"},
+		},
+		Data: map[string][]byte{},
+	}
+}
+
+// roundTrip packs data into a bundle Secret, stores it and its chunks, and
+// reads it back through a KubeStore.
+func roundTrip(t *testing.T, data []byte, compress bool) (*corev1.Secret, []*corev1.Secret, []byte) {
+	t.Helper()
+	secret := newBundleSecret("big")
+	chunks, err := Pack(secret, data, compress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	objects := []runtime.Object{secret.DeepCopy()}
+	for _, chunk := range chunks {
+		objects = append(objects, chunk)
+	}
+	st := NewKubeStore(k8sfake.NewSimpleClientset(objects...).CoreV1())
+	got, err := st.GetBundle(context.Background(), "arlon", "big")
+	if err != nil {
+		t.Fatalf("failed to get bundle: %s", err)
+	}
+	return secret, chunks, got.Data["data"]
+}
+
+func TestPackUnpack(t *testing.T) {
+	// random data doesn't compress, so large random data is chunked
+	random := make([]byte, 2*MaxChunkSize+1000)
+	rand.New(rand.NewSource(1)).Read(random)
+	compressible := bytes.Repeat([]byte("kind: CustomResourceDefinition\n"), 100000)
+	for _, tc := range []struct {
+		name        string
+		data        []byte
+		compress    bool
+		chunks      int
+		compression string
+	}{
+		{"small", []byte("kind: ConfigMap\n"), false, 0, ""},
+		{"compressed", []byte("kind: ConfigMap\n"), true, 0, "gzip"},
+		{"compressed to fit", compressible, false, 0, "gzip"},
+		{"chunked", random, false, 3, "gzip"},
+	} {
+		secret, chunks, got := roundTrip(t, tc.data, tc.compress)
+		if len(chunks) != tc.chunks {
+			t.Errorf("%s: expected %d chunks, got %d", tc.name, tc.chunks, len(chunks))
+		}
+		if secret.Annotations[CompressionAnnotation] != tc.compression {
+			t.Errorf("%s: expected compression %q, got %q", tc.name, tc.compression,
+				secret.Annotations[CompressionAnnotation])
+		}
+		if StoredSize(secret) != len(tc.data) {
+			t.Errorf("%s: expected stored size %d, got %d", tc.name, len(tc.data), StoredSize(secret))
+		}
+		if !bytes.Equal(got, tc.data) {
+			t.Errorf("%s: expected the bundle data to be restored", tc.name)
+		}
+		for i, chunk := range chunks {
+			if chunk.Name != ChunkName("big", i) || !strings.Contains(chunk.Name, "-chunk-") ||
+				chunk.Labels[ChunkLabel] != "big" {
+				t.Errorf("%s: unexpected chunk %s with labels %v", tc.name, chunk.Name, chunk.Labels)
+			}
+		}
+	}
+}
+
+func TestUnpackLegacyChunks(t *testing.T) {
+	data := make([]byte, MaxChunkSize+1000)
+	rand.New(rand.NewSource(1)).Read(data)
+	secret := newBundleSecret("big")
+	chunks, err := Pack(secret, data, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	objects := []runtime.Object{secret}
+	for i, chunk := range chunks {
+		chunk.Name = legacyChunkName("big", i)
+		objects = append(objects, chunk)
+	}
+	got, err := NewKubeStore(k8sfake.NewSimpleClientset(objects...).CoreV1()).GetBundle(context.Background(),
+		"arlon", "big")
+	if err != nil {
+		t.Fatalf("failed to get bundle with legacy chunks: %s", err)
+	}
+	if !bytes.Equal(got.Data["data"], data) {
+		t.Error("expected the bundle data to be restored from legacy chunks")
+	}
+}
+
+func TestUnpackForeignChunk(t *testing.T) {
+	secret := newBundleSecret("big")
+	secret.Annotations = map[string]string{ChunksAnnotation: "1"}
+	// a bundle that happens to be named like a chunk
+	other := newBundleSecret(ChunkName("big", 0))
+	other.Data["data"] = []byte("kind: ConfigMap\n")
+	err := Unpack(context.Background(), k8sfake.NewSimpleClientset(other).CoreV1(), secret)
+	if err == nil || !strings.Contains(err.Error(), "is not a chunk of bundle big") {
+		t.Errorf("expected a foreign secret to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,30 @@
+package bundle
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	corev1 "k8s.io/api/core/v1"
+	"strings"
+)
+
+// ContentHash returns the hex encoded sha256 of what a bundle deploys: the
+// manifests of an inline bundle, or the source of a reference bundle, that
+// is its repository, path or chart, revision and Helm values. Comparing it
+// with the hash recorded when a cluster was deployed tells whether the
+// cluster runs the bundle's current content.
+func ContentHash(secret *corev1.Secret) string {
+	var content []byte
+	if secret.Labels["bundle-type"] == "reference" {
+		content = []byte(strings.Join([]string{
+			secret.Annotations["repo-url"],
+			secret.Annotations["repo-path"],
+			secret.Annotations["repo-chart"],
+			secret.Annotations["repo-revision"],
+			string(secret.Data["values"]),
+		}, "\n"))
+	} else {
+		content = secret.Data["data"]
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
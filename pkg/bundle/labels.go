@@ -0,0 +1,71 @@
+package bundle
+
+import (
+	"context"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"strings"
+)
+
+// BundleSelectorKey is the profile key holding a label selector, such as
+// tier=networking,env in (prod,staging), adding the bundles of the profile's
+// namespace that match it to the ones the profile lists by name.
+const BundleSelectorKey = "bundleSelector"
+
+// reservedLabels are the labels arlon sets on bundles to classify them.
+var reservedLabels = map[string]bool{
+	"managed-by":  true,
+	"arlon-type":  true,
+	"bundle-type": true,
+}
+
+// CheckLabels returns an error if labels can't be set on a bundle, because
+// they are invalid or reserved by arlon.
+func CheckLabels(bundleLabels map[string]string) error {
+	for key, val := range bundleLabels {
+		if reservedLabels[key] {
+			return fmt.Errorf("label %s is reserved", key)
+		}
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return fmt.Errorf("invalid label key %s: %s", key, strings.Join(errs, ", "))
+		}
+		if errs := validation.IsValidLabelValue(val); len(errs) > 0 {
+			return fmt.Errorf("invalid value of label %s: %s", key, strings.Join(errs, ", "))
+		}
+	}
+	return nil
+}
+
+// ProfileBundleRefs returns the references of the bundles of a profile: the
+// ones it lists by name, followed by the ones of its namespace matching its
+// bundle selector that it doesn't already list.
+func ProfileBundleRefs(ctx context.Context, st Store, profile *corev1.ConfigMap) ([]string, error) {
+	var refs []string
+	seen := make(map[string]bool)
+	for _, ref := range strings.Split(profile.Data["bundles"], ",") {
+		if ref = strings.TrimSpace(ref); ref != "" {
+			refs = append(refs, ref)
+			ns, name := ParseRef(ref, profile.Namespace)
+			seen[ns+"/"+name] = true
+		}
+	}
+	if profile.Data[BundleSelectorKey] == "" {
+		return refs, nil
+	}
+	selector, err := labels.Parse(profile.Data[BundleSelectorKey])
+	if err != nil {
+		return nil, fmt.Errorf("invalid bundle selector of profile %s: %w", profile.Name, err)
+	}
+	names, err := st.ListBundles(ctx, profile.Namespace, selector)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if !seen[profile.Namespace+"/"+name] {
+			refs = append(refs, name)
+		}
+	}
+	return refs, nil
+}
@@ -0,0 +1,41 @@
+package bundle
+
+import (
+	"fmt"
+	"strings"
+)
+
+// OCIScheme prefixes the URL of a Helm repository stored in an OCI registry,
+// for e.g. oci://ghcr.io/example/charts.
+const OCIScheme = "oci://"
+
+// IsOCI tells whether repoUrl refers to a Helm repository in an OCI registry.
+func IsOCI(repoUrl string) bool {
+	return strings.HasPrefix(repoUrl, OCIScheme)
+}
+
+// ArgoRepoURL returns the repoURL that ArgoCD applications use for repoUrl.
+// ArgoCD expects OCI registries without their scheme.
+func ArgoRepoURL(repoUrl string) string {
+	return strings.TrimPrefix(repoUrl, OCIScheme)
+}
+
+// ParseOCIChart splits a chart reference of the form
+// oci://registry/path/chart:version into the repository URL, still carrying
+// its scheme, the chart name and its version.
+func ParseOCIChart(ref string) (repoUrl string, chart string, version string, err error) {
+	if !IsOCI(ref) {
+		return "", "", "", fmt.Errorf("chart reference %s doesn't start with %s", ref, OCIScheme)
+	}
+	i := strings.LastIndex(ref, "/")
+	j := strings.LastIndex(ref, ":")
+	if i < len(OCIScheme) || j < i {
+		return "", "", "", fmt.Errorf("chart reference %s is not of the form %sregistry/path/chart:version",
+			ref, OCIScheme)
+	}
+	repoUrl, chart, version = ref[:i], ref[i+1:j], ref[j+1:]
+	if chart == "" || version == "" {
+		return "", "", "", fmt.Errorf("chart reference %s has no chart name or version", ref)
+	}
+	return repoUrl, chart, version, nil
+}
@@ -0,0 +1,14 @@
+package bundle
+
+import "strings"
+
+// ParseRef splits a reference to a bundle (or profile or clusterspec), which
+// is either a name or a namespace qualified name (for e.g. teamA/nginx-bundle),
+// into its namespace and name. Unqualified references are in defaultNs.
+func ParseRef(ref string, defaultNs string) (ns string, name string) {
+	ref = strings.TrimSpace(ref)
+	if i := strings.Index(ref, "/"); i >= 0 {
+		return ref[:i], ref[i+1:]
+	}
+	return defaultNs, ref
+}
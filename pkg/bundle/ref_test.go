@@ -0,0 +1,19 @@
+package bundle
+
+import "testing"
+
+func TestParseRef(t *testing.T) {
+	for _, tc := range []struct {
+		ref  string
+		ns   string
+		name string
+	}{
+		{"nginx", "arlon", "nginx"},
+		{" team-a/nginx ", "team-a", "nginx"},
+		{"/nginx", "", "nginx"},
+	} {
+		if ns, name := ParseRef(tc.ref, "arlon"); ns != tc.ns || name != tc.name {
+			t.Errorf("%q: expected %s/%s, got %s/%s", tc.ref, tc.ns, tc.name, ns, name)
+		}
+	}
+}
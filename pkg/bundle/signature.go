@@ -0,0 +1,103 @@
+package bundle
+
+import (
+	"arlon.io/arlon/pkg/kuberetry"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	corev1api "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1types "k8s.io/client-go/kubernetes/typed/core/v1"
+	"strings"
+)
+
+// TrustedKeysConfigMapName is the ConfigMap in the arlon namespace holding
+// the PEM encoded public keys (for e.g. cosign.pub) that bundle signatures
+// are verified against, one per data key. When it exists, inline bundles
+// without a valid signature from one of the keys are refused.
+const TrustedKeysConfigMapName = "arlon-trusted-keys"
+
+// SignatureKey is the bundle Secret data key holding the signature of the
+// bundle's data, in the base64 format produced by `cosign sign-blob`.
+const SignatureKey = "signature"
+
+type TrustedKey struct {
+	Name string
+	Key  crypto.PublicKey
+}
+
+// LoadTrustedKeys returns the trusted keys, or nil if verification is not
+// enabled in the namespace.
+func LoadTrustedKeys(ctx context.Context, corev1 corev1types.CoreV1Interface, arlonNs string) ([]TrustedKey, error) {
+	var cm *corev1api.ConfigMap
+	err := kuberetry.OnTransient(ctx, func() (err error) {
+		cm, err = corev1.ConfigMaps(arlonNs).Get(ctx, TrustedKeysConfigMapName, metav1.GetOptions{})
+		return
+	})
+	if apierr.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get trusted keys configmap: %w", err)
+	}
+	keys := []TrustedKey{}
+	for name, data := range cm.Data {
+		key, err := ParsePublicKey([]byte(data))
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted key %s: %w", name, err)
+		}
+		keys = append(keys, TrustedKey{Name: name, Key: key})
+	}
+	return keys, nil
+}
+
+func ParsePublicKey(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+	return key, nil
+}
+
+// Verify checks that signature is a valid signature of data by one of the
+// trusted keys, and returns the name of that key.
+func Verify(keys []TrustedKey, data []byte, signature []byte) (string, error) {
+	if len(signature) == 0 {
+		return "", fmt.Errorf("bundle is not signed")
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+	if err != nil {
+		return "", fmt.Errorf("failed to decode signature: %w", err)
+	}
+	digest := sha256.Sum256(data)
+	for _, key := range keys {
+		if verifyWithKey(key.Key, data, digest[:], sig) {
+			return key.Name, nil
+		}
+	}
+	return "", fmt.Errorf("signature does not match any trusted key")
+}
+
+func verifyWithKey(key crypto.PublicKey, data []byte, digest []byte, sig []byte) bool {
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(k, digest, sig)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, digest, sig) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(k, data, sig)
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package bundle
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+)
+
+func encodePublicKey(t *testing.T, key crypto.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func TestVerify(t *testing.T) {
+	data := []byte("kind: ConfigMap\n")
+	digest := sha256.Sum256(data)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecSig, err := ecdsa.SignASN1(rand.Reader, ecKey, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaSig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edSig := ed25519.Sign(edKey, data)
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: TrustedKeysConfigMapName, Namespace: "arlon"},
+		Data: map[string]string{
+			"cosign.pub": encodePublicKey(t, &ecKey.PublicKey),
+			"rsa.pub":    encodePublicKey(t, &rsaKey.PublicKey),
+			"ed.pub":     encodePublicKey(t, edPub),
+		},
+	}
+	keys, err := LoadTrustedKeys(context.Background(), k8sfake.NewSimpleClientset(cm).CoreV1(), "arlon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 3 {
+		t.Fatalf("expected 3 trusted keys, got %d", len(keys))
+	}
+	for name, sig := range map[string][]byte{"cosign.pub": ecSig, "rsa.pub": rsaSig, "ed.pub": edSig} {
+		// signatures are in the base64 format of cosign sign-blob
+		encoded := []byte(base64.StdEncoding.EncodeToString(sig) + "\n")
+		keyName, err := Verify(keys, data, encoded)
+		if err != nil || keyName != name {
+			t.Errorf("expected the signature to be verified by %s, got %q (%v)", name, keyName, err)
+		}
+		if _, err := Verify(keys, []byte("kind: Secret\n"), encoded); err == nil {
+			t.Errorf("expected the signature by %s not to match other data", name)
+		}
+	}
+	if _, err := Verify(keys, data, nil); err == nil || !strings.Contains(err.Error(), "not signed") {
+		t.Errorf("expected an unsigned bundle to be refused, got %v", err)
+	}
+	if _, err := Verify(keys, data, []byte("not base64!")); err == nil {
+		t.Error("expected an undecodable signature to be refused")
+	}
+}
+
+func TestLoadTrustedKeys(t *testing.T) {
+	ctx := context.Background()
+	keys, err := LoadTrustedKeys(ctx, k8sfake.NewSimpleClientset().CoreV1(), "arlon")
+	if err != nil || keys != nil {
+		t.Errorf("expected verification to be disabled without the configmap, got %v (%v)", keys, err)
+	}
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: TrustedKeysConfigMapName, Namespace: "arlon"},
+		Data:       map[string]string{"broken.pub": "not a key"},
+	}
+	_, err = LoadTrustedKeys(ctx, k8sfake.NewSimpleClientset(cm).CoreV1(), "arlon")
+	if err == nil || !strings.Contains(err.Error(), "invalid trusted key broken.pub") {
+		t.Errorf("expected an invalid key to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,270 @@
+package bundle
+
+import (
+	"arlon.io/arlon/pkg/kuberetry"
+	"context"
+	"fmt"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+	corev1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes/scheme"
+	corev1types "k8s.io/client-go/kubernetes/typed/core/v1"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// StoreConfigMapName is the ConfigMap in the arlon namespace selecting where
+// bundles and profiles are read from when deploying clusters. Without it,
+// they are the Secrets and ConfigMaps of the management cluster. Its type key
+// is kubernetes (the default) or git, in which case repoUrl, repoBranch
+// (default main) and path (default the repository root) locate the store.
+const StoreConfigMapName = "arlon-store"
+
+// Store holds the bundles and profiles that clusters are deployed from.
+// Whatever the backend, bundles are returned as Secrets and profiles as
+// ConfigMaps, as they are stored in the management cluster.
+type Store interface {
+	GetBundle(ctx context.Context, ns string, name string) (*corev1.Secret, error)
+	GetProfile(ctx context.Context, ns string, name string) (*corev1.ConfigMap, error)
+	// ListBundles returns the names of the bundles of a namespace whose
+	// labels match selector, sorted
+	ListBundles(ctx context.Context, ns string, selector labels.Selector) ([]string, error)
+	// ListProfiles returns the profiles of all namespaces, sorted by
+	// namespace and name
+	ListProfiles(ctx context.Context) ([]corev1.ConfigMap, error)
+}
+
+// -----------------------------------------------------------------------------
+
+// NewKubeStore returns a Store reading bundles and profiles from the
+// management cluster.
+func NewKubeStore(corev1 corev1types.CoreV1Interface) Store {
+	return &kubeStore{corev1: corev1}
+}
+
+type kubeStore struct {
+	corev1 corev1types.CoreV1Interface
+}
+
+// GetBundle returns the bundle with its original data, reassembled from its
+// chunks and decompressed.
+func (s *kubeStore) GetBundle(ctx context.Context, ns string, name string) (*corev1.Secret, error) {
+	var secret *corev1.Secret
+	err := kuberetry.OnTransient(ctx, func() (err error) {
+		secret, err = s.corev1.Secrets(ns).Get(ctx, name, metav1.GetOptions{})
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+	if secret.Labels["bundle-type"] == "inline" {
+		if err := Unpack(ctx, s.corev1, secret); err != nil {
+			return nil, err
+		}
+	}
+	return secret, nil
+}
+
+func (s *kubeStore) GetProfile(ctx context.Context, ns string, name string) (*corev1.ConfigMap, error) {
+	var cm *corev1.ConfigMap
+	err := kuberetry.OnTransient(ctx, func() (err error) {
+		cm, err = s.corev1.ConfigMaps(ns).Get(ctx, name, metav1.GetOptions{})
+		return
+	})
+	return cm, err
+}
+
+func (s *kubeStore) ListBundles(ctx context.Context, ns string, selector labels.Selector) ([]string, error) {
+	labelSelector := "managed-by=arlon,arlon-type=config-bundle"
+	if !selector.Empty() {
+		labelSelector += "," + selector.String()
+	}
+	var secrets *corev1.SecretList
+	err := kuberetry.OnTransient(ctx, func() (err error) {
+		secrets, err = s.corev1.Secrets(ns).List(ctx, metav1.ListOptions{LabelSelector: labelSelector})
+		return
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list bundles: %w", err)
+	}
+	var names []string
+	for _, secret := range secrets.Items {
+		names = append(names, secret.Name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *kubeStore) ListProfiles(ctx context.Context) ([]corev1.ConfigMap, error) {
+	var configMaps *corev1.ConfigMapList
+	err := kuberetry.OnTransient(ctx, func() (err error) {
+		configMaps, err = s.corev1.ConfigMaps(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+			LabelSelector: "managed-by=arlon,arlon-type=profile",
+		})
+		return
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list profiles: %w", err)
+	}
+	profiles := configMaps.Items
+	sort.Slice(profiles, func(i, j int) bool {
+		a, b := profiles[i], profiles[j]
+		return a.Namespace < b.Namespace || (a.Namespace == b.Namespace && a.Name < b.Name)
+	})
+	return profiles, nil
+}
+
+// -----------------------------------------------------------------------------
+
+// NewGitStore returns a Store reading bundles and profiles from a git
+// working tree, which keeps their history and isn't bound by the size limit
+// of Kubernetes objects. Bundles are Secret manifests at
+// {basePath}/{namespace}/bundles/{name}.yaml, whose data may be given as
+// plain text with stringData, and profiles ConfigMap manifests at
+// {basePath}/{namespace}/profiles/{name}.yaml. Their name, namespace and
+// arlon-type label default to the ones implied by their location.
+func NewGitStore(fsys billy.Filesystem, basePath string) Store {
+	return &gitStore{fsys: fsys, basePath: basePath}
+}
+
+type gitStore struct {
+	fsys     billy.Filesystem
+	basePath string
+}
+
+func (s *gitStore) GetBundle(ctx context.Context, ns string, name string) (*corev1.Secret, error) {
+	secret := &corev1.Secret{}
+	if err := s.read(ns, "bundles", name, secret); err != nil {
+		return nil, err
+	}
+	if secret.Data == nil {
+		secret.Data = make(map[string][]byte)
+	}
+	for key, val := range secret.StringData {
+		secret.Data[key] = []byte(val)
+	}
+	secret.StringData = nil
+	s.defaultMeta(&secret.ObjectMeta, ns, name, "config-bundle")
+	return secret, nil
+}
+
+func (s *gitStore) GetProfile(ctx context.Context, ns string, name string) (*corev1.ConfigMap, error) {
+	cm := &corev1.ConfigMap{}
+	if err := s.read(ns, "profiles", name, cm); err != nil {
+		return nil, err
+	}
+	s.defaultMeta(&cm.ObjectMeta, ns, name, "profile")
+	return cm, nil
+}
+
+func (s *gitStore) ListBundles(ctx context.Context, ns string, selector labels.Selector) ([]string, error) {
+	dir := path.Join(s.basePath, ns, "bundles")
+	items, err := s.fsys.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+	var names []string
+	for _, item := range items {
+		name := strings.TrimSuffix(item.Name(), ".yaml")
+		if item.IsDir() || name == item.Name() {
+			continue
+		}
+		secret, err := s.GetBundle(ctx, ns, name)
+		if err != nil {
+			return nil, err
+		}
+		if selector.Matches(labels.Set(secret.Labels)) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *gitStore) ListProfiles(ctx context.Context) ([]corev1.ConfigMap, error) {
+	namespaces, err := s.fsys.ReadDir(s.basePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", s.basePath, err)
+	}
+	var profiles []corev1.ConfigMap
+	// ReadDir sorts entries by name
+	for _, nsItem := range namespaces {
+		if !nsItem.IsDir() {
+			continue
+		}
+		dir := path.Join(s.basePath, nsItem.Name(), "profiles")
+		items, err := s.fsys.ReadDir(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", dir, err)
+		}
+		for _, item := range items {
+			name := strings.TrimSuffix(item.Name(), ".yaml")
+			if item.IsDir() || name == item.Name() {
+				continue
+			}
+			cm, err := s.GetProfile(ctx, nsItem.Name(), name)
+			if err != nil {
+				return nil, err
+			}
+			profiles = append(profiles, *cm)
+		}
+	}
+	return profiles, nil
+}
+
+func (s *gitStore) read(ns string, kind string, name string, into interface{}) error {
+	filePath := path.Join(s.basePath, ns, kind, name+".yaml")
+	data, err := util.ReadFile(s.fsys, filePath)
+	if os.IsNotExist(err) {
+		return apierr.NewNotFound(corev1.Resource(kind), ns+"/"+name)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", filePath, err)
+	}
+	obj, _, err := scheme.Codecs.UniversalDeserializer().Decode(data, nil, nil)
+	if err != nil {
+		return fmt.Errorf("failed to decode %s: %w", filePath, err)
+	}
+	switch into := into.(type) {
+	case *corev1.Secret:
+		secret, ok := obj.(*corev1.Secret)
+		if !ok {
+			return fmt.Errorf("%s is not a Secret", filePath)
+		}
+		*into = *secret
+	case *corev1.ConfigMap:
+		cm, ok := obj.(*corev1.ConfigMap)
+		if !ok {
+			return fmt.Errorf("%s is not a ConfigMap", filePath)
+		}
+		*into = *cm
+	}
+	return nil
+}
+
+func (s *gitStore) defaultMeta(meta *metav1.ObjectMeta, ns string, name string, arlonType string) {
+	meta.Name, meta.Namespace = name, ns
+	if meta.Labels == nil {
+		meta.Labels = make(map[string]string)
+	}
+	if meta.Labels["managed-by"] == "" {
+		meta.Labels["managed-by"] = "arlon"
+	}
+	if meta.Labels["arlon-type"] == "" {
+		meta.Labels["arlon-type"] = arlonType
+	}
+}
@@ -0,0 +1,83 @@
+package bundle
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func TestGitStore(t *testing.T) {
+	fsys := memfs.New()
+	for name, content := range map[string]string{
+		"store/arlon/profiles/dev.yaml": `apiVersion: v1
+kind: ConfigMap
+data:
+  bundles: guestbook,team-a/nginx
+`,
+		"store/arlon/bundles/guestbook.yaml": `apiVersion: v1
+kind: Secret
+metadata:
+  labels:
+    bundle-type: inline
+stringData:
+  data: |
+    kind: ConfigMap
+`,
+		"store/team-a/bundles/nginx.yaml": `apiVersion: v1
+kind: Secret
+metadata:
+  labels:
+    bundle-type: reference
+  annotations:
+    repo-url: https://charts.example.com
+    repo-chart: nginx
+    repo-revision: "1.0"
+`,
+		"store/team-a/bundles/README.md": "bundles of team a\n",
+	} {
+		if err := util.WriteFile(fsys, name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := context.Background()
+	st := NewGitStore(fsys, "store")
+	profile, err := st.GetProfile(ctx, "arlon", "dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.Name != "dev" || profile.Namespace != "arlon" || profile.Labels["arlon-type"] != "profile" ||
+		profile.Data["bundles"] != "guestbook,team-a/nginx" {
+		t.Errorf("unexpected profile %v", profile)
+	}
+	guestbook, err := st.GetBundle(ctx, "arlon", "guestbook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(guestbook.Data["data"]) != "kind: ConfigMap\n" || guestbook.StringData != nil ||
+		guestbook.Labels["managed-by"] != "arlon" || guestbook.Labels["arlon-type"] != "config-bundle" {
+		t.Errorf("expected the inline bundle's string data to be decoded, got %v", guestbook)
+	}
+	nginx, err := st.GetBundle(ctx, "team-a", "nginx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nginx.Namespace != "team-a" || nginx.Annotations["repo-chart"] != "nginx" {
+		t.Errorf("unexpected reference bundle %v", nginx)
+	}
+	if _, err := st.GetProfile(ctx, "arlon", "prod"); !apierr.IsNotFound(err) {
+		t.Errorf("expected a missing profile to be not found, got %v", err)
+	}
+	names, err := st.ListBundles(ctx, "team-a", labels.SelectorFromSet(labels.Set{"bundle-type": "reference"}))
+	if err != nil || !reflect.DeepEqual(names, []string{"nginx"}) {
+		t.Errorf("expected the reference bundles of team-a to be listed, got %v (%v)", names, err)
+	}
+	profiles, err := st.ListProfiles(ctx)
+	if err != nil || len(profiles) != 1 || profiles[0].Name != "dev" {
+		t.Errorf("expected the dev profile to be listed, got %v (%v)", profiles, err)
+	}
+}
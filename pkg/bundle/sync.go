@@ -0,0 +1,118 @@
+package bundle
+
+import (
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Annotations of bundles customizing the sync policy of the applications
+// generated for them, in addition to arlon's automated sync with pruning.
+const (
+	// SyncOptionsAnnotation holds a comma separated list of ArgoCD sync
+	// options, such as ServerSideApply=true
+	SyncOptionsAnnotation = "sync-options"
+	// SyncRetryLimitAnnotation enables retrying failed syncs, up to this
+	// number of times (-1 retries forever)
+	SyncRetryLimitAnnotation = "sync-retry-limit"
+	// SyncRetryBackoffAnnotation is the duration to wait before the first
+	// retry, for e.g. 5s
+	SyncRetryBackoffAnnotation = "sync-retry-backoff"
+	// SyncRetryBackoffFactorAnnotation multiplies the wait between retries
+	SyncRetryBackoffFactorAnnotation = "sync-retry-backoff-factor"
+	// SyncRetryMaxBackoffAnnotation caps the wait between retries
+	SyncRetryMaxBackoffAnnotation = "sync-retry-max-backoff"
+)
+
+// syncOptionKeys lists the sync options bundles may set. Options that
+// apply to all of an application's resources, unlike the ones set by
+// resource annotations, are the ones CRD-heavy or large add-ons need.
+var syncOptionKeys = map[string]bool{
+	"ApplyOutOfSyncOnly":       true,
+	"CreateNamespace":          true,
+	"FailOnSharedResource":     true,
+	"PruneLast":                true,
+	"PrunePropagationPolicy":   true,
+	"Replace":                  true,
+	"RespectIgnoreDifferences": true,
+	"ServerSideApply":          true,
+	"Validate":                 true,
+}
+
+// SyncRetry configures the retries of an application's failed syncs, with
+// ArgoCD's defaults filled in.
+type SyncRetry struct {
+	Limit         int64
+	Backoff       string
+	BackoffFactor int64
+	MaxBackoff    string
+}
+
+// ParseSyncOptions parses a comma separated list of sync options. An option
+// without a value, for e.g. ServerSideApply, is set to true.
+func ParseSyncOptions(s string) ([]string, error) {
+	var options []string
+	for _, option := range strings.Split(s, ",") {
+		option = strings.TrimSpace(option)
+		if option == "" {
+			continue
+		}
+		if !strings.Contains(option, "=") {
+			option += "=true"
+		}
+		key := strings.SplitN(option, "=", 2)[0]
+		if !syncOptionKeys[key] {
+			return nil, fmt.Errorf("unsupported sync option %s", key)
+		}
+		options = append(options, option)
+	}
+	return options, nil
+}
+
+// SyncPolicyOf returns the sync options and retry settings of a bundle. The
+// retry settings are nil unless the bundle sets a retry limit.
+func SyncPolicyOf(secret *corev1.Secret) (options []string, retry *SyncRetry, err error) {
+	options, err = ParseSyncOptions(secret.Annotations[SyncOptionsAnnotation])
+	if err != nil {
+		return nil, nil, fmt.Errorf("bundle %s: %w", secret.Name, err)
+	}
+	limit := secret.Annotations[SyncRetryLimitAnnotation]
+	if limit == "" {
+		for _, key := range []string{SyncRetryBackoffAnnotation,
+			SyncRetryBackoffFactorAnnotation, SyncRetryMaxBackoffAnnotation} {
+			if secret.Annotations[key] != "" {
+				return nil, nil, fmt.Errorf("bundle %s sets %s without %s",
+					secret.Name, key, SyncRetryLimitAnnotation)
+			}
+		}
+		return options, nil, nil
+	}
+	retry = &SyncRetry{Backoff: "5s", BackoffFactor: 2, MaxBackoff: "3m"}
+	retry.Limit, err = strconv.ParseInt(limit, 10, 64)
+	if err != nil || retry.Limit < -1 {
+		return nil, nil, fmt.Errorf("bundle %s has an invalid retry limit %s", secret.Name, limit)
+	}
+	if factor := secret.Annotations[SyncRetryBackoffFactorAnnotation]; factor != "" {
+		retry.BackoffFactor, err = strconv.ParseInt(factor, 10, 64)
+		if err != nil || retry.BackoffFactor < 1 {
+			return nil, nil, fmt.Errorf("bundle %s has an invalid retry backoff factor %s",
+				secret.Name, factor)
+		}
+	}
+	for key, dst := range map[string]*string{
+		SyncRetryBackoffAnnotation:    &retry.Backoff,
+		SyncRetryMaxBackoffAnnotation: &retry.MaxBackoff,
+	} {
+		val := secret.Annotations[key]
+		if val == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(val); err != nil {
+			return nil, nil, fmt.Errorf("bundle %s has an invalid %s: %w", secret.Name, key, err)
+		}
+		*dst = val
+	}
+	return options, retry, nil
+}
@@ -0,0 +1,174 @@
+package bundle
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"sort"
+)
+
+// validationFieldManager is the field manager of the dry-run applies
+// validating bundle documents.
+const validationFieldManager = "arlon-validate"
+
+// DocumentError is the validation error of a document of an inline bundle.
+type DocumentError struct {
+	// Index is the position of the document in the bundle, from 1
+	Index     int
+	Kind      string
+	Namespace string
+	Name      string
+	Err       string
+}
+
+func (e DocumentError) String() string {
+	if e.Kind == "" {
+		return fmt.Sprintf("document %d: %s", e.Index, e.Err)
+	}
+	name := e.Name
+	if e.Namespace != "" {
+		name = e.Namespace + "/" + name
+	}
+	return fmt.Sprintf("document %d (%s %s): %s", e.Index, e.Kind, name, e.Err)
+}
+
+// Validate checks the manifests of an inline bundle against the cluster of
+// config by applying each of them with a server-side dry-run, so that YAML
+// and schema errors are caught before the bundle is deployed. Documents
+// without a namespace are validated in defaultNs, like ArgoCD deploys them.
+// Objects whose kind or namespace is created by the bundle itself can't be
+// dry-run, they are only checked to be well formed.
+// It returns the errors of the invalid documents, empty if all are valid.
+func Validate(
+	ctx context.Context,
+	config *restclient.Config,
+	data []byte,
+	defaultNs string,
+) ([]DocumentError, error) {
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create discovery client: %w", err)
+	}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dynamic client: %w", err)
+	}
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(discoveryClient))
+	return validateDocuments(ctx, dynamicClient, mapper, data, defaultNs)
+}
+
+func validateDocuments(
+	ctx context.Context,
+	dynamicClient dynamic.Interface,
+	mapper meta.RESTMapper,
+	data []byte,
+	defaultNs string,
+) ([]DocumentError, error) {
+	var docErrs []DocumentError
+	var objs []*unstructured.Unstructured
+	var indexes []int
+	reader := yaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(data)))
+	index := 0
+	for {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// the stream can't be split any further
+			docErrs = append(docErrs, DocumentError{Index: index + 1, Err: err.Error()})
+			break
+		}
+		jsonData, err := yaml.ToJSON(doc)
+		if err == nil && string(jsonData) == "null" {
+			// an empty document, or one holding only comments
+			continue
+		}
+		index++
+		if err != nil {
+			docErrs = append(docErrs, DocumentError{Index: index, Err: err.Error()})
+			continue
+		}
+		obj := &unstructured.Unstructured{}
+		if err := obj.UnmarshalJSON(jsonData); err != nil {
+			docErrs = append(docErrs, DocumentError{Index: index, Err: err.Error()})
+			continue
+		}
+		if obj.GetName() == "" {
+			docErrs = append(docErrs, DocumentError{Index: index, Kind: obj.GetKind(),
+				Err: "missing metadata.name"})
+			continue
+		}
+		objs = append(objs, obj)
+		indexes = append(indexes, index)
+	}
+	// the kinds and namespaces the bundle creates for its other objects
+	bundleKinds := make(map[string]bool)
+	bundleNamespaces := make(map[string]bool)
+	for _, obj := range objs {
+		gvk := obj.GroupVersionKind()
+		switch {
+		case gvk.Group == "" && gvk.Kind == "Namespace":
+			bundleNamespaces[obj.GetName()] = true
+		case gvk.Group == "apiextensions.k8s.io" && gvk.Kind == "CustomResourceDefinition":
+			group, _, _ := unstructured.NestedString(obj.Object, "spec", "group")
+			kind, _, _ := unstructured.NestedString(obj.Object, "spec", "names", "kind")
+			bundleKinds[group+"/"+kind] = true
+		}
+	}
+	for i, obj := range objs {
+		docErr := DocumentError{Index: indexes[i], Kind: obj.GetKind(), Name: obj.GetName()}
+		gvk := obj.GroupVersionKind()
+		mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if err != nil {
+			if meta.IsNoMatchError(err) && bundleKinds[gvk.Group+"/"+gvk.Kind] {
+				continue
+			}
+			docErr.Err = fmt.Sprintf("unknown kind %s: %s", gvk, err)
+			docErrs = append(docErrs, docErr)
+			continue
+		}
+		var resource dynamic.ResourceInterface = dynamicClient.Resource(mapping.Resource)
+		if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+			if obj.GetNamespace() == "" {
+				obj.SetNamespace(defaultNs)
+			}
+			docErr.Namespace = obj.GetNamespace()
+			resource = dynamicClient.Resource(mapping.Resource).Namespace(obj.GetNamespace())
+		}
+		jsonData, err := obj.MarshalJSON()
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal document %d: %w", indexes[i], err)
+		}
+		force := true
+		_, err = resource.Patch(ctx, obj.GetName(), types.ApplyPatchType, jsonData, metav1.PatchOptions{
+			DryRun:       []string{metav1.DryRunAll},
+			FieldManager: validationFieldManager,
+			Force:        &force,
+		})
+		if err != nil {
+			if apierr.IsNotFound(err) && bundleNamespaces[obj.GetNamespace()] {
+				continue
+			}
+			docErr.Err = err.Error()
+			docErrs = append(docErrs, docErr)
+		}
+	}
+	sort.Slice(docErrs, func(i, j int) bool {
+		return docErrs[i].Index < docErrs[j].Index
+	})
+	return docErrs, nil
+}
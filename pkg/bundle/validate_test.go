@@ -0,0 +1,136 @@
+package bundle
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const validatedBundle = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+data:
+  color: blue
+---
+# only comments
+---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: team-x
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: team-settings
+  namespace: team-x
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: broken
+---
+apiVersion: v1
+kind: ConfigMap
+data:
+  color: red
+---
+apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: widgets.example.com
+spec:
+  group: example.com
+  names:
+    kind: Widget
+---
+apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: w1
+---
+apiVersion: example.com/v1
+kind: Gadget
+metadata:
+  name: g1
+---
+kind: [
+`
+
+func TestValidateDocuments(t *testing.T) {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	for _, gvk := range []schema.GroupVersionKind{
+		{Version: "v1", Kind: "ConfigMap"},
+		{Group: "apps", Version: "v1", Kind: "Deployment"},
+	} {
+		mapper.Add(gvk, meta.RESTScopeNamespace)
+	}
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Namespace"}, meta.RESTScopeRoot)
+	mapper.Add(schema.GroupVersionKind{Group: "apiextensions.k8s.io", Version: "v1", Kind: "CustomResourceDefinition"},
+		meta.RESTScopeRoot)
+
+	// the cluster has the default namespace only, and refuses deployments
+	// without a spec
+	var applied []string
+	client := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+	client.PrependReactor("patch", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		patch := action.(k8stesting.PatchAction)
+		if patch.GetPatchType() != types.ApplyPatchType {
+			t.Errorf("expected a server-side apply, got %s", patch.GetPatchType())
+		}
+		obj := &unstructured.Unstructured{}
+		if err := json.Unmarshal(patch.GetPatch(), &obj.Object); err != nil {
+			return true, nil, err
+		}
+		if ns := patch.GetNamespace(); ns != "" && ns != metav1.NamespaceDefault {
+			return true, nil, apierr.NewNotFound(schema.GroupResource{Resource: "namespaces"}, ns)
+		}
+		if obj.GetKind() == "Deployment" {
+			return true, nil, apierr.NewInvalid(schema.GroupKind{Group: "apps", Kind: "Deployment"}, obj.GetName(),
+				field.ErrorList{field.Required(field.NewPath("spec", "selector"), "")})
+		}
+		applied = append(applied, obj.GetKind()+" "+obj.GetNamespace()+"/"+obj.GetName())
+		return true, obj, nil
+	})
+
+	docErrs, err := validateDocuments(context.Background(), client, mapper, []byte(validatedBundle),
+		metav1.NamespaceDefault)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		prefix string
+		err    string
+	}{
+		{"document 4 (Deployment default/broken): ", "spec.selector: Required value"},
+		{"document 5 (ConfigMap ): ", "missing metadata.name"},
+		{"document 8 (Gadget g1): ", "unknown kind example.com/v1, Kind=Gadget"},
+		{"document 9: ", "did not find expected node content"},
+	}
+	if len(docErrs) != len(expected) {
+		t.Fatalf("expected %d invalid documents, got %q", len(expected), docErrs)
+	}
+	for i, e := range expected {
+		msg := docErrs[i].String()
+		if !strings.HasPrefix(msg, e.prefix) || !strings.Contains(msg, e.err) {
+			t.Errorf("expected error %q...%q, got %q", e.prefix, e.err, msg)
+		}
+	}
+	expectedApplied := "ConfigMap default/settings,Namespace /team-x,CustomResourceDefinition /widgets.example.com"
+	if strings.Join(applied, ",") != expectedApplied {
+		t.Errorf("expected dry-runs of %s, got %s", expectedApplied, strings.Join(applied, ","))
+	}
+}
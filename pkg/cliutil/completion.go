@@ -0,0 +1,183 @@
+package cliutil
+
+import (
+	"arlon.io/arlon/pkg/config"
+	"context"
+	"fmt"
+	appclientset "github.com/argoproj/argo-cd/v2/pkg/client/clientset/versioned"
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	restclient "k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CompletionFunc completes a positional argument or flag value.
+type CompletionFunc = func(c *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective)
+
+// completionTimeout bounds the API calls listing resources, so that a
+// missing cluster doesn't hang the shell.
+const completionTimeout = 5 * time.Second
+
+// CompleteArgs completes each positional argument with the function of its
+// position. Arguments past them, or whose function is nil, aren't completed.
+func CompleteArgs(fns ...CompletionFunc) CompletionFunc {
+	return func(c *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) >= len(fns) || fns[len(args)] == nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		return fns[len(args)](c, args, toComplete)
+	}
+}
+
+// CompleteBundles completes the names of the bundles of the arlon namespace,
+// or of the namespace the name being completed is qualified with.
+func CompleteBundles(c *cobra.Command, _ []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return completeArlonResources(c, toComplete, "secrets", "managed-by=arlon,arlon-type=config-bundle")
+}
+
+// CompleteProfiles completes the names of the profiles like CompleteBundles.
+func CompleteProfiles(c *cobra.Command, _ []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return completeArlonResources(c, toComplete, "configmaps", "managed-by=arlon,arlon-type=profile")
+}
+
+// CompleteClusterSpecs completes the names of the clusterspecs like
+// CompleteBundles.
+func CompleteClusterSpecs(c *cobra.Command, _ []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return completeArlonResources(c, toComplete, "configmaps", "managed-by=arlon,arlon-type=clusterspec")
+}
+
+// CompleteClusters completes the names of the deployed clusters, read from
+// their root applications through the Kubernetes API rather than the ArgoCD
+// one, which needs a login session.
+func CompleteClusters(c *cobra.Command, _ []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	config, err := completionClientConfig(c)
+	if err != nil {
+		return completionError(err)
+	}
+	appClient, err := appclientset.NewForConfig(config)
+	if err != nil {
+		return completionError(err)
+	}
+	ctx, cancel := context.WithTimeout(c.Context(), completionTimeout)
+	defer cancel()
+	argocdNs := flagValue(c, "argocd", "argocd-ns")
+	apps, err := appClient.ArgoprojV1alpha1().Applications(argocdNs).List(ctx,
+		metav1.ListOptions{LabelSelector: "managed-by=arlon,arlon-type=cluster"})
+	if err != nil {
+		return completionError(err)
+	}
+	var names []string
+	for _, app := range apps.Items {
+		names = append(names, app.Name)
+	}
+	return filterCompletions(names, toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// CompleteContexts completes the names of the arlon contexts.
+func CompleteContexts(_ *cobra.Command, _ []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	path, err := config.DefaultPath()
+	if err != nil {
+		return completionError(err)
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		return completionError(err)
+	}
+	var names []string
+	for _, ctx := range cfg.Contexts {
+		names = append(names, ctx.Name)
+	}
+	return filterCompletions(names, toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// -----------------------------------------------------------------------------
+
+func completeArlonResources(
+	c *cobra.Command,
+	toComplete string,
+	resource string,
+	selector string,
+) ([]string, cobra.ShellCompDirective) {
+	config, err := completionClientConfig(c)
+	if err != nil {
+		return completionError(err)
+	}
+	kubeClient, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return completionError(err)
+	}
+	ns := flagValue(c, "arlon", "arlon-ns", "ns")
+	prefix := ""
+	if i := strings.Index(toComplete, "/"); i >= 0 {
+		ns, prefix = toComplete[:i], toComplete[:i+1]
+	}
+	ctx, cancel := context.WithTimeout(c.Context(), completionTimeout)
+	defer cancel()
+	opts := metav1.ListOptions{LabelSelector: selector}
+	var names []string
+	switch resource {
+	case "secrets":
+		list, err := kubeClient.CoreV1().Secrets(ns).List(ctx, opts)
+		if err != nil {
+			return completionError(err)
+		}
+		for _, item := range list.Items {
+			names = append(names, prefix+item.Name)
+		}
+	case "configmaps":
+		list, err := kubeClient.CoreV1().ConfigMaps(ns).List(ctx, opts)
+		if err != nil {
+			return completionError(err)
+		}
+		for _, item := range list.Items {
+			names = append(names, prefix+item.Name)
+		}
+	}
+	return filterCompletions(names, toComplete), cobra.ShellCompDirectiveNoFileComp
+}
+
+// completionClientConfig returns the Kubernetes client configuration of the
+// command's kubectl flags. Completion doesn't run the persistent pre-run
+// hooks, so the arlon context is applied here.
+func completionClientConfig(c *cobra.Command) (*restclient.Config, error) {
+	if err := applyContext(c); err != nil {
+		return nil, err
+	}
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = flagValue(c, "", "kubeconfig")
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: flagValue(c, "", "context")}
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+}
+
+// flagValue returns the value of the first of the named flags the command
+// has, or def if it has none of them.
+func flagValue(c *cobra.Command, def string, names ...string) string {
+	for _, name := range names {
+		if flag := c.Flags().Lookup(name); flag != nil {
+			return flag.Value.String()
+		}
+	}
+	return def
+}
+
+func filterCompletions(names []string, toComplete string) []string {
+	var matches []string
+	for _, name := range names {
+		if strings.HasPrefix(name, toComplete) {
+			matches = append(matches, name)
+		}
+	}
+	sort.Strings(matches)
+	return matches
+}
+
+// completionError reports err on the completion's debug output, offering
+// no completions rather than file names.
+func completionError(err error) ([]string, cobra.ShellCompDirective) {
+	cobra.CompDebugln(fmt.Sprintf("arlon: %s", err), false)
+	return nil, cobra.ShellCompDirectiveNoFileComp
+}
@@ -0,0 +1,160 @@
+package cliutil
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"arlon.io/arlon/pkg/config"
+	argoappv1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// setenv sets an environment variable until the test ends.
+func setenv(t *testing.T, key string, value string) {
+	saved, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, saved)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+// newAPIServer serves lists of objects by their path, checking the label
+// selector of the request.
+func newAPIServer(t *testing.T, selectors map[string]string, lists map[string]interface{}) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		list, ok := lists[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if selector := r.URL.Query().Get("labelSelector"); selector != selectors[r.URL.Path] {
+			t.Errorf("unexpected selector %q listing %s", selector, r.URL.Path)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(list)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestCompletion(t *testing.T) {
+	const bundleSelector = "managed-by=arlon,arlon-type=config-bundle"
+	const clusterSelector = "managed-by=arlon,arlon-type=cluster"
+	secrets := func(names ...string) *corev1.SecretList {
+		list := &corev1.SecretList{TypeMeta: metav1.TypeMeta{Kind: "SecretList", APIVersion: "v1"}}
+		for _, name := range names {
+			list.Items = append(list.Items, corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name}})
+		}
+		return list
+	}
+	apps := &argoappv1.ApplicationList{TypeMeta: metav1.TypeMeta{Kind: "ApplicationList", APIVersion: "argoproj.io/v1alpha1"},
+		Items: []argoappv1.Application{{ObjectMeta: metav1.ObjectMeta{Name: "c2"}}, {ObjectMeta: metav1.ObjectMeta{Name: "c1"}}}}
+	server := newAPIServer(t,
+		map[string]string{
+			"/api/v1/namespaces/arlon/secrets":                          bundleSelector,
+			"/api/v1/namespaces/team-a/secrets":                         bundleSelector,
+			"/apis/argoproj.io/v1alpha1/namespaces/argocd/applications": clusterSelector,
+		},
+		map[string]interface{}{
+			"/api/v1/namespaces/arlon/secrets":                          secrets("redis", "guestbook", "nginx"),
+			"/api/v1/namespaces/team-a/secrets":                         secrets("nginx"),
+			"/apis/argoproj.io/v1alpha1/namespaces/argocd/applications": apps,
+		})
+
+	// the kubeconfig of the management cluster comes from the current
+	// arlon context, as for commands
+	dir := t.TempDir()
+	setenv(t, "HOME", dir)
+	kubeconfig := filepath.Join(dir, "kubeconfig")
+	err := os.WriteFile(kubeconfig, []byte(`apiVersion: v1
+kind: Config
+clusters:
+- name: mgmt
+  cluster:
+    server: `+server.URL+`
+contexts:
+- name: mgmt
+  context:
+    cluster: mgmt
+current-context: mgmt
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configPath := filepath.Join(dir, "config")
+	setenv(t, "ARLON_CONFIG", configPath)
+	cfg := &config.Config{CurrentContext: "mgmt", Contexts: []config.Context{
+		{Name: "mgmt", Kubeconfig: kubeconfig},
+		{Name: "edge"},
+	}}
+	if err := cfg.Save(configPath); err != nil {
+		t.Fatal(err)
+	}
+
+	command := &cobra.Command{
+		Use:               "deploy",
+		ValidArgsFunction: CompleteArgs(CompleteClusters, nil, CompleteBundles),
+		Run:               func(*cobra.Command, []string) {},
+	}
+	command.Flags().String("kubeconfig", "", "")
+	command.Flags().String("arlon-ns", "arlon", "")
+	command.Flags().String("argocd-ns", "argocd", "")
+	command.Flags().String("arlon-context", "", "")
+	command.RegisterFlagCompletionFunc("arlon-context", CompleteContexts)
+	root := &cobra.Command{Use: "arlon"}
+	root.AddCommand(command)
+	// complete runs the shell's completion request
+	complete := func(args ...string) []string {
+		var out bytes.Buffer
+		root.SetOut(&out)
+		root.SetErr(io.Discard)
+		root.SetArgs(append([]string{cobra.ShellCompRequestCmd, "deploy"}, args...))
+		if err := root.ExecuteContext(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		if directive := lines[len(lines)-1]; directive != fmt.Sprintf(":%d", cobra.ShellCompDirectiveNoFileComp) {
+			t.Errorf("expected file names not to be completed after %q, got directive %s", args, directive)
+		}
+		return lines[:len(lines)-1]
+	}
+	for _, tc := range []struct {
+		args     []string
+		expected []string
+	}{
+		{[]string{""}, []string{"c1", "c2"}},
+		{[]string{"c2"}, []string{"c2"}},
+		{[]string{"c1", ""}, []string{}},
+		{[]string{"c1", "x", ""}, []string{"guestbook", "nginx", "redis"}},
+		{[]string{"c1", "x", "g"}, []string{"guestbook"}},
+		{[]string{"c1", "x", "team-a/"}, []string{"team-a/nginx"}},
+		{[]string{"c1", "x", "guestbook", ""}, []string{}},
+		{[]string{"--arlon-context", ""}, []string{"edge", "mgmt"}},
+	} {
+		if completions := complete(tc.args...); !reflect.DeepEqual(completions, tc.expected) {
+			t.Errorf("expected completions %q after %q, got %q", tc.expected, tc.args, completions)
+		}
+	}
+
+	// an unreachable cluster offers no completions rather than file names
+	server.Close()
+	if completions := complete("c1", "x", ""); len(completions) != 0 {
+		t.Errorf("expected no completions without a cluster, got %q", completions)
+	}
+}
@@ -0,0 +1,105 @@
+package cliutil
+
+import (
+	"arlon.io/arlon/pkg/argocd"
+	"arlon.io/arlon/pkg/config"
+	"fmt"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+var contextName string
+
+// SkipContextAnnotation marks commands, such as those managing the contexts
+// themselves, whose options don't default to the current context's settings.
+const SkipContextAnnotation = "arlon.io/skip-context"
+
+// NoContextDefaultAnnotation marks options that don't default to the current
+// context's settings, because leaving them unset has a meaning of its own.
+const NoContextDefaultAnnotation = "arlon.io/no-context-default"
+
+// AddContextFlag adds the global --arlon-context flag to the root command,
+// and makes the options of every command default to the settings of that
+// context, or of the current one, and then to the defaults of the
+// configuration file.
+func AddContextFlag(command *cobra.Command) {
+	command.PersistentFlags().StringVar(&contextName, "arlon-context", "",
+		"the arlon context to take default settings from, instead of the current one")
+	command.RegisterFlagCompletionFunc("arlon-context", CompleteContexts)
+	preRun := command.PersistentPreRunE
+	command.PersistentPreRunE = func(c *cobra.Command, args []string) error {
+		if preRun != nil {
+			if err := preRun(c, args); err != nil {
+				return err
+			}
+		}
+		return applyContext(c)
+	}
+}
+
+func applyContext(c *cobra.Command) error {
+	for p := c; p != nil; p = p.Parent() {
+		if p.Annotations[SkipContextAnnotation] != "" {
+			return nil
+		}
+	}
+	path, err := config.DefaultPath()
+	if err != nil {
+		return err
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		return err
+	}
+	d := cfg.Defaults
+	defaults := map[string]string{
+		"argocd-ns":   d.ArgocdNs,
+		"arlon-ns":    d.ArlonNs,
+		"ns":          d.ArlonNs,
+		"repo-url":    d.RepoUrl,
+		"repo-branch": d.RepoBranch,
+		"path":        d.BasePath,
+		"output":      d.Output,
+	}
+	if d.AllowCommandProcessors {
+		defaults["allow-command-processors"] = "true"
+	}
+	name := contextName
+	if name == "" {
+		name = cfg.CurrentContext
+	}
+	if name != "" {
+		ctx := cfg.Get(name)
+		if ctx == nil {
+			return fmt.Errorf("arlon context %s not found", name)
+		}
+		if ctx.ArgocdContext != "" {
+			argocd.SetContext(ctx.ArgocdContext)
+		}
+		for flag, value := range map[string]string{
+			"kubeconfig":  ctx.Kubeconfig,
+			"context":     ctx.KubeContext,
+			"argocd-ns":   ctx.ArgocdNs,
+			"arlon-ns":    ctx.ArlonNs,
+			"ns":          ctx.ArlonNs,
+			"repo-url":    ctx.RepoUrl,
+			"repo-branch": ctx.RepoBranch,
+			"path":        ctx.RepoPath,
+		} {
+			if value != "" {
+				defaults[flag] = value
+			}
+		}
+	}
+	var setErr error
+	c.Flags().VisitAll(func(flag *pflag.Flag) {
+		value := defaults[flag.Name]
+		if value == "" || flag.Changed || flag.Annotations[NoContextDefaultAnnotation] != nil {
+			return
+		}
+		if err := c.Flags().Set(flag.Name, value); err != nil && setErr == nil {
+			setErr = fmt.Errorf("failed to apply default value %s to --%s: %w", value, flag.Name, err)
+		}
+	})
+	return setErr
+}
@@ -0,0 +1,82 @@
+package cliutil
+
+import (
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"arlon.io/arlon/pkg/config"
+	"github.com/spf13/cobra"
+)
+
+func TestContextDefaults(t *testing.T) {
+	dir := t.TempDir()
+	setenv(t, "HOME", dir)
+	configPath := filepath.Join(dir, ".arlon", "config")
+	setenv(t, "ARLON_CONFIG", configPath)
+	cfg := &config.Config{CurrentContext: "prod", Contexts: []config.Context{
+		{Name: "prod", ArlonNs: "arlon-prod", RepoBranch: "release"},
+		{Name: "staging", ArlonNs: "arlon-staging"},
+	}, Defaults: config.Defaults{
+		RepoUrl:    "https://git.example.com/fleet.git",
+		RepoBranch: "main",
+		ArlonNs:    "arlon-default",
+		Output:     "json",
+	}}
+	if err := cfg.Save(configPath); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { contextName = "" }()
+
+	// run runs a command with the options of most commands and returns
+	// their values
+	run := func(args ...string) (map[string]string, error) {
+		values := make(map[string]string)
+		root := &cobra.Command{Use: "arlon"}
+		AddContextFlag(root)
+		command := &cobra.Command{
+			Use: "deploy",
+			Run: func(c *cobra.Command, _ []string) {
+				for _, name := range []string{"repo-url", "repo-branch", "arlon-ns", "output", "profile"} {
+					values[name] = c.Flag(name).Value.String()
+				}
+			},
+		}
+		command.Flags().String("repo-url", "", "")
+		command.Flags().String("repo-branch", "", "")
+		command.Flags().String("arlon-ns", "arlon", "")
+		command.Flags().String("output", "table", "")
+		command.Flags().String("profile", "", "")
+		root.AddCommand(command)
+		root.SetOut(io.Discard)
+		root.SetErr(io.Discard)
+		root.SetArgs(append([]string{"deploy"}, args...))
+		contextName = ""
+		return values, root.Execute()
+	}
+	for _, tc := range []struct {
+		args     []string
+		expected string
+	}{
+		// the current context takes precedence over the defaults
+		{nil, "https://git.example.com/fleet.git,release,arlon-prod,json,"},
+		{[]string{"--arlon-context", "staging"}, "https://git.example.com/fleet.git,main,arlon-staging,json,"},
+		// explicit flags take precedence over both
+		{[]string{"--repo-branch", "hotfix", "--arlon-ns", "arlon", "--output", "yaml", "--profile", "dev"},
+			"https://git.example.com/fleet.git,hotfix,arlon,yaml,dev"},
+	} {
+		values, err := run(tc.args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual := strings.Join([]string{values["repo-url"], values["repo-branch"], values["arlon-ns"],
+			values["output"], values["profile"]}, ",")
+		if actual != tc.expected {
+			t.Errorf("expected options %s with %q, got %s", tc.expected, tc.args, actual)
+		}
+	}
+	if _, err := run("--arlon-context", "dev"); err == nil || !strings.Contains(err.Error(), "arlon context dev not found") {
+		t.Errorf("expected a missing context to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,125 @@
+package cliutil
+
+import (
+	"arlon.io/arlon/pkg/cluster"
+	"arlon.io/arlon/pkg/gitutils"
+	"arlon.io/arlon/pkg/progress"
+	"context"
+	"fmt"
+	"github.com/spf13/cobra"
+	"os"
+	"time"
+)
+
+var timeout time.Duration
+var showProgress bool
+var skipLFS bool
+var commitStrategy = commitStrategyValue(gitutils.CommitSquash)
+var ignoreSyncWindows bool
+var allowCommandProcessors bool
+
+// AddTimeoutFlag adds the global --timeout flag to the root command.
+func AddTimeoutFlag(command *cobra.Command) {
+	command.PersistentFlags().DurationVar(&timeout, "timeout", 0,
+		"maximum duration of the command's API and git calls, for e.g. 5m (0 means no limit)")
+}
+
+// AddProgressFlag adds the global --progress flag to the root command.
+func AddProgressFlag(command *cobra.Command) {
+	command.PersistentFlags().BoolVarP(&showProgress, "progress", "v", false,
+		"report the steps of the command and the progress of git transfers to stderr")
+}
+
+// AddSkipLFSFlag adds the global --skip-lfs flag to the root command.
+func AddSkipLFSFlag(command *cobra.Command) {
+	command.PersistentFlags().BoolVar(&skipLFS, "skip-lfs", false,
+		"write to git repositories using LFS, leaving their LFS files as pointer files")
+}
+
+// AddCommitStrategyFlag adds the global --commit-strategy flag to the root
+// command.
+func AddCommitStrategyFlag(command *cobra.Command) {
+	command.PersistentFlags().Var(&commitStrategy, "commit-strategy",
+		"how changes to git repositories are committed: "+gitutils.CommitSquash+
+			" (one commit per command) or "+gitutils.CommitPerBundle+
+			" (one commit per bundle of each cluster)")
+}
+
+// commitStrategyValue is the value of --commit-strategy, which is validated
+// when the flag is parsed, before a command clones or renders anything.
+type commitStrategyValue string
+
+func (v *commitStrategyValue) String() string {
+	return string(*v)
+}
+
+func (v *commitStrategyValue) Set(s string) error {
+	switch s {
+	case gitutils.CommitSquash, gitutils.CommitPerBundle:
+		*v = commitStrategyValue(s)
+		return nil
+	}
+	return fmt.Errorf("must be %s or %s", gitutils.CommitSquash, gitutils.CommitPerBundle)
+}
+
+func (v *commitStrategyValue) Type() string {
+	return "string"
+}
+
+// AddIgnoreSyncWindowsFlag adds the global --ignore-sync-windows flag to the
+// root command.
+func AddIgnoreSyncWindowsFlag(command *cobra.Command) {
+	command.PersistentFlags().BoolVar(&ignoreSyncWindows, "ignore-sync-windows", false,
+		"push changes to deployed clusters even outside the change windows of their profile")
+}
+
+// AddAllowCommandProcessorsFlag adds the global --allow-command-processors
+// flag to the root command.
+func AddAllowCommandProcessorsFlag(command *cobra.Command) {
+	command.PersistentFlags().BoolVar(&allowCommandProcessors, "allow-command-processors", false,
+		"run the post-processors of the arlon-post-processors configmap that run a command on this machine")
+}
+
+// Context returns the context that a command's API and git calls run under.
+// It derives from the context the command was executed with, is bounded by
+// --timeout, reports progress to stderr if --progress is set and lets git
+// repositories using LFS be written to if --skip-lfs is set. Changes to git
+// repositories are committed following --commit-strategy, and pushed
+// regardless of the clusters' change windows if --ignore-sync-windows is set.
+// Post-processors running a command are allowed if --allow-command-processors
+// is set.
+func Context(c *cobra.Command) (context.Context, context.CancelFunc) {
+	ctx := ServeContext(c)
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// ServeContext returns the context of a long-running command, such as the API
+// server: the context of Context without the --timeout bound, which such
+// commands apply to each of the calls they serve instead.
+func ServeContext(c *cobra.Command) context.Context {
+	ctx := c.Context()
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if showProgress {
+		ctx = progress.WithWriter(ctx, os.Stderr)
+	}
+	if skipLFS {
+		ctx = gitutils.WithSkipLFS(ctx)
+	}
+	if ignoreSyncWindows {
+		ctx = cluster.WithIgnoreSyncWindows(ctx)
+	}
+	if allowCommandProcessors {
+		ctx = cluster.WithCommandProcessors(ctx)
+	}
+	return gitutils.WithCommitStrategy(ctx, string(commitStrategy))
+}
+
+// Timeout returns the value of --timeout, 0 meaning no limit.
+func Timeout() time.Duration {
+	return timeout
+}
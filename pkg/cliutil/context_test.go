@@ -0,0 +1,37 @@
+package cliutil
+
+import (
+	"strings"
+	"testing"
+
+	"arlon.io/arlon/pkg/gitutils"
+	"github.com/spf13/cobra"
+)
+
+func TestCommitStrategyFlag(t *testing.T) {
+	ran := false
+	command := &cobra.Command{
+		Use: "arlon",
+		RunE: func(c *cobra.Command, args []string) error {
+			ran = true
+			return nil
+		},
+	}
+	AddCommitStrategyFlag(command)
+	defer func() { commitStrategy = commitStrategyValue(gitutils.CommitSquash) }()
+	command.SetArgs([]string{"--commit-strategy", "per-commit"})
+	err := command.Execute()
+	if err == nil || !strings.Contains(err.Error(), "must be squash or per-bundle") {
+		t.Errorf("expected an invalid strategy to be rejected, got %v", err)
+	}
+	if ran {
+		t.Error("expected the command not to run with an invalid strategy")
+	}
+	command.SetArgs([]string{"--commit-strategy", gitutils.CommitPerBundle})
+	if err := command.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if !ran || string(commitStrategy) != gitutils.CommitPerBundle {
+		t.Errorf("expected the command to run with strategy %s, got %s", gitutils.CommitPerBundle, commitStrategy)
+	}
+}
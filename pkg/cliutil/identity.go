@@ -0,0 +1,61 @@
+package cliutil
+
+import (
+	"arlon.io/arlon/pkg/cluster"
+	"arlon.io/arlon/pkg/log"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	authv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"os"
+	"strings"
+)
+
+// WithKubeIdentity returns a context whose operations are attributed to the
+// Kubernetes user that config authenticates as, which path policies and
+// recorded events then name, rather than to the local user running arlon.
+func WithKubeIdentity(ctx context.Context, config *rest.Config, kubeClient kubernetes.Interface) context.Context {
+	identity := KubeIdentity(ctx, config, kubeClient)
+	if identity == "" {
+		log.GetLogger().V(1).Info("could not determine the kubernetes identity of the credentials")
+		return ctx
+	}
+	return cluster.WithActor(ctx, identity)
+}
+
+// KubeIdentity returns the name of the Kubernetes user that config
+// authenticates as: the impersonated user, the common name of its client
+// certificate, or the user its bearer token belongs to, as reviewed with a
+// TokenReview. It returns "" if the user can't be determined, e.g. for the
+// credentials of an exec plugin or without permission to review tokens.
+func KubeIdentity(ctx context.Context, config *rest.Config, kubeClient kubernetes.Interface) string {
+	if config.Impersonate.UserName != "" {
+		return config.Impersonate.UserName
+	}
+	certData := config.TLSClientConfig.CertData
+	if len(certData) == 0 && config.TLSClientConfig.CertFile != "" {
+		certData, _ = os.ReadFile(config.TLSClientConfig.CertFile)
+	}
+	if block, _ := pem.Decode(certData); block != nil {
+		if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+			return cert.Subject.CommonName
+		}
+	}
+	token := config.BearerToken
+	if token == "" && config.BearerTokenFile != "" {
+		data, _ := os.ReadFile(config.BearerTokenFile)
+		token = strings.TrimSpace(string(data))
+	}
+	if token == "" {
+		return ""
+	}
+	review, err := kubeClient.AuthenticationV1().TokenReviews().Create(ctx,
+		&authv1.TokenReview{Spec: authv1.TokenReviewSpec{Token: token}}, metav1.CreateOptions{})
+	if err != nil || !review.Status.Authenticated {
+		return ""
+	}
+	return review.Status.User.Username
+}
@@ -0,0 +1,71 @@
+package cliutil
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	authv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func newClientCert(t *testing.T, commonName string) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName, Organization: []string{"system:masters"}},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestKubeIdentity(t *testing.T) {
+	kubeClient := fake.NewSimpleClientset()
+	kubeClient.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authv1.TokenReview)
+		if review.Spec.Token == "token-of-deployer" {
+			review.Status = authv1.TokenReviewStatus{
+				Authenticated: true,
+				User:          authv1.UserInfo{Username: "system:serviceaccount:team-a:deployer"},
+			}
+		}
+		return true, review, nil
+	})
+	cert := newClientCert(t, "alice")
+	for _, tc := range []struct {
+		name     string
+		config   *rest.Config
+		expected string
+	}{
+		{"impersonation", &rest.Config{
+			BearerToken: "token-of-deployer",
+			Impersonate: rest.ImpersonationConfig{UserName: "bob"},
+		}, "bob"},
+		{"client certificate", &rest.Config{TLSClientConfig: rest.TLSClientConfig{CertData: cert}}, "alice"},
+		{"bearer token", &rest.Config{BearerToken: "token-of-deployer"}, "system:serviceaccount:team-a:deployer"},
+		{"invalid token", &rest.Config{BearerToken: "invalid"}, ""},
+		{"no credentials", &rest.Config{}, ""},
+	} {
+		if actual := KubeIdentity(context.Background(), tc.config, kubeClient); actual != tc.expected {
+			t.Errorf("%s: expected %q, got %q", tc.name, tc.expected, actual)
+		}
+	}
+}
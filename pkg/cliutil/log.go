@@ -0,0 +1,22 @@
+package cliutil
+
+import (
+	"arlon.io/arlon/pkg/log"
+	"github.com/spf13/cobra"
+)
+
+var logConfig log.Config
+
+// AddLogFlags adds the global logging flags to the root command, and sets
+// up logging from them before any command runs.
+func AddLogFlags(command *cobra.Command) {
+	command.PersistentFlags().StringVar(&logConfig.Level, "loglevel", "info",
+		"the log level: debug, info, error or an integer verbosity")
+	command.PersistentFlags().StringVar(&logConfig.Format, "logformat", "console",
+		"the log format: console or json")
+	command.PersistentFlags().StringVar(&logConfig.File, "logfile", "",
+		"append logs to this file instead of stderr")
+	command.PersistentPreRunE = func(c *cobra.Command, args []string) error {
+		return log.Setup(logConfig)
+	}
+}
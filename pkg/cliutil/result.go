@@ -0,0 +1,122 @@
+package cliutil
+
+import (
+	"arlon.io/arlon/pkg/cluster"
+	"arlon.io/arlon/pkg/gitutils"
+	"arlon.io/arlon/pkg/log"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"time"
+)
+
+var resultJSON string
+
+// AddResultJSONFlag adds the global --result-json flag to the root command.
+func AddResultJSONFlag(command *cobra.Command) {
+	command.PersistentFlags().StringVar(&resultJSON, "result-json", "",
+		"append a JSON record of the outcome of cluster deploy, delete and profile sync to this file (- for stdout)")
+}
+
+// Outcomes of an operation on a cluster.
+const (
+	OutcomeSucceeded = "succeeded"
+	// OutcomeRefused is the outcome of changes refused because the change
+	// windows of the cluster's profile are closed
+	OutcomeRefused = "refused"
+	OutcomeFailed  = "failed"
+)
+
+// Result is the machine readable record of the outcome of a mutating
+// command for a cluster, which --result-json appends as a line of JSON.
+// CommitSha is empty if nothing was pushed, and Error holds the redacted
+// error of failed operations.
+type Result struct {
+	Operation    string   `json:"operation"`
+	Cluster      string   `json:"cluster"`
+	CommitSha    string   `json:"commitSha,omitempty"`
+	FilesChanged []string `json:"filesChanged"`
+	Duration     float64  `json:"durationSeconds"`
+	Outcome      string   `json:"outcome"`
+	Error        string   `json:"error,omitempty"`
+}
+
+// Operation times a mutating command and records its result.
+type Operation struct {
+	Result
+	start  time.Time
+	pushes *gitutils.PushLog
+}
+
+// StartOperation starts timing an operation on a cluster. The returned
+// context records the pushes made under it, for the result to list the files
+// they changed.
+func StartOperation(ctx context.Context, operation string, clusterName string) (context.Context, *Operation) {
+	o := &Operation{Result: Result{Operation: operation, Cluster: clusterName}, start: time.Now()}
+	if resultJSON != "" {
+		ctx, o.pushes = gitutils.WithPushLog(ctx)
+	}
+	return ctx, o
+}
+
+// Finish records the result of the operation, given the error it failed
+// with, if --result-json is set. It returns err, or the error writing the
+// result if the operation succeeded.
+func (o *Operation) Finish(err error) error {
+	return o.FinishCluster(o.Cluster, o.CommitSha, err)
+}
+
+// FinishCluster is like Finish for one of the clusters of an operation on
+// several clusters, such as a profile sync.
+func (o *Operation) FinishCluster(clusterName string, commitSha string, err error) error {
+	if resultJSON == "" {
+		return err
+	}
+	result := o.Result
+	result.Cluster = clusterName
+	result.CommitSha = commitSha
+	result.FilesChanged = []string{}
+	result.Duration = time.Since(o.start).Seconds()
+	if record, ok := o.pushes.Find(commitSha); ok && commitSha != "" {
+		result.FilesChanged = append(result.FilesChanged, record.Files...)
+	}
+	var windowErr *cluster.SyncWindowClosedError
+	switch {
+	case errors.As(err, &windowErr):
+		result.Outcome = OutcomeRefused
+	case err != nil:
+		result.Outcome = OutcomeFailed
+		// the record may be shared, such as by CI, and mustn't leak credentials
+		result.Error = log.Redact(err.Error())
+	default:
+		result.Outcome = OutcomeSucceeded
+	}
+	if writeErr := writeResult(&result); writeErr != nil && err == nil {
+		return writeErr
+	}
+	return err
+}
+
+func writeResult(result *Result) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("failed to encode result: %w", err)
+	}
+	var w io.Writer = os.Stdout
+	if resultJSON != "-" {
+		f, err := os.OpenFile(resultJSON, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open result file: %w", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if _, err := w.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write result: %w", err)
+	}
+	return nil
+}
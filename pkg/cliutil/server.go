@@ -0,0 +1,30 @@
+package cliutil
+
+import (
+	"arlon.io/arlon/pkg/server"
+	"context"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var serverAddr string
+var serverPlaintext bool
+
+// AddServerFlags adds the global flags making the commands that support it
+// clients of an arlon API server instead of running operations locally.
+func AddServerFlags(command *cobra.Command) {
+	command.PersistentFlags().StringVar(&serverAddr, "server", "",
+		"run operations through the arlon API server at this address; "+
+			"authenticates with the token in $ARLON_TOKEN")
+	command.PersistentFlags().BoolVar(&serverPlaintext, "server-plaintext", false,
+		"connect to the arlon API server without TLS")
+}
+
+// ServerClient returns a client of the arlon API server given by --server,
+// or nil if it isn't set.
+func ServerClient(ctx context.Context) (*server.Client, error) {
+	if serverAddr == "" {
+		return nil, nil
+	}
+	return server.NewClient(ctx, serverAddr, os.Getenv("ARLON_TOKEN"), serverPlaintext)
+}
@@ -0,0 +1,79 @@
+package cluster
+
+import (
+	"fmt"
+	"strconv"
+)
+
+const (
+	autoscalerChartRepo    = "https://kubernetes.github.io/autoscaler"
+	autoscalerChart        = "cluster-autoscaler"
+	autoscalerChartVersion = "9.10.8"
+	autoscalerBundleName   = "cluster-autoscaler"
+)
+
+// The autoscaler runs in the cluster's namespace on the management cluster,
+// where the Cluster API resources it scales live, and reaches the workload
+// cluster through the kubeconfig secret generated by Cluster API.
+const clusterApiAutoscalerValues = `cloudProvider: clusterapi
+clusterAPIMode: kubeconfig-incluster
+clusterAPIKubeconfigSecret: %[1]s-kubeconfig
+autoDiscovery:
+  clusterName: %[1]s
+`
+
+// autoscalerValuesTemplates maps a clusterspec type to the Helm values used
+// to configure the cluster autoscaler for that type's provider.
+var autoscalerValuesTemplates = map[string]string{
+	"":             clusterApiAutoscalerValues,
+	"capi-eks":     clusterApiAutoscalerValues,
+	"capi-kubeadm": clusterApiAutoscalerValues,
+}
+
+// autoscalingEnabled validates the autoscaling settings of a resolved
+// clusterspec and returns whether autoscaling is turned on.
+func autoscalingEnabled(specData map[string]string) (bool, error) {
+	if specData["autoscaling"] != "true" {
+		return false, nil
+	}
+	minNodes, err := strconv.Atoi(specData["minNodeCount"])
+	if err != nil {
+		return false, fmt.Errorf("autoscaling requires a numeric minNodeCount: %w", err)
+	}
+	maxNodes, err := strconv.Atoi(specData["maxNodeCount"])
+	if err != nil {
+		return false, fmt.Errorf("autoscaling requires a numeric maxNodeCount: %w", err)
+	}
+	if minNodes < 0 || maxNodes < minNodes {
+		return false, fmt.Errorf("invalid autoscaling range: minNodeCount=%d, maxNodeCount=%d",
+			minNodes, maxNodes)
+	}
+	return true, nil
+}
+
+// autoscalerBundle returns the cluster autoscaler bundle for the cluster, or
+// nil if the clusterspec doesn't enable autoscaling or opts out of the bundle
+// by setting autoscalerBundle to "false".
+func autoscalerBundle(clusterName string, specData map[string]string) (*AppSettings, error) {
+	enabled, err := autoscalingEnabled(specData)
+	if err != nil {
+		return nil, err
+	}
+	if !enabled || specData["autoscalerBundle"] == "false" {
+		return nil, nil
+	}
+	clusterType := specData["type"]
+	valuesTmpl, ok := autoscalerValuesTemplates[clusterType]
+	if !ok {
+		return nil, fmt.Errorf("no autoscaler configuration for clusterspec type %s", clusterType)
+	}
+	return &AppSettings{
+		BundleName:           autoscalerBundleName,
+		DestinationServer:    "https://kubernetes.default.svc",
+		DestinationNamespace: clusterName,
+		RepoUrl:              autoscalerChartRepo,
+		Chart:                autoscalerChart,
+		TargetRevision:       autoscalerChartVersion,
+		HelmValues:           fmt.Sprintf(valuesTmpl, clusterName),
+	}, nil
+}
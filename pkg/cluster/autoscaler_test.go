@@ -0,0 +1,59 @@
+package cluster
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAutoscalerBundle(t *testing.T) {
+	autoscaling := map[string]string{"autoscaling": "true", "minNodeCount": "1", "maxNodeCount": "5"}
+	with := func(key string, val string) map[string]string {
+		data := map[string]string{key: val}
+		for k, v := range autoscaling {
+			if k != key {
+				data[k] = v
+			}
+		}
+		return data
+	}
+	for _, tc := range []struct {
+		name     string
+		specData map[string]string
+		bundle   bool
+		err      string
+	}{
+		{"disabled", map[string]string{"nodeCount": "3"}, false, ""},
+		{"enabled", autoscaling, true, ""},
+		{"kubeadm", with("type", "capi-kubeadm"), true, ""},
+		{"opted out", with("autoscalerBundle", "false"), false, ""},
+		{"missing minimum", with("minNodeCount", ""), false, "numeric minNodeCount"},
+		{"inverted range", with("maxNodeCount", "0"), false, "invalid autoscaling range"},
+		{"unknown type", with("type", "aks"), false, "no autoscaler configuration"},
+	} {
+		app, err := autoscalerBundle("c1", tc.specData)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%s: expected error %q, got %v", tc.name, tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", tc.name, err)
+			continue
+		}
+		if (app != nil) != tc.bundle {
+			t.Errorf("%s: expected bundle %t, got %v", tc.name, tc.bundle, app)
+			continue
+		}
+		if app == nil {
+			continue
+		}
+		if app.BundleName != autoscalerBundleName || app.Chart != autoscalerChart ||
+			app.DestinationNamespace != "c1" {
+			t.Errorf("%s: unexpected autoscaler bundle %+v", tc.name, app)
+		}
+		if !strings.Contains(app.HelmValues, "clusterAPIKubeconfigSecret: c1-kubeconfig") {
+			t.Errorf("%s: expected the values to reference the cluster's kubeconfig, got:\n%s", tc.name, app.HelmValues)
+		}
+	}
+}
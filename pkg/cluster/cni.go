@@ -0,0 +1,89 @@
+package cluster
+
+import (
+	"fmt"
+)
+
+// cniBundleSettings describes the Helm chart installing a CNI provider onto
+// the workload cluster. The values template receives the pod CIDR block.
+type cniBundleSettings struct {
+	repoUrl        string
+	chart          string
+	version        string
+	namespace      string
+	valuesTemplate string
+}
+
+// cniBundles maps the supported clusterspec cni values to the bundle that
+// installs them. The vpc-cni is built into EKS and needs no bundle.
+var cniBundles = map[string]*cniBundleSettings{
+	"vpc-cni": nil,
+	"calico": {
+		repoUrl:   "https://projectcalico.docs.tigera.io/charts",
+		chart:     "tigera-operator",
+		version:   "v3.21.2",
+		namespace: "tigera-operator",
+		valuesTemplate: `installation:
+  cni:
+    type: Calico
+  calicoNetwork:
+    bgp: Disabled
+    ipPools:
+    - cidr: %s
+      encapsulation: VXLAN
+`,
+	},
+	"cilium": {
+		repoUrl:   "https://helm.cilium.io",
+		chart:     "cilium",
+		version:   "1.10.5",
+		namespace: "kube-system",
+		valuesTemplate: `ipam:
+  mode: cluster-pool
+  operator:
+    clusterPoolIPv4PodCIDR: %s
+`,
+	},
+}
+
+const cniBundleName = "cni"
+
+// cniSyncWave orders the CNI application before all other bundle
+// applications, which can't become healthy without pod networking.
+const cniSyncWave = "-10"
+
+func validateCni(specData map[string]string) error {
+	cni := specData["cni"]
+	if cni == "" {
+		return nil
+	}
+	if _, ok := cniBundles[cni]; !ok {
+		return fmt.Errorf("unsupported cni %s", cni)
+	}
+	return nil
+}
+
+// cniBundle returns the bundle installing the CNI selected by the clusterspec,
+// or nil if the CNI doesn't require one.
+func cniBundle(specData map[string]string) (*AppSettings, error) {
+	if err := validateCni(specData); err != nil {
+		return nil, err
+	}
+	settings := cniBundles[specData["cni"]]
+	if settings == nil {
+		return nil, nil
+	}
+	podCidrBlock := specData["podCidrBlock"]
+	if podCidrBlock == "" {
+		return nil, fmt.Errorf("cni %s requires podCidrBlock", specData["cni"])
+	}
+	return &AppSettings{
+		BundleName:           cniBundleName,
+		DestinationNamespace: settings.namespace,
+		RepoUrl:              settings.repoUrl,
+		Chart:                settings.chart,
+		TargetRevision:       settings.version,
+		HelmValues:           fmt.Sprintf(settings.valuesTemplate, podCidrBlock),
+		SyncWave:             cniSyncWave,
+	}, nil
+}
@@ -0,0 +1,46 @@
+package cluster
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCniBundle(t *testing.T) {
+	for _, tc := range []struct {
+		specData map[string]string
+		chart    string
+		err      string
+	}{
+		{map[string]string{}, "", ""},
+		{map[string]string{"cni": "vpc-cni"}, "", ""},
+		{map[string]string{"cni": "calico", "podCidrBlock": "192.168.0.0/16"}, "tigera-operator", ""},
+		{map[string]string{"cni": "cilium", "podCidrBlock": "10.0.0.0/8"}, "cilium", ""},
+		{map[string]string{"cni": "cilium"}, "", "requires podCidrBlock"},
+		{map[string]string{"cni": "flannel"}, "", "unsupported cni flannel"},
+	} {
+		app, err := cniBundle(tc.specData)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("%v: expected error %q, got %v", tc.specData, tc.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: unexpected error %s", tc.specData, err)
+			continue
+		}
+		if tc.chart == "" {
+			if app != nil {
+				t.Errorf("%v: expected no bundle, got %+v", tc.specData, app)
+			}
+			continue
+		}
+		if app == nil || app.Chart != tc.chart || app.SyncWave != cniSyncWave ||
+			!strings.Contains(app.HelmValues, tc.specData["podCidrBlock"]) {
+			t.Errorf("%v: expected chart %s for the pod CIDR block, got %+v", tc.specData, tc.chart, app)
+		}
+	}
+	if err := validateClusterSpec(map[string]string{"cni": "weave"}); err == nil {
+		t.Error("expected a clusterspec with an unsupported cni to be invalid")
+	}
+}
@@ -0,0 +1,85 @@
+package cluster
+
+import (
+	"arlon.io/arlon/pkg/gitutils"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// bundleCommitGroups returns the commit groups of a cluster's bundles, so
+// that with the per-bundle commit strategy the files of each added, updated
+// or removed bundle are committed separately from the rest of the cluster's
+// mgmt chart. previous and current are the cluster's bundles before and
+// after the change, as recorded in its summary. The messages of added and
+// updated bundles record their content hash, from hashes.
+func bundleCommitGroups(
+	basePath string,
+	clusterName string,
+	previous []BundleSummary,
+	current []BundleSummary,
+	hashes map[string]string,
+) []gitutils.CommitGroup {
+	clusterPath := path.Join(basePath, clusterName)
+	verbs := make(map[string]string)
+	for _, b := range previous {
+		verbs[b.Name] = fmt.Sprintf("remove bundle %s from cluster %s", b.Name, clusterName)
+	}
+	for _, b := range current {
+		if _, ok := verbs[b.Name]; ok {
+			verbs[b.Name] = fmt.Sprintf("update bundle %s of cluster %s", b.Name, clusterName)
+		} else {
+			verbs[b.Name] = fmt.Sprintf("add bundle %s to cluster %s", b.Name, clusterName)
+		}
+		if hash := hashes[b.Name]; hash != "" {
+			verbs[b.Name] += fmt.Sprintf("\n\n%s: %s", BundleHashAnnotation, hash)
+		}
+	}
+	var names []string
+	for name := range verbs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var groups []gitutils.CommitGroup
+	for _, name := range names {
+		groups = append(groups, gitutils.CommitGroup{
+			Paths: []string{
+				path.Join(clusterPath, "workload", name),
+				path.Join(clusterPath, "mgmt", "templates", name+".yaml"),
+			},
+			Message: verbs[name],
+		})
+	}
+	return groups
+}
+
+// bundleHashes returns the content hashes of a cluster's profile bundles, by
+// bundle name.
+func bundleHashes(inlineBundles []inlineBundle, refBundles []AppSettings) map[string]string {
+	hashes := make(map[string]string)
+	for _, b := range inlineBundles {
+		hashes[b.name] = b.hash
+	}
+	for _, app := range refBundles {
+		hashes[app.BundleName] = app.BundleHash
+	}
+	return hashes
+}
+
+// hashesTrailer returns the lines appended to a commit message to record
+// the content hashes of the bundles of the given clusters, for e.g.
+// "bundle c1/guestbook: 4b5c...".
+func hashesTrailer(hashes map[string]map[string]string) string {
+	var lines []string
+	for clusterName, clusterHashes := range hashes {
+		for name, hash := range clusterHashes {
+			lines = append(lines, fmt.Sprintf("bundle %s/%s: %s", clusterName, name, hash))
+		}
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	sort.Strings(lines)
+	return "\n\n" + strings.Join(lines, "\n")
+}
@@ -0,0 +1,17 @@
+package cluster
+
+import (
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+func TestRepoCredsAuth(t *testing.T) {
+	if auth := (&RepoCreds{Url: "file:///srv/git/fleet.git"}).auth(); auth != nil {
+		t.Errorf("expected a repository without credentials to be cloned anonymously, got %v", auth)
+	}
+	auth := (&RepoCreds{Url: "https://git.example.com/fleet.git", Password: "token"}).auth()
+	if basic, ok := auth.(*http.BasicAuth); !ok || basic.Password != "token" {
+		t.Errorf("expected basic auth with the repository's password, got %v", auth)
+	}
+}
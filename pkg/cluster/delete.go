@@ -0,0 +1,92 @@
+package cluster
+
+import (
+	"arlon.io/arlon/pkg/gitutils"
+	"arlon.io/arlon/pkg/log"
+	"arlon.io/arlon/pkg/progress"
+	"context"
+	"fmt"
+	applicationpkg "github.com/argoproj/argo-cd/v2/pkg/apiclient/application"
+	"github.com/go-git/go-billy/v5/util"
+	corev1api "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"path"
+)
+
+// Delete deletes a cluster: its root application is deleted with cascading,
+// so that ArgoCD deletes the cluster's resources, then its directory is
+// removed from the git repository. It is refused while the change windows of
+// the cluster's profile are closed. It returns the hash of the pushed commit.
+func Delete(
+	ctx context.Context,
+	kubeClient kubernetes.Interface,
+	repo gitutils.GitRepo,
+	appIf applicationpkg.ApplicationServiceClient,
+	argocdNs string,
+	arlonNs string,
+	clusterName string,
+) (commitSha string, err error) {
+	log := log.GetLogger()
+	rootApp, err := appIf.Get(ctx,
+		&applicationpkg.ApplicationQuery{Name: &clusterName})
+	if err != nil {
+		return "", fmt.Errorf("failed to get root application %s: %w", clusterName, err)
+	}
+	repoUrl, repoBranch, basePath := rootAppSource(rootApp)
+	err = checkPathPolicy(ctx, kubeClient.CoreV1(), arlonNs, basePath, clusterName)
+	if err != nil {
+		return "", err
+	}
+	creds, err := getRepoCreds(ctx, kubeClient.CoreV1(), argocdNs, repoUrl)
+	if err != nil {
+		return "", err
+	}
+	progress.Step(ctx, "cloning %s (branch %s)", repoUrl, repoBranch)
+	err = repo.Clone(ctx, repoUrl, repoBranch, creds.auth())
+	if err != nil {
+		return "", err
+	}
+	// checked before the cascading deletion of the root application, which
+	// deletes the cluster's resources whether or not the push succeeds
+	err = checkDeployedSyncWindows(ctx, kubeClient.CoreV1(), argocdNs, arlonNs, repo.Worktree(),
+		basePath, clusterName)
+	if err != nil {
+		return "", err
+	}
+	progress.Step(ctx, "deleting root application %s", clusterName)
+	cascade := true
+	_, err = appIf.Delete(ctx,
+		&applicationpkg.ApplicationDeleteRequest{Name: &clusterName, Cascade: &cascade})
+	if err != nil {
+		return "", fmt.Errorf("failed to delete ArgoCD root application %s: %w", clusterName, err)
+	}
+	clusterPath := path.Join(basePath, clusterName)
+	err = util.RemoveAll(repo.Worktree(), clusterPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to remove cluster directory: %w", err)
+	}
+	progress.Step(ctx, "committing changes")
+	changed, err := repo.Commit(fmt.Sprintf("delete cluster %s", clusterName))
+	if err != nil {
+		return "", fmt.Errorf("failed to commit changes: %w", err)
+	}
+	if !changed {
+		log.Info("cluster directory already absent, skipping commit & push", "path", clusterPath)
+		RecordEvent(ctx, kubeClient, rootApp, corev1api.EventTypeNormal, ReasonDeleted, "", "cluster deleted")
+		return "", nil
+	}
+	progress.Step(ctx, "pushing to %s", repoUrl)
+	err = repo.Push(ctx)
+	if err != nil {
+		return "", err
+	}
+	log.Info("succesfully pushed working tree", "repoUrl", repoUrl)
+	commitSha, err = repo.Head()
+	if err != nil {
+		return "", err
+	}
+	// the event outlives the application, and keeps the deletion visible
+	// in the namespace's events
+	RecordEvent(ctx, kubeClient, rootApp, corev1api.EventTypeNormal, ReasonDeleted, commitSha, "cluster deleted")
+	return commitSha, nil
+}
@@ -0,0 +1,256 @@
+package cluster
+
+import (
+	"arlon.io/arlon/pkg/bundle"
+	"arlon.io/arlon/pkg/gitutils"
+	"context"
+	"fmt"
+	applicationpkg "github.com/argoproj/argo-cd/v2/pkg/apiclient/application"
+	argoappv1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	appclient "github.com/argoproj/argo-cd/v2/pkg/client/clientset/versioned/typed/application/v1alpha1"
+	"io"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sort"
+	"strings"
+)
+
+// InUseError is returned when deleting a profile or clusterspec that
+// deployed clusters, or clusterspecs deriving from it, depend on.
+type InUseError struct {
+	// Kind is profile or clusterspec
+	Kind         string
+	Name         string
+	Clusters     []string
+	ClusterSpecs []string
+}
+
+func (e *InUseError) Error() string {
+	var uses []string
+	if len(e.Clusters) > 0 {
+		uses = append(uses, "clusters "+strings.Join(e.Clusters, ", "))
+	}
+	if len(e.ClusterSpecs) > 0 {
+		uses = append(uses, "clusterspecs "+strings.Join(e.ClusterSpecs, ", "))
+	}
+	return fmt.Sprintf("%s %s is used by %s", e.Kind, e.Name, strings.Join(uses, " and "))
+}
+
+// ProfileDependents returns the names of the deployed clusters whose
+// profile is profileName, sorted.
+func ProfileDependents(
+	ctx context.Context,
+	apps appclient.ApplicationInterface,
+	arlonNs string,
+	profileName string,
+) ([]string, error) {
+	selector, err := ProfileSelector(arlonNs, profileName, "")
+	if err != nil {
+		return nil, err
+	}
+	list, err := apps.List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list root applications: %w", err)
+	}
+	var clusters []string
+	for _, app := range list.Items {
+		clusters = append(clusters, app.Name)
+	}
+	sort.Strings(clusters)
+	return clusters, nil
+}
+
+// DeleteProfile deletes a profile unless deployed clusters use it, in which
+// case it fails with an InUseError listing them. With cascade, the profile
+// is detached from those clusters first, as by SetProfile with an empty
+// profile, which removes its bundles from their git trees. The profile is
+// only deleted once all of them are detached. The root applications are
+// listed through apps, the Applications of the ArgoCD namespace, and
+// newAppIf is only called to detach the profile, so that deleting an unused
+// profile doesn't need an ArgoCD session. It returns the detached clusters.
+func DeleteProfile(
+	ctx context.Context,
+	kubeClient kubernetes.Interface,
+	apps appclient.ApplicationInterface,
+	newRepo func() gitutils.GitRepo,
+	newAppIf func() (io.Closer, applicationpkg.ApplicationServiceClient),
+	argocdNs string,
+	arlonNs string,
+	profileName string,
+	cascade bool,
+) (detached []string, err error) {
+	clusters, err := ProfileDependents(ctx, apps, arlonNs, profileName)
+	if err != nil {
+		return nil, err
+	}
+	if len(clusters) > 0 && !cascade {
+		return nil, &InUseError{Kind: "profile", Name: profileName, Clusters: clusters}
+	}
+	if len(clusters) > 0 {
+		conn, appIf := newAppIf()
+		defer conn.Close()
+		return detachProfile(ctx, kubeClient, newRepo, appIf, argocdNs, arlonNs, profileName, clusters)
+	}
+	return nil, deleteProfile(ctx, kubeClient, arlonNs, profileName)
+}
+
+// detachProfile detaches a profile from the clusters using it, then deletes
+// it.
+func detachProfile(
+	ctx context.Context,
+	kubeClient kubernetes.Interface,
+	newRepo func() gitutils.GitRepo,
+	appIf applicationpkg.ApplicationServiceClient,
+	argocdNs string,
+	arlonNs string,
+	profileName string,
+	clusters []string,
+) (detached []string, err error) {
+	for _, clusterName := range clusters {
+		repo := newRepo()
+		_, err = SetProfile(ctx, kubeClient, repo, appIf, argocdNs, arlonNs, clusterName, "")
+		_ = repo.Close()
+		if err != nil {
+			return detached, fmt.Errorf("failed to detach profile %s from cluster %s: %w",
+				profileName, clusterName, err)
+		}
+		detached = append(detached, clusterName)
+	}
+	return detached, deleteProfile(ctx, kubeClient, arlonNs, profileName)
+}
+
+func deleteProfile(ctx context.Context, kubeClient kubernetes.Interface, arlonNs string, profileName string) error {
+	profileNs, name := bundle.ParseRef(profileName, arlonNs)
+	err := kubeClient.CoreV1().ConfigMaps(profileNs).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to delete profile %s: %w", profileName, err)
+	}
+	return nil
+}
+
+// ClusterSpecDependents returns the names of the deployed clusters whose
+// clusterspec is clusterSpecName, and of the clusterspecs deriving from it
+// through their baseSpec chain, which are namespace qualified. Clusters
+// deployed with a derived clusterspec depend on it as well, but are only
+// listed as dependents of the derived one. The root applications are listed
+// through apps, the Applications of the ArgoCD namespace.
+func ClusterSpecDependents(
+	ctx context.Context,
+	kubeClient kubernetes.Interface,
+	apps appclient.ApplicationInterface,
+	arlonNs string,
+	clusterSpecName string,
+) (clusters []string, clusterSpecs []string, err error) {
+	corev1 := kubeClient.CoreV1()
+	specNs, name := bundle.ParseRef(clusterSpecName, arlonNs)
+	qualified := specNs + "/" + name
+	list, err := apps.List(ctx, metav1.ListOptions{LabelSelector: RootAppSelector})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list root applications: %w", err)
+	}
+	for _, app := range list.Items {
+		if rootAppClusterSpec(&app, arlonNs) == qualified {
+			clusters = append(clusters, app.Name)
+		}
+	}
+	configMaps, err := corev1.ConfigMaps(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+		LabelSelector: "managed-by=arlon,arlon-type=clusterspec",
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list clusterspecs: %w", err)
+	}
+	for _, cm := range configMaps.Items {
+		ref := cm.Namespace + "/" + cm.Name
+		if ref == qualified || cm.Data["baseSpec"] == "" {
+			continue
+		}
+		// a broken chain doesn't make the spec a dependent
+		_, chain, err := resolveClusterSpec(ctx, corev1, arlonNs, ref, nil)
+		if err == nil && hasString(chain, qualified) {
+			clusterSpecs = append(clusterSpecs, ref)
+		}
+	}
+	sort.Strings(clusters)
+	sort.Strings(clusterSpecs)
+	return clusters, clusterSpecs, nil
+}
+
+// DeleteClusterSpec deletes a clusterspec unless deployed clusters or other
+// clusterspecs depend on it, in which case it fails with an InUseError
+// listing them. With cascade, the clusterspec is removed from the labels of
+// the clusters deployed with it before deleting it: they keep running with
+// the values rendered into their root application, but are no longer
+// considered by clusterspec diff and must be given a clusterspec when
+// deployed again. Clusterspecs deriving from it are never cascaded, and
+// must be deleted or rebased first. As with DeleteProfile, the root
+// applications are listed through apps and newAppIf is only called to update
+// them. It returns the updated clusters.
+func DeleteClusterSpec(
+	ctx context.Context,
+	kubeClient kubernetes.Interface,
+	apps appclient.ApplicationInterface,
+	newAppIf func() (io.Closer, applicationpkg.ApplicationServiceClient),
+	arlonNs string,
+	clusterSpecName string,
+	cascade bool,
+) (updated []string, err error) {
+	clusters, clusterSpecs, err := ClusterSpecDependents(ctx, kubeClient, apps, arlonNs, clusterSpecName)
+	if err != nil {
+		return nil, err
+	}
+	if len(clusterSpecs) > 0 || (len(clusters) > 0 && !cascade) {
+		return nil, &InUseError{Kind: "clusterspec", Name: clusterSpecName,
+			Clusters: clusters, ClusterSpecs: clusterSpecs}
+	}
+	if len(clusters) > 0 {
+		conn, appIf := newAppIf()
+		defer conn.Close()
+		updated, err = removeClusterSpec(ctx, appIf, clusters)
+		if err != nil {
+			return updated, err
+		}
+	}
+	specNs, name := bundle.ParseRef(clusterSpecName, arlonNs)
+	err = kubeClient.CoreV1().ConfigMaps(specNs).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil {
+		return updated, fmt.Errorf("failed to delete clusterspec %s: %w", clusterSpecName, err)
+	}
+	return updated, nil
+}
+
+// removeClusterSpec removes the clusterspec from the labels of the root
+// applications of clusters.
+func removeClusterSpec(
+	ctx context.Context,
+	appIf applicationpkg.ApplicationServiceClient,
+	clusters []string,
+) (updated []string, err error) {
+	for _, clusterName := range clusters {
+		app, err := appIf.Get(ctx, &applicationpkg.ApplicationQuery{Name: &clusterName})
+		if err != nil {
+			return updated, fmt.Errorf("failed to get root application %s: %w", clusterName, err)
+		}
+		delete(app.Labels, ClusterSpecLabel)
+		delete(app.Labels, ClusterSpecNamespaceLabel)
+		_, err = appIf.Update(ctx, &applicationpkg.ApplicationUpdateRequest{Application: app})
+		if err != nil {
+			return updated, fmt.Errorf("failed to update root application %s: %w", clusterName, err)
+		}
+		updated = append(updated, clusterName)
+	}
+	return updated, nil
+}
+
+// rootAppClusterSpec returns the namespace qualified clusterspec a root
+// application was deployed with, or "" if it has none.
+func rootAppClusterSpec(app *argoappv1.Application, arlonNs string) string {
+	specName := app.Labels[ClusterSpecLabel]
+	if specName == "" {
+		return ""
+	}
+	specNs := app.Labels[ClusterSpecNamespaceLabel]
+	if specNs == "" {
+		specNs = arlonNs
+	}
+	return specNs + "/" + specName
+}
@@ -0,0 +1,68 @@
+package cluster_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	"arlon.io/arlon/pkg/cluster"
+	clustertesting "arlon.io/arlon/pkg/cluster/testing"
+	"arlon.io/arlon/pkg/gitutils"
+	applicationpkg "github.com/argoproj/argo-cd/v2/pkg/apiclient/application"
+	appfake "github.com/argoproj/argo-cd/v2/pkg/client/clientset/versioned/fake"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestDeleteProfile(t *testing.T) {
+	h := clustertesting.New(t,
+		clustertesting.ClusterSpec(clustertesting.ArlonNs, "eks", map[string]string{"region": "us-west-2"}),
+		clustertesting.InlineBundle(clustertesting.ArlonNs, "guestbook", "kind: ConfigMap\n"),
+		clustertesting.Profile(clustertesting.ArlonNs, "dev", "guestbook"),
+		clustertesting.Profile(clustertesting.ArlonNs, "unused", "guestbook"),
+	)
+	h.Deploy("c1", "dev", "eks")
+	var apps []runtime.Object
+	for i := range h.Apps.Apps() {
+		apps = append(apps, &h.Apps.Apps()[i])
+	}
+	appClient := appfake.NewSimpleClientset(apps...).ArgoprojV1alpha1().Applications(clustertesting.ArgocdNs)
+	sessions := 0
+	newAppIf := func() (io.Closer, applicationpkg.ApplicationServiceClient) {
+		sessions++
+		return io.NopCloser(nil), h.Apps
+	}
+	deleteProfile := func(profileName string, cascade bool) ([]string, error) {
+		return cluster.DeleteProfile(context.Background(), h.KubeClient, appClient,
+			func() gitutils.GitRepo { return h.Git.NewRepo() }, newAppIf, clustertesting.ArgocdNs,
+			clustertesting.ArlonNs, profileName, cascade)
+	}
+	profiles := h.KubeClient.CoreV1().ConfigMaps(clustertesting.ArlonNs)
+	if _, err := deleteProfile("unused", false); err != nil {
+		t.Fatalf("failed to delete an unused profile: %s", err)
+	}
+	if _, err := profiles.Get(context.Background(), "unused", metav1.GetOptions{}); !apierr.IsNotFound(err) {
+		t.Errorf("expected the unused profile to be deleted, got %v", err)
+	}
+	var inUse *cluster.InUseError
+	if _, err := deleteProfile("dev", false); !errors.As(err, &inUse) ||
+		!reflect.DeepEqual(inUse.Clusters, []string{"c1"}) {
+		t.Errorf("expected profile dev to be in use by c1, got %v", err)
+	}
+	if sessions != 0 {
+		t.Errorf("expected no ArgoCD session without detaching, got %d", sessions)
+	}
+	detached, err := deleteProfile("dev", true)
+	if err != nil {
+		t.Fatalf("failed to delete profile with cascade: %s", err)
+	}
+	if !reflect.DeepEqual(detached, []string{"c1"}) || sessions != 1 {
+		t.Errorf("expected c1 to be detached in one session, got %v in %d", detached, sessions)
+	}
+	if _, ok := h.ClusterFiles("c1")["mgmt/templates/guestbook.yaml"]; ok {
+		t.Error("expected the profile's bundle to be removed from c1")
+	}
+}
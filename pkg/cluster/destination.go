@@ -0,0 +1,57 @@
+package cluster
+
+import (
+	"arlon.io/arlon/pkg/kuberetry"
+	"context"
+	"fmt"
+	corev1api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	corev1types "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// DestinationServerParam is the root application's Helm parameter that
+// makes the applications of a cluster's bundles target the workload cluster
+// by its server URL, instead of by the name it is registered with in ArgoCD.
+const DestinationServerParam = "destinationServer"
+
+// ResolveDestinationServer returns the server URL of the workload cluster:
+// server if set, else the one of the ArgoCD cluster secret matching
+// selector, or empty to target the cluster by name.
+func ResolveDestinationServer(
+	ctx context.Context,
+	corev1 corev1types.CoreV1Interface,
+	argocdNs string,
+	server string,
+	selector string,
+) (string, error) {
+	if server != "" && selector != "" {
+		return "", fmt.Errorf("a destination server and selector can't both be specified")
+	}
+	if selector == "" {
+		return server, nil
+	}
+	sel, err := labels.Parse(selector)
+	if err != nil {
+		return "", fmt.Errorf("invalid destination selector: %w", err)
+	}
+	var secrets *corev1api.SecretList
+	err = kuberetry.OnTransient(ctx, func() (err error) {
+		secrets, err = corev1.Secrets(argocdNs).List(ctx, metav1.ListOptions{
+			LabelSelector: "argocd.argoproj.io/secret-type=cluster," + sel.String(),
+		})
+		return
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to list argocd cluster secrets: %w", err)
+	}
+	if len(secrets.Items) != 1 {
+		return "", fmt.Errorf("destination selector %s matches %d argocd clusters instead of one",
+			selector, len(secrets.Items))
+	}
+	server = string(secrets.Items[0].Data["server"])
+	if server == "" {
+		return "", fmt.Errorf("argocd cluster secret %s has no server", secrets.Items[0].Name)
+	}
+	return server, nil
+}
@@ -0,0 +1,152 @@
+package cluster
+
+import (
+	"arlon.io/arlon/pkg/diff"
+	"arlon.io/arlon/pkg/gitutils"
+	"arlon.io/arlon/pkg/progress"
+	"context"
+	"fmt"
+	applicationpkg "github.com/argoproj/argo-cd/v2/pkg/apiclient/application"
+	"github.com/go-git/go-billy/v5/memfs"
+	"io"
+	"k8s.io/client-go/kubernetes"
+	"path"
+)
+
+// Diff writes to w a git style diff between the cluster's directory as
+// committed to the repository and as DeployToGit would render it from the
+// current arlon resources, without committing or pushing anything. It
+// reports whether they differ.
+func Diff(
+	ctx context.Context,
+	kubeClient kubernetes.Interface,
+	repo gitutils.GitRepo,
+	argocdNs string,
+	arlonNs string,
+	clusterName string,
+	repoUrl string,
+	repoBranch string,
+	basePath string,
+	profileName string,
+	clusterSpecName string,
+	w io.Writer,
+) (bool, error) {
+	err := cloneForDiff(ctx, kubeClient, repo, argocdNs, repoUrl, repoBranch)
+	if err != nil {
+		return false, err
+	}
+	return diffClone(ctx, kubeClient, repo, argocdNs, arlonNs, clusterName, repoUrl, repoBranch,
+		basePath, profileName, clusterSpecName, w)
+}
+
+// DiffDeployed is like Diff for a deployed cluster, whose repository location
+// is taken from its root application and whose profile and clusterspec are
+// read from the summary committed with it. A non empty profileName or
+// clusterSpecName overrides the deployed one, for e.g. to preview a change.
+func DiffDeployed(
+	ctx context.Context,
+	kubeClient kubernetes.Interface,
+	repo gitutils.GitRepo,
+	appIf applicationpkg.ApplicationServiceClient,
+	argocdNs string,
+	arlonNs string,
+	clusterName string,
+	profileName string,
+	clusterSpecName string,
+	w io.Writer,
+) (bool, error) {
+	rootApp, err := appIf.Get(ctx,
+		&applicationpkg.ApplicationQuery{Name: &clusterName})
+	if err != nil {
+		return false, fmt.Errorf("failed to get root application %s: %w", clusterName, err)
+	}
+	repoUrl, repoBranch, basePath := rootAppSource(rootApp)
+	err = cloneForDiff(ctx, kubeClient, repo, argocdNs, repoUrl, repoBranch)
+	if err != nil {
+		return false, err
+	}
+	summary, err := ReadSummary(repo.Worktree(), basePath, clusterName)
+	if err != nil {
+		return false, err
+	}
+	if summary != nil {
+		if profileName == "" {
+			profileName = summary.Profile
+		}
+		if clusterSpecName == "" {
+			clusterSpecName = summary.ClusterSpec
+		}
+	} else if clusterSpecName == "" {
+		// clusters deployed before summaries existed only record their
+		// clusterspec, in the root application's labels
+		clusterSpecName = rootApp.Labels[ClusterSpecLabel]
+		if specNs := rootApp.Labels[ClusterSpecNamespaceLabel]; specNs != "" {
+			clusterSpecName = specNs + "/" + clusterSpecName
+		}
+	}
+	return diffClone(ctx, kubeClient, repo, argocdNs, arlonNs, clusterName, repoUrl, repoBranch,
+		basePath, profileName, clusterSpecName, w)
+}
+
+// -----------------------------------------------------------------------------
+
+func cloneForDiff(
+	ctx context.Context,
+	kubeClient kubernetes.Interface,
+	repo gitutils.GitRepo,
+	argocdNs string,
+	repoUrl string,
+	repoBranch string,
+) error {
+	progress.Step(ctx, "resolving credentials for %s", repoUrl)
+	creds, err := getRepoCreds(ctx, kubeClient.CoreV1(), argocdNs, repoUrl)
+	if err != nil {
+		return err
+	}
+	progress.Step(ctx, "cloning %s (branch %s)", repoUrl, repoBranch)
+	return repo.Clone(ctx, repoUrl, repoBranch, creds.auth())
+}
+
+// diffClone diffs the cluster's directory in the cloned repo against a fresh
+// rendering of the cluster.
+func diffClone(
+	ctx context.Context,
+	kubeClient kubernetes.Interface,
+	repo gitutils.GitRepo,
+	argocdNs string,
+	arlonNs string,
+	clusterName string,
+	repoUrl string,
+	repoBranch string,
+	basePath string,
+	profileName string,
+	clusterSpecName string,
+	w io.Writer,
+) (bool, error) {
+	st, err := loadStore(ctx, kubeClient.CoreV1(), argocdNs, arlonNs)
+	if err != nil {
+		return false, err
+	}
+	progress.Step(ctx, "reading profile and clusterspec")
+	tree, err := newClusterTree(ctx, kubeClient.CoreV1(), st, arlonNs, clusterName, repoUrl,
+		repoBranch, basePath, profileName, clusterSpecName)
+	if err != nil {
+		return false, err
+	}
+	clusterPath := path.Join(basePath, clusterName)
+	committed, err := diff.ReadTree(repo.Worktree(), clusterPath)
+	if err != nil {
+		return false, err
+	}
+	progress.Step(ctx, "rendering cluster %s", clusterName)
+	fsys := memfs.New()
+	err = tree.write(ctx, fsys)
+	if err != nil {
+		return false, err
+	}
+	rendered, err := diff.ReadTree(fsys, clusterPath)
+	if err != nil {
+		return false, err
+	}
+	return diff.Trees(w, committed, rendered)
+}
@@ -0,0 +1,123 @@
+package cluster
+
+import (
+	"arlon.io/arlon/pkg/gitutils"
+	"context"
+	"fmt"
+	argoappv1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	"k8s.io/client-go/kubernetes"
+	"sort"
+)
+
+// DeployedDrift compares the inputs a cluster was deployed from, as recorded
+// in its committed summary, with the current state of its clusterspec,
+// profile and bundles. It returns a description of each changed input, which
+// is empty if the cluster is up to date. The summary is nil for clusters
+// deployed before summaries existed, whose drift can't be determined.
+func DeployedDrift(
+	ctx context.Context,
+	kubeClient kubernetes.Interface,
+	repo gitutils.GitRepo,
+	argocdNs string,
+	arlonNs string,
+	rootApp *argoappv1.Application,
+) (changes []string, summary *Summary, err error) {
+	repoUrl, repoBranch, basePath := rootAppSource(rootApp)
+	err = cloneForDiff(ctx, kubeClient, repo, argocdNs, repoUrl, repoBranch)
+	if err != nil {
+		return nil, nil, err
+	}
+	summary, err = ReadSummary(repo.Worktree(), basePath, rootApp.Name)
+	if err != nil || summary == nil {
+		return nil, nil, err
+	}
+	corev1 := kubeClient.CoreV1()
+	st, err := loadStore(ctx, corev1, argocdNs, arlonNs)
+	if err != nil {
+		return nil, nil, err
+	}
+	inlineBundles, refBundles, err := getProfileBundles(ctx, summary.Profile, st, corev1, arlonNs)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get profile bundles: %w", err)
+	}
+	var specData map[string]string
+	if summary.ClusterSpec != "" {
+		specData, err = getClusterSpecData(ctx, corev1, arlonNs, summary.ClusterSpec)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	specBundles, err := clusterSpecBundles(rootApp.Name, specData)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get clusterspec bundles: %w", err)
+	}
+	current := newSummary(rootApp.Name, repoUrl, repoBranch, basePath, summary.Profile, summary.ClusterSpec,
+		specData, inlineBundles, refBundles, specBundles)
+	return summaryDrift(summary, current), summary, nil
+}
+
+// summaryDrift describes how the inputs recorded in the current summary
+// differ from the deployed one.
+func summaryDrift(deployed *Summary, current *Summary) (changes []string) {
+	for _, key := range changedKeys(deployed.ClusterSpecValues, current.ClusterSpecValues) {
+		from, wasSet := deployed.ClusterSpecValues[key]
+		to, isSet := current.ClusterSpecValues[key]
+		switch {
+		case !wasSet:
+			changes = append(changes, fmt.Sprintf("clusterspec %s: %s added", current.ClusterSpec, key))
+		case !isSet:
+			changes = append(changes, fmt.Sprintf("clusterspec %s: %s removed", current.ClusterSpec, key))
+		default:
+			changes = append(changes, fmt.Sprintf("clusterspec %s: %s changed from %q to %q",
+				current.ClusterSpec, key, from, to))
+		}
+	}
+	deployedBundles := make(map[string]BundleSummary)
+	for _, b := range deployed.Bundles {
+		deployedBundles[b.Namespace+"/"+b.Name] = b
+	}
+	seen := make(map[string]bool)
+	for _, b := range current.Bundles {
+		key := b.Namespace + "/" + b.Name
+		seen[key] = true
+		d, ok := deployedBundles[key]
+		switch {
+		case !ok:
+			changes = append(changes, fmt.Sprintf("bundle %s: added", bundleDisplayName(b)))
+		case d.Hash != b.Hash:
+			changes = append(changes, fmt.Sprintf("bundle %s: content changed", bundleDisplayName(b)))
+		case d.Type != b.Type || d.RepoUrl != b.RepoUrl || d.RepoPath != b.RepoPath ||
+			d.Chart != b.Chart || d.Version != b.Version:
+			changes = append(changes, fmt.Sprintf("bundle %s: source changed", bundleDisplayName(b)))
+		}
+	}
+	for _, b := range deployed.Bundles {
+		if !seen[b.Namespace+"/"+b.Name] {
+			changes = append(changes, fmt.Sprintf("bundle %s: removed", bundleDisplayName(b)))
+		}
+	}
+	return
+}
+
+// changedKeys returns the sorted keys whose values differ between from and to.
+func changedKeys(from map[string]string, to map[string]string) (keys []string) {
+	for key, val := range from {
+		if other, ok := to[key]; !ok || other != val {
+			keys = append(keys, key)
+		}
+	}
+	for key := range to {
+		if _, ok := from[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return
+}
+
+func bundleDisplayName(b BundleSummary) string {
+	if b.Namespace == "" {
+		return b.Name
+	}
+	return b.Namespace + "/" + b.Name
+}
@@ -0,0 +1,43 @@
+package cluster
+
+import (
+	"arlon.io/arlon/pkg/gitutils"
+	"errors"
+	"fmt"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	"os"
+)
+
+// Errors that callers can test for with errors.Is, to tell failures worth
+// retrying from ones needing a change by the user. The errors returned by
+// this package wrap the underlying errors of the API server and git, so
+// that errors.Is and errors.As also apply to them.
+var (
+	// ErrRepoCredsNotFound is returned when no repository registered with
+	// ArgoCD matches the URL of a git or Helm repository.
+	ErrRepoCredsNotFound = errors.New("did not find argocd repository")
+	// ErrProfileNotFound is returned when a cluster refers to a profile
+	// that doesn't exist.
+	ErrProfileNotFound = errors.New("profile not found")
+	// ErrBundleEmpty is returned for an inline bundle without manifests.
+	ErrBundleEmpty = errors.New("bundle has no data")
+	// ErrPushConflict is returned when a push to git is rejected because
+	// the branch was updated concurrently. Retrying is expected to succeed.
+	ErrPushConflict = gitutils.ErrPushConflict
+)
+
+// IsRetryable reports whether the operation that failed with err can be
+// retried as is, as opposed to failures that need a change of input,
+// configuration or credentials.
+func IsRetryable(err error) bool {
+	return errors.Is(err, ErrPushConflict)
+}
+
+// profileError wraps the error of getting profile profileName, returning
+// ErrProfileNotFound if it doesn't exist.
+func profileError(profileName string, err error) error {
+	if apierr.IsNotFound(err) || os.IsNotExist(err) {
+		return fmt.Errorf("%w: %s", ErrProfileNotFound, profileName)
+	}
+	return fmt.Errorf("failed to get profile %s: %w", profileName, err)
+}
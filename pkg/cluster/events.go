@@ -0,0 +1,106 @@
+package cluster
+
+import (
+	"arlon.io/arlon/pkg/log"
+	"context"
+	"fmt"
+	argoappv1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	corev1api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"time"
+)
+
+// Reasons of the Events recorded on root applications
+const (
+	ReasonDeployed       = "ClusterDeployed"
+	ReasonUpdated        = "ClusterUpdated"
+	ReasonUpdateFailed   = "ClusterUpdateFailed"
+	ReasonProfileChanged = "ProfileChanged"
+	ReasonProfileSynced  = "ProfileSynced"
+	ReasonRolledBack     = "ClusterRolledBack"
+	ReasonRenamed        = "ClusterRenamed"
+	ReasonDeleted        = "ClusterDeleted"
+)
+
+// Annotations of the Events recorded on root applications
+const (
+	EventActorAnnotation     = "arlon.io/actor"
+	EventCommitShaAnnotation = "arlon.io/commit-sha"
+)
+
+const eventComponent = "arlon"
+
+type actorKey struct{}
+
+// WithActor returns a context whose operations are recorded as performed by
+// actor, such as the user authenticated by the API server.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// Actor returns the user performing the operations of the context, or
+// "unknown" if no actor was set.
+func Actor(ctx context.Context) string {
+	if actor, _ := ctx.Value(actorKey{}).(string); actor != "" {
+		return actor
+	}
+	return "unknown"
+}
+
+// RecordEvent records an Event on a root application, so that the history of
+// the cluster shows in kubectl describe and in event pipelines. The event
+// names the actor and, if set, the commit the operation pushed. Failing to
+// record an event doesn't fail the operation, it is only logged.
+func RecordEvent(
+	ctx context.Context,
+	kubeClient kubernetes.Interface,
+	app *argoappv1.Application,
+	eventType string,
+	reason string,
+	commitSha string,
+	message string,
+) {
+	if app == nil {
+		return
+	}
+	actor := Actor(ctx)
+	message = fmt.Sprintf("%s by %s", message, actor)
+	annotations := map[string]string{EventActorAnnotation: actor}
+	if commitSha != "" {
+		message = fmt.Sprintf("%s (commit %s)", message, commitSha)
+		annotations[EventCommitShaAnnotation] = commitSha
+	}
+	now := metav1.NewTime(time.Now())
+	event := &corev1api.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        fmt.Sprintf("%s.%x", app.Name, now.UnixNano()),
+			Namespace:   app.Namespace,
+			Annotations: annotations,
+		},
+		InvolvedObject: corev1api.ObjectReference{
+			Kind:            app.Kind,
+			APIVersion:      app.APIVersion,
+			Name:            app.Name,
+			Namespace:       app.Namespace,
+			UID:             app.UID,
+			ResourceVersion: app.ResourceVersion,
+		},
+		Type:           eventType,
+		Reason:         reason,
+		Message:        message,
+		Source:         corev1api.EventSource{Component: eventComponent},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+	if event.InvolvedObject.Kind == "" {
+		// the ArgoCD API doesn't set the type meta of returned applications
+		event.InvolvedObject.Kind = "Application"
+		event.InvolvedObject.APIVersion = "argoproj.io/v1alpha1"
+	}
+	_, err := kubeClient.CoreV1().Events(app.Namespace).Create(ctx, event, metav1.CreateOptions{})
+	if err != nil {
+		log.GetLogger().Error(err, "failed to record event", "application", app.Name, "reason", reason)
+	}
+}
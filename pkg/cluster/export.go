@@ -0,0 +1,153 @@
+package cluster
+
+import (
+	"arlon.io/arlon/pkg/bundle"
+	"arlon.io/arlon/pkg/gitutils"
+	"arlon.io/arlon/pkg/log"
+	"arlon.io/arlon/pkg/progress"
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/go-git/go-billy/v5/util"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"os"
+	"path"
+)
+
+// ExportBundle moves the manifests of an inline bundle to repoPath in a git
+// repository registered with ArgoCD, then converts the bundle into a
+// reference bundle pointing at them, at repoRevision, so that the clusters
+// it is deployed to get the same content from git. The manifests are written
+// to {repoPath}/{bundle}.yaml, and repoPath must not hold anything else.
+// Signed bundles are verified against the trusted keys first, since
+// reference bundles aren't verified when deployed. It returns the hash of
+// the pushed commit, which is empty if the repository already held the
+// manifests, for e.g. when retrying after a failed update of the bundle.
+func ExportBundle(
+	ctx context.Context,
+	kubeClient kubernetes.Interface,
+	repo gitutils.GitRepo,
+	argocdNs string,
+	arlonNs string,
+	bundleName string,
+	repoUrl string,
+	repoBranch string,
+	repoPath string,
+	repoRevision string,
+) (commitSha string, err error) {
+	log := log.GetLogger()
+	corev1 := kubeClient.CoreV1()
+	repoPath = gitutils.SlashPath(repoPath)
+	if path.Clean(repoPath) == "." || path.Clean(repoPath) == "/" {
+		return "", fmt.Errorf("a path in the repository must be given for the bundle")
+	}
+	secr, err := bundle.NewKubeStore(corev1).GetBundle(ctx, arlonNs, bundleName)
+	if err != nil {
+		return "", fmt.Errorf("failed to get bundle %s: %w", bundleName, err)
+	}
+	if secr.Labels["bundle-type"] != "inline" {
+		return "", fmt.Errorf("bundle %s is not an inline bundle", bundleName)
+	}
+	data := secr.Data["data"]
+	if len(data) == 0 {
+		return "", fmt.Errorf("inline bundle %s: %w", bundleName, ErrBundleEmpty)
+	}
+	trustedKeys, err := bundle.LoadTrustedKeys(ctx, corev1, arlonNs)
+	if err != nil {
+		return "", err
+	}
+	if trustedKeys != nil {
+		_, err = bundle.Verify(trustedKeys, data, secr.Data[bundle.SignatureKey])
+		if err != nil {
+			return "", fmt.Errorf("refusing to export bundle %s: %w", bundleName, err)
+		}
+	}
+	fileName := bundleName + ".yaml"
+	err = checkPathPolicy(ctx, corev1, arlonNs, repoPath, fileName)
+	if err != nil {
+		return "", err
+	}
+	creds, err := getRepoCreds(ctx, corev1, argocdNs, repoUrl)
+	if err != nil {
+		return "", err
+	}
+	progress.Step(ctx, "cloning %s (branch %s)", repoUrl, repoBranch)
+	err = repo.Clone(ctx, repoUrl, repoBranch, creds.auth())
+	if err != nil {
+		return "", err
+	}
+	wt := repo.Worktree()
+	items, err := wt.ReadDir(repoPath)
+	if err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to read %s: %w", repoPath, err)
+	}
+	for _, item := range items {
+		// the reference bundle's application deploys everything in the path
+		if item.Name() != fileName {
+			return "", fmt.Errorf("path %s of the repository already holds %s", repoPath, item.Name())
+		}
+	}
+	filePath := path.Join(repoPath, fileName)
+	existing, err := util.ReadFile(wt, filePath)
+	if err == nil && !bytes.Equal(existing, data) {
+		return "", fmt.Errorf("%s already exists with different content", filePath)
+	}
+	progress.Step(ctx, "writing %s", filePath)
+	err = util.WriteFile(wt, filePath, data, 0644)
+	if err != nil {
+		return "", fmt.Errorf("failed to write %s: %w", filePath, err)
+	}
+	progress.Step(ctx, "committing changes")
+	changed, err := repo.Commit(fmt.Sprintf("export bundle %s", bundleName))
+	if err != nil {
+		return "", fmt.Errorf("failed to commit changes: %w", err)
+	}
+	if changed {
+		progress.Step(ctx, "pushing to %s", repoUrl)
+		err = repo.Push(ctx)
+		if err != nil {
+			return "", err
+		}
+		log.Info("succesfully pushed working tree", "repoUrl", repoUrl)
+		commitSha, err = repo.Head()
+		if err != nil {
+			return "", err
+		}
+	} else {
+		log.Info("bundle already exported, skipping commit & push", "path", filePath)
+	}
+	if repoRevision == "" {
+		repoRevision = repoBranch
+	}
+	progress.Step(ctx, "converting bundle %s to a reference bundle", bundleName)
+	secretsApi := corev1.Secrets(arlonNs)
+	// the stored secret, whose data may be chunked or compressed
+	secr, err = secretsApi.Get(ctx, bundleName, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get bundle %s: %w", bundleName, err)
+	}
+	secr.Labels["bundle-type"] = "reference"
+	for _, key := range []string{bundle.CompressionAnnotation, bundle.ChunksAnnotation, bundle.SizeAnnotation} {
+		delete(secr.Annotations, key)
+	}
+	if secr.Annotations == nil {
+		secr.Annotations = make(map[string]string)
+	}
+	secr.Annotations["repo-url"] = repoUrl
+	secr.Annotations["repo-path"] = repoPath
+	secr.Annotations["repo-revision"] = repoRevision
+	delete(secr.Data, "data")
+	delete(secr.Data, bundle.SignatureKey)
+	_, err = secretsApi.Update(ctx, secr, metav1.UpdateOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to update bundle %s: %w", bundleName, err)
+	}
+	err = secretsApi.DeleteCollection(ctx, metav1.DeleteOptions{}, metav1.ListOptions{
+		LabelSelector: bundle.ChunkLabel + "=" + bundleName,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to delete chunks of bundle %s: %w", bundleName, err)
+	}
+	return commitSha, nil
+}
@@ -1,21 +1,23 @@
 package cluster
 
 import (
+	"arlon.io/arlon/pkg/bundle"
 	"arlon.io/arlon/pkg/gitutils"
+	"arlon.io/arlon/pkg/kuberetry"
 	"arlon.io/arlon/pkg/log"
+	"arlon.io/arlon/pkg/progress"
 	"bytes"
 	"context"
 	"embed"
 	"fmt"
-	gogit "github.com/go-git/go-git/v5"
-	"github.com/go-git/go-git/v5/plumbing"
-	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-git/v5/plumbing/transport"
 	"io"
 	"io/fs"
+	corev1api "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	corev1types "k8s.io/client-go/kubernetes/typed/core/v1"
-	"os"
 	"path"
 	"strings"
 	"text/template"
@@ -32,13 +34,23 @@ type RepoCreds struct {
 
 type inlineBundle struct {
 	name string
+	namespace string
 	data []byte
+	syncOptions []string
+	retry *bundle.SyncRetry
+	hash string
 }
 
 // -----------------------------------------------------------------------------
 
+// DeployToGit writes the cluster's mgmt chart and bundles to the git
+// repository and returns the hash of the pushed commit, which is empty if
+// the repository was already up to date. A missing repoBranch is created
+// according to createBranch, one of the gitutils.CreateBranch* modes.
 func DeployToGit(
-	kubeClient *kubernetes.Clientset,
+	ctx context.Context,
+	kubeClient kubernetes.Interface,
+	repo gitutils.GitRepo,
 	argocdNs string,
 	arlonNs string,
 	clusterName string,
@@ -46,124 +58,234 @@ func DeployToGit(
 	repoBranch string,
 	basePath string,
 	profileName string,
-) error {
+	clusterSpecName string,
+	createBranch string,
+) (commitSha string, err error) {
+	return DeployManyToGit(ctx, kubeClient, repo, argocdNs, arlonNs, repoUrl, repoBranch,
+		[]Deployment{{clusterName, basePath, profileName, clusterSpecName}}, createBranch)
+}
+
+// Deployment describes a cluster deployed by DeployManyToGit.
+type Deployment struct {
+	ClusterName     string
+	BasePath        string
+	ProfileName     string
+	ClusterSpecName string
+}
+
+// DeployManyToGit is like DeployToGit for several clusters sharing a
+// repository branch, which are all written in a single commit.
+func DeployManyToGit(
+	ctx context.Context,
+	kubeClient kubernetes.Interface,
+	repo gitutils.GitRepo,
+	argocdNs string,
+	arlonNs string,
+	repoUrl string,
+	repoBranch string,
+	deployments []Deployment,
+	createBranch string,
+) (commitSha string, err error) {
 	log := log.GetLogger()
 	corev1 := kubeClient.CoreV1()
-	secretsApi := corev1.Secrets(argocdNs)
-	opts := metav1.ListOptions{
-		LabelSelector: "argocd.argoproj.io/secret-type=repository",
+	progress.Step(ctx, "resolving credentials for %s", repoUrl)
+	creds, err := getRepoCreds(ctx, corev1, argocdNs, repoUrl)
+	if err != nil {
+		return "", err
 	}
-	secrets, err := secretsApi.List(context.Background(), opts)
+	st, err := loadStore(ctx, corev1, argocdNs, arlonNs)
 	if err != nil {
-		return fmt.Errorf("failed to list secrets: %s", err)
+		return "", err
 	}
-	var creds *RepoCreds
-	for _, repoSecret := range secrets.Items {
-		if strings.Compare(repoUrl, string(repoSecret.Data["url"])) == 0 {
-			creds = &RepoCreds{
-				Url: string(repoSecret.Data["url"]),
-				Username: string(repoSecret.Data["username"]),
-				Password: string(repoSecret.Data["password"]),
-			}
-			break
-		}
+	policies, err := getRegoPolicies(ctx, corev1, arlonNs)
+	if err != nil {
+		return "", err
 	}
-	if creds == nil {
-		return fmt.Errorf("did not find argocd repository matching %s (did you register it?)", repoUrl)
+	var trees []*clusterTree
+	var clusterNames []string
+	for _, d := range deployments {
+		d.BasePath = gitutils.SlashPath(d.BasePath)
+		err = checkPathPolicy(ctx, corev1, arlonNs, d.BasePath, d.ClusterName)
+		if err != nil {
+			return "", err
+		}
+		progress.Step(ctx, "reading profile and clusterspec of cluster %s", d.ClusterName)
+		tree, err := newClusterTree(ctx, corev1, st, arlonNs, d.ClusterName, repoUrl, repoBranch,
+			d.BasePath, d.ProfileName, d.ClusterSpecName)
+		if err != nil {
+			return "", err
+		}
+		trees = append(trees, tree)
+		clusterNames = append(clusterNames, d.ClusterName)
 	}
-
-	inlineBundles, err := getInlineBundles(profileName, corev1, arlonNs)
+	err = checkOCIRepos(ctx, corev1, argocdNs, trees)
 	if err != nil {
-		return fmt.Errorf("failed to get inline bundles: %s", err)
-	}
-	tmpDir, err := os.MkdirTemp("", "arlon-")
-	branchRef := plumbing.NewBranchReferenceName(repoBranch)
-	auth := &http.BasicAuth{
-		Username: creds.Username,
-		Password: creds.Password,
-	}
-	repo, err := gogit.PlainCloneContext(context.Background(), tmpDir, false, &gogit.CloneOptions{
-		URL:           repoUrl,
-		Auth:          auth,
-		RemoteName:    gogit.DefaultRemoteName,
-		ReferenceName: branchRef,
-		SingleBranch:  true,
-		NoCheckout: false,
-		Progress:   nil,
-		Tags:       gogit.NoTags,
-		CABundle:   nil,
-	})
-	if err != nil {
-		return fmt.Errorf("failed to clone repository: %s", err)
+		return "", err
 	}
-	mgmtPath := path.Join(basePath, clusterName, "mgmt")
-	workloadPath := path.Join(basePath, clusterName, "workload")
-	wt, err := repo.Worktree()
+	progress.Step(ctx, "cloning %s (branch %s)", repoUrl, repoBranch)
+	err = gitutils.CloneBranch(ctx, repo, repoUrl, repoBranch, creds.auth(), createBranch)
 	if err != nil {
-		return fmt.Errorf("failed to get repo worktree: %s", err)
+		return "", err
 	}
-	err = copyManifests(wt, ".", mgmtPath)
-	if err != nil {
-		return fmt.Errorf("failed to copy embedded content: %s", err)
+	updated := 0
+	var groups []gitutils.CommitGroup
+	// profiles whose change windows apply, by deployed cluster
+	windowProfiles := make(map[string][]string)
+	hashes := make(map[string]map[string]string)
+	for _, tree := range trees {
+		progress.Step(ctx, "rendering cluster %s", tree.clusterName)
+		if _, err := repo.Worktree().Stat(path.Join(tree.basePath, tree.clusterName)); err == nil {
+			updated++
+		}
+		previous, err := ReadSummary(repo.Worktree(), tree.basePath, tree.clusterName)
+		if err != nil {
+			return "", err
+		}
+		var previousBundles []BundleSummary
+		if previous != nil {
+			previousBundles = previous.Bundles
+			windowProfiles[tree.clusterName] = []string{previous.Profile, tree.summary.Profile}
+		}
+		hashes[tree.clusterName] = bundleHashes(tree.inlineBundles, tree.refBundles)
+		groups = append(groups, bundleCommitGroups(tree.basePath, tree.clusterName,
+			previousBundles, tree.summary.Bundles, hashes[tree.clusterName])...)
+		err = tree.write(ctx, repo.Worktree())
+		if err != nil {
+			return "", err
+		}
+		err = checkRegoPolicies(ctx, repo.Worktree(), tree.clusterName,
+			path.Join(tree.basePath, tree.clusterName), policies)
+		if err != nil {
+			return "", err
+		}
+	}
+	verb := "add"
+	if updated == len(trees) {
+		verb = "update"
+	}
+	commitMsg := fmt.Sprintf("%s arlon manifests", verb)
+	if len(clusterNames) > 1 {
+		commitMsg = fmt.Sprintf("%s arlon manifests for clusters %s", verb, strings.Join(clusterNames, ", "))
+	} else if updated == 1 {
+		commitMsg = fmt.Sprintf("update arlon manifests for cluster %s", clusterNames[0])
 	}
-	err = copyInlineBundles(wt, clusterName, repoUrl, mgmtPath, workloadPath, inlineBundles)
+	commitMsg += hashesTrailer(hashes)
+	groups, err = gitutils.CommitGroups(ctx, groups)
 	if err != nil {
-		return fmt.Errorf("failed to copy inline bundles: %s", err)
+		return "", err
 	}
-	changed, err := gitutils.CommitChanges(tmpDir, wt)
+	progress.Step(ctx, "committing changes")
+	changed, err := repo.Commit(commitMsg, groups...)
 	if err != nil {
-		return fmt.Errorf("failed to commit changes: %s", err)
+		return "", fmt.Errorf("failed to commit changes: %w", err)
 	}
 	if !changed {
 		log.Info("no changed files, skipping commit & push")
-		return nil
+		return "", nil
+	}
+	for _, tree := range trees {
+		if profiles, ok := windowProfiles[tree.clusterName]; ok {
+			err = checkSyncWindows(ctx, st, arlonNs, tree.clusterName, profiles...)
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+	progress.Step(ctx, "pushing to %s", repoUrl)
+	err = repo.Push(ctx)
+	if err != nil {
+		return "", err
+	}
+	log.Info("succesfully pushed working tree", "repoUrl", repoUrl)
+	return repo.Head()
+}
+
+// -----------------------------------------------------------------------------
+
+func getRepoCreds(
+	ctx context.Context,
+	corev1 corev1types.CoreV1Interface,
+	argocdNs string,
+	repoUrl string,
+) (*RepoCreds, error) {
+	secretsApi := corev1.Secrets(argocdNs)
+	opts := metav1.ListOptions{
+		LabelSelector: "argocd.argoproj.io/secret-type=repository",
 	}
-	err = repo.Push(&gogit.PushOptions{
-		RemoteName: gogit.DefaultRemoteName,
-		Auth:       auth,
-		Progress:   nil,
-		CABundle:   nil,
+	var secrets *corev1api.SecretList
+	err := kuberetry.OnTransient(ctx, func() (err error) {
+		secrets, err = secretsApi.List(ctx, opts)
+		return
 	})
 	if err != nil {
-		return fmt.Errorf("failed to push to remote repository: %s", err)
+		return nil, fmt.Errorf("failed to list secrets: %w", err)
 	}
-	log.Info("succesfully pushed working tree", "tmpDir", tmpDir)
-	return nil
+	for _, repoSecret := range secrets.Items {
+		if strings.Compare(repoUrl, string(repoSecret.Data["url"])) == 0 {
+			log.AddSecret(string(repoSecret.Data["password"]))
+			return &RepoCreds{
+				Url: string(repoSecret.Data["url"]),
+				Username: string(repoSecret.Data["username"]),
+				Password: string(repoSecret.Data["password"]),
+			}, nil
+		}
+	}
+	if gitutils.IsLocal(repoUrl) {
+		// a local mirror is readable without registering it, though ArgoCD
+		// must be able to read it at the same URL
+		return &RepoCreds{Url: repoUrl}, nil
+	}
+	return nil, fmt.Errorf("%w matching %s (did you register it?)", ErrRepoCredsNotFound, repoUrl)
+}
+
+// auth returns the authentication of the repository, shaped for its git
+// hosting service, or nil for a repository registered without credentials,
+// such as a local or anonymously readable mirror.
+func (creds *RepoCreds) auth() transport.AuthMethod {
+	if creds.Username == "" && creds.Password == "" {
+		return nil
+	}
+	return gitutils.BasicAuth(creds.Url, creds.Username, creds.Password)
 }
 
 // -----------------------------------------------------------------------------
 
-func copyManifests(wt *gogit.Worktree, root string, mgmtPath string) error {
+// copyManifests copies the embedded mgmt chart to mgmtPath, without the
+// cluster chart's templates unless withTemplates is set.
+func copyManifests(fsys billy.Filesystem, root string, mgmtPath string, withTemplates bool) error {
 	log := log.GetLogger()
 	items, err := content.ReadDir(root)
 	if err != nil {
-		return fmt.Errorf("failed to read embedded directory: %s", err)
+		return fmt.Errorf("failed to read embedded directory: %w", err)
 	}
 	for _, item := range items {
 		filePath := path.Join(root, item.Name())
 		if item.IsDir() {
-			if err := copyManifests(wt, filePath, mgmtPath); err != nil {
+			if !withTemplates && filePath == "manifests/templates" {
+				continue
+			}
+			if err := copyManifests(fsys, filePath, mgmtPath, withTemplates); err != nil {
 				return err
 			}
 		} else {
 			src, err := content.Open(filePath)
 			if err != nil {
-				return fmt.Errorf("failed to open embedded file %s: %s", filePath, err)
+				return fmt.Errorf("failed to open embedded file %s: %w", filePath, err)
 			}
 			// remove manifests/ prefix
 			components := strings.Split(filePath, "/")
 			dstPath := path.Join(components[1:]...)
 			dstPath = path.Join(mgmtPath, dstPath)
-			dst, err := wt.Filesystem.Create(dstPath)
+			dst, err := fsys.Create(dstPath)
 			if err != nil {
 				_ = src.Close()
-				return fmt.Errorf("failed to create destination file %s: %s", dstPath, err)
+				return fmt.Errorf("failed to create destination file %s: %w", dstPath, err)
 			}
 			_, err = io.Copy(dst, src)
 			_ = src.Close()
 			_ = dst.Close()
 			if err != nil {
-				return fmt.Errorf("failed to copy embedded file: %s", err)
+				return fmt.Errorf("failed to copy embedded file: %w", err)
 			}
 			log.V(1).Info("copied embedded file", "destination", dstPath)
 		}
@@ -173,41 +295,85 @@ func copyManifests(wt *gogit.Worktree, root string, mgmtPath string) error {
 
 // -----------------------------------------------------------------------------
 
-func getInlineBundles(
+// getProfileBundles returns the profile's inline bundles, whose data is
+// copied to the repository, and the applications to generate for its
+// reference bundles, which point to their source directly.
+func getProfileBundles(
+	ctx context.Context,
 	profileName string,
+	st bundle.Store,
 	corev1 corev1types.CoreV1Interface,
 	arlonNs string,
-) (inlineBundles []inlineBundle, err error) {
+) (inlineBundles []inlineBundle, refBundles []AppSettings, err error) {
 
 	log := log.GetLogger()
 	if profileName == "" {
 		return
 	}
-	configMapsApi := corev1.ConfigMaps(arlonNs)
-	secretsApi := corev1.Secrets(arlonNs)
-	profileConfigMap, err := configMapsApi.Get(context.Background(), profileName, metav1.GetOptions{})
+	profileNs, profileName := bundle.ParseRef(profileName, arlonNs)
+	profileConfigMap, err := st.GetProfile(ctx, profileNs, profileName)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get profile configmap: %s", err)
+		return nil, nil, profileError(profileName, err)
 	}
 	if profileConfigMap.Labels["arlon-type"] != "profile" {
-		return nil, fmt.Errorf("profile configmap does not have expected label")
+		return nil, nil, fmt.Errorf("profile configmap does not have expected label")
 	}
-	bundles := profileConfigMap.Data["bundles"]
-	if bundles == "" {
-		return nil, fmt.Errorf("profile has no bundles")
+	bundleItems, err := bundle.ProfileBundleRefs(ctx, st, profileConfigMap)
+	if err != nil {
+		return nil, nil, err
 	}
-	bundleItems := strings.Split(bundles, ",")
-	for _, bundleName := range bundleItems {
-		secr, err := secretsApi.Get(context.Background(), bundleName, metav1.GetOptions{})
+	if len(bundleItems) == 0 {
+		return nil, nil, fmt.Errorf("profile has no bundles")
+	}
+	// trusted keys are always taken from the arlon namespace, so that
+	// bundles in team namespaces can't vouch for themselves
+	trustedKeys, err := bundle.LoadTrustedKeys(ctx, corev1, arlonNs)
+	if err != nil {
+		return nil, nil, err
+	}
+	seen := make(map[string]string)
+	for _, bundleRef := range bundleItems {
+		bundleNs, bundleName := bundle.ParseRef(bundleRef, profileNs)
+		// bundles are laid out by name in the repo, so names must be unique
+		if other, ok := seen[bundleName]; ok {
+			return nil, nil, fmt.Errorf("bundles %s and %s have the same name", other, bundleRef)
+		}
+		seen[bundleName] = bundleRef
+		secr, err := st.GetBundle(ctx, bundleNs, bundleName)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get bundle secret %s: %s", bundleName, err)
+			return nil, nil, fmt.Errorf("failed to get bundle secret %s: %w", bundleRef, err)
+		}
+		if secr.Labels["bundle-type"] == "reference" {
+			app, err := referenceBundleApp(secr)
+			if err != nil {
+				return nil, nil, err
+			}
+			app.BundleNamespace = bundleNs
+			refBundles = append(refBundles, *app)
+			log.V(1).Info("adding reference bundle", "bundleName", bundleName)
+			continue
 		}
 		if secr.Labels["bundle-type"] != "inline" {
 			continue
 		}
+		if trustedKeys != nil {
+			keyName, err := bundle.Verify(trustedKeys, secr.Data["data"], secr.Data[bundle.SignatureKey])
+			if err != nil {
+				return nil, nil, fmt.Errorf("refusing to render bundle %s: %w", bundleName, err)
+			}
+			log.V(1).Info("verified bundle signature", "bundleName", bundleName, "key", keyName)
+		}
+		syncOptions, retry, err := bundle.SyncPolicyOf(secr)
+		if err != nil {
+			return nil, nil, err
+		}
 		inlineBundles = append(inlineBundles, inlineBundle{
 			name: bundleName,
+			namespace: bundleNs,
 			data: secr.Data["data"],
+			syncOptions: syncOptions,
+			retry: retry,
+			hash: bundle.ContentHash(secr),
 		})
 		log.V(1).Info("adding inline bundle", "bundleName", bundleName)
 	}
@@ -216,37 +382,186 @@ func getInlineBundles(
 
 // -----------------------------------------------------------------------------
 
+// referenceBundleApp returns the settings of the application deploying a
+// reference bundle, which is either a path in a git repository or a chart in
+// a Helm repository.
+func referenceBundleApp(secr *corev1api.Secret) (*AppSettings, error) {
+	app := &AppSettings{
+		BundleName:           secr.Name,
+		DestinationNamespace: secr.Annotations["destination-namespace"],
+		RepoUrl:              secr.Annotations["repo-url"],
+		RepoPath:             secr.Annotations["repo-path"],
+		Chart:                secr.Annotations["repo-chart"],
+		TargetRevision:       secr.Annotations["repo-revision"],
+		HelmValues:           string(secr.Data["values"]),
+		BundleHash:           bundle.ContentHash(secr),
+	}
+	if app.RepoUrl == "" {
+		return nil, fmt.Errorf("reference bundle %s has no repo url", secr.Name)
+	}
+	var err error
+	app.SyncOptions, app.Retry, err = bundle.SyncPolicyOf(secr)
+	if err != nil {
+		return nil, err
+	}
+	if bundle.IsOCI(app.RepoUrl) && app.Chart == "" {
+		return nil, fmt.Errorf("OCI reference bundle %s has no chart", secr.Name)
+	}
+	if app.DestinationNamespace == "" {
+		app.DestinationNamespace = "default"
+	}
+	if app.Chart != "" {
+		if app.TargetRevision == "" {
+			return nil, fmt.Errorf("helm reference bundle %s has no chart version", secr.Name)
+		}
+		return app, nil
+	}
+	if app.RepoPath == "" {
+		app.RepoPath = "."
+	}
+	if app.TargetRevision == "" {
+		app.TargetRevision = "HEAD"
+	}
+	return app, nil
+}
+
+// -----------------------------------------------------------------------------
+
+// clusterSpecBundles returns the bundles implied by the settings of the
+// resolved clusterspec specData, such as the CNI or the autoscaler for
+// clusters with autoscaling enabled. There are none without a clusterspec.
+func clusterSpecBundles(clusterName string, specData map[string]string) (bundles []AppSettings, err error) {
+	if specData == nil {
+		return
+	}
+	cni, err := cniBundle(specData)
+	if err != nil {
+		return nil, err
+	}
+	if cni != nil {
+		bundles = append(bundles, *cni)
+	}
+	autoscaler, err := autoscalerBundle(clusterName, specData)
+	if err != nil {
+		return nil, err
+	}
+	if autoscaler != nil {
+		bundles = append(bundles, *autoscaler)
+	}
+	return
+}
+
+// -----------------------------------------------------------------------------
+
 const appTmpl = `
 apiVersion: argoproj.io/v1alpha1
 kind: Application
 metadata:
   name: {{.ClusterName}}-{{.BundleName}}
   namespace: {{.AppNamespace}}
+{{- if or .SyncWave .BundleHash}}
+  annotations:
+{{- if .SyncWave}}
+    argocd.argoproj.io/sync-wave: "{{.SyncWave}}"
+{{- end}}
+{{- if .BundleHash}}
+    arlon.io/bundle-hash: {{.BundleHash}}
+{{- end}}
+{{- end}}
 spec:
   syncPolicy:
     automated:
       prune: true
+{{- if .SyncOptions}}
+    syncOptions:
+{{- range .SyncOptions}}
+    - {{.}}
+{{- end}}
+{{- end}}
+{{- with .Retry}}
+    retry:
+      limit: {{.Limit}}
+      backoff:
+        duration: {{.Backoff}}
+        factor: {{.BackoffFactor}}
+        maxDuration: {{.MaxBackoff}}
+{{- end}}
   destination:
+{{- if .DestinationServer}}
+    server: {{.DestinationServer}}
+{{- else}}
+{{"{{- if .Values.destinationServer }}"}}
+    server: {{"{{ .Values.destinationServer }}"}}
+{{"{{- else }}"}}
     name: {{.ClusterName}}
+{{"{{- end }}"}}
+{{- end}}
     namespace: {{.DestinationNamespace}}
-  project: default
+  project: '{{"{{ .Values.project | default \"default\" }}"}}'
   source:
-    repoURL: {{.RepoUrl}}
+    repoURL: {{repoURL .RepoUrl}}
+{{- if .Chart}}
+    chart: {{.Chart}}
+    targetRevision: "{{.TargetRevision}}"
+{{- else if .RepoPath}}
+    path: {{.RepoPath}}
+    targetRevision: "{{.TargetRevision}}"
+{{- else}}
     path: {{.WorkloadPath}}/{{.BundleName}}
     targetRevision: HEAD
+{{- end}}
+{{- if .HelmValues}}
+    helm:
+      values: |
+{{indent 8 .HelmValues}}
+{{- end}}
 `
 
+// AppSettings holds the values of a generated bundle application. Inline
+// bundles are sourced from their directory under WorkloadPath, whereas
+// setting Chart sources the application from a Helm chart in RepoUrl, which
+// may be an OCI registry (oci://...).
+// An empty DestinationServer targets the workload cluster: by the server URL
+// set as the root application's destinationServer Helm parameter, or else by
+// the name it is registered with in ArgoCD, ClusterName.
+// SyncWave orders the application relative to the cluster's other ones.
+// SyncOptions and Retry customize its sync policy, as set by its bundle.
+// BundleHash is the content hash of a profile bundle, recorded in the
+// BundleHashAnnotation of its application, and BundleNamespace the namespace
+// of a profile's reference bundle, recorded in the cluster summary.
 type AppSettings struct {
 	ClusterName string
 	BundleName string
 	WorkloadPath string
 	AppNamespace string
 	DestinationNamespace string
+	DestinationServer string
 	RepoUrl string
+	RepoPath string
+	Chart string
+	TargetRevision string
+	HelmValues string
+	SyncWave string
+	SyncOptions []string
+	Retry *bundle.SyncRetry
+	BundleHash string
+	BundleNamespace string
+}
+
+func newAppTemplate() (*template.Template, error) {
+	funcs := template.FuncMap{
+		"indent": func(n int, s string) string {
+			pad := strings.Repeat(" ", n)
+			lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+			return pad + strings.Join(lines, "\n"+pad)
+		},
+		"repoURL": bundle.ArgoRepoURL,
+	}
+	return template.New("app").Funcs(funcs).Parse(appTmpl)
 }
 
 func copyInlineBundles(
-	wt *gogit.Worktree,
+	fsys billy.Filesystem,
 	clusterName string,
 	repoUrl string,
 	mgmtPath string,
@@ -256,45 +571,81 @@ func copyInlineBundles(
 	if len(bundles) == 0 {
 		return nil
 	}
-	tmpl, err := template.New("app").Parse(appTmpl)
+	tmpl, err := newAppTemplate()
 	if err != nil {
-		return fmt.Errorf("failed to create app template: %s", err)
+		return fmt.Errorf("failed to create app template: %w", err)
 	}
 	for _, bundle := range bundles {
 		dirPath := path.Join(workloadPath, bundle.name)
-		err := wt.Filesystem.MkdirAll(dirPath, fs.ModeDir | 0700)
+		err := fsys.MkdirAll(dirPath, fs.ModeDir | 0700)
 		if err != nil {
-			return fmt.Errorf("failed to create directory in working tree: %s", err)
+			return fmt.Errorf("failed to create directory in working tree: %w", err)
 		}
 		bundleFileName := fmt.Sprintf("%s.yaml", bundle.name)
 		bundlePath := path.Join(dirPath, bundleFileName)
-		dst, err := wt.Filesystem.Create(bundlePath)
+		dst, err := fsys.Create(bundlePath)
 		if err != nil {
-			return fmt.Errorf("failed to create file in working tree: %s", err)
+			return fmt.Errorf("failed to create file in working tree: %w", err)
 		}
 		if bundle.data == nil {
-			return fmt.Errorf("inline bundle %s has no data", bundle.name)
+			return fmt.Errorf("inline bundle %s: %w", bundle.name, ErrBundleEmpty)
 		}
 		_, err = io.Copy(dst, bytes.NewReader(bundle.data))
 		if err != nil {
 			dst.Close()
-			return fmt.Errorf("failed to copy inline bundle %s: %s", bundle.name, err)
+			return fmt.Errorf("failed to copy inline bundle %s: %w", bundle.name, err)
 		}
 		dst.Close()
 		appPath := path.Join(mgmtPath, "templates", bundleFileName)
-		dst, err = wt.Filesystem.Create(appPath)
+		dst, err = fsys.Create(appPath)
 		if err != nil {
-			return fmt.Errorf("failed to create application file %s: %s", appPath, err)
+			return fmt.Errorf("failed to create application file %s: %w", appPath, err)
 		}
 		app := AppSettings{ClusterName: clusterName, BundleName: bundle.name,
 			WorkloadPath: workloadPath, AppNamespace: "argocd",
-			DestinationNamespace: "default", RepoUrl: repoUrl}
+			DestinationNamespace: "default", RepoUrl: repoUrl,
+			SyncOptions: bundle.syncOptions, Retry: bundle.retry,
+			BundleHash: bundle.hash}
 		err = tmpl.Execute(dst, &app)
 		if err != nil {
 			dst.Close()
-			return fmt.Errorf("failed to render application template %s: %s", appPath, err)
+			return fmt.Errorf("failed to render application template %s: %w", appPath, err)
+		}
+		dst.Close()
+	}
+	return nil
+}
+
+// -----------------------------------------------------------------------------
+
+// renderBundleApps writes an application to the mgmt chart for each bundle
+// that isn't backed by files in the workload directory, e.g. Helm charts.
+func renderBundleApps(
+	fsys billy.Filesystem,
+	clusterName string,
+	mgmtPath string,
+	bundles []AppSettings,
+) error {
+	if len(bundles) == 0 {
+		return nil
+	}
+	tmpl, err := newAppTemplate()
+	if err != nil {
+		return fmt.Errorf("failed to create app template: %w", err)
+	}
+	for _, app := range bundles {
+		app.ClusterName = clusterName
+		app.AppNamespace = "argocd"
+		appPath := path.Join(mgmtPath, "templates", fmt.Sprintf("%s.yaml", app.BundleName))
+		dst, err := fsys.Create(appPath)
+		if err != nil {
+			return fmt.Errorf("failed to create application file %s: %w", appPath, err)
 		}
+		err = tmpl.Execute(dst, &app)
 		dst.Close()
+		if err != nil {
+			return fmt.Errorf("failed to render application template %s: %w", appPath, err)
+		}
 	}
 	return nil
 }
@@ -0,0 +1,1381 @@
+package cluster_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"arlon.io/arlon/pkg/bundle"
+	"arlon.io/arlon/pkg/cluster"
+	clustertesting "arlon.io/arlon/pkg/cluster/testing"
+	"arlon.io/arlon/pkg/gitutils"
+	"arlon.io/arlon/pkg/gitutils/fake"
+	applicationpkg "github.com/argoproj/argo-cd/v2/pkg/apiclient/application"
+	argoappv1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	appfake "github.com/argoproj/argo-cd/v2/pkg/client/clientset/versioned/fake"
+	"github.com/go-git/go-billy/v5/util"
+	"google.golang.org/grpc"
+	corev1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func testObjects() []runtime.Object {
+	return []runtime.Object{
+		clustertesting.RepoSecret(clustertesting.ArgocdNs, clustertesting.RepoUrl),
+		clustertesting.ClusterSpec(clustertesting.ArlonNs, "eks",
+			map[string]string{"region": "us-west-2", "nodeCount": "2"}),
+		clustertesting.Profile(clustertesting.ArlonNs, "dev", "guestbook", "nginx"),
+		clustertesting.InlineBundle(clustertesting.ArlonNs, "guestbook", "kind: ConfigMap\n"),
+		clustertesting.ChartBundle(clustertesting.ArlonNs, "nginx", "https://charts.example.com", "nginx", "1.0"),
+	}
+}
+
+func TestDeployToGit(t *testing.T) {
+	kubeClient := k8sfake.NewSimpleClientset(testObjects()...)
+	server := fake.NewServer()
+	server.CreateBranch(clustertesting.RepoUrl, "main", map[string][]byte{"README.md": []byte("fleet\n")})
+
+	sha, err := cluster.DeployToGit(context.Background(), kubeClient, server.NewRepo(), "argocd", "arlon", "c1",
+		clustertesting.RepoUrl, "main", "arlon", "dev", "eks", "")
+	if err != nil {
+		t.Fatalf("deploy failed: %s", err)
+	}
+	commits := server.Commits(clustertesting.RepoUrl, "main")
+	if len(commits) != 2 || commits[1].Hash != sha {
+		t.Fatalf("expected one pushed commit with hash %s, got %v", sha, commits)
+	}
+	files := server.Files(clustertesting.RepoUrl, "main")
+	for _, name := range []string{
+		"README.md",
+		"arlon/c1/arlon-cluster.yaml",
+		"arlon/c1/README.md",
+		"arlon/c1/mgmt/Chart.yaml",
+		"arlon/c1/mgmt/templates/guestbook.yaml",
+		"arlon/c1/mgmt/templates/nginx.yaml",
+		"arlon/c1/workload/guestbook/guestbook.yaml",
+	} {
+		if files[name] == nil {
+			t.Errorf("expected %s to be pushed", name)
+		}
+	}
+	if app := string(files["arlon/c1/mgmt/templates/nginx.yaml"]); !strings.Contains(app, "chart: nginx") {
+		t.Errorf("expected nginx application to deploy the chart, got:\n%s", app)
+	}
+
+	// deploying again changes nothing
+	sha, err = cluster.DeployToGit(context.Background(), kubeClient, server.NewRepo(), "argocd", "arlon", "c1",
+		clustertesting.RepoUrl, "main", "arlon", "dev", "eks", "")
+	if err != nil {
+		t.Fatalf("second deploy failed: %s", err)
+	}
+	if sha != "" || len(server.Commits(clustertesting.RepoUrl, "main")) != 2 {
+		t.Errorf("expected no commit when nothing changed")
+	}
+}
+
+func TestDeployToGitUnregisteredRepo(t *testing.T) {
+	kubeClient := k8sfake.NewSimpleClientset(testObjects()...)
+	server := fake.NewServer()
+	_, err := cluster.DeployToGit(context.Background(), kubeClient, server.NewRepo(), "argocd", "arlon", "c1",
+		"https://git.example.com/other.git", "main", "arlon", "dev", "eks", "")
+	if !errors.Is(err, cluster.ErrRepoCredsNotFound) || cluster.IsRetryable(err) {
+		t.Errorf("expected unregistered repository error, got %v", err)
+	}
+}
+
+func TestDeployToGitTransientFailures(t *testing.T) {
+	kubeClient := k8sfake.NewSimpleClientset(testObjects()...)
+	failures := 2
+	kubeClient.PrependReactor("*", "*", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if failures == 0 || action.GetVerb() != "list" && action.GetVerb() != "get" {
+			return false, nil, nil
+		}
+		failures--
+		return true, nil, apierr.NewServiceUnavailable("restarting")
+	})
+	server := fake.NewServer()
+	server.CreateBranch(clustertesting.RepoUrl, "main", nil)
+	_, err := cluster.DeployToGit(context.Background(), kubeClient, server.NewRepo(), "argocd", "arlon", "c1",
+		clustertesting.RepoUrl, "main", "arlon", "dev", "eks", "")
+	if err != nil {
+		t.Fatalf("expected transient failures to be retried, got %s", err)
+	}
+	kubeClient.PrependReactor("get", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, apierr.NewForbidden(corev1.Resource("configmaps"), "eks", errors.New("denied"))
+	})
+	_, err = cluster.DeployToGit(context.Background(), kubeClient, server.NewRepo(), "argocd", "arlon", "c2",
+		clustertesting.RepoUrl, "main", "arlon", "dev", "eks", "")
+	if !apierr.IsForbidden(err) {
+		t.Errorf("expected forbidden error, got %v", err)
+	}
+}
+
+func TestDeployToGitLocalRepo(t *testing.T) {
+	kubeClient := k8sfake.NewSimpleClientset(testObjects()...)
+	server := fake.NewServer()
+	for _, repoUrl := range []string{"file:///srv/git/fleet.git", `C:\git\fleet.git`} {
+		server.CreateBranch(repoUrl, "main", nil)
+		// windows paths are converted to repository paths
+		_, err := cluster.DeployToGit(context.Background(), kubeClient, server.NewRepo(), "argocd", "arlon", "c1",
+			repoUrl, "main", `arlon\team`, "dev", "eks", "")
+		if err != nil {
+			t.Fatalf("deploy to unregistered local repository %s failed: %s", repoUrl, err)
+		}
+		if server.Files(repoUrl, "main")["arlon/team/c1/mgmt/templates/guestbook.yaml"] == nil {
+			t.Errorf("expected the cluster to be deployed to arlon/team/c1 of %s", repoUrl)
+		}
+	}
+}
+
+func TestDeployToGitErrors(t *testing.T) {
+	kubeClient := k8sfake.NewSimpleClientset(testObjects()...)
+	server := fake.NewServer()
+	server.CreateBranch(clustertesting.RepoUrl, "main", nil)
+	_, err := cluster.DeployToGit(context.Background(), kubeClient, server.NewRepo(), "argocd", "arlon", "c1",
+		clustertesting.RepoUrl, "main", "arlon", "missing", "eks", "")
+	if !errors.Is(err, cluster.ErrProfileNotFound) {
+		t.Errorf("expected profile not found error, got %v", err)
+	}
+	// a clone made before another deployment can't be pushed
+	stale := server.NewRepo()
+	if err := stale.Clone(context.Background(), clustertesting.RepoUrl, "main", nil); err != nil {
+		t.Fatalf("failed to clone: %s", err)
+	}
+	_, err = cluster.DeployToGit(context.Background(), kubeClient, server.NewRepo(), "argocd", "arlon", "c1",
+		clustertesting.RepoUrl, "main", "arlon", "dev", "eks", "")
+	if err != nil {
+		t.Fatalf("failed to deploy: %s", err)
+	}
+	_ = util.WriteFile(stale.Worktree(), "arlon/c2/README.md", []byte("c2\n"), 0644)
+	if _, err := stale.Commit("add c2"); err != nil {
+		t.Fatalf("failed to commit: %s", err)
+	}
+	err = stale.Push(context.Background())
+	if !errors.Is(err, cluster.ErrPushConflict) || !cluster.IsRetryable(err) {
+		t.Errorf("expected push conflict error, got %v", err)
+	}
+}
+
+func TestDeployToGitPushFailure(t *testing.T) {
+	kubeClient := k8sfake.NewSimpleClientset(testObjects()...)
+	server := fake.NewServer()
+	server.CreateBranch(clustertesting.RepoUrl, "main", nil)
+	server.PushErr = errors.New("permission denied")
+	_, err := cluster.DeployToGit(context.Background(), kubeClient, server.NewRepo(), "argocd", "arlon", "c1",
+		clustertesting.RepoUrl, "main", "arlon", "dev", "eks", "")
+	if err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("expected push error, got %v", err)
+	}
+	if len(server.Commits(clustertesting.RepoUrl, "main")) != 1 {
+		t.Errorf("expected nothing to be pushed")
+	}
+}
+
+func TestDeployToGitOCI(t *testing.T) {
+	objects := append(testObjects(),
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "eks-oci",
+				Namespace: "arlon",
+				Labels:    map[string]string{"managed-by": "arlon", "arlon-type": "clusterspec"},
+			},
+			Data: map[string]string{
+				"baseSpec":     "eks",
+				"clusterChart": "oci://registry.example.com/charts/arlon-cluster:0.2.0",
+			},
+		},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "oci",
+				Namespace: "arlon",
+				Labels:    map[string]string{"managed-by": "arlon", "arlon-type": "profile"},
+			},
+			Data: map[string]string{"bundles": "redis"},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "redis",
+				Namespace: "arlon",
+				Labels:    map[string]string{"arlon-type": "config-bundle", "bundle-type": "reference"},
+				Annotations: map[string]string{
+					"repo-url":      "oci://registry.example.com/charts",
+					"repo-chart":    "redis",
+					"repo-revision": "16.0.0",
+				},
+			},
+		},
+	)
+	kubeClient := k8sfake.NewSimpleClientset(objects...)
+	server := fake.NewServer()
+	server.CreateBranch(clustertesting.RepoUrl, "main", nil)
+	_, err := cluster.DeployToGit(context.Background(), kubeClient, server.NewRepo(), "argocd", "arlon", "c1",
+		clustertesting.RepoUrl, "main", "arlon", "oci", "eks-oci", "")
+	if err == nil || !strings.Contains(err.Error(), "enableOCI") {
+		t.Fatalf("expected unregistered OCI registry error, got %v", err)
+	}
+
+	_, err = kubeClient.CoreV1().Secrets("argocd").Create(context.Background(), &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "registry",
+			Labels: map[string]string{"argocd.argoproj.io/secret-type": "repo-creds"},
+		},
+		Data: map[string][]byte{
+			"url":       []byte("registry.example.com"),
+			"type":      []byte("helm"),
+			"enableOCI": []byte("true"),
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cluster.DeployToGit(context.Background(), kubeClient, server.NewRepo(), "argocd", "arlon", "c1",
+		clustertesting.RepoUrl, "main", "arlon", "oci", "eks-oci", "")
+	if err != nil {
+		t.Fatalf("deploy failed: %s", err)
+	}
+	files := server.Files(clustertesting.RepoUrl, "main")
+	app := string(files["arlon/c1/mgmt/templates/redis.yaml"])
+	if !strings.Contains(app, "repoURL: registry.example.com/charts\n") || !strings.Contains(app, "chart: redis") {
+		t.Errorf("expected redis application to pull the chart from the registry, got:\n%s", app)
+	}
+	chartApp := string(files["arlon/c1/mgmt/templates/cluster-chart.yaml"])
+	if !strings.Contains(chartApp, "chart: arlon-cluster") || !strings.Contains(chartApp, "toYaml .Values") {
+		t.Errorf("expected cluster chart application, got:\n%s", chartApp)
+	}
+	if files["arlon/c1/mgmt/templates/cluster.yaml"] != nil {
+		t.Errorf("expected embedded cluster chart templates to be left out")
+	}
+}
+
+func TestDeployedDrift(t *testing.T) {
+	ctx := context.Background()
+	kubeClient := k8sfake.NewSimpleClientset(testObjects()...)
+	server := fake.NewServer()
+	server.CreateBranch(clustertesting.RepoUrl, "main", nil)
+	_, err := cluster.DeployToGit(ctx, kubeClient, server.NewRepo(), "argocd", "arlon", "c1",
+		clustertesting.RepoUrl, "main", "arlon", "dev", "eks", "")
+	if err != nil {
+		t.Fatalf("deploy failed: %s", err)
+	}
+	rootApp, err := cluster.ConstructRootApp(ctx, kubeClient, "argocd", "arlon", "c1",
+		clustertesting.RepoUrl, "main", "arlon", "eks", "dev", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes, summary, err := cluster.DeployedDrift(ctx, kubeClient, server.NewRepo(), "argocd", "arlon", rootApp)
+	if err != nil || summary == nil {
+		t.Fatalf("drift failed: %v", err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("expected no drift right after deploying, got %v", changes)
+	}
+
+	spec, _ := kubeClient.CoreV1().ConfigMaps("arlon").Get(ctx, "eks", metav1.GetOptions{})
+	spec.Data["nodeCount"] = "3"
+	_, _ = kubeClient.CoreV1().ConfigMaps("arlon").Update(ctx, spec, metav1.UpdateOptions{})
+	guestbook, _ := kubeClient.CoreV1().Secrets("arlon").Get(ctx, "guestbook", metav1.GetOptions{})
+	guestbook.Data["data"] = []byte("kind: Secret\n")
+	_, _ = kubeClient.CoreV1().Secrets("arlon").Update(ctx, guestbook, metav1.UpdateOptions{})
+	nginx, _ := kubeClient.CoreV1().Secrets("arlon").Get(ctx, "nginx", metav1.GetOptions{})
+	nginx.Data = map[string][]byte{"values": []byte("replicas: 2\n")}
+	_, _ = kubeClient.CoreV1().Secrets("arlon").Update(ctx, nginx, metav1.UpdateOptions{})
+	changes, _, err = cluster.DeployedDrift(ctx, kubeClient, server.NewRepo(), "argocd", "arlon", rootApp)
+	if err != nil {
+		t.Fatalf("drift failed: %s", err)
+	}
+	expected := []string{
+		`clusterspec eks: nodeCount changed from "2" to "3"`,
+		"bundle arlon/guestbook: content changed",
+		"bundle arlon/nginx: content changed",
+	}
+	if strings.Join(changes, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected changes %v, got %v", expected, changes)
+	}
+}
+
+func TestDeployToGitCreateBranch(t *testing.T) {
+	kubeClient := k8sfake.NewSimpleClientset(testObjects()...)
+	server := fake.NewServer()
+	server.CreateBranch(clustertesting.RepoUrl, "main", map[string][]byte{"README.md": []byte("fleet\n")})
+
+	_, err := cluster.DeployToGit(context.Background(), kubeClient, server.NewRepo(), "argocd", "arlon", "c1",
+		clustertesting.RepoUrl, "staging", "arlon", "dev", "eks", "")
+	if !errors.Is(err, gitutils.ErrBranchNotFound) {
+		t.Fatalf("expected branch not found error, got %v", err)
+	}
+
+	_, err = cluster.DeployToGit(context.Background(), kubeClient, server.NewRepo(), "argocd", "arlon", "c1",
+		clustertesting.RepoUrl, "staging", "arlon", "dev", "eks", gitutils.CreateBranchFromDefault)
+	if err != nil {
+		t.Fatalf("deploy failed: %s", err)
+	}
+	files := server.Files(clustertesting.RepoUrl, "staging")
+	if files["README.md"] == nil || files["arlon/c1/arlon-cluster.yaml"] == nil {
+		t.Errorf("expected branch created from main with cluster files, got %v", files)
+	}
+
+	_, err = cluster.DeployToGit(context.Background(), kubeClient, server.NewRepo(), "argocd", "arlon", "c1",
+		clustertesting.RepoUrl, "orphan", "arlon", "dev", "eks", gitutils.CreateBranchOrphan)
+	if err != nil {
+		t.Fatalf("deploy failed: %s", err)
+	}
+	commits := server.Commits(clustertesting.RepoUrl, "orphan")
+	if len(commits) != 1 || commits[0].Files["README.md"] != nil {
+		t.Errorf("expected orphan branch with a single commit, got %v", commits)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	kubeClient := k8sfake.NewSimpleClientset(testObjects()...)
+	server := fake.NewServer()
+	server.CreateBranch(clustertesting.RepoUrl, "main", nil)
+
+	var out bytes.Buffer
+	changed, err := cluster.Diff(context.Background(), kubeClient, server.NewRepo(), "argocd", "arlon", "c1",
+		clustertesting.RepoUrl, "main", "arlon", "dev", "eks", &out)
+	if err != nil {
+		t.Fatalf("diff failed: %s", err)
+	}
+	if !changed || !strings.Contains(out.String(), "+++ b/arlon/c1/mgmt/templates/nginx.yaml") {
+		t.Errorf("expected new cluster files in diff, got:\n%s", out.String())
+	}
+	if len(server.Commits(clustertesting.RepoUrl, "main")) != 1 {
+		t.Errorf("expected nothing to be pushed")
+	}
+
+	_, err = cluster.DeployToGit(context.Background(), kubeClient, server.NewRepo(), "argocd", "arlon", "c1",
+		clustertesting.RepoUrl, "main", "arlon", "dev", "eks", "")
+	if err != nil {
+		t.Fatalf("deploy failed: %s", err)
+	}
+	out.Reset()
+	changed, err = cluster.Diff(context.Background(), kubeClient, server.NewRepo(), "argocd", "arlon", "c1",
+		clustertesting.RepoUrl, "main", "arlon", "dev", "eks", &out)
+	if err != nil || changed {
+		t.Errorf("expected no diff after deploy, got %v:\n%s", err, out.String())
+	}
+}
+
+// fakeAppClient serves the root application of cluster c1 deployed to
+// clustertesting.RepoUrl.
+type fakeAppClient struct {
+	applicationpkg.ApplicationServiceClient
+}
+
+func (fakeAppClient) Get(
+	_ context.Context,
+	query *applicationpkg.ApplicationQuery,
+	_ ...grpc.CallOption,
+) (*argoappv1.Application, error) {
+	if *query.Name != "c1" {
+		return nil, errors.New("not found")
+	}
+	app := &argoappv1.Application{}
+	app.Name = "c1"
+	app.Namespace = "argocd"
+	app.Spec.Source.RepoURL = clustertesting.RepoUrl
+	app.Spec.Source.TargetRevision = "main"
+	app.Spec.Source.Path = "arlon/c1/mgmt"
+	return app, nil
+}
+
+func (fakeAppClient) Update(
+	_ context.Context,
+	req *applicationpkg.ApplicationUpdateRequest,
+	_ ...grpc.CallOption,
+) (*argoappv1.Application, error) {
+	return req.Application, nil
+}
+
+func TestSetProfile(t *testing.T) {
+	kubeClient := k8sfake.NewSimpleClientset(testObjects()...)
+	server := fake.NewServer()
+	server.CreateBranch(clustertesting.RepoUrl, "main", nil)
+	_, err := cluster.DeployToGit(context.Background(), kubeClient, server.NewRepo(), "argocd", "arlon", "c1",
+		clustertesting.RepoUrl, "main", "arlon", "dev", "eks", "")
+	if err != nil {
+		t.Fatalf("deploy failed: %s", err)
+	}
+
+	_, err = cluster.SetProfile(context.Background(), kubeClient, server.NewRepo(), fakeAppClient{},
+		"argocd", "arlon", "c1", "")
+	if err != nil {
+		t.Fatalf("detach failed: %s", err)
+	}
+	files := server.Files(clustertesting.RepoUrl, "main")
+	for _, name := range []string{
+		"arlon/c1/mgmt/templates/guestbook.yaml",
+		"arlon/c1/mgmt/templates/nginx.yaml",
+		"arlon/c1/workload/guestbook/guestbook.yaml",
+	} {
+		if files[name] != nil {
+			t.Errorf("expected %s to be removed", name)
+		}
+	}
+	if files["arlon/c1/mgmt/Chart.yaml"] == nil {
+		t.Errorf("expected mgmt chart to be kept")
+	}
+
+	_, err = cluster.SetProfile(context.Background(), kubeClient, server.NewRepo(), fakeAppClient{},
+		"argocd", "arlon", "c1", "dev")
+	if err != nil {
+		t.Fatalf("attach failed: %s", err)
+	}
+	var out bytes.Buffer
+	changed, err := cluster.Diff(context.Background(), kubeClient, server.NewRepo(), "argocd", "arlon", "c1",
+		clustertesting.RepoUrl, "main", "arlon", "dev", "eks", &out)
+	if err != nil || changed {
+		t.Errorf("expected attached profile to match a deployment, got %v:\n%s", err, out.String())
+	}
+}
+
+func TestSyncProfile(t *testing.T) {
+	kubeClient := k8sfake.NewSimpleClientset(testObjects()...)
+	server := fake.NewServer()
+	server.CreateBranch(clustertesting.RepoUrl, "main", nil)
+	_, err := cluster.DeployToGit(context.Background(), kubeClient, server.NewRepo(), "argocd", "arlon", "c1",
+		clustertesting.RepoUrl, "main", "arlon", "dev", "eks", "")
+	if err != nil {
+		t.Fatalf("deploy failed: %s", err)
+	}
+	_, changed, err := cluster.SyncProfile(context.Background(), kubeClient, server.NewRepo(), fakeAppClient{},
+		"argocd", "arlon", "c1", "dev", true)
+	if err != nil || changed {
+		t.Fatalf("expected up to date cluster, got changed=%v err=%v", changed, err)
+	}
+
+	secrets := kubeClient.CoreV1().Secrets("arlon")
+	guestbook, err := secrets.Get(context.Background(), "guestbook", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	guestbook.Data["data"] = []byte("kind: Secret\n")
+	_, err = secrets.Update(context.Background(), guestbook, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const bundleFile = "arlon/c1/workload/guestbook/guestbook.yaml"
+	commitSha, changed, err := cluster.SyncProfile(context.Background(), kubeClient, server.NewRepo(), fakeAppClient{},
+		"argocd", "arlon", "c1", "dev", true)
+	if err != nil || !changed || commitSha != "" {
+		t.Fatalf("expected dry run to report a change, got changed=%v sha=%q err=%v", changed, commitSha, err)
+	}
+	if got := string(server.Files(clustertesting.RepoUrl, "main")[bundleFile]); got != "kind: ConfigMap\n" {
+		t.Errorf("expected dry run not to push, got %q", got)
+	}
+	commitSha, changed, err = cluster.SyncProfile(context.Background(), kubeClient, server.NewRepo(), fakeAppClient{},
+		"argocd", "arlon", "c1", "dev", false)
+	if err != nil || !changed || commitSha == "" {
+		t.Fatalf("expected sync to push a commit, got changed=%v sha=%q err=%v", changed, commitSha, err)
+	}
+	if got := string(server.Files(clustertesting.RepoUrl, "main")[bundleFile]); got != "kind: Secret\n" {
+		t.Errorf("expected synced bundle content, got %q", got)
+	}
+	events, err := kubeClient.CoreV1().Events("argocd").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events.Items) != 1 || events.Items[0].Reason != cluster.ReasonProfileSynced ||
+		events.Items[0].Annotations[cluster.EventCommitShaAnnotation] != commitSha {
+		t.Errorf("expected a single %s event for commit %s, got %+v", cluster.ReasonProfileSynced, commitSha, events.Items)
+	}
+
+	_, _, err = cluster.SyncProfile(context.Background(), kubeClient, server.NewRepo(), fakeAppClient{},
+		"argocd", "arlon", "c1", "prod", true)
+	if err == nil {
+		t.Errorf("expected syncing another profile to fail")
+	}
+}
+
+func TestRenderProfile(t *testing.T) {
+	kubeClient := k8sfake.NewSimpleClientset(testObjects()...)
+	files, err := cluster.RenderProfile(context.Background(), kubeClient, "argocd", "arlon", "c1",
+		clustertesting.RepoUrl, "arlon", "dev")
+	if err != nil {
+		t.Fatalf("render failed: %s", err)
+	}
+	for _, name := range []string{
+		"arlon/c1/mgmt/templates/guestbook.yaml",
+		"arlon/c1/mgmt/templates/nginx.yaml",
+		"arlon/c1/workload/guestbook/guestbook.yaml",
+	} {
+		if files[name] == nil {
+			t.Errorf("expected %s to be rendered", name)
+		}
+	}
+	if len(files) != 3 {
+		t.Errorf("expected only the profile's files, got %d", len(files))
+	}
+}
+
+func TestRedeployToGit(t *testing.T) {
+	kubeClient := k8sfake.NewSimpleClientset(testObjects()...)
+	server := fake.NewServer()
+	server.CreateBranch(clustertesting.RepoUrl, "main", nil)
+	_, err := cluster.DeployToGit(context.Background(), kubeClient, server.NewRepo(), "argocd", "arlon", "c1",
+		clustertesting.RepoUrl, "main", "arlon", "dev", "eks", "")
+	if err != nil {
+		t.Fatalf("deploy failed: %s", err)
+	}
+	configMaps := kubeClient.CoreV1().ConfigMaps("arlon")
+	profile, err := configMaps.Get(context.Background(), "dev", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile.Data["bundles"] = "nginx"
+	_, err = configMaps.Update(context.Background(), profile, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cluster.DeployToGit(context.Background(), kubeClient, server.NewRepo(), "argocd", "arlon", "c1",
+		clustertesting.RepoUrl, "main", "arlon", "dev", "eks", "")
+	if err != nil {
+		t.Fatalf("redeploy failed: %s", err)
+	}
+	commits := server.Commits(clustertesting.RepoUrl, "main")
+	if last := commits[len(commits)-1]; commitSubject(last) != "update arlon manifests for cluster c1" {
+		t.Errorf("expected an update commit, got %q", last.Message)
+	}
+	files := server.Files(clustertesting.RepoUrl, "main")
+	for _, name := range []string{
+		"arlon/c1/mgmt/templates/guestbook.yaml",
+		"arlon/c1/workload/guestbook/guestbook.yaml",
+	} {
+		if files[name] != nil {
+			t.Errorf("expected %s of the removed bundle to be pruned", name)
+		}
+	}
+	if files["arlon/c1/mgmt/templates/nginx.yaml"] == nil {
+		t.Errorf("expected the application of the remaining bundle to be kept")
+	}
+}
+
+// commitSubject returns the first line of a commit's message.
+func commitSubject(commit fake.Commit) string {
+	return strings.SplitN(commit.Message, "\n", 2)[0]
+}
+
+func TestDeployManyToGit(t *testing.T) {
+	kubeClient := k8sfake.NewSimpleClientset(testObjects()...)
+	server := fake.NewServer()
+	server.CreateBranch(clustertesting.RepoUrl, "main", nil)
+
+	_, err := cluster.DeployManyToGit(context.Background(), kubeClient, server.NewRepo(), "argocd", "arlon",
+		clustertesting.RepoUrl, "main", []cluster.Deployment{
+			{ClusterName: "c1", BasePath: "arlon", ProfileName: "dev", ClusterSpecName: "eks"},
+			{ClusterName: "c2", BasePath: "arlon", ProfileName: "dev"},
+		}, "")
+	if err != nil {
+		t.Fatalf("deploy failed: %s", err)
+	}
+	commits := server.Commits(clustertesting.RepoUrl, "main")
+	if len(commits) != 2 || commitSubject(commits[1]) != "add arlon manifests for clusters c1, c2" {
+		t.Fatalf("expected a single commit for both clusters, got %v", commits)
+	}
+	files := server.Files(clustertesting.RepoUrl, "main")
+	for _, name := range []string{"arlon/c1/arlon-cluster.yaml", "arlon/c2/arlon-cluster.yaml"} {
+		if files[name] == nil {
+			t.Errorf("expected %s to be pushed", name)
+		}
+	}
+}
+
+func TestConstructRootAppHelmValues(t *testing.T) {
+	ctx := context.Background()
+	kubeClient := k8sfake.NewSimpleClientset(testObjects()...)
+	spec, _ := kubeClient.CoreV1().ConfigMaps("arlon").Get(ctx, "eks", metav1.GetOptions{})
+	values := "tags:\n  team: platform\n"
+	spec.Data["helmValues"] = values
+	_, _ = kubeClient.CoreV1().ConfigMaps("arlon").Update(ctx, spec, metav1.UpdateOptions{})
+	rootApp, err := cluster.ConstructRootApp(ctx, kubeClient, "argocd", "arlon", "c1",
+		clustertesting.RepoUrl, "main", "arlon", "eks", "dev", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rootApp.Spec.Source.Helm.Values != values {
+		t.Errorf("expected helm values %q, got %q", values, rootApp.Spec.Source.Helm.Values)
+	}
+
+	spec.Data["helmValues"] = "- not a map"
+	_, _ = kubeClient.CoreV1().ConfigMaps("arlon").Update(ctx, spec, metav1.UpdateOptions{})
+	_, err = cluster.ConstructRootApp(ctx, kubeClient, "argocd", "arlon", "c1",
+		clustertesting.RepoUrl, "main", "arlon", "eks", "dev", "", "")
+	if err == nil || !strings.Contains(err.Error(), "invalid helmValues") {
+		t.Errorf("expected invalid helmValues error, got %v", err)
+	}
+}
+
+func TestPathPolicy(t *testing.T) {
+	policy := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: cluster.PathPolicyConfigMapName, Namespace: "arlon"},
+		Data: map[string]string{"policy": `
+- subjects: ["system:serviceaccount:team-a:deployer"]
+  paths: [teams/a]
+- subjects: ["*"]
+  paths: [sandbox]
+`},
+	}
+	kubeClient := k8sfake.NewSimpleClientset(append(testObjects(), policy)...)
+	server := fake.NewServer()
+	server.CreateBranch(clustertesting.RepoUrl, "main", nil)
+	ctx := cluster.WithActor(context.Background(), "system:serviceaccount:team-a:deployer")
+	_, err := cluster.DeployToGit(ctx, kubeClient, server.NewRepo(), "argocd", "arlon", "c1",
+		clustertesting.RepoUrl, "main", "teams/a", "dev", "eks", "")
+	if err != nil {
+		t.Fatalf("deploy to an allowed path failed: %s", err)
+	}
+	_, err = cluster.DeployToGit(ctx, kubeClient, server.NewRepo(), "argocd", "arlon", "c1",
+		clustertesting.RepoUrl, "main", "teams/ab", "dev", "eks", "")
+	if err == nil || !strings.Contains(err.Error(), "not allowed to write to teams/ab/c1") {
+		t.Errorf("expected path policy error, got %v", err)
+	}
+	_, err = cluster.DeployToGit(cluster.WithActor(context.Background(), "bob"), kubeClient, server.NewRepo(),
+		"argocd", "arlon", "c1", clustertesting.RepoUrl, "main", "sandbox", "dev", "eks", "")
+	if err != nil {
+		t.Errorf("deploy to a path allowed to everyone failed: %s", err)
+	}
+}
+
+func TestProfileBundleSelector(t *testing.T) {
+	ctx := context.Background()
+	kubeClient := k8sfake.NewSimpleClientset(testObjects()...)
+	for _, name := range []string{"nginx", "guestbook"} {
+		secr, _ := kubeClient.CoreV1().Secrets("arlon").Get(ctx, name, metav1.GetOptions{})
+		secr.Labels["managed-by"] = "arlon"
+		if name == "nginx" {
+			secr.Labels["tier"] = "web"
+		}
+		_, _ = kubeClient.CoreV1().Secrets("arlon").Update(ctx, secr, metav1.UpdateOptions{})
+	}
+	profile, _ := kubeClient.CoreV1().ConfigMaps("arlon").Get(ctx, "dev", metav1.GetOptions{})
+	profile.Data["bundles"] = ""
+	profile.Data[bundle.BundleSelectorKey] = "tier=web"
+	_, _ = kubeClient.CoreV1().ConfigMaps("arlon").Update(ctx, profile, metav1.UpdateOptions{})
+	server := fake.NewServer()
+	server.CreateBranch(clustertesting.RepoUrl, "main", nil)
+	_, err := cluster.DeployToGit(ctx, kubeClient, server.NewRepo(), "argocd", "arlon", "c1",
+		clustertesting.RepoUrl, "main", "arlon", "dev", "eks", "")
+	if err != nil {
+		t.Fatalf("deploy failed: %s", err)
+	}
+	files := server.Files(clustertesting.RepoUrl, "main")
+	if files["arlon/c1/mgmt/templates/nginx.yaml"] == nil {
+		t.Errorf("expected the selected nginx bundle to be deployed")
+	}
+	if files["arlon/c1/mgmt/templates/guestbook.yaml"] != nil {
+		t.Errorf("expected the guestbook bundle not to be selected")
+	}
+}
+
+func TestSetProfilePrunesOrphans(t *testing.T) {
+	kubeClient := k8sfake.NewSimpleClientset(testObjects()...)
+	server := fake.NewServer()
+	server.CreateBranch(clustertesting.RepoUrl, "main", nil)
+	_, err := cluster.DeployToGit(context.Background(), kubeClient, server.NewRepo(), "argocd", "arlon", "c1",
+		clustertesting.RepoUrl, "main", "arlon", "dev", "eks", "")
+	if err != nil {
+		t.Fatalf("deploy failed: %s", err)
+	}
+	// files of a bundle the cluster summary doesn't know about
+	repo := server.NewRepo()
+	if err := repo.Clone(context.Background(), clustertesting.RepoUrl, "main", nil); err != nil {
+		t.Fatal(err)
+	}
+	orphanApp := "apiVersion: argoproj.io/v1alpha1\nkind: Application\nmetadata:\n  name: c1-old\n"
+	_ = util.WriteFile(repo.Worktree(), "arlon/c1/mgmt/templates/old.yaml", []byte(orphanApp), 0644)
+	_ = util.WriteFile(repo.Worktree(), "arlon/c1/workload/old/old.yaml", []byte("kind: ConfigMap\n"), 0644)
+	if _, err := repo.Commit("add orphan bundle"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Push(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = cluster.SetProfile(context.Background(), kubeClient, server.NewRepo(), fakeAppClient{},
+		"argocd", "arlon", "c1", "dev")
+	if err != nil {
+		t.Fatalf("attach failed: %s", err)
+	}
+	files := server.Files(clustertesting.RepoUrl, "main")
+	for _, name := range []string{
+		"arlon/c1/mgmt/templates/old.yaml",
+		"arlon/c1/workload/old/old.yaml",
+	} {
+		if files[name] != nil {
+			t.Errorf("expected orphaned %s to be pruned", name)
+		}
+	}
+	for _, name := range []string{
+		"arlon/c1/mgmt/templates/cluster.yaml",
+		"arlon/c1/mgmt/templates/nginx.yaml",
+		"arlon/c1/workload/guestbook/guestbook.yaml",
+	} {
+		if files[name] == nil {
+			t.Errorf("expected %s to be kept", name)
+		}
+	}
+}
+
+func TestResolveDestinationServer(t *testing.T) {
+	ctx := context.Background()
+	clusterSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "cluster-prod-1",
+			Namespace: "argocd",
+			Labels:    map[string]string{"argocd.argoproj.io/secret-type": "cluster", "env": "prod"},
+		},
+		Data: map[string][]byte{"server": []byte("https://prod-1.example.com")},
+	}
+	kubeClient := k8sfake.NewSimpleClientset(append(testObjects(), clusterSecret)...)
+	server, err := cluster.ResolveDestinationServer(ctx, kubeClient.CoreV1(), "argocd", "", "env=prod")
+	if err != nil || server != "https://prod-1.example.com" {
+		t.Errorf("expected the server of the selected cluster, got %q, %v", server, err)
+	}
+	_, err = cluster.ResolveDestinationServer(ctx, kubeClient.CoreV1(), "argocd", "", "env=staging")
+	if err == nil || !strings.Contains(err.Error(), "matches 0 argocd clusters") {
+		t.Errorf("expected no matching cluster error, got %v", err)
+	}
+	rootApp, err := cluster.ConstructRootApp(ctx, kubeClient, "argocd", "arlon", "c1",
+		clustertesting.RepoUrl, "main", "arlon", "eks", "dev", "", server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, param := range rootApp.Spec.Source.Helm.Parameters {
+		found = found || (param.Name == cluster.DestinationServerParam && param.Value == server)
+	}
+	if !found {
+		t.Errorf("expected the root application to set %s", cluster.DestinationServerParam)
+	}
+}
+
+func TestBundleSyncPolicy(t *testing.T) {
+	objects := testObjects()
+	guestbook := objects[3].(*corev1.Secret)
+	guestbook.Annotations = map[string]string{
+		bundle.SyncOptionsAnnotation:    "ServerSideApply,Replace=true",
+		bundle.SyncRetryLimitAnnotation: "5",
+	}
+	kubeClient := k8sfake.NewSimpleClientset(objects...)
+	server := fake.NewServer()
+	server.CreateBranch(clustertesting.RepoUrl, "main", nil)
+	_, err := cluster.DeployToGit(context.Background(), kubeClient, server.NewRepo(), "argocd", "arlon", "c1",
+		clustertesting.RepoUrl, "main", "arlon", "dev", "eks", "")
+	if err != nil {
+		t.Fatalf("deploy failed: %s", err)
+	}
+	app := string(server.Files(clustertesting.RepoUrl, "main")["arlon/c1/mgmt/templates/guestbook.yaml"])
+	for _, expected := range []string{
+		"    syncOptions:\n    - ServerSideApply=true\n    - Replace=true\n",
+		"    retry:\n      limit: 5\n      backoff:\n        duration: 5s\n        factor: 2\n",
+	} {
+		if !strings.Contains(app, expected) {
+			t.Errorf("expected the guestbook application to contain:\n%s\ngot:\n%s", expected, app)
+		}
+	}
+	guestbook.Annotations[bundle.SyncOptionsAnnotation] = "Force=true"
+	kubeClient = k8sfake.NewSimpleClientset(objects...)
+	_, err = cluster.DeployToGit(context.Background(), kubeClient, server.NewRepo(), "argocd", "arlon", "c1",
+		clustertesting.RepoUrl, "main", "arlon", "dev", "eks", "")
+	if err == nil || !strings.Contains(err.Error(), "unsupported sync option Force") {
+		t.Errorf("expected an unsupported sync option error, got %v", err)
+	}
+}
+
+// listAppClient lists the given root applications.
+type listAppClient struct {
+	applicationpkg.ApplicationServiceClient
+	apps []argoappv1.Application
+}
+
+func (c listAppClient) List(
+	_ context.Context,
+	_ *applicationpkg.ApplicationQuery,
+	_ ...grpc.CallOption,
+) (*argoappv1.ApplicationList, error) {
+	return &argoappv1.ApplicationList{Items: c.apps}, nil
+}
+
+func TestBundleHashes(t *testing.T) {
+	ctx := context.Background()
+	objects := testObjects()
+	guestbook := objects[3].(*corev1.Secret)
+	hash := bundle.ContentHash(guestbook)
+	kubeClient := k8sfake.NewSimpleClientset(objects...)
+	server := fake.NewServer()
+	server.CreateBranch(clustertesting.RepoUrl, "main", nil)
+	_, err := cluster.DeployToGit(ctx, kubeClient, server.NewRepo(), "argocd", "arlon", "c1",
+		clustertesting.RepoUrl, "main", "arlon", "dev", "eks", "")
+	if err != nil {
+		t.Fatalf("deploy failed: %s", err)
+	}
+	app := string(server.Files(clustertesting.RepoUrl, "main")["arlon/c1/mgmt/templates/guestbook.yaml"])
+	if !strings.Contains(app, "  annotations:\n    arlon.io/bundle-hash: "+hash+"\n") {
+		t.Errorf("expected the guestbook application to record its hash, got:\n%s", app)
+	}
+	commits := server.Commits(clustertesting.RepoUrl, "main")
+	if msg := commits[len(commits)-1].Message; !strings.Contains(msg, "\nbundle c1/guestbook: "+hash) {
+		t.Errorf("expected the commit message to record the hash of guestbook, got %q", msg)
+	}
+
+	rootApp := func(name string) argoappv1.Application {
+		return argoappv1.Application{ObjectMeta: metav1.ObjectMeta{Name: name,
+			Labels: map[string]string{"managed-by": "arlon", "arlon-type": "cluster"}}}
+	}
+	bundleApp := func(name string, hash string) argoappv1.Application {
+		return argoappv1.Application{ObjectMeta: metav1.ObjectMeta{Name: name,
+			Annotations: map[string]string{cluster.BundleHashAnnotation: hash}}}
+	}
+	appIf := listAppClient{apps: []argoappv1.Application{
+		rootApp("c1"), bundleApp("c1-guestbook", hash),
+		rootApp("c2"), bundleApp("c2-guestbook", "0ld"),
+		rootApp("c3"), bundleApp("c3-nginx", "n"),
+	}}
+	usages, err := cluster.BundleWhereUsed(ctx, kubeClient, appIf, "argocd", "arlon", "guestbook")
+	if err != nil {
+		t.Fatalf("where-used failed: %s", err)
+	}
+	if len(usages) != 2 || usages[0].ClusterName != "c1" || usages[0].Changed() ||
+		usages[1].ClusterName != "c2" || !usages[1].Changed() {
+		t.Errorf("expected guestbook to be current in c1 and changed in c2, got %v", usages)
+	}
+}
+
+func TestBundleProfiles(t *testing.T) {
+	profile := func(ns string, name string, data map[string]string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: ns,
+				Labels: map[string]string{"managed-by": "arlon", "arlon-type": "profile"}},
+			Data: data,
+		}
+	}
+	objects := append(testObjects(),
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "redis", Namespace: "team-a",
+				Labels: map[string]string{"managed-by": "arlon", "arlon-type": "config-bundle",
+					"bundle-type": "inline", "tier": "cache"}},
+			Data: map[string][]byte{"data": []byte("kind: ConfigMap\n")},
+		},
+		profile("team-a", "caches", map[string]string{bundle.BundleSelectorKey: "tier=cache"}),
+		profile("team-a", "shared", map[string]string{"bundles": "arlon/guestbook"}),
+		profile("team-b", "other", map[string]string{"bundles": "guestbook"}),
+	)
+	kubeClient := k8sfake.NewSimpleClientset(objects...)
+	for bundleName, expected := range map[string][]string{
+		"guestbook":    {"arlon/dev", "team-a/shared"},
+		"team-a/redis": {"team-a/caches"},
+	} {
+		profiles, err := cluster.BundleProfiles(context.Background(), kubeClient, "argocd", "arlon", bundleName)
+		if err != nil {
+			t.Fatalf("failed to list profiles of %s: %s", bundleName, err)
+		}
+		if !reflect.DeepEqual(profiles, expected) {
+			t.Errorf("expected %s to be used by %v, got %v", bundleName, expected, profiles)
+		}
+	}
+}
+
+func TestClusterSpecImpact(t *testing.T) {
+	ctx := context.Background()
+	objects := append(testObjects(),
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "eks-large", Namespace: "arlon"},
+			Data:       map[string]string{"baseSpec": "eks", "nodeCount": "10"},
+		},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "arlon"},
+			Data:       map[string]string{"region": "eu-west-1"},
+		})
+	kubeClient := k8sfake.NewSimpleClientset(objects...)
+	var apps []argoappv1.Application
+	for clusterName, specName := range map[string]string{"c1": "eks", "c2": "eks-large", "c3": "other"} {
+		app, err := cluster.ConstructRootApp(ctx, kubeClient, "argocd", "arlon", clusterName,
+			clustertesting.RepoUrl, "main", "arlon", specName, "", "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		apps = append(apps, *app)
+	}
+	proposed := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "eks"},
+		Data: map[string]string{"region": "us-west-2", "nodeCount": "3",
+			"podCidrBlock": "10.1.0.0/16"},
+	}
+	specChanges, impacts, err := cluster.ClusterSpecImpact(ctx, kubeClient, listAppClient{apps: apps},
+		"arlon", proposed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []cluster.Change{
+		{Name: "nodeCount", Old: "2", New: "3"},
+		{Name: "podCidrBlock", New: "10.1.0.0/16", Disruptive: true},
+	}
+	if len(specChanges) != 2 || specChanges[0] != expected[0] || specChanges[1] != expected[1] {
+		t.Errorf("expected changes %v, got %v", expected, specChanges)
+	}
+	// c2 keeps its own node count, c3 doesn't use the spec
+	if len(impacts) != 2 || impacts[0].ClusterName != "c1" || impacts[1].ClusterName != "c2" ||
+		len(impacts[0].Changes) != 2 || len(impacts[1].Changes) != 1 ||
+		impacts[1].Changes[0] != expected[1] {
+		t.Errorf("expected c1 and c2 to change, got %v", impacts)
+	}
+}
+
+func TestClusterSpecDependents(t *testing.T) {
+	ctx := context.Background()
+	objects := append(testObjects(),
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "eks-large", Namespace: "arlon",
+				Labels: map[string]string{"managed-by": "arlon", "arlon-type": "clusterspec"}},
+			Data: map[string]string{"baseSpec": "eks", "nodeCount": "10"},
+		})
+	kubeClient := k8sfake.NewSimpleClientset(objects...)
+	var apps []runtime.Object
+	appIf := clustertesting.NewAppClient()
+	for _, clusterName := range []string{"c2", "c1"} {
+		app, err := cluster.ConstructRootApp(ctx, kubeClient, "argocd", "arlon", clusterName,
+			clustertesting.RepoUrl, "main", "arlon", "eks", "", "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		apps = append(apps, app)
+		if _, err := appIf.Create(ctx, &applicationpkg.ApplicationCreateRequest{Application: *app}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	appClient := appfake.NewSimpleClientset(apps...).ArgoprojV1alpha1().Applications("argocd")
+	sessions := 0
+	newAppIf := func() (io.Closer, applicationpkg.ApplicationServiceClient) {
+		sessions++
+		return io.NopCloser(nil), appIf
+	}
+	clusters, clusterSpecs, err := cluster.ClusterSpecDependents(ctx, kubeClient, appClient, "arlon", "eks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(clusters, []string{"c1", "c2"}) ||
+		!reflect.DeepEqual(clusterSpecs, []string{"arlon/eks-large"}) {
+		t.Errorf("expected clusters c1, c2 and clusterspec arlon/eks-large, got %v and %v",
+			clusters, clusterSpecs)
+	}
+	// derived clusterspecs are never cascaded
+	_, err = cluster.DeleteClusterSpec(ctx, kubeClient, appClient, newAppIf, "arlon", "eks", true)
+	var inUse *cluster.InUseError
+	if !errors.As(err, &inUse) || len(inUse.ClusterSpecs) != 1 {
+		t.Errorf("expected an in use error, got %v", err)
+	}
+	_, err = cluster.DeleteClusterSpec(ctx, kubeClient, appClient, newAppIf, "arlon", "eks-large", false)
+	if err != nil {
+		t.Fatalf("failed to delete unused clusterspec: %s", err)
+	}
+	_, err = kubeClient.CoreV1().ConfigMaps("arlon").Get(ctx, "eks-large", metav1.GetOptions{})
+	if !apierr.IsNotFound(err) {
+		t.Errorf("expected eks-large to be deleted, got %v", err)
+	}
+	if sessions != 0 {
+		t.Errorf("expected no ArgoCD session without cascading, got %d", sessions)
+	}
+	updated, err := cluster.DeleteClusterSpec(ctx, kubeClient, appClient, newAppIf, "arlon", "eks", true)
+	if err != nil {
+		t.Fatalf("failed to delete clusterspec with cascade: %s", err)
+	}
+	if !reflect.DeepEqual(updated, []string{"c1", "c2"}) || sessions != 1 {
+		t.Errorf("expected c1 and c2 to be updated in one session, got %v in %d", updated, sessions)
+	}
+	for _, app := range appIf.Apps() {
+		if _, ok := app.Labels[cluster.ClusterSpecLabel]; ok {
+			t.Errorf("expected the clusterspec to be removed from %s, got labels %v", app.Name, app.Labels)
+		}
+	}
+}
+
+func TestPostProcessors(t *testing.T) {
+	ctx := context.Background()
+	objects := append(testObjects(), &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: cluster.PostProcessorsConfigMapName, Namespace: "arlon"},
+		Data: map[string]string{"processors": `
+- name: team-label
+  clusters: ["c*"]
+  kinds: [ConfigMap]
+  patch: |
+    metadata:
+      labels:
+        team: platform
+- name: uppercase
+  clusters: ["other"]
+  command: [tr, a-z, A-Z]
+- name: environment
+  clusters: ["c2"]
+  command: [env]
+`},
+	})
+	kubeClient := k8sfake.NewSimpleClientset(objects...)
+	server := fake.NewServer()
+	server.CreateBranch(clustertesting.RepoUrl, "main", nil)
+	_, err := cluster.DeployToGit(ctx, kubeClient, server.NewRepo(), "argocd", "arlon", "c1",
+		clustertesting.RepoUrl, "main", "arlon", "dev", "eks", "")
+	if err != nil {
+		t.Fatalf("deploy failed: %s", err)
+	}
+	files := server.Files(clustertesting.RepoUrl, "main")
+	expected := "kind: ConfigMap\nmetadata:\n  labels:\n    team: platform\n"
+	if data := string(files["arlon/c1/workload/guestbook/guestbook.yaml"]); data != expected {
+		t.Errorf("expected patched bundle %q, got %q", expected, data)
+	}
+	_, err = cluster.DeployToGit(ctx, kubeClient, server.NewRepo(), "argocd", "arlon", "c2",
+		clustertesting.RepoUrl, "main", "arlon", "dev", "eks", "")
+	if err == nil || !strings.Contains(err.Error(), "--allow-command-processors") {
+		t.Errorf("expected the command processor to require an opt-in, got %v", err)
+	}
+	os.Setenv("ARLON_TEST_SECRET", "secret")
+	defer os.Unsetenv("ARLON_TEST_SECRET")
+	_, err = cluster.DeployToGit(cluster.WithCommandProcessors(ctx), kubeClient, server.NewRepo(), "argocd", "arlon", "c2",
+		clustertesting.RepoUrl, "main", "arlon", "dev", "eks", "")
+	if err != nil {
+		t.Fatalf("deploy with command processors failed: %s", err)
+	}
+	env := string(server.Files(clustertesting.RepoUrl, "main")["arlon/c2/workload/guestbook/guestbook.yaml"])
+	if !strings.Contains(env, "ARLON_CLUSTER=c2\n") || strings.Contains(env, "ARLON_TEST_SECRET") {
+		t.Errorf("expected the command to only get a minimal environment, got %q", env)
+	}
+	_, err = cluster.ParsePostProcessors("- name: both\n  patch: 'a: b'\n  command: [cat]\n")
+	if err == nil {
+		t.Error("expected a processor with a patch and a command to be invalid")
+	}
+}
+
+func TestRegoPolicies(t *testing.T) {
+	ctx := context.Background()
+	objects := append(testObjects(), &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: cluster.RegoPoliciesConfigMapName, Namespace: "arlon"},
+		Data: map[string]string{"deny.rego": `package arlon
+
+deny[msg] {
+	file := input.files[_]
+	startswith(file.path, "workload/")
+	file.objects[_].kind == "ConfigMap"
+	msg := sprintf("%s: configmaps are not allowed in cluster %s", [file.path, input.cluster])
+}
+`},
+	})
+	kubeClient := k8sfake.NewSimpleClientset(objects...)
+	server := fake.NewServer()
+	server.CreateBranch(clustertesting.RepoUrl, "main", nil)
+	commits := len(server.Commits(clustertesting.RepoUrl, "main"))
+	_, err := cluster.DeployToGit(ctx, kubeClient, server.NewRepo(), "argocd", "arlon", "c1",
+		clustertesting.RepoUrl, "main", "arlon", "dev", "eks", "")
+	var violation *cluster.PolicyViolationError
+	if !errors.As(err, &violation) || violation.ClusterName != "c1" ||
+		!reflect.DeepEqual(violation.Violations,
+			[]string{"workload/guestbook/guestbook.yaml: configmaps are not allowed in cluster c1"}) {
+		t.Fatalf("expected a policy violation, got %v", err)
+	}
+	if len(server.Commits(clustertesting.RepoUrl, "main")) != commits {
+		t.Error("expected nothing to be pushed")
+	}
+	_, err = cluster.DeployToGit(ctx, kubeClient, server.NewRepo(), "argocd", "arlon", "c1",
+		clustertesting.RepoUrl, "main", "arlon", "", "eks", "")
+	if err != nil {
+		t.Errorf("expected a cluster without bundles to pass, got %s", err)
+	}
+}
+
+func TestPerBundleCommits(t *testing.T) {
+	kubeClient := k8sfake.NewSimpleClientset(testObjects()...)
+	server := fake.NewServer()
+	server.CreateBranch(clustertesting.RepoUrl, "main", nil)
+	ctx := gitutils.WithCommitStrategy(context.Background(), gitutils.CommitPerBundle)
+
+	_, err := cluster.DeployToGit(ctx, kubeClient, server.NewRepo(), "argocd", "arlon", "c1",
+		clustertesting.RepoUrl, "main", "arlon", "dev", "eks", "")
+	if err != nil {
+		t.Fatalf("deploy failed: %s", err)
+	}
+	expected := []string{
+		"add bundle guestbook to cluster c1",
+		"add bundle nginx to cluster c1",
+		"add arlon manifests",
+	}
+	commits := server.Commits(clustertesting.RepoUrl, "main")[1:]
+	if len(commits) != len(expected) {
+		t.Fatalf("expected %d commits, got %v", len(expected), commits)
+	}
+	for i, msg := range expected {
+		if commitSubject(commits[i]) != msg {
+			t.Errorf("expected commit %d to be %q, got %q", i, msg, commits[i].Message)
+		}
+	}
+	if files := commits[0].Files; files["arlon/c1/workload/guestbook/guestbook.yaml"] == nil ||
+		files["arlon/c1/mgmt/templates/nginx.yaml"] != nil || files["arlon/c1/mgmt/Chart.yaml"] != nil {
+		t.Errorf("expected the first commit to only hold the guestbook bundle")
+	}
+
+	configMaps := kubeClient.CoreV1().ConfigMaps("arlon")
+	profile, err := configMaps.Get(ctx, "dev", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	profile.Data["bundles"] = "nginx"
+	_, err = configMaps.Update(ctx, profile, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cluster.DeployToGit(ctx, kubeClient, server.NewRepo(), "argocd", "arlon", "c1",
+		clustertesting.RepoUrl, "main", "arlon", "dev", "eks", "")
+	if err != nil {
+		t.Fatalf("redeploy failed: %s", err)
+	}
+	commits = server.Commits(clustertesting.RepoUrl, "main")[4:]
+	if len(commits) != 2 || commitSubject(commits[0]) != "remove bundle guestbook from cluster c1" ||
+		commitSubject(commits[1]) != "update arlon manifests for cluster c1" {
+		t.Errorf("expected the removed bundle to be committed separately, got %v", commits)
+	}
+
+	_, err = cluster.DeployToGit(gitutils.WithCommitStrategy(context.Background(), "per-file"), kubeClient,
+		server.NewRepo(), "argocd", "arlon", "c1", clustertesting.RepoUrl, "main", "arlon", "dev", "eks", "")
+	if err == nil {
+		t.Errorf("expected an invalid commit strategy to be refused")
+	}
+}
+
+func TestExportBundle(t *testing.T) {
+	kubeClient := k8sfake.NewSimpleClientset(testObjects()...)
+	server := fake.NewServer()
+	server.CreateBranch(clustertesting.RepoUrl, "main", nil)
+	ctx := context.Background()
+
+	sha, err := cluster.ExportBundle(ctx, kubeClient, server.NewRepo(), "argocd", "arlon", "guestbook",
+		clustertesting.RepoUrl, "main", "bundles/guestbook", "")
+	if err != nil {
+		t.Fatalf("export failed: %s", err)
+	}
+	if sha == "" {
+		t.Fatalf("expected a pushed commit")
+	}
+	files := server.Files(clustertesting.RepoUrl, "main")
+	if string(files["bundles/guestbook/guestbook.yaml"]) != "kind: ConfigMap\n" {
+		t.Errorf("expected the bundle's manifests to be pushed, got %v", files)
+	}
+	secr, err := kubeClient.CoreV1().Secrets("arlon").Get(ctx, "guestbook", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secr.Labels["bundle-type"] != "reference" || secr.Annotations["repo-path"] != "bundles/guestbook" ||
+		secr.Annotations["repo-revision"] != "main" || secr.Data["data"] != nil {
+		t.Errorf("expected the bundle to be converted to a reference bundle, got %v", secr)
+	}
+
+	_, err = cluster.ExportBundle(ctx, kubeClient, server.NewRepo(), "argocd", "arlon", "guestbook",
+		clustertesting.RepoUrl, "main", "bundles/guestbook", "")
+	if err == nil {
+		t.Errorf("expected exporting a reference bundle to fail")
+	}
+	_, err = cluster.DeployToGit(ctx, kubeClient, server.NewRepo(), "argocd", "arlon", "c1",
+		clustertesting.RepoUrl, "main", "arlon", "dev", "eks", "")
+	if err != nil {
+		t.Fatalf("deploy failed: %s", err)
+	}
+	files = server.Files(clustertesting.RepoUrl, "main")
+	if files["arlon/c1/workload/guestbook/guestbook.yaml"] != nil ||
+		!strings.Contains(string(files["arlon/c1/mgmt/templates/guestbook.yaml"]), "path: bundles/guestbook") {
+		t.Errorf("expected the exported bundle to be deployed from git")
+	}
+}
+
+// storedAppClient serves a single root application, which updates replace.
+type storedAppClient struct {
+	applicationpkg.ApplicationServiceClient
+	app *argoappv1.Application
+}
+
+func (c *storedAppClient) Get(
+	_ context.Context,
+	query *applicationpkg.ApplicationQuery,
+	_ ...grpc.CallOption,
+) (*argoappv1.Application, error) {
+	if *query.Name != c.app.Name {
+		return nil, errors.New("not found")
+	}
+	return c.app.DeepCopy(), nil
+}
+
+func (c *storedAppClient) Update(
+	_ context.Context,
+	req *applicationpkg.ApplicationUpdateRequest,
+	_ ...grpc.CallOption,
+) (*argoappv1.Application, error) {
+	c.app = req.Application.DeepCopy()
+	return req.Application, nil
+}
+
+func TestClusterLabels(t *testing.T) {
+	ctx := context.Background()
+	kubeClient := k8sfake.NewSimpleClientset(testObjects()...)
+	rootApp, err := cluster.ConstructRootApp(ctx, kubeClient, "argocd", "arlon", "c1",
+		clustertesting.RepoUrl, "main", "arlon", "eks", "dev", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cluster.SetClusterLabels(rootApp, map[string]string{"arlon-profile": "prod"}); err == nil {
+		t.Errorf("expected a reserved label to be refused")
+	}
+	if err := cluster.SetClusterLabels(rootApp, map[string]string{"env": "staging"}); err != nil {
+		t.Fatal(err)
+	}
+	appIf := &storedAppClient{app: rootApp.DeepCopy()}
+	err = cluster.LabelCluster(ctx, appIf, "c1", map[string]string{"region": "eu"}, []string{"env"})
+	if err != nil {
+		t.Fatalf("labeling failed: %s", err)
+	}
+	if appIf.app.Labels["region"] != "eu" || appIf.app.Labels["env"] != "" ||
+		appIf.app.Labels[cluster.ProfileLabel] != "dev" {
+		t.Errorf("unexpected labels %v", appIf.app.Labels)
+	}
+
+	// redeploying keeps the labels set since
+	rootApp.Labels[cluster.ProfileLabel] = "prod"
+	_, err = cluster.ApplyRootApp(ctx, kubeClient, appIf, rootApp, "")
+	if err != nil {
+		t.Fatalf("redeploy failed: %s", err)
+	}
+	expected := map[string]string{"env": "staging", "region": "eu"}
+	if labels := cluster.ClusterLabels(appIf.app); !reflect.DeepEqual(labels, expected) ||
+		appIf.app.Labels[cluster.ProfileLabel] != "prod" {
+		t.Errorf("expected cluster labels %v to be kept, got %v", expected, appIf.app.Labels)
+	}
+
+	selector, err := cluster.ProfileSelector("arlon", "dev", "env in (staging,prod)")
+	if err != nil || selector != cluster.RootAppSelector+",arlon-profile=dev,!arlon-profile-namespace,env in (staging,prod)" {
+		t.Errorf("unexpected profile selector %q (%v)", selector, err)
+	}
+	if _, err := cluster.ClusterSelector("env in staging"); err == nil {
+		t.Errorf("expected an invalid selector to be refused")
+	}
+}
+
+func TestSyncWindows(t *testing.T) {
+	kubeClient := k8sfake.NewSimpleClientset(testObjects()...)
+	server := fake.NewServer()
+	server.CreateBranch(clustertesting.RepoUrl, "main", nil)
+	ctx := context.Background()
+	_, err := cluster.DeployToGit(ctx, kubeClient, server.NewRepo(), "argocd", "arlon", "c1",
+		clustertesting.RepoUrl, "main", "arlon", "dev", "eks", "")
+	if err != nil {
+		t.Fatalf("deploy failed: %s", err)
+	}
+	configMaps := kubeClient.CoreV1().ConfigMaps("arlon")
+	profile, err := configMaps.Get(ctx, "dev", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a deny window opening every minute for an hour is always open
+	profile.Data["bundles"] = "nginx"
+	profile.Data[cluster.SyncWindowsKey] = `
+- kind: deny
+  schedule: "* * * * *"
+  duration: 1h
+  clusters: ["c*"]
+`
+	_, err = configMaps.Update(ctx, profile, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cluster.DeployToGit(ctx, kubeClient, server.NewRepo(), "argocd", "arlon", "c1",
+		clustertesting.RepoUrl, "main", "arlon", "dev", "eks", "")
+	var windowErr *cluster.SyncWindowClosedError
+	if !errors.As(err, &windowErr) || len(server.Commits(clustertesting.RepoUrl, "main")) != 2 {
+		t.Fatalf("expected the redeploy to be refused, got %v", err)
+	}
+	// new clusters aren't restricted
+	_, err = cluster.DeployToGit(ctx, kubeClient, server.NewRepo(), "argocd", "arlon", "c2",
+		clustertesting.RepoUrl, "main", "arlon", "dev", "eks", "")
+	if err != nil {
+		t.Fatalf("deploy of a new cluster failed: %s", err)
+	}
+	_, _, err = cluster.SyncProfile(ctx, kubeClient, server.NewRepo(), fakeAppClient{},
+		"argocd", "arlon", "c1", "dev", false)
+	if !errors.As(err, &windowErr) {
+		t.Errorf("expected the profile sync to be refused, got %v", err)
+	}
+	_, _, err = cluster.SyncProfile(cluster.WithIgnoreSyncWindows(ctx), kubeClient, server.NewRepo(), fakeAppClient{},
+		"argocd", "arlon", "c1", "dev", false)
+	if err != nil {
+		t.Errorf("expected ignoring the windows to sync the profile, got %s", err)
+	}
+
+	if _, err := cluster.ParseSyncWindows("- kind: allow\n  schedule: never\n  duration: 1h\n"); err == nil {
+		t.Errorf("expected an invalid schedule to be refused")
+	}
+}
+
+func TestDeployVerifiesSignatures(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifests := "kind: ConfigMap\n"
+	signed := clustertesting.InlineBundle(clustertesting.ArlonNs, "signed", manifests)
+	signed.Data[bundle.SignatureKey] = []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(key, []byte(manifests))))
+	h := clustertesting.New(t,
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: bundle.TrustedKeysConfigMapName, Namespace: clustertesting.ArlonNs},
+			Data:       map[string]string{"cosign.pub": string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))},
+		},
+		signed,
+		clustertesting.InlineBundle(clustertesting.ArlonNs, "unsigned", manifests),
+		clustertesting.Profile(clustertesting.ArlonNs, "signed", "signed"),
+		clustertesting.Profile(clustertesting.ArlonNs, "unsigned", "signed", "unsigned"),
+	)
+	if h.Deploy("c1", "signed", "") == "" {
+		t.Error("expected the signed bundle to be deployed")
+	}
+	_, err = cluster.DeployToGit(context.Background(), h.KubeClient, h.Git.NewRepo(), clustertesting.ArgocdNs,
+		clustertesting.ArlonNs, "c2", clustertesting.RepoUrl, clustertesting.RepoBranch, clustertesting.BasePath,
+		"unsigned", "", "")
+	if err == nil || !strings.Contains(err.Error(), "refusing to render bundle unsigned: bundle is not signed") {
+		t.Errorf("expected the unsigned bundle to be refused, got %v", err)
+	}
+}
+
+func TestDeployNamespacedBundles(t *testing.T) {
+	h := clustertesting.New(t,
+		clustertesting.InlineBundle(clustertesting.ArlonNs, "guestbook", "kind: ConfigMap\n"),
+		clustertesting.InlineBundle("team-a", "web", "kind: Service\n"),
+		// unqualified bundles are in the namespace of the profile
+		clustertesting.Profile("team-a", "dev", "web", "arlon/guestbook"),
+	)
+	h.Deploy("c1", "team-a/dev", "")
+	files := h.ClusterFiles("c1")
+	if string(files["workload/web/web.yaml"]) != "kind: Service\n" ||
+		string(files["workload/guestbook/guestbook.yaml"]) != "kind: ConfigMap\n" {
+		t.Errorf("expected the bundles of both namespaces to be rendered, got %v", files)
+	}
+	summary := string(files[cluster.SummaryFileName])
+	for _, expected := range []string{"profile: team-a/dev", "name: web\n  namespace: team-a", "name: guestbook\n  namespace: arlon"} {
+		if !strings.Contains(summary, expected) {
+			t.Errorf("expected the summary to hold %q, got:\n%s", expected, summary)
+		}
+	}
+	labels := h.RootApp("c1").Labels
+	if labels["arlon-profile"] != "dev" || labels["arlon-profile-namespace"] != "team-a" {
+		t.Errorf("unexpected profile labels %v", labels)
+	}
+}
@@ -0,0 +1,88 @@
+package cluster
+
+import (
+	"arlon.io/arlon/pkg/kuberetry"
+	"context"
+	"fmt"
+	corev1api "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"os"
+)
+
+// kubeconfigSecretSuffixes lists, in order of preference, the suffixes of the
+// secrets in which Cluster API providers store a workload cluster's kubeconfig.
+// The EKS provider also generates a user kubeconfig which, unlike the default
+// one, doesn't embed a short-lived token.
+var kubeconfigSecretSuffixes = []string{"-user-kubeconfig", "-kubeconfig"}
+
+// GetKubeconfig returns the kubeconfig of a provisioned workload cluster.
+// Cluster API keeps it in a secret in the cluster's namespace, which the
+// cluster chart names after the cluster.
+func GetKubeconfig(ctx context.Context, kubeClient kubernetes.Interface, clusterName string) ([]byte, error) {
+	secretsApi := kubeClient.CoreV1().Secrets(clusterName)
+	for _, suffix := range kubeconfigSecretSuffixes {
+		secretName := clusterName + suffix
+		var secret *corev1api.Secret
+		err := kuberetry.OnTransient(ctx, func() (err error) {
+			secret, err = secretsApi.Get(ctx, secretName, metav1.GetOptions{})
+			return
+		})
+		if apierr.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get secret %s: %w", secretName, err)
+		}
+		data := secret.Data["value"]
+		if data == nil {
+			return nil, fmt.Errorf("secret %s has no kubeconfig value", secretName)
+		}
+		return data, nil
+	}
+	return nil, fmt.Errorf("no kubeconfig found for cluster %s (is it provisioned yet?)", clusterName)
+}
+
+// MergeKubeconfig merges a workload cluster's kubeconfig into the kubeconfig
+// file at configPath. Its cluster, user and context entries are renamed after
+// the cluster so they don't collide with existing entries.
+func MergeKubeconfig(data []byte, clusterName string, configPath string, setCurrent bool) error {
+	newConfig, err := clientcmd.Load(data)
+	if err != nil {
+		return fmt.Errorf("failed to parse kubeconfig: %w", err)
+	}
+	config, err := clientcmd.LoadFromFile(configPath)
+	if os.IsNotExist(err) {
+		config, err = clientcmdapi.NewConfig(), nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load kubeconfig file %s: %w", configPath, err)
+	}
+	currentCtx := newConfig.Contexts[newConfig.CurrentContext]
+	if currentCtx == nil {
+		return fmt.Errorf("kubeconfig has no current context")
+	}
+	cluster := newConfig.Clusters[currentCtx.Cluster]
+	authInfo := newConfig.AuthInfos[currentCtx.AuthInfo]
+	if cluster == nil || authInfo == nil {
+		return fmt.Errorf("kubeconfig's current context is incomplete")
+	}
+	userName := clusterName + "-admin"
+	config.Clusters[clusterName] = cluster
+	config.AuthInfos[userName] = authInfo
+	config.Contexts[clusterName] = &clientcmdapi.Context{
+		Cluster:  clusterName,
+		AuthInfo: userName,
+	}
+	if setCurrent {
+		config.CurrentContext = clusterName
+	}
+	err = clientcmd.WriteToFile(*config, configPath)
+	if err != nil {
+		return fmt.Errorf("failed to write kubeconfig file %s: %w", configPath, err)
+	}
+	return nil
+}
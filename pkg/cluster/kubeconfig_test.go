@@ -0,0 +1,95 @@
+package cluster_test
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"arlon.io/arlon/pkg/cluster"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+func newKubeconfig(t *testing.T, server string, token string) []byte {
+	config := clientcmdapi.NewConfig()
+	config.Clusters["capi"] = &clientcmdapi.Cluster{Server: server}
+	config.AuthInfos["capi-admin"] = &clientcmdapi.AuthInfo{Token: token}
+	config.Contexts["capi-admin@capi"] = &clientcmdapi.Context{Cluster: "capi", AuthInfo: "capi-admin"}
+	config.CurrentContext = "capi-admin@capi"
+	data, err := clientcmd.Write(*config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func kubeconfigSecret(name string, data []byte) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "c1"},
+		Data:       map[string][]byte{"value": data},
+	}
+}
+
+func TestGetKubeconfig(t *testing.T) {
+	ctx := context.Background()
+	kubeconfig := newKubeconfig(t, "https://c1.example.com", "short-lived")
+	userKubeconfig := newKubeconfig(t, "https://c1.example.com", "user")
+	kubeClient := k8sfake.NewSimpleClientset(kubeconfigSecret("c1-kubeconfig", kubeconfig))
+	data, err := cluster.GetKubeconfig(ctx, kubeClient, "c1")
+	if err != nil || string(data) != string(kubeconfig) {
+		t.Errorf("expected the kubeconfig of the cluster, got %q (%v)", data, err)
+	}
+	// the user kubeconfig of the EKS provider is preferred
+	kubeClient = k8sfake.NewSimpleClientset(kubeconfigSecret("c1-kubeconfig", kubeconfig),
+		kubeconfigSecret("c1-user-kubeconfig", userKubeconfig))
+	data, err = cluster.GetKubeconfig(ctx, kubeClient, "c1")
+	if err != nil || string(data) != string(userKubeconfig) {
+		t.Errorf("expected the user kubeconfig of the cluster, got %q (%v)", data, err)
+	}
+	_, err = cluster.GetKubeconfig(ctx, k8sfake.NewSimpleClientset(), "c1")
+	if err == nil || !strings.Contains(err.Error(), "is it provisioned yet?") {
+		t.Errorf("expected an unprovisioned cluster to have no kubeconfig, got %v", err)
+	}
+}
+
+func TestMergeKubeconfig(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config")
+	existing := clientcmdapi.NewConfig()
+	existing.Clusters["mgmt"] = &clientcmdapi.Cluster{Server: "https://mgmt.example.com"}
+	existing.AuthInfos["mgmt"] = &clientcmdapi.AuthInfo{Token: "mgmt"}
+	existing.Contexts["mgmt"] = &clientcmdapi.Context{Cluster: "mgmt", AuthInfo: "mgmt"}
+	existing.CurrentContext = "mgmt"
+	if err := clientcmd.WriteToFile(*existing, configPath); err != nil {
+		t.Fatal(err)
+	}
+	data := newKubeconfig(t, "https://c1.example.com", "c1")
+	if err := cluster.MergeKubeconfig(data, "c1", configPath, false); err != nil {
+		t.Fatal(err)
+	}
+	config, err := clientcmd.LoadFromFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.CurrentContext != "mgmt" || config.Contexts["mgmt"] == nil {
+		t.Errorf("expected the existing entries to be kept, got %v", config)
+	}
+	// entries are renamed after the cluster
+	ctx := config.Contexts["c1"]
+	if ctx == nil || ctx.Cluster != "c1" || ctx.AuthInfo != "c1-admin" ||
+		config.Clusters["c1"].Server != "https://c1.example.com" || config.AuthInfos["c1-admin"].Token != "c1" {
+		t.Errorf("expected the cluster's entries to be merged, got %v", config)
+	}
+	if err := cluster.MergeKubeconfig(data, "c1", configPath, true); err != nil {
+		t.Fatal(err)
+	}
+	if config, err = clientcmd.LoadFromFile(configPath); err != nil || config.CurrentContext != "c1" {
+		t.Errorf("expected the cluster's context to be made current, got %v (%v)", config, err)
+	}
+	if err := cluster.MergeKubeconfig([]byte("kind: Config\n"), "c2", configPath, false); err == nil {
+		t.Error("expected a kubeconfig without a current context to be rejected")
+	}
+}
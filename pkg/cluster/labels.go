@@ -0,0 +1,122 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	applicationpkg "github.com/argoproj/argo-cd/v2/pkg/apiclient/application"
+	argoappv1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"strings"
+)
+
+// Cluster labels are free form labels of root applications, for e.g.
+// env=staging, that group clusters so that fleet commands can select them.
+// The labels arlon sets on root applications to record a cluster's type,
+// clusterspec and profile are reserved.
+
+// RootAppSelector selects the root applications of arlon clusters.
+const RootAppSelector = "managed-by=arlon,arlon-type=cluster"
+
+// isReservedLabel returns whether arlon sets the label on root applications.
+func isReservedLabel(key string) bool {
+	return key == "managed-by" || key == "arlon-type" || strings.HasPrefix(key, "arlon-")
+}
+
+// CheckClusterLabels returns an error if labels can't be set on a cluster,
+// because they are invalid or reserved by arlon.
+func CheckClusterLabels(clusterLabels map[string]string) error {
+	for key, val := range clusterLabels {
+		if isReservedLabel(key) {
+			return fmt.Errorf("label %s is reserved", key)
+		}
+		if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+			return fmt.Errorf("invalid label key %s: %s", key, strings.Join(errs, ", "))
+		}
+		if errs := validation.IsValidLabelValue(val); len(errs) > 0 {
+			return fmt.Errorf("invalid value of label %s: %s", key, strings.Join(errs, ", "))
+		}
+	}
+	return nil
+}
+
+// SetClusterLabels adds cluster labels to a root application, such as one
+// returned by ConstructRootApp.
+func SetClusterLabels(app *argoappv1.Application, clusterLabels map[string]string) error {
+	if err := CheckClusterLabels(clusterLabels); err != nil {
+		return err
+	}
+	if app.Labels == nil && len(clusterLabels) > 0 {
+		app.Labels = make(map[string]string)
+	}
+	for key, val := range clusterLabels {
+		app.Labels[key] = val
+	}
+	return nil
+}
+
+// ClusterLabels returns the cluster labels of a root application.
+func ClusterLabels(app *argoappv1.Application) map[string]string {
+	clusterLabels := make(map[string]string)
+	for key, val := range app.Labels {
+		if !isReservedLabel(key) {
+			clusterLabels[key] = val
+		}
+	}
+	return clusterLabels
+}
+
+// ClusterSelector returns the selector of the root applications of the
+// clusters whose labels match selector, or of all clusters if it is empty.
+// The selector is validated, so that a typo doesn't silently select nothing.
+func ClusterSelector(selector string) (string, error) {
+	return restrictSelector(RootAppSelector, selector)
+}
+
+// restrictSelector returns base narrowed by the user given selector.
+func restrictSelector(base string, selector string) (string, error) {
+	if strings.TrimSpace(selector) == "" {
+		return base, nil
+	}
+	if _, err := labels.Parse(selector); err != nil {
+		return "", fmt.Errorf("invalid cluster selector %s: %w", selector, err)
+	}
+	return base + "," + selector, nil
+}
+
+// LabelCluster sets and removes cluster labels of a deployed cluster's root
+// application.
+func LabelCluster(
+	ctx context.Context,
+	appIf applicationpkg.ApplicationServiceClient,
+	clusterName string,
+	set map[string]string,
+	remove []string,
+) error {
+	if err := CheckClusterLabels(set); err != nil {
+		return err
+	}
+	for _, key := range remove {
+		if isReservedLabel(key) {
+			return fmt.Errorf("label %s is reserved", key)
+		}
+	}
+	app, err := appIf.Get(ctx, &applicationpkg.ApplicationQuery{Name: &clusterName})
+	if err != nil {
+		return fmt.Errorf("failed to get root application %s: %w", clusterName, err)
+	}
+	if app.Labels["managed-by"] != "arlon" || app.Labels["arlon-type"] != "cluster" {
+		return fmt.Errorf("application %s isn't the root application of an arlon cluster", clusterName)
+	}
+	for _, key := range remove {
+		delete(app.Labels, key)
+	}
+	for key, val := range set {
+		app.Labels[key] = val
+	}
+	_, err = appIf.Update(ctx, &applicationpkg.ApplicationUpdateRequest{Application: app})
+	if err != nil {
+		return fmt.Errorf("failed to update root application %s: %w", clusterName, err)
+	}
+	return nil
+}
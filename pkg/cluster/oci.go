@@ -0,0 +1,115 @@
+package cluster
+
+import (
+	"arlon.io/arlon/pkg/bundle"
+	"arlon.io/arlon/pkg/kuberetry"
+	"arlon.io/arlon/pkg/log"
+	"context"
+	"fmt"
+	corev1api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1types "k8s.io/client-go/kubernetes/typed/core/v1"
+	"strings"
+)
+
+// clusterChartKey is the clusterspec key that sources the cluster chart from
+// an OCI registry, as oci://registry/path/chart:version, instead of the chart
+// embedded in arlon.
+const clusterChartKey = "clusterChart"
+
+// clusterChartAppName names the application deploying a cluster chart
+// sourced from an OCI registry, in place of the embedded chart's templates.
+const clusterChartAppName = "cluster-chart"
+
+// forwardValues passes the mgmt chart's values, which the root application
+// sets from the clusterspec, on to the cluster chart. It is rendered by Helm
+// when ArgoCD syncs the root application, not by arlon.
+const forwardValues = "{{- toYaml .Values | nindent 8 }}"
+
+// clusterChartApp returns the settings of the application deploying the
+// cluster chart named by the clusterspec, or nil if it uses the embedded one.
+func clusterChartApp(specData map[string]string) (*AppSettings, error) {
+	ref := specData[clusterChartKey]
+	if ref == "" {
+		return nil, nil
+	}
+	repoUrl, chart, version, err := bundle.ParseOCIChart(ref)
+	if err != nil {
+		return nil, err
+	}
+	return &AppSettings{
+		BundleName:           clusterChartAppName,
+		DestinationServer:    "https://kubernetes.default.svc",
+		DestinationNamespace: "default",
+		RepoUrl:              repoUrl,
+		Chart:                chart,
+		TargetRevision:       version,
+		HelmValues:           forwardValues,
+	}, nil
+}
+
+// getOCIRepoCreds returns the credentials of the ArgoCD Helm repository
+// registered for an OCI registry. ArgoCD can only pull charts from OCI
+// registries registered with enableOCI, either as a repository matching
+// repoUrl or as a credentials template whose url prefixes it.
+func getOCIRepoCreds(
+	ctx context.Context,
+	corev1 corev1types.CoreV1Interface,
+	argocdNs string,
+	repoUrl string,
+) (*RepoCreds, error) {
+	url := bundle.ArgoRepoURL(repoUrl)
+	for _, secretType := range []string{"repository", "repo-creds"} {
+		var secrets *corev1api.SecretList
+		err := kuberetry.OnTransient(ctx, func() (err error) {
+			secrets, err = corev1.Secrets(argocdNs).List(ctx, metav1.ListOptions{
+				LabelSelector: "argocd.argoproj.io/secret-type=" + secretType,
+			})
+			return
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list secrets: %w", err)
+		}
+		for _, repoSecret := range secrets.Items {
+			if string(repoSecret.Data["type"]) != "helm" || string(repoSecret.Data["enableOCI"]) != "true" {
+				continue
+			}
+			secretUrl := bundle.ArgoRepoURL(string(repoSecret.Data["url"]))
+			if secretUrl != url && (secretType == "repository" ||
+				!strings.HasPrefix(url, strings.TrimSuffix(secretUrl, "/")+"/")) {
+				continue
+			}
+			log.AddSecret(string(repoSecret.Data["password"]))
+			return &RepoCreds{
+				Url:      secretUrl,
+				Username: string(repoSecret.Data["username"]),
+				Password: string(repoSecret.Data["password"]),
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("%w with enableOCI matching %s (did you register it?)", ErrRepoCredsNotFound, url)
+}
+
+// checkOCIRepos fails unless every OCI registry the clusters' applications
+// pull charts from is registered in ArgoCD, so that a deployment doesn't
+// commit applications that can never sync.
+func checkOCIRepos(
+	ctx context.Context,
+	corev1 corev1types.CoreV1Interface,
+	argocdNs string,
+	trees []*clusterTree,
+) error {
+	checked := make(map[string]bool)
+	for _, tree := range trees {
+		for _, app := range tree.apps() {
+			if !bundle.IsOCI(app.RepoUrl) || checked[app.RepoUrl] {
+				continue
+			}
+			if _, err := getOCIRepoCreds(ctx, corev1, argocdNs, app.RepoUrl); err != nil {
+				return fmt.Errorf("cluster %s: %w", tree.clusterName, err)
+			}
+			checked[app.RepoUrl] = true
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package cluster
+
+import (
+	"arlon.io/arlon/pkg/kuberetry"
+	"context"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	corev1api "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1types "k8s.io/client-go/kubernetes/typed/core/v1"
+	"path"
+	"strings"
+)
+
+// PathPolicyConfigMapName names the ConfigMap in the arlon namespace that
+// restricts the repository paths each user may write cluster directories to.
+// Its "policy" key holds a list of PathRule.
+const PathPolicyConfigMapName = "arlon-path-policy"
+
+// PathRule allows its subjects, which are user names such as
+// system:serviceaccount:team-a:deployer or "*" for every user, to write the
+// directories of clusters below its paths.
+type PathRule struct {
+	Subjects []string `yaml:"subjects"`
+	Paths    []string `yaml:"paths"`
+}
+
+// checkPathPolicy returns an error unless the actor of ctx may write name
+// below basePath, which is the directory of a cluster or the file of an
+// exported bundle. Without a policy ConfigMap, any path may be written.
+func checkPathPolicy(
+	ctx context.Context,
+	corev1 corev1types.CoreV1Interface,
+	arlonNs string,
+	basePath string,
+	name string,
+) error {
+	var cm *corev1api.ConfigMap
+	err := kuberetry.OnTransient(ctx, func() (err error) {
+		cm, err = corev1.ConfigMaps(arlonNs).Get(ctx, PathPolicyConfigMapName, metav1.GetOptions{})
+		return
+	})
+	if apierr.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get path policy configmap: %w", err)
+	}
+	var rules []PathRule
+	if err := yaml.UnmarshalStrict([]byte(cm.Data["policy"]), &rules); err != nil {
+		return fmt.Errorf("failed to parse path policy: %w", err)
+	}
+	actor := Actor(ctx)
+	clusterPath := path.Clean(strings.Trim(path.Join(basePath, name), "/"))
+	for _, rule := range rules {
+		if !hasSubject(rule.Subjects, actor) {
+			continue
+		}
+		for _, p := range rule.Paths {
+			p = path.Clean(strings.Trim(p, "/"))
+			if clusterPath == p || strings.HasPrefix(clusterPath, p+"/") {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%s is not allowed to write to %s by the %s configmap",
+		actor, clusterPath, PathPolicyConfigMapName)
+}
+
+func hasSubject(subjects []string, actor string) bool {
+	for _, subject := range subjects {
+		if subject == "*" || subject == actor {
+			return true
+		}
+	}
+	return false
+}
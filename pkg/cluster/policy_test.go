@@ -0,0 +1,72 @@
+package cluster_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"arlon.io/arlon/pkg/cluster"
+	clustertesting "arlon.io/arlon/pkg/cluster/testing"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TestPathPolicyOnEveryCommit checks that the commands changing a deployed
+// cluster's directory are refused to users the path policy doesn't allow,
+// before anything is pushed or deleted.
+func TestPathPolicyOnEveryCommit(t *testing.T) {
+	h, _ := newRollbackHarness(t)
+	_, err := h.KubeClient.CoreV1().ConfigMaps(clustertesting.ArlonNs).Create(context.Background(),
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: cluster.PathPolicyConfigMapName, Namespace: clustertesting.ArlonNs},
+			Data: map[string]string{"policy": `
+- subjects: [alice]
+  paths: [clusters]
+- subjects: [bob]
+  paths: [clusters/c1]
+`},
+		}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	commits := len(h.Git.Commits(clustertesting.RepoUrl, clustertesting.RepoBranch))
+	rollback := func(ctx context.Context) error {
+		_, _, err := cluster.Rollback(ctx, h.KubeClient, h.Git.NewRepo(), h.Apps,
+			clustertesting.ArgocdNs, clustertesting.ArlonNs, "c1", false)
+		return err
+	}
+	rename := func(ctx context.Context) error {
+		_, err := cluster.Rename(ctx, h.KubeClient, h.Git.NewRepo(), h.Apps,
+			clustertesting.ArgocdNs, clustertesting.ArlonNs, "c1", "c2")
+		return err
+	}
+	remove := func(ctx context.Context) error {
+		_, err := cluster.Delete(ctx, h.KubeClient, h.Git.NewRepo(), h.Apps,
+			clustertesting.ArgocdNs, clustertesting.ArlonNs, "c1")
+		return err
+	}
+	for _, tc := range []struct {
+		name  string
+		actor string
+		run   func(ctx context.Context) error
+	}{
+		{"rollback", "carol", rollback},
+		{"rename", "carol", rename},
+		// bob may write c1's directory, but not c2's
+		{"rename", "bob", rename},
+		{"delete", "carol", remove},
+	} {
+		err := tc.run(cluster.WithActor(context.Background(), tc.actor))
+		if err == nil || !strings.Contains(err.Error(), "is not allowed to write to clusters/c") {
+			t.Errorf("%s by %s: expected a path policy error, got %v", tc.name, tc.actor, err)
+		}
+	}
+	if actual := len(h.Git.Commits(clustertesting.RepoUrl, clustertesting.RepoBranch)); actual != commits {
+		t.Errorf("expected no commit to be pushed, got %d new ones", actual-commits)
+	}
+	// fails the test if the root application was deleted
+	h.RootApp("c1")
+	if err := remove(cluster.WithActor(context.Background(), "alice")); err != nil {
+		t.Errorf("delete by an allowed user failed: %s", err)
+	}
+}
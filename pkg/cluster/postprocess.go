@@ -0,0 +1,315 @@
+package cluster
+
+import (
+	"arlon.io/arlon/pkg/kuberetry"
+	"bytes"
+	"context"
+	"fmt"
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+	"gopkg.in/yaml.v2"
+	corev1api "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1types "k8s.io/client-go/kubernetes/typed/core/v1"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	sigsyaml "sigs.k8s.io/yaml"
+	"strings"
+)
+
+// PostProcessorsConfigMapName names the ConfigMap in the arlon namespace
+// listing the post-processors applied to the files rendered for a cluster
+// before they are committed. Its "processors" key holds a list of
+// PostProcessor, which are applied in order.
+const PostProcessorsConfigMapName = "arlon-post-processors"
+
+// PostProcessor transforms the rendered files of the clusters whose name
+// matches one of its Clusters glob patterns, or of all clusters if there are
+// none. Files are glob patterns of the paths of the files to transform,
+// relative to the cluster's directory, a pattern matching a directory
+// matching the files below it. They default to the workload directory, which
+// holds the manifests of inline bundles, since the mgmt directory holds the
+// templates of a Helm chart.
+//
+// A processor either merges Patch, a YAML merge patch as in RFC 7386, into
+// the documents of the files whose kind is one of Kinds, or any kind if
+// there are none, or runs Command with each file on its standard input and
+// replaces the file with its standard output. Since anyone able to write the
+// ConfigMap could run commands wherever arlon deploys, commands only run
+// under a context returned by WithCommandProcessors, and rendering fails
+// otherwise. The command's environment only holds PATH, the cluster name in
+// ARLON_CLUSTER and the file's path in ARLON_FILE.
+type PostProcessor struct {
+	Name     string   `yaml:"name"`
+	Clusters []string `yaml:"clusters,omitempty"`
+	Files    []string `yaml:"files,omitempty"`
+	Kinds    []string `yaml:"kinds,omitempty"`
+	Patch    string   `yaml:"patch,omitempty"`
+	Command  []string `yaml:"command,omitempty"`
+}
+
+type commandProcessorsKey struct{}
+
+// WithCommandProcessors returns a context under which the post-processors
+// running a command are allowed, as opted into locally by the user running
+// arlon.
+func WithCommandProcessors(ctx context.Context) context.Context {
+	return context.WithValue(ctx, commandProcessorsKey{}, true)
+}
+
+func commandProcessorsAllowed(ctx context.Context) bool {
+	allowed, _ := ctx.Value(commandProcessorsKey{}).(bool)
+	return allowed
+}
+
+// ParsePostProcessors parses and validates a list of post-processors.
+func ParsePostProcessors(data string) ([]PostProcessor, error) {
+	var processors []PostProcessor
+	if err := yaml.UnmarshalStrict([]byte(data), &processors); err != nil {
+		return nil, fmt.Errorf("failed to parse post-processors: %w", err)
+	}
+	for i, p := range processors {
+		if p.Name == "" {
+			return nil, fmt.Errorf("post-processor %d has no name", i)
+		}
+		if (p.Patch == "") == (len(p.Command) == 0) {
+			return nil, fmt.Errorf("post-processor %s must have either a patch or a command", p.Name)
+		}
+		if p.Patch != "" {
+			if _, err := sigsyaml.YAMLToJSON([]byte(p.Patch)); err != nil {
+				return nil, fmt.Errorf("invalid patch of post-processor %s: %w", p.Name, err)
+			}
+		} else if len(p.Kinds) > 0 {
+			return nil, fmt.Errorf("post-processor %s selects kinds without a patch", p.Name)
+		}
+		for _, pattern := range append(append([]string{}, p.Clusters...), p.Files...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid pattern %s of post-processor %s: %w", pattern, p.Name, err)
+			}
+		}
+	}
+	return processors, nil
+}
+
+// getPostProcessors returns the post-processors applying to a cluster. There
+// are none without the post-processors ConfigMap.
+func getPostProcessors(
+	ctx context.Context,
+	corev1 corev1types.CoreV1Interface,
+	arlonNs string,
+	clusterName string,
+) ([]PostProcessor, error) {
+	var cm *corev1api.ConfigMap
+	err := kuberetry.OnTransient(ctx, func() (err error) {
+		cm, err = corev1.ConfigMaps(arlonNs).Get(ctx, PostProcessorsConfigMapName, metav1.GetOptions{})
+		return
+	})
+	if apierr.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get post-processors configmap: %w", err)
+	}
+	processors, err := ParsePostProcessors(cm.Data["processors"])
+	if err != nil {
+		return nil, err
+	}
+	var applied []PostProcessor
+	for _, p := range processors {
+		if p.appliesTo(clusterName) {
+			applied = append(applied, p)
+		}
+	}
+	return applied, nil
+}
+
+func (p *PostProcessor) appliesTo(clusterName string) bool {
+	if len(p.Clusters) == 0 {
+		return true
+	}
+	for _, pattern := range p.Clusters {
+		if ok, _ := path.Match(pattern, clusterName); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// matches returns whether the processor transforms the file at relPath,
+// relative to the cluster's directory.
+func (p *PostProcessor) matches(relPath string) bool {
+	patterns := p.Files
+	if len(patterns) == 0 {
+		patterns = []string{"workload"}
+	}
+	for _, pattern := range patterns {
+		pattern = path.Clean(strings.Trim(pattern, "/"))
+		// a pattern matching one of the file's directories matches the file
+		for dir := relPath; dir != "."; dir = path.Dir(dir) {
+			if ok, _ := path.Match(pattern, dir); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// postProcess applies the processors, in order, to the files of the cluster
+// at clusterPath, which were just rendered.
+func postProcess(
+	ctx context.Context,
+	fsys billy.Filesystem,
+	clusterName string,
+	clusterPath string,
+	files []string,
+	processors []PostProcessor,
+) error {
+	for _, p := range processors {
+		if len(p.Command) > 0 && !commandProcessorsAllowed(ctx) {
+			return fmt.Errorf("post-processor %s runs a command, which is only allowed with "+
+				"--allow-command-processors", p.Name)
+		}
+		for _, filePath := range files {
+			relPath := strings.TrimPrefix(filePath, clusterPath+"/")
+			if !p.matches(relPath) {
+				continue
+			}
+			data, err := util.ReadFile(fsys, filePath)
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", filePath, err)
+			}
+			var processed []byte
+			if p.Patch != "" {
+				processed, err = p.patch(data)
+			} else {
+				processed, err = p.run(ctx, clusterName, relPath, data)
+			}
+			if err != nil {
+				return fmt.Errorf("post-processor %s failed on %s: %w", p.Name, relPath, err)
+			}
+			if processed != nil && !bytes.Equal(processed, data) {
+				if err := writeFile(fsys, filePath, processed); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// renderedFiles returns the paths of the files of the mgmt and workload
+// directories of the cluster at clusterPath, or, if bundleNames are given,
+// only of those of the bundles.
+func renderedFiles(fsys billy.Filesystem, clusterPath string, bundleNames ...string) ([]string, error) {
+	var files []string
+	if len(bundleNames) == 0 {
+		for _, dir := range []string{"mgmt", "workload"} {
+			err := listFiles(fsys, path.Join(clusterPath, dir), &files)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return files, nil
+	}
+	for _, name := range bundleNames {
+		appPath := path.Join(clusterPath, "mgmt", "templates", name+".yaml")
+		if _, err := fsys.Stat(appPath); err == nil {
+			files = append(files, appPath)
+		}
+		err := listFiles(fsys, path.Join(clusterPath, "workload", name), &files)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// listFiles appends the paths of the files below dir to files, in lexical
+// order. A missing dir has no files.
+func listFiles(fsys billy.Filesystem, dir string, files *[]string) error {
+	infos, err := fsys.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read directory %s: %w", dir, err)
+	}
+	for _, info := range infos {
+		p := path.Join(dir, info.Name())
+		if info.IsDir() {
+			if err := listFiles(fsys, p, files); err != nil {
+				return err
+			}
+		} else {
+			*files = append(*files, p)
+		}
+	}
+	return nil
+}
+
+var documentSeparator = regexp.MustCompile(`(?m)^---[ \t]*$`)
+
+// patch merges the processor's patch into the documents of a YAML file whose
+// kind it selects. It returns nil if it selects none of them, leaving the
+// file as is rather than reformatting it.
+func (p *PostProcessor) patch(data []byte) ([]byte, error) {
+	patch, err := sigsyaml.YAMLToJSON([]byte(p.Patch))
+	if err != nil {
+		return nil, err
+	}
+	docs := documentSeparator.Split(string(data), -1)
+	patched := false
+	for i, doc := range docs {
+		var meta metav1.TypeMeta
+		if err := sigsyaml.Unmarshal([]byte(doc), &meta); err != nil {
+			return nil, fmt.Errorf("failed to parse document %d: %w", i, err)
+		}
+		if meta.Kind == "" || (len(p.Kinds) > 0 && !hasString(p.Kinds, meta.Kind)) {
+			continue
+		}
+		obj, err := sigsyaml.YAMLToJSON([]byte(doc))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse document %d: %w", i, err)
+		}
+		obj, err = jsonpatch.MergePatch(obj, patch)
+		if err != nil {
+			return nil, fmt.Errorf("failed to patch document %d: %w", i, err)
+		}
+		out, err := sigsyaml.JSONToYAML(obj)
+		if err != nil {
+			return nil, err
+		}
+		// the separator's newline is kept with the previous document
+		docs[i] = string(out)
+		if i > 0 {
+			docs[i] = "\n" + docs[i]
+		}
+		patched = true
+	}
+	if !patched {
+		return nil, nil
+	}
+	return []byte(strings.Join(docs, "---")), nil
+}
+
+// run runs the processor's command on a file and returns its output.
+func (p *PostProcessor) run(ctx context.Context, clusterName string, relPath string, data []byte) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, p.Command[0], p.Command[1:]...)
+	// the command doesn't get the credentials arlon's environment may hold
+	cmd.Env = []string{"PATH=" + os.Getenv("PATH"), "ARLON_CLUSTER=" + clusterName, "ARLON_FILE=" + relPath}
+	cmd.Stdin = bytes.NewReader(data)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
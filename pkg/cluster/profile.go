@@ -0,0 +1,255 @@
+package cluster
+
+import (
+	"arlon.io/arlon/pkg/bundle"
+	"arlon.io/arlon/pkg/gitutils"
+	"arlon.io/arlon/pkg/log"
+	"arlon.io/arlon/pkg/progress"
+	"context"
+	"fmt"
+	applicationpkg "github.com/argoproj/argo-cd/v2/pkg/apiclient/application"
+	corev1api "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"path"
+	"reflect"
+)
+
+// SetProfile replaces the profile of a deployed cluster, or removes it if
+// profileName is empty. Only the profile's bundle directories and generated
+// applications are rewritten: the mgmt chart and the clusterspec bundles are
+// left as deployed. The cluster's summary records the bundles to remove, so
+// clusters deployed before summaries existed must be redeployed first.
+// It returns the hash of the pushed commit, empty if nothing changed.
+func SetProfile(
+	ctx context.Context,
+	kubeClient kubernetes.Interface,
+	repo gitutils.GitRepo,
+	appIf applicationpkg.ApplicationServiceClient,
+	argocdNs string,
+	arlonNs string,
+	clusterName string,
+	profileName string,
+) (commitSha string, err error) {
+	commitSha, _, err = setProfile(ctx, kubeClient, repo, appIf, argocdNs, arlonNs,
+		clusterName, profileName, false, false)
+	return
+}
+
+// SyncProfile renders the current bundles of the profile of a deployed
+// cluster again, picking up the changes made to the profile since the
+// cluster was deployed. In dry-run mode the changes are committed to the
+// local clone only, to tell whether the cluster is up to date.
+// It returns the hash of the pushed commit, empty if nothing changed or in
+// dry-run mode, and whether the git tree of the cluster changed.
+func SyncProfile(
+	ctx context.Context,
+	kubeClient kubernetes.Interface,
+	repo gitutils.GitRepo,
+	appIf applicationpkg.ApplicationServiceClient,
+	argocdNs string,
+	arlonNs string,
+	clusterName string,
+	profileName string,
+	dryRun bool,
+) (commitSha string, changed bool, err error) {
+	return setProfile(ctx, kubeClient, repo, appIf, argocdNs, arlonNs,
+		clusterName, profileName, true, dryRun)
+}
+
+func setProfile(
+	ctx context.Context,
+	kubeClient kubernetes.Interface,
+	repo gitutils.GitRepo,
+	appIf applicationpkg.ApplicationServiceClient,
+	argocdNs string,
+	arlonNs string,
+	clusterName string,
+	profileName string,
+	sync bool,
+	dryRun bool,
+) (commitSha string, changed bool, err error) {
+	log := log.GetLogger()
+	corev1 := kubeClient.CoreV1()
+	rootApp, err := appIf.Get(ctx,
+		&applicationpkg.ApplicationQuery{Name: &clusterName})
+	if err != nil {
+		return "", false, fmt.Errorf("failed to get root application %s: %w", clusterName, err)
+	}
+	repoUrl, repoBranch, basePath := rootAppSource(rootApp)
+	err = checkPathPolicy(ctx, corev1, arlonNs, basePath, clusterName)
+	if err != nil {
+		return "", false, err
+	}
+	creds, err := getRepoCreds(ctx, corev1, argocdNs, repoUrl)
+	if err != nil {
+		return "", false, err
+	}
+	st, err := loadStore(ctx, corev1, argocdNs, arlonNs)
+	if err != nil {
+		return "", false, err
+	}
+	progress.Step(ctx, "reading profile %s", profileName)
+	inlineBundles, refBundles, err := getProfileBundles(ctx, profileName, st, corev1, arlonNs)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to get profile bundles: %w", err)
+	}
+	profileBundles := profileBundleSummaries(inlineBundles, refBundles)
+	progress.Step(ctx, "cloning %s (branch %s)", repoUrl, repoBranch)
+	err = repo.Clone(ctx, repoUrl, repoBranch, creds.auth())
+	if err != nil {
+		return "", false, err
+	}
+	wt := repo.Worktree()
+	summary, err := ReadSummary(wt, basePath, clusterName)
+	if err != nil {
+		return "", false, err
+	}
+	if summary == nil {
+		return "", false, fmt.Errorf("cluster %s has no %s summary, deploy it again first",
+			clusterName, SummaryFileName)
+	}
+	if sync && !sameRef(summary.Profile, profileName, arlonNs) {
+		return "", false, fmt.Errorf("cluster %s is deployed with profile %s, not %s",
+			clusterName, summary.Profile, profileName)
+	}
+	clusterPath := path.Join(basePath, clusterName)
+	mgmtPath := path.Join(clusterPath, "mgmt")
+	workloadPath := path.Join(clusterPath, "workload")
+	progress.Step(ctx, "rendering bundles of cluster %s", clusterName)
+	// remove the bundles of the current profile, and any left behind by
+	// earlier ones, keeping clusterspec ones
+	specBundles := make(map[string]bool)
+	var bundles []BundleSummary
+	previousBundles := summary.Bundles
+	for _, b := range summary.Bundles {
+		if b.Type == "chart" {
+			specBundles[b.Name] = true
+			bundles = append(bundles, b)
+		}
+	}
+	pruned, err := pruneBundles(wt, clusterName, mgmtPath, workloadPath, specBundles)
+	if err != nil {
+		return "", false, err
+	}
+	log.V(1).Info("removed bundle files", "paths", pruned)
+	for _, b := range profileBundles {
+		if specBundles[b.Name] {
+			return "", false, fmt.Errorf("bundle %s has the same name as a clusterspec bundle", b.Name)
+		}
+	}
+	err = copyInlineBundles(wt, clusterName, repoUrl, mgmtPath, workloadPath, inlineBundles)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to copy inline bundles: %w", err)
+	}
+	err = renderBundleApps(wt, clusterName, mgmtPath, refBundles)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to render reference bundles: %w", err)
+	}
+	processors, err := getPostProcessors(ctx, corev1, arlonNs, clusterName)
+	if err != nil {
+		return "", false, err
+	}
+	if len(processors) > 0 && len(profileBundles) > 0 {
+		// the other files were processed when the cluster was deployed
+		var bundleNames []string
+		for _, b := range profileBundles {
+			bundleNames = append(bundleNames, b.Name)
+		}
+		files, err := renderedFiles(wt, clusterPath, bundleNames...)
+		if err != nil {
+			return "", false, err
+		}
+		err = postProcess(ctx, wt, clusterName, clusterPath, files, processors)
+		if err != nil {
+			return "", false, err
+		}
+	}
+	previousProfile := summary.Profile
+	if !sync {
+		summary.Profile = profileName
+	}
+	summary.Bundles = append(profileBundles, bundles...)
+	err = writeSummary(wt, clusterPath, summary)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to write cluster summary: %w", err)
+	}
+	policies, err := getRegoPolicies(ctx, corev1, arlonNs)
+	if err != nil {
+		return "", false, err
+	}
+	err = checkRegoPolicies(ctx, wt, clusterName, clusterPath, policies)
+	if err != nil {
+		return "", false, err
+	}
+	commitMsg := fmt.Sprintf("attach profile %s to cluster %s", profileName, clusterName)
+	if sync {
+		commitMsg = fmt.Sprintf("sync profile %s of cluster %s", profileName, clusterName)
+	} else if profileName == "" {
+		commitMsg = fmt.Sprintf("detach profile %s from cluster %s", previousProfile, clusterName)
+	}
+	hashes := bundleHashes(inlineBundles, refBundles)
+	groups, err := gitutils.CommitGroups(ctx,
+		bundleCommitGroups(basePath, clusterName, previousBundles, summary.Bundles, hashes))
+	if err != nil {
+		return "", false, err
+	}
+	progress.Step(ctx, "committing changes")
+	changed, err = repo.Commit(commitMsg+hashesTrailer(map[string]map[string]string{clusterName: hashes}),
+		groups...)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to commit changes: %w", err)
+	}
+	if dryRun {
+		return "", changed, nil
+	}
+	if changed {
+		err = checkSyncWindows(ctx, st, arlonNs, clusterName, previousProfile, profileName)
+		if err != nil {
+			return "", false, err
+		}
+		progress.Step(ctx, "pushing to %s", repoUrl)
+		err = repo.Push(ctx)
+		if err != nil {
+			return "", false, err
+		}
+		log.Info("succesfully pushed working tree", "repoUrl", repoUrl)
+		commitSha, err = repo.Head()
+		if err != nil {
+			return "", false, err
+		}
+	} else {
+		log.Info("no changed files, skipping commit & push")
+	}
+	if sync {
+		if changed {
+			RecordEvent(ctx, kubeClient, rootApp, corev1api.EventTypeNormal, ReasonProfileSynced, commitSha,
+				fmt.Sprintf("profile %s synced", profileName))
+		}
+		return commitSha, changed, nil
+	}
+	// record the profile on the root application so that profile sync
+	// finds the cluster
+	updated := rootApp.DeepCopy()
+	setProfileLabels(updated, arlonNs, profileName)
+	if !reflect.DeepEqual(updated.Labels, rootApp.Labels) {
+		rootApp, err = appIf.Update(ctx,
+			&applicationpkg.ApplicationUpdateRequest{Application: updated})
+		if err != nil {
+			return commitSha, changed, fmt.Errorf("failed to update root application labels: %w", err)
+		}
+	}
+	RecordEvent(ctx, kubeClient, rootApp, corev1api.EventTypeNormal, ReasonProfileChanged, commitSha,
+		commitMsg)
+	return commitSha, changed, nil
+}
+
+// sameRef returns whether two possibly namespace qualified references name
+// the same resource.
+func sameRef(a string, b string, arlonNs string) bool {
+	if a == "" || b == "" {
+		return a == b
+	}
+	aNs, aName := bundle.ParseRef(a, arlonNs)
+	bNs, bName := bundle.ParseRef(b, arlonNs)
+	return aNs == bNs && aName == bName
+}
@@ -0,0 +1,92 @@
+package cluster
+
+import (
+	"fmt"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+	"gopkg.in/yaml.v2"
+	"os"
+	"path"
+	"strings"
+)
+
+// pruneBundles removes the files of the bundles of a cluster's tree that
+// aren't in keep: the directories of inline bundles under workloadPath, and
+// the bundle applications in mgmtPath's templates, other than the one of an
+// OCI cluster chart. Bundle applications are told apart from the cluster
+// chart's templates by their content, so that files left behind by bundles
+// arlon no longer knows about, for e.g. ones removed from a profile before
+// summaries were recorded, are pruned as well. It returns the paths it
+// removed.
+func pruneBundles(
+	fsys billy.Filesystem,
+	clusterName string,
+	mgmtPath string,
+	workloadPath string,
+	keep map[string]bool,
+) (pruned []string, err error) {
+	items, err := fsys.ReadDir(workloadPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read %s: %w", workloadPath, err)
+	}
+	for _, item := range items {
+		if item.IsDir() && !keep[item.Name()] {
+			pruned = append(pruned, path.Join(workloadPath, item.Name()))
+		}
+	}
+	templatesPath := path.Join(mgmtPath, "templates")
+	items, err = fsys.ReadDir(templatesPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read %s: %w", templatesPath, err)
+	}
+	for _, item := range items {
+		bundleName := strings.TrimSuffix(item.Name(), ".yaml")
+		if item.IsDir() || bundleName == item.Name() || keep[bundleName] ||
+			bundleName == clusterChartAppName {
+			continue
+		}
+		filePath := path.Join(templatesPath, item.Name())
+		isApp, err := isBundleApp(fsys, filePath, clusterName, bundleName)
+		if err != nil {
+			return nil, err
+		}
+		if isApp {
+			pruned = append(pruned, filePath)
+		}
+	}
+	for _, p := range pruned {
+		if err := util.RemoveAll(fsys, p); err != nil {
+			return nil, fmt.Errorf("failed to remove %s: %w", p, err)
+		}
+	}
+	return pruned, nil
+}
+
+// isBundleApp returns whether the file holds the application arlon
+// generates for a bundle of the cluster.
+func isBundleApp(fsys billy.Filesystem, filePath string, clusterName string, bundleName string) (bool, error) {
+	data, err := util.ReadFile(fsys, filePath)
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s: %w", filePath, err)
+	}
+	var app struct {
+		ApiVersion string `yaml:"apiVersion"`
+		Kind       string `yaml:"kind"`
+		Metadata   struct {
+			Name string `yaml:"name"`
+		} `yaml:"metadata"`
+	}
+	// leave out the Helm directives of generated applications; chart
+	// templates aren't necessarily valid YAML before being rendered
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if !strings.Contains(line, "{{") {
+			lines = append(lines, line)
+		}
+	}
+	if yaml.Unmarshal([]byte(strings.Join(lines, "\n")), &app) != nil {
+		return false, nil
+	}
+	return app.Kind == "Application" && strings.HasPrefix(app.ApiVersion, "argoproj.io/") &&
+		app.Metadata.Name == clusterName+"-"+bundleName, nil
+}
@@ -0,0 +1,138 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	cmdutil "github.com/argoproj/argo-cd/v2/cmd/util"
+	argoappv1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	"github.com/argoproj/argo-cd/v2/util/clusterauth"
+	corev1api "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	corev1types "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// RegisterCluster registers a provisioned workload cluster with ArgoCD under
+// the arlon cluster's name, which the applications of its bundles target.
+// It installs ArgoCD's manager service account in the workload cluster using
+// the kubeconfig generated by Cluster API, and writes the ArgoCD cluster
+// secret with that account's token. It returns the cluster's server URL.
+func RegisterCluster(
+	ctx context.Context,
+	kubeClient kubernetes.Interface,
+	argocdNs string,
+	clusterName string,
+) (string, error) {
+	data, err := GetKubeconfig(ctx, kubeClient, clusterName)
+	if err != nil {
+		return "", err
+	}
+	conf, err := clientcmd.RESTConfigFromKubeConfig(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to read kubeconfig: %w", err)
+	}
+	workloadClient, err := kubernetes.NewForConfig(conf)
+	if err != nil {
+		return "", fmt.Errorf("failed to get workload cluster client: %w", err)
+	}
+	token, err := clusterauth.InstallClusterManagerRBAC(workloadClient, "kube-system", []string{})
+	if err != nil {
+		return "", fmt.Errorf("failed to install service account in workload cluster: %w", err)
+	}
+	clust := cmdutil.NewCluster(clusterName, nil, false, conf, token, nil, nil, nil, nil)
+	err = writeClusterSecret(ctx, kubeClient.CoreV1(), argocdNs, clust)
+	if err != nil {
+		return "", err
+	}
+	return clust.Server, nil
+}
+
+// writeClusterSecret creates or updates the ArgoCD cluster secret of clust.
+// A secret already registering the cluster by name or server, for e.g. one
+// written by the ClusterRegistration controller, is updated in place so that
+// ArgoCD doesn't end up with two clusters for the same server.
+func writeClusterSecret(
+	ctx context.Context,
+	corev1 corev1types.CoreV1Interface,
+	argocdNs string,
+	clust *argoappv1.Cluster,
+) error {
+	config, err := json.Marshal(clust.Config)
+	if err != nil {
+		return fmt.Errorf("failed to marshal cluster config: %w", err)
+	}
+	secretsApi := corev1.Secrets(argocdNs)
+	secrets, err := secretsApi.List(ctx, metav1.ListOptions{
+		LabelSelector: "argocd.argoproj.io/secret-type=cluster",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list argocd cluster secrets: %w", err)
+	}
+	for _, secret := range secrets.Items {
+		if string(secret.Data["name"]) != clust.Name && string(secret.Data["server"]) != clust.Server {
+			continue
+		}
+		if secret.Data == nil {
+			secret.Data = make(map[string][]byte)
+		}
+		secret.Data["name"] = []byte(clust.Name)
+		secret.Data["server"] = []byte(clust.Server)
+		secret.Data["config"] = config
+		_, err = secretsApi.Update(ctx, &secret, metav1.UpdateOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to update argocd cluster secret %s: %w", secret.Name, err)
+		}
+		return nil
+	}
+	secret := &corev1api.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "cluster-" + clust.Name,
+			Labels: map[string]string{
+				"argocd.argoproj.io/secret-type": "cluster",
+				"managed-by":                     "arlon",
+			},
+		},
+		Data: map[string][]byte{
+			"name":   []byte(clust.Name),
+			"server": []byte(clust.Server),
+			"config": config,
+		},
+	}
+	_, err = secretsApi.Create(ctx, secret, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to create argocd cluster secret: %w", err)
+	}
+	return nil
+}
+
+// renameClusterSecret renames the ArgoCD cluster registered by name
+// clusterName, for the applications of its bundles to keep targeting it. It
+// does nothing if no cluster is registered by that name.
+func renameClusterSecret(
+	ctx context.Context,
+	corev1 corev1types.CoreV1Interface,
+	argocdNs string,
+	clusterName string,
+	newName string,
+) error {
+	secretsApi := corev1.Secrets(argocdNs)
+	secrets, err := secretsApi.List(ctx, metav1.ListOptions{
+		LabelSelector: "argocd.argoproj.io/secret-type=cluster",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list argocd cluster secrets: %w", err)
+	}
+	for _, secret := range secrets.Items {
+		if string(secret.Data["name"]) != clusterName {
+			continue
+		}
+		secret.Data["name"] = []byte(newName)
+		_, err = secretsApi.Update(ctx, &secret, metav1.UpdateOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to update argocd cluster secret %s: %w", secret.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package cluster
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	argoappv1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+)
+
+func TestWriteClusterSecret(t *testing.T) {
+	ctx := context.Background()
+	kubeClient := k8sfake.NewSimpleClientset()
+	clust := &argoappv1.Cluster{
+		Name:   "c1",
+		Server: "https://c1.example.com",
+		Config: argoappv1.ClusterConfig{BearerToken: "token-1"},
+	}
+	secretsApi := kubeClient.CoreV1().Secrets("argocd")
+	if err := writeClusterSecret(ctx, kubeClient.CoreV1(), "argocd", clust); err != nil {
+		t.Fatal(err)
+	}
+	secret, err := secretsApi.Get(ctx, "cluster-c1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if secret.Labels["argocd.argoproj.io/secret-type"] != "cluster" ||
+		!strings.Contains(string(secret.Data["config"]), "token-1") {
+		t.Errorf("unexpected cluster secret %v", secret)
+	}
+	// re-registering updates the secret of the cluster's server in place
+	secret.Name = "cluster-c1.example.com-123"
+	secret.Data["name"] = []byte("other")
+	if _, err := secretsApi.Create(ctx, secret, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := secretsApi.Delete(ctx, "cluster-c1", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	clust.Config.BearerToken = "token-2"
+	if err := writeClusterSecret(ctx, kubeClient.CoreV1(), "argocd", clust); err != nil {
+		t.Fatal(err)
+	}
+	secret, err = secretsApi.Get(ctx, "cluster-c1.example.com-123", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(secret.Data["name"]) != "c1" || !strings.Contains(string(secret.Data["config"]), "token-2") {
+		t.Errorf("expected the existing secret to be updated, got %v", secret.Data)
+	}
+	if _, err := secretsApi.Get(ctx, "cluster-c1", metav1.GetOptions{}); err == nil {
+		t.Errorf("expected no second cluster secret")
+	}
+}
@@ -0,0 +1,185 @@
+package cluster
+
+import (
+	"arlon.io/arlon/pkg/kuberetry"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/open-policy-agent/opa/rego"
+	corev1api "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1types "k8s.io/client-go/kubernetes/typed/core/v1"
+	sigsyaml "sigs.k8s.io/yaml"
+	"sort"
+	"strings"
+)
+
+// RegoPoliciesConfigMapName names the ConfigMap in the arlon namespace whose
+// keys ending in .rego hold the Rego policies that the files rendered for a
+// cluster must pass before they are pushed. Policies are evaluated in
+// process with OPA's rego package.
+const RegoPoliciesConfigMapName = "arlon-rego-policies"
+
+// RegoDenyQuery is the query evaluated against the rendered files: the
+// policies define the deny rule of the arlon package as a set of messages,
+// each describing a violation. Its input holds the cluster's name in
+// "cluster", and its rendered files in "files", each with its "path"
+// relative to the cluster's directory, its "content", and the "objects" of
+// its YAML documents, which are null for files that don't parse, such as the
+// mgmt chart's Helm templates.
+const RegoDenyQuery = "data.arlon.deny"
+
+// PolicyViolationError is returned when the files rendered for a cluster
+// violate the Rego policies, listing the messages of the violations.
+type PolicyViolationError struct {
+	ClusterName string
+	Violations  []string
+}
+
+func (e *PolicyViolationError) Error() string {
+	return fmt.Sprintf("rendered files of cluster %s violate policies: %s",
+		e.ClusterName, strings.Join(e.Violations, "; "))
+}
+
+type regoFile struct {
+	Path    string        `json:"path"`
+	Content string        `json:"content"`
+	Objects []interface{} `json:"objects"`
+}
+
+type regoInput struct {
+	Cluster string     `json:"cluster"`
+	Files   []regoFile `json:"files"`
+}
+
+// getRegoPolicies returns the Rego modules of the policies ConfigMap, keyed
+// by their file name. There are none without the ConfigMap.
+func getRegoPolicies(
+	ctx context.Context,
+	corev1 corev1types.CoreV1Interface,
+	arlonNs string,
+) (map[string]string, error) {
+	var cm *corev1api.ConfigMap
+	err := kuberetry.OnTransient(ctx, func() (err error) {
+		cm, err = corev1.ConfigMaps(arlonNs).Get(ctx, RegoPoliciesConfigMapName, metav1.GetOptions{})
+		return
+	})
+	if apierr.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rego policies configmap: %w", err)
+	}
+	modules := make(map[string]string)
+	for key, module := range cm.Data {
+		// keys are file names, which can't hold path separators
+		if strings.HasSuffix(key, ".rego") {
+			modules[key] = module
+		}
+	}
+	return modules, nil
+}
+
+// checkRegoPolicies evaluates the Rego policies against the rendered files of
+// the cluster at clusterPath, and returns a PolicyViolationError if they deny
+// any of them.
+func checkRegoPolicies(
+	ctx context.Context,
+	fsys billy.Filesystem,
+	clusterName string,
+	clusterPath string,
+	modules map[string]string,
+) error {
+	if len(modules) == 0 {
+		return nil
+	}
+	files, err := renderedFiles(fsys, clusterPath)
+	if err != nil {
+		return err
+	}
+	input := regoInput{Cluster: clusterName, Files: []regoFile{}}
+	for _, filePath := range files {
+		data, err := util.ReadFile(fsys, filePath)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", filePath, err)
+		}
+		input.Files = append(input.Files, regoFile{
+			Path:    strings.TrimPrefix(filePath, clusterPath+"/"),
+			Content: string(data),
+			Objects: parseObjects(data),
+		})
+	}
+	violations, err := evalRego(ctx, modules, &input)
+	if err != nil {
+		return fmt.Errorf("failed to evaluate rego policies for cluster %s: %w", clusterName, err)
+	}
+	if len(violations) > 0 {
+		return &PolicyViolationError{ClusterName: clusterName, Violations: violations}
+	}
+	return nil
+}
+
+// parseObjects returns the non-empty documents of a YAML file, or nil if
+// any of them doesn't parse.
+func parseObjects(data []byte) []interface{} {
+	objects := []interface{}{}
+	for _, doc := range documentSeparator.Split(string(data), -1) {
+		var obj interface{}
+		if err := sigsyaml.Unmarshal([]byte(doc), &obj); err != nil {
+			return nil
+		}
+		if obj != nil {
+			objects = append(objects, obj)
+		}
+	}
+	return objects
+}
+
+// evalRego evaluates RegoDenyQuery against the input, and returns the
+// messages of the set it defines, sorted.
+func evalRego(ctx context.Context, modules map[string]string, input *regoInput) ([]string, error) {
+	// the input is passed as JSON values, as opa eval would read it
+	data, err := json.Marshal(input)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	options := []func(*rego.Rego){rego.Query(RegoDenyQuery), rego.Input(value)}
+	names := make([]string, 0, len(modules))
+	for name := range modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		options = append(options, rego.Module(name, modules[name]))
+	}
+	results, err := rego.New(options...).Eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var violations []string
+	for _, result := range results {
+		for _, expr := range result.Expressions {
+			values, ok := expr.Value.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s must be a set, got %v", RegoDenyQuery, expr.Value)
+			}
+			for _, val := range values {
+				if msg, ok := val.(string); ok {
+					violations = append(violations, msg)
+				} else {
+					msg, _ := json.Marshal(val)
+					violations = append(violations, string(msg))
+				}
+			}
+		}
+	}
+	sort.Strings(violations)
+	return violations, nil
+}
@@ -0,0 +1,39 @@
+package cluster
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestEvalRego(t *testing.T) {
+	ctx := context.Background()
+	modules := map[string]string{"deny.rego": `package arlon
+
+deny[msg] {
+	input.files[_].objects[_].kind == "Secret"
+	msg := sprintf("cluster %s may not hold secrets", [input.cluster])
+}
+
+deny[{"path": file.path}] {
+	file := input.files[_]
+	file.objects == null
+}
+`}
+	input := &regoInput{Cluster: "c1", Files: []regoFile{
+		{Path: "workload/db/secret.yaml", Objects: parseObjects([]byte("kind: Secret\n---\nkind: Service\n"))},
+		{Path: "mgmt/templates/db.yaml", Objects: parseObjects([]byte("{{ .Values.x }}: [\n"))},
+	}}
+	violations, err := evalRego(ctx, modules, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"cluster c1 may not hold secrets", `{"path":"mgmt/templates/db.yaml"}`}
+	if !reflect.DeepEqual(violations, expected) {
+		t.Errorf("expected violations %v, got %v", expected, violations)
+	}
+	_, err = evalRego(ctx, map[string]string{"invalid.rego": "package arlon\ndeny[msg] {\n"}, input)
+	if err == nil {
+		t.Error("expected an invalid policy to fail")
+	}
+}
@@ -0,0 +1,412 @@
+package cluster
+
+import (
+	"arlon.io/arlon/pkg/bundle"
+	"arlon.io/arlon/pkg/gitutils"
+	"arlon.io/arlon/pkg/log"
+	"arlon.io/arlon/pkg/progress"
+	"bytes"
+	"context"
+	"fmt"
+	applicationpkg "github.com/argoproj/argo-cd/v2/pkg/apiclient/application"
+	argoappv1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+	corev1api "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8syaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Rename moves an arlon cluster to a new name. The cluster's git directory
+// is moved and its generated bundle applications are re-rendered in a single
+// commit. The repository location is taken from the existing root
+// application.
+//
+// The cluster chart names the Cluster API resources, and their namespace,
+// after the cluster, so renaming a provisioned cluster would replace its
+// workload cluster: Rename refuses it once the cluster's namespace exists.
+// Otherwise the root application is recreated under the new name, and the
+// old one and its bundle applications deleted without cascading, before
+// pushing, so that no application ever syncs the moved directory from its old
+// path; the new root application recreates the bundle applications under the
+// new name, which take the resources over. The ArgoCD cluster
+// secret registering the cluster by name is renamed too. Renaming is refused
+// while the change windows of the cluster's profile are closed for its old
+// or new name. It returns the hash of the pushed commit.
+func Rename(
+	ctx context.Context,
+	kubeClient kubernetes.Interface,
+	repo gitutils.GitRepo,
+	appIf applicationpkg.ApplicationServiceClient,
+	argocdNs string,
+	arlonNs string,
+	clusterName string,
+	newName string,
+) (commitSha string, err error) {
+	log := log.GetLogger()
+	rootApp, err := appIf.Get(ctx,
+		&applicationpkg.ApplicationQuery{Name: &clusterName})
+	if err != nil {
+		return "", fmt.Errorf("failed to get root application %s: %w", clusterName, err)
+	}
+	_, err = appIf.Get(ctx,
+		&applicationpkg.ApplicationQuery{Name: &newName})
+	if err == nil {
+		return "", fmt.Errorf("an application named %s already exists", newName)
+	}
+	if status.Code(err) != codes.NotFound {
+		return "", fmt.Errorf("failed to get application %s: %w", newName, err)
+	}
+	_, err = kubeClient.CoreV1().Namespaces().Get(ctx, clusterName, metav1.GetOptions{})
+	if err == nil {
+		return "", fmt.Errorf("cluster %s is provisioned: its Cluster API resources are named after it, "+
+			"so renaming it would replace the workload cluster", clusterName)
+	}
+	if !apierr.IsNotFound(err) {
+		return "", fmt.Errorf("failed to get namespace %s: %w", clusterName, err)
+	}
+	repoUrl, repoBranch, basePath := rootAppSource(rootApp)
+	// the cluster directory is both removed and written
+	for _, name := range []string{clusterName, newName} {
+		err = checkPathPolicy(ctx, kubeClient.CoreV1(), arlonNs, basePath, name)
+		if err != nil {
+			return "", err
+		}
+	}
+	creds, err := getRepoCreds(ctx, kubeClient.CoreV1(), argocdNs, repoUrl)
+	if err != nil {
+		return "", err
+	}
+	progress.Step(ctx, "cloning %s (branch %s)", repoUrl, repoBranch)
+	err = repo.Clone(ctx, repoUrl, repoBranch, creds.auth())
+	if err != nil {
+		return "", err
+	}
+	wt := repo.Worktree()
+	oldPath := path.Join(basePath, clusterName)
+	newPath := path.Join(basePath, newName)
+	if _, err := wt.Stat(oldPath); err != nil {
+		return "", fmt.Errorf("cluster directory %s not found in repository: %w", oldPath, err)
+	}
+	if _, err := wt.Stat(newPath); err == nil {
+		return "", fmt.Errorf("directory %s already exists in repository", newPath)
+	}
+	err = checkDeployedSyncWindows(ctx, kubeClient.CoreV1(), argocdNs, arlonNs, wt, basePath, clusterName)
+	if err != nil {
+		return "", err
+	}
+	progress.Step(ctx, "moving %s to %s", oldPath, newPath)
+	err = moveDir(wt, oldPath, newPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to move cluster directory: %w", err)
+	}
+	// windows may apply to the cluster's new name only
+	err = checkDeployedSyncWindows(ctx, kubeClient.CoreV1(), argocdNs, arlonNs, wt, basePath, newName)
+	if err != nil {
+		return "", err
+	}
+	bundleApps, err := renameBundleApps(wt, clusterName, newName, path.Join(newPath, "mgmt"),
+		path.Join(newPath, "workload"))
+	if err != nil {
+		return "", err
+	}
+	summary, err := ReadSummary(wt, basePath, newName)
+	if err != nil {
+		return "", err
+	}
+	if summary != nil {
+		summary.ClusterName = newName
+		err = writeSummary(wt, newPath, summary)
+		if err != nil {
+			return "", fmt.Errorf("failed to write cluster summary: %w", err)
+		}
+	}
+	commitMsg := fmt.Sprintf("rename cluster %s to %s", clusterName, newName)
+	progress.Step(ctx, "committing changes")
+	changed, err := repo.Commit(commitMsg)
+	if err != nil {
+		return "", fmt.Errorf("failed to commit changes: %w", err)
+	}
+	progress.Step(ctx, "recreating root application as %s", newName)
+	newApp, err := renameRootApp(ctx, appIf, rootApp, bundleApps, newName, basePath)
+	if err == nil {
+		err = renameClusterSecret(ctx, kubeClient.CoreV1(), argocdNs, clusterName, newName)
+	}
+	if err == nil && changed {
+		progress.Step(ctx, "pushing to %s", repoUrl)
+		err = repo.Push(ctx)
+	}
+	if err != nil {
+		// the directory wasn't moved, so the old root application is restored
+		if restoreErr := restoreRootApp(ctx, appIf, rootApp, newName); restoreErr != nil {
+			log.Error(restoreErr, "failed to restore root application", "name", clusterName)
+		}
+		if secretErr := renameClusterSecret(ctx, kubeClient.CoreV1(), argocdNs, newName, clusterName); secretErr != nil {
+			log.Error(secretErr, "failed to restore argocd cluster secret", "name", clusterName)
+		}
+		return "", err
+	}
+	if changed {
+		log.Info("succesfully pushed working tree", "repoUrl", repoUrl)
+		commitSha, err = repo.Head()
+		if err != nil {
+			return "", err
+		}
+	}
+	RecordEvent(ctx, kubeClient, newApp, corev1api.EventTypeNormal, ReasonRenamed, commitSha,
+		fmt.Sprintf("cluster renamed from %s", clusterName))
+	return commitSha, nil
+}
+
+// -----------------------------------------------------------------------------
+
+// moveDir moves the files below oldPath to newPath, one by one since not
+// every billy filesystem renames directories.
+func moveDir(fsys billy.Filesystem, oldPath string, newPath string) error {
+	var files []string
+	if err := listFiles(fsys, oldPath, &files); err != nil {
+		return err
+	}
+	for _, filePath := range files {
+		data, err := util.ReadFile(fsys, filePath)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", filePath, err)
+		}
+		err = writeFile(fsys, path.Join(newPath, strings.TrimPrefix(filePath, oldPath+"/")), data)
+		if err != nil {
+			return err
+		}
+	}
+	return util.RemoveAll(fsys, oldPath)
+}
+
+// renameBundleApps re-renders the generated bundle applications found in the
+// mgmt chart's templates directory so that their names, destinations and
+// source paths refer to the new cluster name. Files belonging to the embedded
+// chart are left alone since they are parameterized by clusterName. It
+// returns the old names of the re-rendered applications.
+func renameBundleApps(
+	fsys billy.Filesystem,
+	clusterName string,
+	newName string,
+	mgmtPath string,
+	workloadPath string,
+) ([]string, error) {
+	log := log.GetLogger()
+	chartFiles, err := content.ReadDir("manifests/templates")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read embedded directory: %w", err)
+	}
+	isChartFile := make(map[string]bool)
+	for _, item := range chartFiles {
+		isChartFile[item.Name()] = true
+	}
+	templatesPath := path.Join(mgmtPath, "templates")
+	items, err := fsys.ReadDir(templatesPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory %s: %w", templatesPath, err)
+	}
+	tmpl, err := newAppTemplate()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create app template: %w", err)
+	}
+	var renamed []string
+	for _, item := range items {
+		if item.IsDir() || isChartFile[item.Name()] {
+			continue
+		}
+		appPath := path.Join(templatesPath, item.Name())
+		f, err := fsys.Open(appPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open application file %s: %w", appPath, err)
+		}
+		data, err := io.ReadAll(f)
+		_ = f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read application file %s: %w", appPath, err)
+		}
+		// the destination server is chosen when the chart is rendered, and
+		// is chosen again by the re-rendered application
+		data = destinationServerBlock.ReplaceAll(data, []byte("$1"))
+		var app argoappv1.Application
+		err = k8syaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), 4096).Decode(&app)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode application file %s: %w", appPath, err)
+		}
+		prefix := clusterName + "-"
+		if app.Kind != "Application" || !strings.HasPrefix(app.Name, prefix) {
+			log.V(1).Info("skipping non-generated file", "path", appPath)
+			continue
+		}
+		settings := renamedAppSettings(&app, clusterName, newName, workloadPath)
+		dst, err := fsys.Create(appPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create application file %s: %w", appPath, err)
+		}
+		err = tmpl.Execute(dst, settings)
+		_ = dst.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to render application template %s: %w", appPath, err)
+		}
+		log.V(1).Info("renamed bundle application", "path", appPath)
+		renamed = append(renamed, app.Name)
+	}
+	return renamed, nil
+}
+
+// -----------------------------------------------------------------------------
+
+// destinationServerBlock matches the Helm conditional of a generated bundle
+// application targeting the workload cluster, capturing its destination by
+// name.
+var destinationServerBlock = regexp.MustCompile(
+	`(?m)^\{\{- if \.Values\.destinationServer \}\}\n.*\n\{\{- else \}\}\n(.*\n)\{\{- end \}\}\n`)
+
+// renamedAppSettings recovers the settings a generated bundle application was
+// rendered from, with references to the old cluster name replaced. Generated
+// Helm values only mention the cluster name in identifiers, so whole-word
+// occurrences of it are replaced.
+func renamedAppSettings(
+	app *argoappv1.Application,
+	clusterName string,
+	newName string,
+	workloadPath string,
+) *AppSettings {
+	settings := &AppSettings{
+		ClusterName:          newName,
+		BundleName:           strings.TrimPrefix(app.Name, clusterName+"-"),
+		WorkloadPath:         workloadPath,
+		AppNamespace:         app.Namespace,
+		DestinationNamespace: app.Spec.Destination.Namespace,
+		DestinationServer:    app.Spec.Destination.Server,
+		RepoUrl:              app.Spec.Source.RepoURL,
+		Chart:                app.Spec.Source.Chart,
+		TargetRevision:       app.Spec.Source.TargetRevision,
+		SyncWave:             app.Annotations["argocd.argoproj.io/sync-wave"],
+		BundleHash:           app.Annotations[BundleHashAnnotation],
+	}
+	if syncPolicy := app.Spec.SyncPolicy; syncPolicy != nil {
+		settings.SyncOptions = syncPolicy.SyncOptions
+		if retry := syncPolicy.Retry; retry != nil {
+			settings.Retry = &bundle.SyncRetry{Limit: retry.Limit}
+			if retry.Backoff != nil {
+				settings.Retry.Backoff = retry.Backoff.Duration
+				settings.Retry.MaxBackoff = retry.Backoff.MaxDuration
+				if retry.Backoff.Factor != nil {
+					settings.Retry.BackoffFactor = *retry.Backoff.Factor
+				}
+			}
+		}
+	}
+	inlinePath := path.Join(clusterName, "workload", settings.BundleName)
+	if settings.Chart == "" && !strings.HasSuffix(app.Spec.Source.Path, inlinePath) {
+		// reference bundles point to their own source
+		settings.RepoPath = app.Spec.Source.Path
+	}
+	if settings.DestinationNamespace == clusterName {
+		settings.DestinationNamespace = newName
+	}
+	if app.Spec.Source.Helm != nil {
+		nameRe := regexp.MustCompile(`\b` + regexp.QuoteMeta(clusterName) + `\b`)
+		settings.HelmValues = nameRe.ReplaceAllString(app.Spec.Source.Helm.Values, newName)
+	}
+	return settings
+}
+
+// -----------------------------------------------------------------------------
+
+// renameRootApp creates the root application under its new name and then
+// deletes the old one, and the bundle applications it created, without
+// cascading, so that ArgoCD does not tear down resources before the new
+// applications take ownership of the cluster. The new application sources
+// the cluster's new directory, which it syncs once pushed. It returns the
+// created application.
+func renameRootApp(
+	ctx context.Context,
+	appIf applicationpkg.ApplicationServiceClient,
+	rootApp *argoappv1.Application,
+	bundleApps []string,
+	newName string,
+	basePath string,
+) (*argoappv1.Application, error) {
+	oldName := rootApp.Name
+	app := &argoappv1.Application{
+		TypeMeta: rootApp.TypeMeta,
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        newName,
+			Namespace:   rootApp.Namespace,
+			Labels:      rootApp.Labels,
+			Annotations: rootApp.Annotations,
+		},
+		Spec: *rootApp.Spec.DeepCopy(),
+	}
+	app.Spec.Source.Path = path.Join(basePath, newName, "mgmt")
+	if app.Spec.Source.Helm != nil {
+		for i, param := range app.Spec.Source.Helm.Parameters {
+			if param.Name == "clusterName" {
+				app.Spec.Source.Helm.Parameters[i].Value = newName
+			}
+		}
+	}
+	created, err := appIf.Create(ctx,
+		&applicationpkg.ApplicationCreateRequest{Application: *app})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ArgoCD root application %s: %w", newName, err)
+	}
+	cascade := false
+	_, err = appIf.Delete(ctx,
+		&applicationpkg.ApplicationDeleteRequest{Name: &oldName, Cascade: &cascade})
+	if err != nil {
+		return created, fmt.Errorf("failed to delete ArgoCD root application %s: %w", oldName, err)
+	}
+	for _, name := range bundleApps {
+		name := name
+		_, err = appIf.Delete(ctx,
+			&applicationpkg.ApplicationDeleteRequest{Name: &name, Cascade: &cascade})
+		if err != nil && status.Code(err) != codes.NotFound {
+			return created, fmt.Errorf("failed to delete ArgoCD application %s: %w", name, err)
+		}
+	}
+	return created, nil
+}
+
+// restoreRootApp recreates the root application a failed rename deleted, and
+// deletes the one it created under newName, without cascading.
+func restoreRootApp(
+	ctx context.Context,
+	appIf applicationpkg.ApplicationServiceClient,
+	rootApp *argoappv1.Application,
+	newName string,
+) error {
+	app := &argoappv1.Application{
+		TypeMeta: rootApp.TypeMeta,
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        rootApp.Name,
+			Namespace:   rootApp.Namespace,
+			Labels:      rootApp.Labels,
+			Annotations: rootApp.Annotations,
+		},
+		Spec: rootApp.Spec,
+	}
+	upsert := true
+	_, err := appIf.Create(ctx,
+		&applicationpkg.ApplicationCreateRequest{Application: *app, Upsert: &upsert})
+	if err != nil {
+		return fmt.Errorf("failed to recreate ArgoCD root application %s: %w", rootApp.Name, err)
+	}
+	cascade := false
+	_, err = appIf.Delete(ctx,
+		&applicationpkg.ApplicationDeleteRequest{Name: &newName, Cascade: &cascade})
+	if err != nil && status.Code(err) != codes.NotFound {
+		return fmt.Errorf("failed to delete ArgoCD root application %s: %w", newName, err)
+	}
+	return nil
+}
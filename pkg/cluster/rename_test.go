@@ -0,0 +1,128 @@
+package cluster_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"arlon.io/arlon/pkg/cluster"
+	clustertesting "arlon.io/arlon/pkg/cluster/testing"
+	applicationpkg "github.com/argoproj/argo-cd/v2/pkg/apiclient/application"
+	argoappv1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newRenameHarness(t *testing.T) *clustertesting.Harness {
+	h := clustertesting.New(t,
+		clustertesting.ClusterSpec(clustertesting.ArlonNs, "eks", map[string]string{"region": "us-west-2"}),
+		clustertesting.InlineBundle(clustertesting.ArlonNs, "guestbook", "kind: ConfigMap\n"),
+		clustertesting.Profile(clustertesting.ArlonNs, "dev", "guestbook"),
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "cluster-c1",
+				Namespace: clustertesting.ArgocdNs,
+				Labels:    map[string]string{"argocd.argoproj.io/secret-type": "cluster"},
+			},
+			Data: map[string][]byte{"name": []byte("c1"), "server": []byte("https://c1.example.com")},
+		},
+	)
+	h.Deploy("c1", "dev", "eks")
+	return h
+}
+
+func rename(h *clustertesting.Harness, clusterName string, newName string) (string, error) {
+	return cluster.Rename(context.Background(), h.KubeClient, h.Git.NewRepo(), h.Apps,
+		clustertesting.ArgocdNs, clustertesting.ArlonNs, clusterName, newName)
+}
+
+func TestRename(t *testing.T) {
+	h := newRenameHarness(t)
+	files := h.ClusterFiles("c1")
+	// the bundle application the root application synced
+	_, err := h.Apps.Create(context.Background(), &applicationpkg.ApplicationCreateRequest{
+		Application: argoappv1.Application{ObjectMeta: metav1.ObjectMeta{
+			Name:      "c1-guestbook",
+			Namespace: clustertesting.ArgocdNs,
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	commitSha, err := rename(h, "c1", "c2")
+	if err != nil {
+		t.Fatalf("failed to rename cluster: %s", err)
+	}
+	if commitSha == "" {
+		t.Error("expected the rename to be pushed")
+	}
+	if len(h.ClusterFiles("c1")) != 0 {
+		t.Error("expected the old cluster directory to be removed")
+	}
+	if len(h.ClusterFiles("c2")) != len(files) {
+		t.Errorf("expected the %d files of the cluster to be moved, got %d", len(files), len(h.ClusterFiles("c2")))
+	}
+	app := string(h.ClusterFiles("c2")["mgmt/templates/guestbook.yaml"])
+	if !strings.Contains(app, "name: c2-guestbook") || !strings.Contains(app, "clusters/c2/workload/guestbook") ||
+		!strings.Contains(app, "{{- if .Values.destinationServer }}") || !strings.Contains(app, "name: c2\n") ||
+		!strings.Contains(app, cluster.BundleHashAnnotation) {
+		t.Errorf("expected the bundle application to be re-rendered, got:\n%s", app)
+	}
+	apps := h.Apps.Apps()
+	if len(apps) != 1 || apps[0].Name != "c2" || apps[0].Spec.Source.Path != "clusters/c2/mgmt" {
+		t.Errorf("expected a single root application c2 sourcing the new directory, got %v", apps)
+	}
+	for _, app := range apps {
+		if strings.HasPrefix(app.Name, "c1-") {
+			t.Errorf("expected the bundle applications of c1 to be deleted, got %s", app.Name)
+		}
+	}
+	secret, err := h.KubeClient.CoreV1().Secrets(clustertesting.ArgocdNs).Get(context.Background(),
+		"cluster-c1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(secret.Data["name"]) != "c2" {
+		t.Errorf("expected the argocd cluster to be renamed, got %s", secret.Data["name"])
+	}
+}
+
+func TestRenameRefused(t *testing.T) {
+	h := newRenameHarness(t)
+	h.Deploy("c2", "dev", "eks")
+	if _, err := rename(h, "c1", "c2"); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("expected renaming to an existing application to fail, got %v", err)
+	}
+	_, err := h.KubeClient.CoreV1().Namespaces().Create(context.Background(),
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "c1"}}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rename(h, "c1", "c3"); err == nil || !strings.Contains(err.Error(), "is provisioned") {
+		t.Errorf("expected renaming a provisioned cluster to fail, got %v", err)
+	}
+	if len(h.ClusterFiles("c3")) != 0 || len(h.Apps.Apps()) != 2 {
+		t.Error("expected a refused rename to change nothing")
+	}
+}
+
+func TestRenamePushFailure(t *testing.T) {
+	h := newRenameHarness(t)
+	h.Git.PushErr = errors.New("permission denied")
+	if _, err := rename(h, "c1", "c2"); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Fatalf("expected push error, got %v", err)
+	}
+	apps := h.Apps.Apps()
+	if len(apps) != 1 || apps[0].Name != "c1" || apps[0].Spec.Source.Path != "clusters/c1/mgmt" {
+		t.Errorf("expected the root application c1 to be restored, got %v", apps)
+	}
+	secret, err := h.KubeClient.CoreV1().Secrets(clustertesting.ArgocdNs).Get(context.Background(),
+		"cluster-c1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(secret.Data["name"]) != "c1" {
+		t.Errorf("expected the argocd cluster name to be restored, got %s", secret.Data["name"])
+	}
+}
@@ -0,0 +1,97 @@
+package cluster
+
+import (
+	"arlon.io/arlon/pkg/diff"
+	"context"
+	"fmt"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/osfs"
+	"k8s.io/client-go/kubernetes"
+	"os"
+	"path"
+)
+
+// Render writes the files that DeployToGit would commit for a cluster into
+// outDir, laid out as they would be in the repository, without cloning or
+// pushing anything.
+func Render(
+	ctx context.Context,
+	kubeClient kubernetes.Interface,
+	argocdNs string,
+	arlonNs string,
+	clusterName string,
+	repoUrl string,
+	repoBranch string,
+	basePath string,
+	profileName string,
+	clusterSpecName string,
+	outDir string,
+) error {
+	st, err := loadStore(ctx, kubeClient.CoreV1(), argocdNs, arlonNs)
+	if err != nil {
+		return err
+	}
+	tree, err := newClusterTree(ctx, kubeClient.CoreV1(), st, arlonNs, clusterName, repoUrl,
+		repoBranch, basePath, profileName, clusterSpecName)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(outDir, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	return tree.write(ctx, osfs.New(outDir))
+}
+
+// RenderProfile returns the files the bundles of a profile would add to the
+// git tree of a cluster, keyed by their path in the repository: the
+// applications of the cluster's mgmt chart and the workload files of its
+// inline bundles. Nothing is written, so that profiles can be reviewed before
+// any cluster uses them.
+func RenderProfile(
+	ctx context.Context,
+	kubeClient kubernetes.Interface,
+	argocdNs string,
+	arlonNs string,
+	clusterName string,
+	repoUrl string,
+	basePath string,
+	profileName string,
+) (map[string][]byte, error) {
+	corev1 := kubeClient.CoreV1()
+	st, err := loadStore(ctx, corev1, argocdNs, arlonNs)
+	if err != nil {
+		return nil, err
+	}
+	inlineBundles, refBundles, err := getProfileBundles(ctx, profileName, st, corev1, arlonNs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get profile bundles: %w", err)
+	}
+	fsys := memfs.New()
+	clusterPath := path.Join(basePath, clusterName)
+	mgmtPath := path.Join(clusterPath, "mgmt")
+	workloadPath := path.Join(clusterPath, "workload")
+	err = copyInlineBundles(fsys, clusterName, repoUrl, mgmtPath, workloadPath, inlineBundles)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy inline bundles: %w", err)
+	}
+	err = renderBundleApps(fsys, clusterName, mgmtPath, refBundles)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render reference bundles: %w", err)
+	}
+	processors, err := getPostProcessors(ctx, corev1, arlonNs, clusterName)
+	if err != nil {
+		return nil, err
+	}
+	if len(processors) > 0 {
+		files, err := renderedFiles(fsys, clusterPath)
+		if err != nil {
+			return nil, err
+		}
+		err = postProcess(ctx, fsys, clusterName, clusterPath, files, processors)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return diff.ReadTree(fsys, basePath)
+}
@@ -0,0 +1,61 @@
+package cluster_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"arlon.io/arlon/pkg/cluster"
+	clustertesting "arlon.io/arlon/pkg/cluster/testing"
+)
+
+func TestRender(t *testing.T) {
+	h := clustertesting.New(t,
+		clustertesting.ClusterSpec(clustertesting.ArlonNs, "eks", map[string]string{"region": "us-west-2"}),
+		clustertesting.InlineBundle(clustertesting.ArlonNs, "guestbook", "kind: ConfigMap\n"),
+		clustertesting.ChartBundle(clustertesting.ArlonNs, "nginx", "https://charts.example.com", "nginx", "1.0"),
+		clustertesting.Profile(clustertesting.ArlonNs, "dev", "guestbook", "nginx"),
+	)
+	commits := len(h.Git.Commits(clustertesting.RepoUrl, clustertesting.RepoBranch))
+	outDir := t.TempDir()
+	err := cluster.Render(context.Background(), h.KubeClient, clustertesting.ArgocdNs, clustertesting.ArlonNs,
+		"c1", clustertesting.RepoUrl, clustertesting.RepoBranch, clustertesting.BasePath, "dev", "eks", outDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(h.Git.Commits(clustertesting.RepoUrl, clustertesting.RepoBranch)) != commits {
+		t.Error("expected rendering to push nothing")
+	}
+	rendered := make(map[string][]byte)
+	clusterDir := filepath.Join(outDir, clustertesting.BasePath, "c1")
+	err = filepath.Walk(clusterDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(clusterDir, p)
+		rendered[filepath.ToSlash(rel)] = data
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the rendered files are the ones deploying the cluster commits
+	h.Deploy("c1", "dev", "eks")
+	deployed := h.ClusterFiles("c1")
+	if len(rendered) == 0 || !reflect.DeepEqual(rendered, deployed) {
+		var renderedPaths, deployedPaths []string
+		for p := range rendered {
+			renderedPaths = append(renderedPaths, p)
+		}
+		for p := range deployed {
+			deployedPaths = append(deployedPaths, p)
+		}
+		t.Errorf("expected the rendered files to be the deployed ones, got %v and %v", renderedPaths, deployedPaths)
+	}
+}
@@ -0,0 +1,226 @@
+package cluster
+
+import (
+	"arlon.io/arlon/pkg/gitutils"
+	"arlon.io/arlon/pkg/log"
+	"arlon.io/arlon/pkg/progress"
+	"bytes"
+	"context"
+	"fmt"
+	applicationpkg "github.com/argoproj/argo-cd/v2/pkg/apiclient/application"
+	argoappv1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+	corev1api "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// Rollback reverts the most recent operation of arlon that changed a
+// cluster's directory, such as its last deployment, by restoring the files
+// the operation changed to their content before it. All the commits of the
+// operation are reverted, as identified by their OperationTrailer, since the
+// per-bundle commit strategy splits an operation into several commits. If
+// restoreRootApp is set, the root application's Helm parameters are also
+// restored from the clusterspec values recorded in the restored summary. It
+// is refused while the change windows of the current or the restored
+// profile are closed. It returns the hashes of the reverted commits, newest
+// first, and of the pushed revert.
+func Rollback(
+	ctx context.Context,
+	kubeClient kubernetes.Interface,
+	repo gitutils.GitRepo,
+	appIf applicationpkg.ApplicationServiceClient,
+	argocdNs string,
+	arlonNs string,
+	clusterName string,
+	restoreRootApp bool,
+) (reverted []string, commitSha string, err error) {
+	log := log.GetLogger()
+	rootApp, err := appIf.Get(ctx,
+		&applicationpkg.ApplicationQuery{Name: &clusterName})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get root application %s: %w", clusterName, err)
+	}
+	repoUrl, repoBranch, basePath := rootAppSource(rootApp)
+	err = checkPathPolicy(ctx, kubeClient.CoreV1(), arlonNs, basePath, clusterName)
+	if err != nil {
+		return nil, "", err
+	}
+	creds, err := getRepoCreds(ctx, kubeClient.CoreV1(), argocdNs, repoUrl)
+	if err != nil {
+		return nil, "", err
+	}
+	progress.Step(ctx, "cloning %s (branch %s)", repoUrl, repoBranch)
+	err = repo.Clone(ctx, repoUrl, repoBranch, creds.auth())
+	if err != nil {
+		return nil, "", err
+	}
+	clusterPath := path.Join(basePath, clusterName)
+	history, err := repo.History(clusterPath)
+	if err != nil {
+		return nil, "", err
+	}
+	commits := operationCommits(history)
+	if len(commits) == 0 {
+		return nil, "", fmt.Errorf("no arlon commit found for cluster directory %s", clusterPath)
+	}
+	// the commits of an operation are pushed together, so they follow each
+	// other on the branch
+	newest, oldest := commits[0], commits[len(commits)-1]
+	for _, commit := range commits {
+		reverted = append(reverted, commit.Hash)
+	}
+	if oldest.ParentHash == "" {
+		return nil, "", fmt.Errorf("commit %s is the first of the branch, there is nothing to roll back to",
+			oldest.Hash)
+	}
+	previous, err := repo.ReadTreeAt(oldest.ParentHash, clusterPath)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(previous) == 0 {
+		return nil, "", fmt.Errorf("cluster directory %s was created by commit %s, delete the cluster instead",
+			clusterPath, oldest.Hash)
+	}
+	changed, err := repo.ReadTreeAt(newest.Hash, clusterPath)
+	if err != nil {
+		return nil, "", err
+	}
+	progress.Step(ctx, "restoring %s before commit %s", clusterPath, oldest.Hash)
+	wt := repo.Worktree()
+	current, err := ReadSummary(wt, basePath, clusterName)
+	if err != nil {
+		return nil, "", err
+	}
+	err = revertFiles(wt, previous, changed)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to revert commits %s: %w", strings.Join(reverted, ", "), err)
+	}
+	// the windows of both the current and the restored profile apply
+	var currentProfile string
+	if current != nil {
+		currentProfile = current.Profile
+	}
+	err = checkDeployedSyncWindows(ctx, kubeClient.CoreV1(), argocdNs, arlonNs, wt, basePath, clusterName,
+		currentProfile)
+	if err != nil {
+		return nil, "", err
+	}
+	// the last commit of an operation has its main message
+	subject := strings.SplitN(newest.Message, "\n", 2)[0]
+	commitMsg := fmt.Sprintf("Revert \"%s\"\n\nThis reverts commits %s for cluster %s.",
+		subject, strings.Join(reverted, ", "), clusterName)
+	progress.Step(ctx, "committing changes")
+	committed, err := repo.Commit(commitMsg)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to commit changes: %w", err)
+	}
+	if committed {
+		progress.Step(ctx, "pushing to %s", repoUrl)
+		if err := repo.Push(ctx); err != nil {
+			return nil, "", err
+		}
+		log.Info("succesfully pushed working tree", "repoUrl", repoUrl)
+		commitSha, err = repo.Head()
+		if err != nil {
+			return nil, "", err
+		}
+	}
+	if !restoreRootApp {
+		RecordEvent(ctx, kubeClient, rootApp, corev1api.EventTypeNormal, ReasonRolledBack, commitSha,
+			fmt.Sprintf("reverted commits %s", strings.Join(reverted, ", ")))
+		return reverted, commitSha, nil
+	}
+	summary, err := ReadSummary(wt, basePath, clusterName)
+	if err != nil {
+		return nil, "", err
+	}
+	if summary == nil {
+		return nil, "", fmt.Errorf("no cluster summary was recorded before commit %s, cannot restore the root application",
+			oldest.Hash)
+	}
+	progress.Step(ctx, "restoring root application %s", clusterName)
+	if rootApp.Spec.Source.Helm == nil {
+		rootApp.Spec.Source.Helm = &argoappv1.ApplicationSourceHelm{}
+	}
+	// the destination and project aren't part of the clusterspec, keep the
+	// current ones
+	params := rootAppHelmParams(clusterName, summary.ClusterSpecValues)
+	for _, param := range rootApp.Spec.Source.Helm.Parameters {
+		if param.Name == DestinationServerParam || param.Name == ProjectParam {
+			params = append(params, param)
+		}
+	}
+	rootApp.Spec.Source.Helm.Parameters = params
+	rootApp.Spec.Source.Helm.Values = summary.ClusterSpecValues[helmValuesKey]
+	updated, err := appIf.Update(ctx, &applicationpkg.ApplicationUpdateRequest{Application: rootApp})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to update ArgoCD root application %s: %w", clusterName, err)
+	}
+	RecordEvent(ctx, kubeClient, updated, corev1api.EventTypeNormal, ReasonRolledBack, commitSha,
+		fmt.Sprintf("reverted commits %s and restored the root application", strings.Join(reverted, ", ")))
+	return reverted, commitSha, nil
+}
+
+// operationCommits returns the commits of the most recent operation of
+// arlon in history, newest first. Commits made by hand are left alone.
+func operationCommits(history []gitutils.CommitInfo) []gitutils.CommitInfo {
+	for i := range history {
+		operation := gitutils.Operation(history[i].Message)
+		if operation == "" {
+			continue
+		}
+		j := i + 1
+		for j < len(history) && gitutils.Operation(history[j].Message) == operation {
+			j++
+		}
+		return history[i:j]
+	}
+	return nil
+}
+
+// revertFiles restores the files of the working tree that a commit changed,
+// from their content before (previous) and after (changed) the commit, so
+// that the changes of later commits are kept. It fails if a file the commit
+// changed was changed again since.
+func revertFiles(wt billy.Filesystem, previous map[string][]byte, changed map[string][]byte) error {
+	var names []string
+	for name, data := range changed {
+		if before, ok := previous[name]; !ok || !bytes.Equal(before, data) {
+			names = append(names, name)
+		}
+	}
+	for name := range previous {
+		if _, ok := changed[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		current, err := util.ReadFile(wt, name)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		data, ok := changed[name]
+		if ok != (err == nil) || !bytes.Equal(current, data) {
+			return fmt.Errorf("%s was changed since", name)
+		}
+	}
+	for _, name := range names {
+		data, ok := previous[name]
+		if !ok {
+			if err := wt.Remove(name); err != nil {
+				return fmt.Errorf("failed to remove %s: %w", name, err)
+			}
+			continue
+		}
+		if err := util.WriteFile(wt, name, data, 0644); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package cluster_test
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"arlon.io/arlon/pkg/cluster"
+	clustertesting "arlon.io/arlon/pkg/cluster/testing"
+	"arlon.io/arlon/pkg/gitutils"
+)
+
+func newRollbackHarness(t *testing.T) (*clustertesting.Harness, string) {
+	h := clustertesting.New(t,
+		clustertesting.ClusterSpec(clustertesting.ArlonNs, "eks", map[string]string{"region": "us-west-2"}),
+		clustertesting.InlineBundle(clustertesting.ArlonNs, "guestbook", "kind: ConfigMap\n"),
+		clustertesting.InlineBundle(clustertesting.ArlonNs, "redis", "kind: Secret\n"),
+		clustertesting.Profile(clustertesting.ArlonNs, "dev", "guestbook"),
+		clustertesting.Profile(clustertesting.ArlonNs, "prod", "guestbook", "redis"),
+	)
+	h.Deploy("c1", "dev", "eks")
+	return h, h.SetProfile("c1", "prod")
+}
+
+func rollback(h *clustertesting.Harness) ([]string, error) {
+	repo := h.Git.NewRepo()
+	defer repo.Close()
+	reverted, _, err := cluster.Rollback(context.Background(), h.KubeClient, repo, h.Apps,
+		clustertesting.ArgocdNs, clustertesting.ArlonNs, "c1", false)
+	return reverted, err
+}
+
+func TestRollbackSkipsManualCommits(t *testing.T) {
+	h, profileSha := newRollbackHarness(t)
+	h.Git.Commit(clustertesting.RepoUrl, clustertesting.RepoBranch, "add notes",
+		map[string][]byte{clustertesting.BasePath + "/c1/NOTES.md": []byte("notes\n")})
+	reverted, err := rollback(h)
+	if err != nil {
+		t.Fatalf("failed to roll back: %s", err)
+	}
+	if !reflect.DeepEqual(reverted, []string{profileSha}) {
+		t.Errorf("expected the profile change %s to be reverted, got %v", profileSha, reverted)
+	}
+	files := h.ClusterFiles("c1")
+	if _, ok := files["mgmt/templates/redis.yaml"]; ok {
+		t.Error("expected the redis bundle to be removed")
+	}
+	if _, ok := files["mgmt/templates/guestbook.yaml"]; !ok {
+		t.Error("expected the guestbook bundle to be kept")
+	}
+	if string(files["NOTES.md"]) != "notes\n" {
+		t.Error("expected the manual commit to be kept")
+	}
+	if h.Git.OpenClones() != 0 {
+		t.Errorf("%d clones weren't closed", h.Git.OpenClones())
+	}
+}
+
+func TestRollbackConflict(t *testing.T) {
+	h, _ := newRollbackHarness(t)
+	h.Git.Commit(clustertesting.RepoUrl, clustertesting.RepoBranch, "edit redis",
+		map[string][]byte{clustertesting.BasePath + "/c1/mgmt/templates/redis.yaml": []byte("edited\n")})
+	_, err := rollback(h)
+	if err == nil || !strings.Contains(err.Error(), "redis.yaml was changed since") {
+		t.Errorf("expected the rollback to fail on the edited file, got %v", err)
+	}
+}
+
+func TestRollbackPerBundleOperation(t *testing.T) {
+	h := clustertesting.New(t,
+		clustertesting.ClusterSpec(clustertesting.ArlonNs, "eks", map[string]string{"region": "us-west-2"}),
+		clustertesting.InlineBundle(clustertesting.ArlonNs, "guestbook", "kind: ConfigMap\n"),
+		clustertesting.InlineBundle(clustertesting.ArlonNs, "redis", "kind: Secret\n"),
+		clustertesting.Profile(clustertesting.ArlonNs, "dev", "guestbook"),
+		clustertesting.Profile(clustertesting.ArlonNs, "prod", "guestbook", "redis"),
+	)
+	h.Deploy("c1", "dev", "eks")
+	before := h.ClusterFiles("c1")
+	repo := h.Git.NewRepo()
+	ctx := gitutils.WithCommitStrategy(context.Background(), gitutils.CommitPerBundle)
+	_, err := cluster.SetProfile(ctx, h.KubeClient, repo, h.Apps, clustertesting.ArgocdNs,
+		clustertesting.ArlonNs, "c1", "prod")
+	repo.Close()
+	if err != nil {
+		t.Fatalf("failed to set profile: %s", err)
+	}
+	commits := h.Git.Commits(clustertesting.RepoUrl, clustertesting.RepoBranch)
+	reverted, err := rollback(h)
+	if err != nil {
+		t.Fatalf("failed to roll back: %s", err)
+	}
+	// the profile change is split into the commit of the redis bundle and
+	// that of the rest of the cluster's directory
+	if len(reverted) != 2 || reverted[0] != commits[len(commits)-1].Hash ||
+		reverted[1] != commits[len(commits)-2].Hash {
+		t.Errorf("expected both commits of the profile change to be reverted, got %v", reverted)
+	}
+	if after := h.ClusterFiles("c1"); !reflect.DeepEqual(after, before) {
+		t.Error("expected the cluster directory to be restored as deployed")
+	}
+}
@@ -1,18 +1,37 @@
 package cluster
 
 import (
+	"arlon.io/arlon/pkg/bundle"
+	"arlon.io/arlon/pkg/gitutils"
+	"arlon.io/arlon/pkg/kuberetry"
 	"context"
 	"fmt"
+	applicationpkg "github.com/argoproj/argo-cd/v2/pkg/apiclient/application"
+	projectpkg "github.com/argoproj/argo-cd/v2/pkg/apiclient/project"
 	"github.com/argoproj/argo-cd/v2/pkg/apis/application"
 	argoappv1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	"github.com/argoproj/argo-cd/v2/util/glob"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gopkg.in/yaml.v2"
+	corev1api "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	corev1types "k8s.io/client-go/kubernetes/typed/core/v1"
 	"path"
+	"strings"
 )
 
+// ConstructRootApp returns the root application of a cluster, which is
+// created and updated through the ArgoCD API server so that the restrictions
+// of its ArgoCD project, RBAC and audit logging apply to it. An empty project
+// selects ArgoCD's default project. A destinationServer makes the cluster's
+// bundle applications target the workload cluster by server URL rather than
+// by name.
 func ConstructRootApp(
-	kubeClient *kubernetes.Clientset,
+	ctx context.Context,
+	kubeClient kubernetes.Interface,
 	argocdNs string,
 	arlonNs string,
 	clusterName string,
@@ -20,13 +39,20 @@ func ConstructRootApp(
 	repoBranch string,
 	basePath string,
 	clusterSpecName string,
+	profileName string,
+	project string,
+	destinationServer string,
 ) (*argoappv1.Application, error) {
 	corev1 := kubeClient.CoreV1()
-	configMapsApi := corev1.ConfigMaps(arlonNs)
-	cm, err := configMapsApi.Get(context.Background(), clusterSpecName, metav1.GetOptions{})
+	specData, err := getClusterSpecData(ctx, corev1, arlonNs, clusterSpecName)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get clusterspec configmap: %s", err)
+		return nil, err
 	}
+	if err := validateClusterSpec(specData); err != nil {
+		return nil, fmt.Errorf("invalid clusterspec %s: %w", clusterSpecName, err)
+	}
+	// label values can't hold a namespace qualified reference
+	specNs, specName := bundle.ParseRef(clusterSpecName, arlonNs)
 	app := &argoappv1.Application{
 		TypeMeta: v1.TypeMeta{
 			Kind:       application.ApplicationKind,
@@ -35,30 +61,42 @@ func ConstructRootApp(
 		ObjectMeta: v1.ObjectMeta{
 			Name: clusterName,
 			Namespace: argocdNs,
+			Labels: map[string]string{
+				"managed-by":     "arlon",
+				"arlon-type":     "cluster",
+				ClusterSpecLabel: specName,
+			},
 		},
 	}
-	keys := []string{
-		"region", "sshKeyName", "kubernetesVersion", "podCidrBlock", "nodeCount", "nodeType",
+	if specNs != arlonNs {
+		app.Labels[ClusterSpecNamespaceLabel] = specNs
 	}
-	helmParams := [] argoappv1.HelmParameter{
-		{
-			Name:  "clusterName",
-			Value: clusterName,
-		},
+	setProfileLabels(app, arlonNs, profileName)
+	helmParams := rootAppHelmParams(clusterName, specData)
+	if destinationServer != "" {
+		helmParams = append(helmParams, argoappv1.HelmParameter{
+			Name:  DestinationServerParam,
+			Value: destinationServer,
+		})
 	}
-	for _, key := range keys {
-		val := cm.Data[key]
-		if val != "" {
-			helmParams = append(helmParams, argoappv1.HelmParameter{
-				Name: key,
-				Value: val,
-			})
-		}
+	app.Spec.Source.Helm = &argoappv1.ApplicationSourceHelm{
+		Parameters: helmParams,
+		Values:     specData[helmValuesKey],
+	}
+	if project == "" {
+		project = argoappv1.DefaultAppProjectName
+	}
+	app.Spec.Project = project
+	if project != argoappv1.DefaultAppProjectName {
+		// bundle applications are placed in the root application's project
+		app.Spec.Source.Helm.Parameters = append(app.Spec.Source.Helm.Parameters, argoappv1.HelmParameter{
+			Name:  ProjectParam,
+			Value: project,
+		})
 	}
-	app.Spec.Source.Helm = &argoappv1.ApplicationSourceHelm{Parameters: helmParams}
 	app.Spec.Source.RepoURL = repoUrl
 	app.Spec.Source.TargetRevision = repoBranch
-	app.Spec.Source.Path = path.Join(basePath, clusterName, "mgmt")
+	app.Spec.Source.Path = path.Join(gitutils.SlashPath(basePath), clusterName, "mgmt")
 	app.Spec.Destination.Server = "https://kubernetes.default.svc"
 	app.Spec.Destination.Namespace = "default"
 	app.Spec.SyncPolicy = &argoappv1.SyncPolicy{
@@ -80,3 +118,342 @@ func ConstructRootApp(
 	}
 	return app, nil
 }
+
+// ProjectParam is the root application's Helm parameter that places the
+// applications of a cluster's bundles in the root application's ArgoCD
+// project. It is only set for projects other than the default one.
+const ProjectParam = "project"
+
+// CheckProject returns an error if the ArgoCD project of a root application
+// doesn't exist, or doesn't allow its source and destination or the
+// workload cluster targeted by the cluster's bundle applications, so that
+// callers can fail before pushing the cluster's git tree.
+func CheckProject(
+	ctx context.Context,
+	projIf projectpkg.ProjectServiceClient,
+	rootApp *argoappv1.Application,
+) error {
+	name := rootApp.Spec.Project
+	proj, err := projIf.Get(ctx, &projectpkg.ProjectQuery{Name: name})
+	if status.Code(err) == codes.NotFound {
+		return fmt.Errorf("ArgoCD project %s does not exist", name)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get ArgoCD project %s: %w", name, err)
+	}
+	if !proj.IsSourcePermitted(rootApp.Spec.Source) {
+		return fmt.Errorf("ArgoCD project %s does not allow repository %s", name, rootApp.Spec.Source.RepoURL)
+	}
+	if !proj.IsDestinationPermitted(rootApp.Spec.Destination) {
+		return fmt.Errorf("ArgoCD project %s does not allow destination %s, namespace %s", name,
+			rootApp.Spec.Destination.Server, rootApp.Spec.Destination.Namespace)
+	}
+	// bundle applications target the workload cluster in the namespaces
+	// chosen by their bundles
+	workload := argoappv1.ApplicationDestination{Name: rootApp.Name}
+	for _, param := range rootApp.Spec.Source.Helm.Parameters {
+		if param.Name == DestinationServerParam {
+			workload = argoappv1.ApplicationDestination{Server: param.Value}
+		}
+	}
+	for _, dest := range proj.Spec.Destinations {
+		// a destination allowing any server, like the one of the default
+		// project, allows clusters targeted by name too
+		if (workload.Name != "" && (glob.Match(dest.Name, workload.Name) || dest.Server == "*")) ||
+			(workload.Server != "" && glob.Match(dest.Server, workload.Server)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("ArgoCD project %s does not allow workload cluster %s%s", name, workload.Name, workload.Server)
+}
+
+// CheckRootApp returns an error if the root application of a cluster can't
+// be applied with ApplyRootApp, so that callers can fail before pushing the
+// cluster's git tree.
+func CheckRootApp(
+	ctx context.Context,
+	appIf applicationpkg.ApplicationServiceClient,
+	rootApp *argoappv1.Application,
+) error {
+	_, err := deployedRootApp(ctx, appIf, rootApp)
+	return err
+}
+
+// ApplyRootApp creates the root application of a cluster, or updates the
+// deployed one in place by replacing its labels and spec while keeping its
+// identity. The deployed cluster labels that rootApp doesn't set are kept,
+// so that redeploying a cluster doesn't ungroup it. Moving a deployed cluster to another repository location is not
+// supported. The deployment is recorded as an event on the application.
+// It returns whether the application was created.
+func ApplyRootApp(
+	ctx context.Context,
+	kubeClient kubernetes.Interface,
+	appIf applicationpkg.ApplicationServiceClient,
+	rootApp *argoappv1.Application,
+	commitSha string,
+) (created bool, err error) {
+	current, err := deployedRootApp(ctx, appIf, rootApp)
+	if err != nil {
+		return false, err
+	}
+	if current == nil {
+		app, err := appIf.Create(ctx, &applicationpkg.ApplicationCreateRequest{Application: *rootApp})
+		if err != nil {
+			return false, fmt.Errorf("failed to create ArgoCD root application: %w", err)
+		}
+		RecordEvent(ctx, kubeClient, app, corev1api.EventTypeNormal, ReasonDeployed, commitSha,
+			fmt.Sprintf("cluster deployed to %s", rootApp.Spec.Source.RepoURL))
+		return true, nil
+	}
+	updated := current.DeepCopy()
+	updated.Labels = make(map[string]string)
+	for key, val := range ClusterLabels(current) {
+		updated.Labels[key] = val
+	}
+	for key, val := range rootApp.Labels {
+		updated.Labels[key] = val
+	}
+	updated.Spec = rootApp.Spec
+	app, err := appIf.Update(ctx, &applicationpkg.ApplicationUpdateRequest{Application: updated})
+	if err != nil {
+		return false, fmt.Errorf("failed to update ArgoCD root application: %w", err)
+	}
+	RecordEvent(ctx, kubeClient, app, corev1api.EventTypeNormal, ReasonUpdated, commitSha,
+		"cluster updated in place")
+	return false, nil
+}
+
+// deployedRootApp returns the deployed root application rootApp would
+// update, or nil if the cluster isn't deployed.
+func deployedRootApp(
+	ctx context.Context,
+	appIf applicationpkg.ApplicationServiceClient,
+	rootApp *argoappv1.Application,
+) (*argoappv1.Application, error) {
+	current, err := appIf.Get(ctx, &applicationpkg.ApplicationQuery{Name: &rootApp.Name})
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get root application %s: %w", rootApp.Name, err)
+	}
+	if current.Labels["managed-by"] != "arlon" || current.Labels["arlon-type"] != "cluster" {
+		return nil, fmt.Errorf("application %s exists and isn't the root application of an arlon cluster",
+			rootApp.Name)
+	}
+	src, desired := current.Spec.Source, rootApp.Spec.Source
+	if src.RepoURL != desired.RepoURL || src.TargetRevision != desired.TargetRevision ||
+		src.Path != desired.Path {
+		return nil, fmt.Errorf("cluster %s is deployed to %s (branch %s, path %s), moving it is not supported",
+			rootApp.Name, src.RepoURL, src.TargetRevision, src.Path)
+	}
+	return current, nil
+}
+
+// Labels of root applications recording the cluster's clusterspec and
+// profile. Their namespace is only recorded if it isn't the arlon namespace.
+const (
+	ClusterSpecLabel          = "arlon-clusterspec"
+	ClusterSpecNamespaceLabel = "arlon-clusterspec-namespace"
+	ProfileLabel              = "arlon-profile"
+	ProfileNamespaceLabel     = "arlon-profile-namespace"
+)
+
+// setProfileLabels records the cluster's profile in its root application,
+// or removes it if profileName is empty.
+func setProfileLabels(app *argoappv1.Application, arlonNs string, profileName string) {
+	delete(app.Labels, ProfileLabel)
+	delete(app.Labels, ProfileNamespaceLabel)
+	if profileName == "" {
+		return
+	}
+	profileNs, name := bundle.ParseRef(profileName, arlonNs)
+	if app.Labels == nil {
+		app.Labels = make(map[string]string)
+	}
+	app.Labels[ProfileLabel] = name
+	if profileNs != arlonNs {
+		app.Labels[ProfileNamespaceLabel] = profileNs
+	}
+}
+
+// ProfileSelector selects the root applications of the clusters deployed
+// with a profile, narrowed to those whose labels match clusterSelector if
+// it isn't empty.
+func ProfileSelector(arlonNs string, profileName string, clusterSelector string) (string, error) {
+	profileNs, name := bundle.ParseRef(profileName, arlonNs)
+	selector := fmt.Sprintf("%s,%s=%s", RootAppSelector, ProfileLabel, name)
+	if profileNs != arlonNs {
+		selector = fmt.Sprintf("%s,%s=%s", selector, ProfileNamespaceLabel, profileNs)
+	} else {
+		selector = fmt.Sprintf("%s,!%s", selector, ProfileNamespaceLabel)
+	}
+	return restrictSelector(selector, clusterSelector)
+}
+
+// getClusterSpecData returns the data of the referenced clusterspec, resolved
+// against its chain of base specs. References may be namespace qualified;
+// unqualified base specs are looked up in the namespace of the spec naming them.
+func getClusterSpecData(
+	ctx context.Context,
+	corev1 corev1types.CoreV1Interface,
+	arlonNs string,
+	clusterSpecRef string,
+) (map[string]string, error) {
+	data, _, err := resolveClusterSpec(ctx, corev1, arlonNs, clusterSpecRef, nil)
+	return data, err
+}
+
+// resolveClusterSpec is getClusterSpecData with the spec of the same name and
+// namespace as override, if set, replaced by it. It also returns the
+// namespace qualified names of the specs of the chain.
+func resolveClusterSpec(
+	ctx context.Context,
+	corev1 corev1types.CoreV1Interface,
+	arlonNs string,
+	clusterSpecRef string,
+	override *corev1api.ConfigMap,
+) (map[string]string, []string, error) {
+	var chain []map[string]string
+	var names []string
+	visited := make(map[string]bool)
+	ns := arlonNs
+	for ref := clusterSpecRef; ref != ""; {
+		var name string
+		ns, name = bundle.ParseRef(ref, ns)
+		qualified := ns + "/" + name
+		if visited[qualified] {
+			return nil, nil, fmt.Errorf("clusterspec %s has a circular baseSpec chain through %s",
+				clusterSpecRef, ref)
+		}
+		visited[qualified] = true
+		names = append(names, qualified)
+		cm := override
+		if cm == nil || cm.Namespace != ns || cm.Name != name {
+			err := kuberetry.OnTransient(ctx, func() (err error) {
+				cm, err = corev1.ConfigMaps(ns).Get(ctx, name, metav1.GetOptions{})
+				return
+			})
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to get clusterspec configmap %s: %w", ref, err)
+			}
+		}
+		chain = append(chain, cm.Data)
+		ref = cm.Data["baseSpec"]
+	}
+	data := make(map[string]string)
+	// apply from the most basic spec up so that derived specs win
+	for i := len(chain) - 1; i >= 0; i-- {
+		for key, val := range chain[i] {
+			data[key] = val
+		}
+	}
+	delete(data, "baseSpec")
+	return data, names, nil
+}
+
+// validateClusterSpec checks the resolved clusterspec settings that need more
+// than being passed through to the cluster chart.
+func validateClusterSpec(specData map[string]string) error {
+	if _, err := autoscalingEnabled(specData); err != nil {
+		return err
+	}
+	capacityType := specData["capacityType"]
+	if capacityType != "" && capacityType != "spot" && capacityType != "on-demand" {
+		return fmt.Errorf("capacityType must be spot or on-demand, not %s", capacityType)
+	}
+	if err := validateHelmValues(specData[helmValuesKey]); err != nil {
+		return fmt.Errorf("invalid %s: %w", helmValuesKey, err)
+	}
+	if _, err := clusterChartApp(specData); err != nil {
+		return fmt.Errorf("invalid %s: %w", clusterChartKey, err)
+	}
+	return validateCni(specData)
+}
+
+// helmValuesKey is the clusterspec key holding a values YAML block that the
+// root application passes to the cluster chart as is, for nested settings
+// that can't be expressed as individual parameters. The parameters computed
+// from the other keys take precedence over it.
+const helmValuesKey = "helmValues"
+
+// validateHelmValues checks that values, if set, is a YAML map.
+func validateHelmValues(values string) error {
+	if strings.TrimSpace(values) == "" {
+		return nil
+	}
+	var parsed map[string]interface{}
+	if err := yaml.Unmarshal([]byte(values), &parsed); err != nil {
+		return fmt.Errorf("values must be a YAML map: %w", err)
+	}
+	return nil
+}
+
+// splitList splits a comma separated clusterspec value into its items.
+func splitList(val string) (items []string) {
+	for _, item := range strings.Split(val, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return
+}
+
+// listHelmParams returns indexed Helm parameters setting the chart's list
+// value of the given name.
+func listHelmParams(name string, items []string) (params []argoappv1.HelmParameter) {
+	for i, item := range items {
+		params = append(params, argoappv1.HelmParameter{
+			Name:  fmt.Sprintf("%s[%d]", name, i),
+			Value: item,
+		})
+	}
+	return
+}
+
+// rootAppSource returns the repository location a cluster was deployed to,
+// from the source of its root application.
+func rootAppSource(rootApp *argoappv1.Application) (repoUrl string, repoBranch string, basePath string) {
+	// root app path is {basePath}/{clusterName}/mgmt
+	basePath = path.Dir(path.Dir(rootApp.Spec.Source.Path))
+	return rootApp.Spec.Source.RepoURL, rootApp.Spec.Source.TargetRevision, basePath
+}
+
+// rootAppHelmParams returns the root application's Helm parameters, which
+// set the cluster chart's values from the clusterspec data.
+func rootAppHelmParams(clusterName string, specData map[string]string) []argoappv1.HelmParameter {
+	keys := []string{
+		"region", "sshKeyName", "kubernetesVersion", "podCidrBlock", "nodeCount", "nodeType",
+		"autoscaling", "minNodeCount", "maxNodeCount",
+		"cni", "serviceCidrBlock", "endpointPublicAccess", "endpointPrivateAccess",
+		"capacityType",
+	}
+	helmParams := [] argoappv1.HelmParameter{
+		{
+			Name:  "clusterName",
+			Value: clusterName,
+		},
+	}
+	for _, key := range keys {
+		val := specData[key]
+		if key == "nodeType" {
+			// a list of node types selects mixed instances; the first
+			// one remains the primary node type
+			val = strings.TrimSpace(strings.Split(val, ",")[0])
+		}
+		if val != "" {
+			helmParams = append(helmParams, argoappv1.HelmParameter{
+				Name: key,
+				Value: val,
+			})
+		}
+	}
+	if nodeTypes := splitList(specData["nodeType"]); len(nodeTypes) > 1 {
+		helmParams = append(helmParams, listHelmParams("instanceTypes", nodeTypes)...)
+	}
+	helmParams = append(helmParams, listHelmParams("availabilityZones",
+		splitList(specData["availabilityZones"]))...)
+	helmParams = append(helmParams, listHelmParams("subnets",
+		splitList(specData["subnets"]))...)
+	return helmParams
+}
@@ -0,0 +1,184 @@
+package cluster_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"arlon.io/arlon/pkg/cluster"
+	clustertesting "arlon.io/arlon/pkg/cluster/testing"
+	projectpkg "github.com/argoproj/argo-cd/v2/pkg/apiclient/project"
+	argoappv1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// projectClient serves the projects it holds from Get.
+type projectClient struct {
+	projectpkg.ProjectServiceClient
+	projects []argoappv1.AppProject
+}
+
+func (c *projectClient) Get(_ context.Context, q *projectpkg.ProjectQuery, _ ...grpc.CallOption) (*argoappv1.AppProject, error) {
+	for i := range c.projects {
+		if c.projects[i].Name == q.Name {
+			return &c.projects[i], nil
+		}
+	}
+	return nil, status.Errorf(codes.NotFound, "appproject %s not found", q.Name)
+}
+
+func newProject(name string, sourceRepo string, destinations ...argoappv1.ApplicationDestination) argoappv1.AppProject {
+	return argoappv1.AppProject{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: argoappv1.AppProjectSpec{
+			SourceRepos:  []string{sourceRepo},
+			Destinations: destinations,
+		},
+	}
+}
+
+func TestCheckProject(t *testing.T) {
+	h := clustertesting.New(t,
+		clustertesting.ClusterSpec(clustertesting.ArlonNs, "eks", map[string]string{"region": "us-west-2"}))
+	inCluster := argoappv1.ApplicationDestination{Server: "https://kubernetes.default.svc", Namespace: "*"}
+	projects := &projectClient{projects: []argoappv1.AppProject{
+		newProject("team-a", clustertesting.RepoUrl, inCluster, argoappv1.ApplicationDestination{Name: "c*", Namespace: "*"}),
+		newProject("team-b", clustertesting.RepoUrl, inCluster, argoappv1.ApplicationDestination{Name: "other", Namespace: "*"}),
+		newProject("team-c", "https://git.example.com/other.git", inCluster),
+		// the default project ArgoCD is installed with
+		newProject(argoappv1.DefaultAppProjectName, "*", argoappv1.ApplicationDestination{Server: "*", Namespace: "*"}),
+	}}
+	for _, tc := range []struct {
+		project string
+		err     string
+	}{
+		{"team-a", ""},
+		{argoappv1.DefaultAppProjectName, ""},
+		{"team-b", "does not allow workload cluster c1"},
+		{"team-c", "does not allow repository"},
+		{"missing", "ArgoCD project missing does not exist"},
+	} {
+		rootApp, err := cluster.ConstructRootApp(context.Background(), h.KubeClient, clustertesting.ArgocdNs,
+			clustertesting.ArlonNs, "c1", clustertesting.RepoUrl, clustertesting.RepoBranch, clustertesting.BasePath,
+			"eks", "", tc.project, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var param string
+		for _, p := range rootApp.Spec.Source.Helm.Parameters {
+			if p.Name == cluster.ProjectParam {
+				param = p.Value
+			}
+		}
+		expectedParam := tc.project
+		if tc.project == argoappv1.DefaultAppProjectName {
+			expectedParam = ""
+		}
+		if param != expectedParam {
+			t.Errorf("expected the bundle applications to be placed in project %q, got %q", expectedParam, param)
+		}
+		err = cluster.CheckProject(context.Background(), projects, rootApp)
+		if tc.err == "" && err != nil {
+			t.Errorf("project %s: unexpected error %s", tc.project, err)
+		}
+		if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+			t.Errorf("project %s: expected error %q, got %v", tc.project, tc.err, err)
+		}
+	}
+}
+
+// helmParams returns the Helm parameters of a root application by name.
+func helmParams(rootApp *argoappv1.Application) map[string]string {
+	params := make(map[string]string)
+	for _, p := range rootApp.Spec.Source.Helm.Parameters {
+		params[p.Name] = p.Value
+	}
+	return params
+}
+
+func TestConstructRootAppBaseSpec(t *testing.T) {
+	h := clustertesting.New(t,
+		clustertesting.ClusterSpec(clustertesting.ArlonNs, "common",
+			map[string]string{"region": "us-west-2", "kubernetesVersion": "1.21", "nodeCount": "2"}),
+		clustertesting.ClusterSpec("team-a", "base",
+			map[string]string{"baseSpec": "arlon/common", "nodeType": "m5.large"}),
+		clustertesting.ClusterSpec("team-a", "large",
+			map[string]string{"baseSpec": "base", "nodeCount": "6"}),
+		clustertesting.ClusterSpec(clustertesting.ArlonNs, "loop-a", map[string]string{"baseSpec": "loop-b"}),
+		clustertesting.ClusterSpec(clustertesting.ArlonNs, "loop-b", map[string]string{"baseSpec": "loop-a"}),
+	)
+	rootApp, err := cluster.ConstructRootApp(context.Background(), h.KubeClient, clustertesting.ArgocdNs,
+		clustertesting.ArlonNs, "c1", clustertesting.RepoUrl, clustertesting.RepoBranch, clustertesting.BasePath,
+		"team-a/large", "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := helmParams(rootApp)
+	// unqualified base specs are looked up in the namespace of the spec naming them
+	for name, expected := range map[string]string{
+		"region":            "us-west-2",
+		"kubernetesVersion": "1.21",
+		"nodeType":          "m5.large",
+		"nodeCount":         "6",
+	} {
+		if params[name] != expected {
+			t.Errorf("expected parameter %s to be %q, got %q", name, expected, params[name])
+		}
+	}
+	if _, ok := params["baseSpec"]; ok {
+		t.Error("expected baseSpec not to be passed to the cluster chart")
+	}
+	if rootApp.Labels[cluster.ClusterSpecLabel] != "large" ||
+		rootApp.Labels[cluster.ClusterSpecNamespaceLabel] != "team-a" {
+		t.Errorf("unexpected clusterspec labels %v", rootApp.Labels)
+	}
+	_, err = cluster.ConstructRootApp(context.Background(), h.KubeClient, clustertesting.ArgocdNs,
+		clustertesting.ArlonNs, "c1", clustertesting.RepoUrl, clustertesting.RepoBranch, clustertesting.BasePath,
+		"loop-a", "", "", "")
+	if err == nil || !strings.Contains(err.Error(), "circular baseSpec chain") {
+		t.Errorf("expected a circular chain to be rejected, got %v", err)
+	}
+}
+
+func TestConstructRootAppMixedInstances(t *testing.T) {
+	h := clustertesting.New(t,
+		clustertesting.ClusterSpec(clustertesting.ArlonNs, "spot", map[string]string{
+			"nodeType":          "m5.large, m5a.large,m4.large",
+			"capacityType":      "spot",
+			"availabilityZones": "us-west-2a,us-west-2b",
+			"subnets":           "subnet-1",
+		}),
+		clustertesting.ClusterSpec(clustertesting.ArlonNs, "invalid", map[string]string{"capacityType": "reserved"}),
+	)
+	rootApp, err := cluster.ConstructRootApp(context.Background(), h.KubeClient, clustertesting.ArgocdNs,
+		clustertesting.ArlonNs, "c1", clustertesting.RepoUrl, clustertesting.RepoBranch, clustertesting.BasePath,
+		"spot", "", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := helmParams(rootApp)
+	for name, expected := range map[string]string{
+		// the first node type remains the primary one
+		"nodeType":             "m5.large",
+		"instanceTypes[0]":     "m5.large",
+		"instanceTypes[1]":     "m5a.large",
+		"instanceTypes[2]":     "m4.large",
+		"capacityType":         "spot",
+		"availabilityZones[0]": "us-west-2a",
+		"availabilityZones[1]": "us-west-2b",
+		"subnets[0]":           "subnet-1",
+	} {
+		if params[name] != expected {
+			t.Errorf("expected parameter %s to be %q, got %q", name, expected, params[name])
+		}
+	}
+	_, err = cluster.ConstructRootApp(context.Background(), h.KubeClient, clustertesting.ArgocdNs,
+		clustertesting.ArlonNs, "c1", clustertesting.RepoUrl, clustertesting.RepoBranch, clustertesting.BasePath,
+		"invalid", "", "", "")
+	if err == nil || !strings.Contains(err.Error(), "capacityType must be spot or on-demand") {
+		t.Errorf("expected an invalid capacity type to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	applicationpkg "github.com/argoproj/argo-cd/v2/pkg/apiclient/application"
+	corev1api "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sort"
+	"strings"
+)
+
+// disruptiveParams lists the cluster chart values whose change replaces the
+// workload cluster, its network or its nodes.
+var disruptiveParams = map[string]bool{
+	"region":            true,
+	"podCidrBlock":      true,
+	"serviceCidrBlock":  true,
+	"cni":               true,
+	"availabilityZones": true,
+	"subnets":           true,
+	"kubernetesVersion": true,
+	"nodeType":          true,
+	"instanceTypes":     true,
+	"capacityType":      true,
+}
+
+// Change is a change of a clusterspec key or of a root application's Helm
+// parameter. Old is empty for an added one, and New for a removed one.
+type Change struct {
+	Name       string
+	Old        string
+	New        string
+	Disruptive bool
+}
+
+// ClusterImpact describes how a proposed clusterspec would change the root
+// application of a deployed cluster.
+type ClusterImpact struct {
+	ClusterName string
+	Changes     []Change
+}
+
+// ClusterSpecImpact compares a proposed version of a clusterspec with the
+// stored one, without applying it. It returns the changes of the spec's keys,
+// and for each deployed cluster whose spec is or derives from it, the
+// changes to its root application's Helm parameters. Clusters whose
+// parameters wouldn't change are left out.
+func ClusterSpecImpact(
+	ctx context.Context,
+	kubeClient kubernetes.Interface,
+	appIf applicationpkg.ApplicationServiceClient,
+	arlonNs string,
+	proposed *corev1api.ConfigMap,
+) (specChanges []Change, impacts []ClusterImpact, err error) {
+	corev1 := kubeClient.CoreV1()
+	if proposed.Namespace == "" {
+		proposed.Namespace = arlonNs
+	}
+	qualified := proposed.Namespace + "/" + proposed.Name
+	current, err := corev1.ConfigMaps(proposed.Namespace).Get(ctx, proposed.Name, metav1.GetOptions{})
+	if err != nil && !apierr.IsNotFound(err) {
+		return nil, nil, fmt.Errorf("failed to get clusterspec configmap %s: %w", qualified, err)
+	}
+	var currentData map[string]string
+	if err == nil {
+		currentData = current.Data
+	}
+	specChanges = diffMaps(currentData, proposed.Data)
+	specData, _, err := resolveClusterSpec(ctx, corev1, arlonNs, qualified, proposed)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := validateClusterSpec(specData); err != nil {
+		return nil, nil, fmt.Errorf("invalid clusterspec %s: %w", qualified, err)
+	}
+	apps, err := appIf.List(ctx, &applicationpkg.ApplicationQuery{
+		Selector: "managed-by=arlon,arlon-type=cluster"})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list root applications: %w", err)
+	}
+	for _, app := range apps.Items {
+		specRef := rootAppClusterSpec(&app, arlonNs)
+		if specRef == "" {
+			continue
+		}
+		data, chain, err := resolveClusterSpec(ctx, corev1, arlonNs, specRef, proposed)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to resolve clusterspec of cluster %s: %w", app.Name, err)
+		}
+		if !hasString(chain, qualified) {
+			continue
+		}
+		if err := validateClusterSpec(data); err != nil {
+			return nil, nil, fmt.Errorf("invalid clusterspec for cluster %s: %w", app.Name, err)
+		}
+		deployed := make(map[string]string)
+		if helm := app.Spec.Source.Helm; helm != nil {
+			for _, param := range helm.Parameters {
+				deployed[param.Name] = param.Value
+			}
+			deployed[helmValuesKey] = helm.Values
+		}
+		updated := map[string]string{helmValuesKey: data[helmValuesKey]}
+		for _, param := range rootAppHelmParams(app.Name, data) {
+			updated[param.Name] = param.Value
+		}
+		// the destination server and project don't come from the clusterspec
+		delete(deployed, DestinationServerParam)
+		delete(deployed, ProjectParam)
+		if changes := diffMaps(deployed, updated); len(changes) > 0 {
+			impacts = append(impacts, ClusterImpact{ClusterName: app.Name, Changes: changes})
+		}
+	}
+	sort.Slice(impacts, func(i, j int) bool {
+		return impacts[i].ClusterName < impacts[j].ClusterName
+	})
+	return specChanges, impacts, nil
+}
+
+// diffMaps returns the changes from one set of values to another, sorted
+// by name.
+func diffMaps(from map[string]string, to map[string]string) (changes []Change) {
+	for name, val := range to {
+		if from[name] != val {
+			changes = append(changes, Change{Name: name, Old: from[name], New: val})
+		}
+	}
+	for name, val := range from {
+		if _, ok := to[name]; !ok && val != "" {
+			changes = append(changes, Change{Name: name, Old: val})
+		}
+	}
+	for i := range changes {
+		// list values are set by indexed parameters, for e.g. subnets[0]
+		changes[i].Disruptive = disruptiveParams[strings.SplitN(changes[i].Name, "[", 2)[0]]
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Name < changes[j].Name })
+	return
+}
+
+func hasString(items []string, s string) bool {
+	for _, item := range items {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
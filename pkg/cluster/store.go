@@ -0,0 +1,94 @@
+package cluster
+
+import (
+	"arlon.io/arlon/pkg/bundle"
+	"arlon.io/arlon/pkg/gitutils"
+	"arlon.io/arlon/pkg/kuberetry"
+	"arlon.io/arlon/pkg/progress"
+	"context"
+	"fmt"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	corev1api "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1types "k8s.io/client-go/kubernetes/typed/core/v1"
+	"path"
+	"strings"
+)
+
+// loadStore returns the store of bundles and profiles selected by the
+// bundle.StoreConfigMapName ConfigMap. A git store is cloned with the
+// credentials of its ArgoCD repository.
+func loadStore(
+	ctx context.Context,
+	corev1 corev1types.CoreV1Interface,
+	argocdNs string,
+	arlonNs string,
+) (bundle.Store, error) {
+	var cm *corev1api.ConfigMap
+	err := kuberetry.OnTransient(ctx, func() (err error) {
+		cm, err = corev1.ConfigMaps(arlonNs).Get(ctx, bundle.StoreConfigMapName, metav1.GetOptions{})
+		return
+	})
+	if apierr.IsNotFound(err) {
+		return bundle.NewKubeStore(corev1), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get store configmap: %w", err)
+	}
+	switch cm.Data["type"] {
+	case "", "kubernetes":
+		return bundle.NewKubeStore(corev1), nil
+	case "git":
+	default:
+		return nil, fmt.Errorf("invalid store type %s", cm.Data["type"])
+	}
+	repoUrl, repoBranch := cm.Data["repoUrl"], cm.Data["repoBranch"]
+	if repoUrl == "" {
+		return nil, fmt.Errorf("git store has no repoUrl")
+	}
+	if repoBranch == "" {
+		repoBranch = "main"
+	}
+	creds, err := getRepoCreds(ctx, corev1, argocdNs, repoUrl)
+	if err != nil {
+		return nil, err
+	}
+	progress.Step(ctx, "cloning bundle store %s (branch %s)", repoUrl, repoBranch)
+	repo := gitutils.NewRepo()
+	defer repo.Close()
+	err = repo.Clone(ctx, repoUrl, repoBranch, creds.auth())
+	if err != nil {
+		return nil, fmt.Errorf("failed to clone bundle store: %w", err)
+	}
+	fsys, err := copyStore(repo.Worktree(), cm.Data["path"])
+	if err != nil {
+		return nil, err
+	}
+	return bundle.NewGitStore(fsys, cm.Data["path"]), nil
+}
+
+// copyStore copies the files below storePath into memory so that the clone
+// they were read from can be removed.
+func copyStore(worktree billy.Filesystem, storePath string) (billy.Filesystem, error) {
+	var files []string
+	if err := listFiles(worktree, path.Clean("/"+storePath), &files); err != nil {
+		return nil, err
+	}
+	fsys := memfs.New()
+	for _, filePath := range files {
+		if strings.HasPrefix(filePath, "/.git/") {
+			continue
+		}
+		data, err := util.ReadFile(worktree, filePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", filePath, err)
+		}
+		if err := writeFile(fsys, filePath, data); err != nil {
+			return nil, err
+		}
+	}
+	return fsys, nil
+}
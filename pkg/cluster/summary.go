@@ -0,0 +1,216 @@
+package cluster
+
+import (
+	"arlon.io/arlon/pkg/version"
+	"fmt"
+	"github.com/go-git/go-billy/v5"
+	"gopkg.in/yaml.v2"
+	"io"
+	"path"
+	"sort"
+	"text/template"
+)
+
+// SummaryFileName is the name of the machine readable summary written to
+// {basePath}/{clusterName}/ by DeployToGit. A README.md rendered from the
+// same data is written next to it.
+const SummaryFileName = "arlon-cluster.yaml"
+
+// Summary records what was deployed for a cluster, so that the git
+// repository documents itself and later commands can read it back.
+type Summary struct {
+	ClusterName       string            `yaml:"clusterName"`
+	RepoUrl           string            `yaml:"repoUrl"`
+	RepoBranch        string            `yaml:"repoBranch"`
+	BasePath          string            `yaml:"basePath"`
+	ClusterSpec       string            `yaml:"clusterSpec,omitempty"`
+	ClusterSpecValues map[string]string `yaml:"clusterSpecValues,omitempty"`
+	Profile           string            `yaml:"profile,omitempty"`
+	Bundles           []BundleSummary   `yaml:"bundles,omitempty"`
+	ArlonVersion      string            `yaml:"arlonVersion"`
+}
+
+type BundleSummary struct {
+	Name      string `yaml:"name"`
+	Namespace string `yaml:"namespace,omitempty"`
+	// Type is inline or reference for profile bundles, chart for the
+	// bundles generated from the clusterspec
+	Type string `yaml:"type"`
+	// Hash is the bundle.ContentHash of a profile bundle
+	Hash     string `yaml:"hash,omitempty"`
+	RepoUrl  string `yaml:"repoUrl,omitempty"`
+	RepoPath string `yaml:"repoPath,omitempty"`
+	Chart    string `yaml:"chart,omitempty"`
+	Version  string `yaml:"version,omitempty"`
+}
+
+const readmeTmpl = `# {{.ClusterName}}
+
+This directory was generated by arlon {{.ArlonVersion}}. Do not edit it by
+hand: it is overwritten when the cluster is deployed again.
+See [{{.SummaryFileName}}]({{.SummaryFileName}}) for a machine readable version.
+
+- Cluster spec: {{if .ClusterSpec}}{{.ClusterSpec}}{{else}}(none){{end}}
+- Profile: {{if .Profile}}{{.Profile}}{{else}}(none){{end}}
+{{- if .SpecKeys}}
+
+## Cluster spec values
+
+| Key | Value |
+| --- | ----- |
+{{- range .SpecKeys}}
+| {{.}} | {{index $.ClusterSpecValues .}} |
+{{- end}}
+{{- end}}
+{{- if .Bundles}}
+
+## Bundles
+
+| Name | Type | Source |
+| ---- | ---- | ------ |
+{{- range .Bundles}}
+| {{.Name}} | {{.Type}} | {{if .Chart}}{{.RepoUrl}} {{.Chart}} {{.Version}}{{else if .RepoUrl}}{{.RepoUrl}} {{.RepoPath}}{{else}}sha256:{{.Hash}}{{end}} |
+{{- end}}
+{{- end}}
+`
+
+// -----------------------------------------------------------------------------
+
+// newSummary summarizes a cluster from what is rendered for it: the resolved
+// data of its clusterspec, the bundles of its profile and the ones implied by
+// its clusterspec.
+func newSummary(
+	clusterName string,
+	repoUrl string,
+	repoBranch string,
+	basePath string,
+	profileName string,
+	clusterSpecName string,
+	specData map[string]string,
+	inlineBundles []inlineBundle,
+	refBundles []AppSettings,
+	specBundles []AppSettings,
+) *Summary {
+	summary := &Summary{
+		ClusterName:       clusterName,
+		RepoUrl:           repoUrl,
+		RepoBranch:        repoBranch,
+		BasePath:          basePath,
+		ClusterSpec:       clusterSpecName,
+		ClusterSpecValues: specData,
+		Profile:           profileName,
+		ArlonVersion:      version.Version,
+		Bundles:           profileBundleSummaries(inlineBundles, refBundles),
+	}
+	for _, app := range specBundles {
+		summary.Bundles = append(summary.Bundles, BundleSummary{
+			Name:    app.BundleName,
+			Type:    "chart",
+			RepoUrl: app.RepoUrl,
+			Chart:   app.Chart,
+			Version: app.TargetRevision,
+		})
+	}
+	return summary
+}
+
+// profileBundleSummaries returns the summaries of the rendered bundles of a
+// profile, inline ones first.
+func profileBundleSummaries(inlineBundles []inlineBundle, refBundles []AppSettings) (bundles []BundleSummary) {
+	for _, b := range inlineBundles {
+		bundles = append(bundles, BundleSummary{
+			Name:      b.name,
+			Namespace: b.namespace,
+			Type:      "inline",
+			Hash:      b.hash,
+		})
+	}
+	for _, app := range refBundles {
+		bundles = append(bundles, BundleSummary{
+			Name:      app.BundleName,
+			Namespace: app.BundleNamespace,
+			Type:      "reference",
+			RepoUrl:   app.RepoUrl,
+			RepoPath:  app.RepoPath,
+			Chart:     app.Chart,
+			Version:   app.TargetRevision,
+			Hash:      app.BundleHash,
+		})
+	}
+	return
+}
+
+// -----------------------------------------------------------------------------
+
+// writeSummary writes the summary and its README into the cluster directory.
+func writeSummary(fs billy.Filesystem, clusterPath string, summary *Summary) error {
+	data, err := yaml.Marshal(summary)
+	if err != nil {
+		return fmt.Errorf("failed to marshal cluster summary: %w", err)
+	}
+	err = writeFile(fs, path.Join(clusterPath, SummaryFileName), data)
+	if err != nil {
+		return err
+	}
+	tmpl, err := template.New("readme").Parse(readmeTmpl)
+	if err != nil {
+		return fmt.Errorf("failed to parse readme template: %w", err)
+	}
+	var specKeys []string
+	for key := range summary.ClusterSpecValues {
+		specKeys = append(specKeys, key)
+	}
+	sort.Strings(specKeys)
+	f, err := fs.Create(path.Join(clusterPath, "README.md"))
+	if err != nil {
+		return fmt.Errorf("failed to create README.md: %w", err)
+	}
+	defer f.Close()
+	err = tmpl.Execute(f, struct {
+		*Summary
+		SummaryFileName string
+		SpecKeys        []string
+	}{summary, SummaryFileName, specKeys})
+	if err != nil {
+		return fmt.Errorf("failed to render README.md: %w", err)
+	}
+	return nil
+}
+
+func writeFile(fs billy.Filesystem, filePath string, data []byte) error {
+	f, err := fs.Create(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", filePath, err)
+	}
+	defer f.Close()
+	_, err = f.Write(data)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", filePath, err)
+	}
+	return nil
+}
+
+// ReadSummary reads back the summary of a cluster deployed to the
+// repository checked out in fs. It returns nil if there is none, for
+// clusters deployed by older versions of arlon.
+func ReadSummary(fs billy.Filesystem, basePath string, clusterName string) (*Summary, error) {
+	summaryPath := path.Join(basePath, clusterName, SummaryFileName)
+	f, err := fs.Open(summaryPath)
+	if err != nil {
+		if _, statErr := fs.Stat(summaryPath); statErr != nil {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to open %s: %w", summaryPath, err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", summaryPath, err)
+	}
+	var summary Summary
+	err = yaml.Unmarshal(data, &summary)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", summaryPath, err)
+	}
+	return &summary, nil
+}
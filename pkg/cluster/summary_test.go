@@ -0,0 +1,54 @@
+package cluster_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"arlon.io/arlon/pkg/cluster"
+	clustertesting "arlon.io/arlon/pkg/cluster/testing"
+)
+
+func TestReadSummary(t *testing.T) {
+	h := clustertesting.New(t,
+		clustertesting.ClusterSpec(clustertesting.ArlonNs, "eks", map[string]string{
+			"region":       "us-west-2",
+			"autoscaling":  "true",
+			"minNodeCount": "1",
+			"maxNodeCount": "3",
+		}),
+		clustertesting.InlineBundle(clustertesting.ArlonNs, "guestbook", "kind: ConfigMap\n"),
+		clustertesting.ChartBundle(clustertesting.ArlonNs, "nginx", "https://charts.example.com", "nginx", "1.0"),
+		clustertesting.Profile(clustertesting.ArlonNs, "dev", "guestbook", "nginx"),
+	)
+	h.Deploy("c1", "dev", "eks")
+	repo := h.Git.NewRepo()
+	defer repo.Close()
+	if err := repo.Clone(context.Background(), clustertesting.RepoUrl, clustertesting.RepoBranch, nil); err != nil {
+		t.Fatal(err)
+	}
+	summary, err := cluster.ReadSummary(repo.Worktree(), clustertesting.BasePath, "c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary == nil || summary.ClusterName != "c1" || summary.Profile != "dev" || summary.ClusterSpec != "eks" ||
+		summary.ArlonVersion != clustertesting.ArlonVersion || summary.ClusterSpecValues["region"] != "us-west-2" {
+		t.Fatalf("unexpected summary %+v", summary)
+	}
+	var bundles []string
+	for _, b := range summary.Bundles {
+		bundles = append(bundles, b.Name+" "+b.Type)
+		if b.Type == "inline" && b.Hash == "" {
+			t.Errorf("expected inline bundle %s to record its hash", b.Name)
+		}
+	}
+	// the bundles of the profile come first, inline ones first
+	expected := []string{"guestbook inline", "nginx reference", "cluster-autoscaler chart"}
+	if !reflect.DeepEqual(bundles, expected) {
+		t.Errorf("expected bundles %v, got %v", expected, bundles)
+	}
+	summary, err = cluster.ReadSummary(repo.Worktree(), clustertesting.BasePath, "c2")
+	if err != nil || summary != nil {
+		t.Errorf("expected no summary for a cluster that isn't deployed, got %+v (%v)", summary, err)
+	}
+}
@@ -0,0 +1,119 @@
+package testing
+
+import (
+	"context"
+	applicationpkg "github.com/argoproj/argo-cd/v2/pkg/apiclient/application"
+	argoappv1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/labels"
+	"sort"
+	"sync"
+)
+
+// AppClient is an in-memory ArgoCD application service holding the
+// applications created through it. Only Get, List, Create, Update and
+// Delete are implemented, the other methods panic.
+type AppClient struct {
+	applicationpkg.ApplicationServiceClient
+	mu   sync.Mutex
+	apps map[string]*argoappv1.Application
+}
+
+// NewAppClient returns an AppClient holding apps.
+func NewAppClient(apps ...argoappv1.Application) *AppClient {
+	c := &AppClient{apps: make(map[string]*argoappv1.Application)}
+	for i := range apps {
+		c.apps[apps[i].Name] = apps[i].DeepCopy()
+	}
+	return c
+}
+
+// Apps returns the applications, sorted by name.
+func (c *AppClient) Apps() []argoappv1.Application {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var apps []argoappv1.Application
+	for _, app := range c.apps {
+		apps = append(apps, *app.DeepCopy())
+	}
+	sort.Slice(apps, func(i, j int) bool { return apps[i].Name < apps[j].Name })
+	return apps
+}
+
+func (c *AppClient) Get(
+	_ context.Context,
+	query *applicationpkg.ApplicationQuery,
+	_ ...grpc.CallOption,
+) (*argoappv1.Application, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	app, ok := c.apps[query.GetName()]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "applications.argoproj.io %q not found", query.GetName())
+	}
+	return app.DeepCopy(), nil
+}
+
+func (c *AppClient) List(
+	_ context.Context,
+	query *applicationpkg.ApplicationQuery,
+	_ ...grpc.CallOption,
+) (*argoappv1.ApplicationList, error) {
+	selector, err := labels.Parse(query.Selector)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid selector: %s", err)
+	}
+	list := &argoappv1.ApplicationList{}
+	for _, app := range c.Apps() {
+		if selector.Matches(labels.Set(app.Labels)) {
+			list.Items = append(list.Items, app)
+		}
+	}
+	return list, nil
+}
+
+func (c *AppClient) Create(
+	_ context.Context,
+	req *applicationpkg.ApplicationCreateRequest,
+	_ ...grpc.CallOption,
+) (*argoappv1.Application, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.apps[req.Application.Name]; ok && !req.GetUpsert() {
+		return nil, status.Errorf(codes.AlreadyExists, "application %s already exists", req.Application.Name)
+	}
+	app := req.Application.DeepCopy()
+	c.apps[app.Name] = app
+	return app.DeepCopy(), nil
+}
+
+func (c *AppClient) Update(
+	_ context.Context,
+	req *applicationpkg.ApplicationUpdateRequest,
+	_ ...grpc.CallOption,
+) (*argoappv1.Application, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.apps[req.Application.Name]; !ok {
+		return nil, status.Errorf(codes.NotFound, "applications.argoproj.io %q not found", req.Application.Name)
+	}
+	app := req.Application.DeepCopy()
+	c.apps[app.Name] = app
+	return app.DeepCopy(), nil
+}
+
+func (c *AppClient) Delete(
+	_ context.Context,
+	req *applicationpkg.ApplicationDeleteRequest,
+	_ ...grpc.CallOption,
+) (*applicationpkg.ApplicationResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.apps[req.GetName()]; !ok {
+		return nil, status.Errorf(codes.NotFound, "applications.argoproj.io %q not found", req.GetName())
+	}
+	delete(c.apps, req.GetName())
+	return &applicationpkg.ApplicationResponse{}, nil
+}
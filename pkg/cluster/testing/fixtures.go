@@ -0,0 +1,96 @@
+package testing
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"strings"
+)
+
+// RepoSecret returns the ArgoCD secret registering a git repository, which
+// arlon reads the repository's credentials from.
+func RepoSecret(argocdNs string, repoUrl string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "repo-" + strings.NewReplacer(":", "-", "/", "-", ".", "-").Replace(repoUrl),
+			Namespace: argocdNs,
+			Labels:    map[string]string{"argocd.argoproj.io/secret-type": "repository"},
+		},
+		Data: map[string][]byte{"url": []byte(repoUrl)},
+	}
+}
+
+// ClusterSpec returns a clusterspec with the settings of data.
+func ClusterSpec(ns string, name string, data map[string]string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: ns,
+			Labels:    map[string]string{"managed-by": "arlon", "arlon-type": "clusterspec"},
+		},
+		Data: data,
+	}
+}
+
+// Profile returns a profile listing bundles, which may be namespace
+// qualified.
+func Profile(ns string, name string, bundles ...string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: ns,
+			Labels: map[string]string{
+				"managed-by":   "arlon",
+				"arlon-type":   "profile",
+				"profile-type": "configuration",
+			},
+		},
+		Data: map[string]string{"bundles": strings.Join(bundles, ",")},
+	}
+}
+
+// InlineBundle returns a bundle holding the manifests of data.
+func InlineBundle(ns string, name string, data string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: ns,
+			Labels: map[string]string{
+				"managed-by":  "arlon",
+				"arlon-type":  "config-bundle",
+				"bundle-type": "inline",
+			},
+			Annotations: map[string]string{},
+		},
+		Data: map[string][]byte{"data": []byte(data)},
+	}
+}
+
+// ReferenceBundle returns a bundle referencing the manifests at repoPath in
+// a git repository, at revision.
+func ReferenceBundle(ns string, name string, repoUrl string, repoPath string, revision string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: ns,
+			Labels: map[string]string{
+				"managed-by":  "arlon",
+				"arlon-type":  "config-bundle",
+				"bundle-type": "reference",
+			},
+			Annotations: map[string]string{
+				"repo-url":      repoUrl,
+				"repo-path":     repoPath,
+				"repo-revision": revision,
+			},
+		},
+	}
+}
+
+// ChartBundle returns a bundle referencing a Helm chart of a chart
+// repository, at version.
+func ChartBundle(ns string, name string, repoUrl string, chart string, version string) *corev1.Secret {
+	secret := ReferenceBundle(ns, name, repoUrl, "", version)
+	delete(secret.Annotations, "repo-path")
+	secret.Annotations["repo-chart"] = chart
+	return secret
+}
@@ -0,0 +1,142 @@
+// Package testing provides a harness for regression tests of what arlon
+// renders for clusters, for contributors and for tools embedding arlon: a
+// fake clientset pre-loaded with the arlon resources of a test, in-memory
+// git and ArgoCD application services, and golden file snapshots of the
+// rendered git tree and root applications.
+package testing
+
+import (
+	"arlon.io/arlon/pkg/cluster"
+	"arlon.io/arlon/pkg/gitutils/fake"
+	"arlon.io/arlon/pkg/version"
+	"context"
+	argoappv1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"strings"
+	stdtesting "testing"
+)
+
+// The namespaces and repository location clusters are deployed with.
+const (
+	ArgocdNs   = "argocd"
+	ArlonNs    = "arlon"
+	RepoUrl    = "https://git.example.com/fleet.git"
+	RepoBranch = "main"
+	BasePath   = "clusters"
+)
+
+// ArlonVersion is the arlon version that clusters are rendered with while a
+// harness is in use, so that snapshots don't depend on the version of the
+// arlon build running the tests.
+const ArlonVersion = "v0.0.0-test"
+
+// Harness deploys clusters as the arlon CLI does, to its in-memory git
+// server and ArgoCD application service. Its methods fail the test on
+// errors.
+type Harness struct {
+	KubeClient *k8sfake.Clientset
+	Git        *fake.Server
+	Apps       *AppClient
+	t          stdtesting.TB
+}
+
+// New returns a harness whose clientset holds objects, such as the bundles,
+// profiles and clusterspecs built by this package, along with the ArgoCD
+// secret registering RepoUrl, which has an empty RepoBranch. The arlon
+// version is ArlonVersion until the test ends.
+func New(t stdtesting.TB, objects ...runtime.Object) *Harness {
+	t.Helper()
+	savedVersion := version.Version
+	version.Version = ArlonVersion
+	t.Cleanup(func() { version.Version = savedVersion })
+	objects = append([]runtime.Object{RepoSecret(ArgocdNs, RepoUrl)}, objects...)
+	git := fake.NewServer()
+	git.CreateBranch(RepoUrl, RepoBranch, nil)
+	return &Harness{
+		KubeClient: k8sfake.NewSimpleClientset(objects...),
+		Git:        git,
+		Apps:       NewAppClient(),
+		t:          t,
+	}
+}
+
+// Deploy deploys a cluster like arlon cluster deploy: it pushes the cluster's
+// git tree, then creates or updates its root application. It returns the
+// hash of the pushed commit, empty if the tree didn't change.
+func (h *Harness) Deploy(clusterName string, profileName string, clusterSpecName string) string {
+	h.t.Helper()
+	ctx := context.Background()
+	rootApp, err := cluster.ConstructRootApp(ctx, h.KubeClient, ArgocdNs, ArlonNs, clusterName,
+		RepoUrl, RepoBranch, BasePath, clusterSpecName, profileName, "", "")
+	if err != nil {
+		h.t.Fatalf("failed to construct root app of cluster %s: %s", clusterName, err)
+	}
+	repo := h.Git.NewRepo()
+	defer repo.Close()
+	commitSha, err := cluster.DeployToGit(ctx, h.KubeClient, repo, ArgocdNs, ArlonNs,
+		clusterName, RepoUrl, RepoBranch, BasePath, profileName, clusterSpecName, "")
+	if err != nil {
+		h.t.Fatalf("failed to deploy cluster %s: %s", clusterName, err)
+	}
+	_, err = cluster.ApplyRootApp(ctx, h.KubeClient, h.Apps, rootApp, commitSha)
+	if err != nil {
+		h.t.Fatalf("failed to apply root app of cluster %s: %s", clusterName, err)
+	}
+	return commitSha
+}
+
+// SetProfile attaches a profile to a deployed cluster, or detaches its
+// profile if profileName is empty, like arlon cluster attach-profile. It
+// returns the hash of the pushed commit, empty if the tree didn't change.
+func (h *Harness) SetProfile(clusterName string, profileName string) string {
+	h.t.Helper()
+	repo := h.Git.NewRepo()
+	defer repo.Close()
+	commitSha, err := cluster.SetProfile(context.Background(), h.KubeClient, repo, h.Apps,
+		ArgocdNs, ArlonNs, clusterName, profileName)
+	if err != nil {
+		h.t.Fatalf("failed to set profile of cluster %s: %s", clusterName, err)
+	}
+	return commitSha
+}
+
+// ClusterFiles returns the files of a cluster's directory on RepoBranch,
+// keyed by their path relative to it.
+func (h *Harness) ClusterFiles(clusterName string) map[string][]byte {
+	prefix := BasePath + "/" + clusterName + "/"
+	files := make(map[string][]byte)
+	for p, data := range h.Git.Files(RepoUrl, RepoBranch) {
+		if strings.HasPrefix(p, prefix) {
+			files[strings.TrimPrefix(p, prefix)] = data
+		}
+	}
+	return files
+}
+
+// RootApp returns the root application of a deployed cluster.
+func (h *Harness) RootApp(clusterName string) *argoappv1.Application {
+	h.t.Helper()
+	for _, app := range h.Apps.Apps() {
+		if app.Name == clusterName {
+			return &app
+		}
+	}
+	h.t.Fatalf("cluster %s has no root application", clusterName)
+	return nil
+}
+
+// AssertCluster compares the files of a deployed cluster's directory with
+// the golden files below dir/tree, and its root application, without its
+// status, with dir/root-app.yaml. See AssertTree.
+func (h *Harness) AssertCluster(clusterName string, dir string) {
+	h.t.Helper()
+	AssertTree(h.t, dir+"/tree", h.ClusterFiles(clusterName))
+	app := h.RootApp(clusterName)
+	AssertYAML(h.t, dir+"/root-app.yaml", struct {
+		metav1.TypeMeta   `json:",inline"`
+		metav1.ObjectMeta `json:"metadata"`
+		Spec              argoappv1.ApplicationSpec `json:"spec"`
+	}{app.TypeMeta, app.ObjectMeta, app.Spec})
+}
@@ -0,0 +1,29 @@
+package testing
+
+import "testing"
+
+func TestHarness(t *testing.T) {
+	h := New(t,
+		ClusterSpec(ArlonNs, "eks", map[string]string{"region": "us-west-2", "nodeCount": "2"}),
+		InlineBundle(ArlonNs, "guestbook", "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: guestbook\n"),
+		ChartBundle(ArlonNs, "nginx", "https://charts.example.com", "nginx", "1.0"),
+		Profile(ArlonNs, "dev", "guestbook", "nginx"),
+		Profile(ArlonNs, "minimal", "guestbook"),
+	)
+	if h.Deploy("c1", "dev", "eks") == "" {
+		t.Fatal("expected the deployment to be pushed")
+	}
+	h.AssertCluster("c1", "testdata/deploy")
+	if h.Deploy("c1", "dev", "eks") != "" {
+		t.Error("expected redeploying an unchanged cluster to push nothing")
+	}
+	h.SetProfile("c1", "minimal")
+	AssertTree(t, "testdata/minimal", h.ClusterFiles("c1"))
+	if labels := h.RootApp("c1").Labels; labels["arlon-profile"] != "minimal" {
+		t.Errorf("expected the root application to record profile minimal, got %v", labels)
+	}
+	apps := h.Apps.Apps()
+	if len(apps) != 1 || apps[0].Name != "c1" {
+		t.Errorf("expected a single root application, got %d", len(apps))
+	}
+}
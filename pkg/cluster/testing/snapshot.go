@@ -0,0 +1,87 @@
+package testing
+
+import (
+	"arlon.io/arlon/pkg/diff"
+	"bytes"
+	"github.com/go-git/go-billy/v5/osfs"
+	"os"
+	"path/filepath"
+	sigsyaml "sigs.k8s.io/yaml"
+	stdtesting "testing"
+)
+
+// UpdateEnv names the environment variable which, set to true, makes the
+// snapshot assertions write their golden files rather than compare with
+// them, after a deliberate change of what arlon renders:
+//
+//	ARLON_UPDATE_SNAPSHOTS=true go test ./...
+const UpdateEnv = "ARLON_UPDATE_SNAPSHOTS"
+
+func updating() bool {
+	return os.Getenv(UpdateEnv) == "true"
+}
+
+// AssertTree compares files, keyed by path, with the golden files below dir,
+// and fails the test with a diff of the added, removed and changed files.
+func AssertTree(t stdtesting.TB, dir string, files map[string][]byte) {
+	t.Helper()
+	if updating() {
+		if err := os.RemoveAll(dir); err != nil {
+			t.Fatalf("failed to remove snapshot %s: %s", dir, err)
+		}
+		for p, data := range files {
+			writeGolden(t, filepath.Join(dir, filepath.FromSlash(p)), data)
+		}
+		return
+	}
+	golden, err := diff.ReadTree(osfs.New(dir), ".")
+	if err != nil {
+		t.Fatalf("failed to read snapshot %s (set %s=true to write it): %s", dir, UpdateEnv, err)
+	}
+	var buf bytes.Buffer
+	changed, err := diff.Trees(&buf, golden, files)
+	if err != nil {
+		t.Fatalf("failed to diff snapshot %s: %s", dir, err)
+	}
+	if changed {
+		t.Errorf("rendered tree differs from snapshot %s (set %s=true to update it):\n%s",
+			dir, UpdateEnv, buf.String())
+	}
+}
+
+// AssertYAML compares the YAML encoding of obj with the golden file, and
+// fails the test with their diff.
+func AssertYAML(t stdtesting.TB, goldenFile string, obj interface{}) {
+	t.Helper()
+	data, err := sigsyaml.Marshal(obj)
+	if err != nil {
+		t.Fatalf("failed to encode %s: %s", goldenFile, err)
+	}
+	if updating() {
+		writeGolden(t, goldenFile, data)
+		return
+	}
+	golden, err := os.ReadFile(goldenFile)
+	if err != nil {
+		t.Fatalf("failed to read snapshot %s (set %s=true to write it): %s", goldenFile, UpdateEnv, err)
+	}
+	var buf bytes.Buffer
+	changed, err := diff.Unified(&buf, goldenFile, goldenFile, golden, data)
+	if err != nil {
+		t.Fatalf("failed to diff snapshot %s: %s", goldenFile, err)
+	}
+	if changed {
+		t.Errorf("%s differs from its snapshot (set %s=true to update it):\n%s",
+			goldenFile, UpdateEnv, buf.String())
+	}
+}
+
+func writeGolden(t stdtesting.TB, p string, data []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		t.Fatalf("failed to create snapshot directory: %s", err)
+	}
+	if err := os.WriteFile(p, data, 0644); err != nil {
+		t.Fatalf("failed to write snapshot %s: %s", p, err)
+	}
+}
@@ -0,0 +1,142 @@
+package cluster
+
+import (
+	"arlon.io/arlon/pkg/bundle"
+	"context"
+	"fmt"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+	corev1types "k8s.io/client-go/kubernetes/typed/core/v1"
+	"path"
+)
+
+// clusterTree holds everything written to the git repository for a cluster,
+// gathered from the arlon resources before anything is written.
+type clusterTree struct {
+	clusterName   string
+	repoUrl       string
+	basePath      string
+	inlineBundles []inlineBundle
+	refBundles    []AppSettings
+	specBundles   []AppSettings
+	// clusterChart is set when the clusterspec sources the cluster chart
+	// from an OCI registry
+	clusterChart *AppSettings
+	summary      *Summary
+	processors   []PostProcessor
+}
+
+func newClusterTree(
+	ctx context.Context,
+	corev1 corev1types.CoreV1Interface,
+	st bundle.Store,
+	arlonNs string,
+	clusterName string,
+	repoUrl string,
+	repoBranch string,
+	basePath string,
+	profileName string,
+	clusterSpecName string,
+) (*clusterTree, error) {
+	inlineBundles, refBundles, err := getProfileBundles(ctx, profileName, st, corev1, arlonNs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get profile bundles: %w", err)
+	}
+	var specData map[string]string
+	var clusterChart *AppSettings
+	if clusterSpecName != "" {
+		specData, err = getClusterSpecData(ctx, corev1, arlonNs, clusterSpecName)
+		if err != nil {
+			return nil, err
+		}
+		clusterChart, err = clusterChartApp(specData)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s of clusterspec %s: %w", clusterChartKey, clusterSpecName, err)
+		}
+	}
+	specBundles, err := clusterSpecBundles(clusterName, specData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get clusterspec bundles: %w", err)
+	}
+	summary := newSummary(clusterName, repoUrl, repoBranch, basePath, profileName, clusterSpecName,
+		specData, inlineBundles, refBundles, specBundles)
+	processors, err := getPostProcessors(ctx, corev1, arlonNs, clusterName)
+	if err != nil {
+		return nil, err
+	}
+	return &clusterTree{
+		clusterName:   clusterName,
+		repoUrl:       repoUrl,
+		basePath:      basePath,
+		inlineBundles: inlineBundles,
+		refBundles:    refBundles,
+		specBundles:   specBundles,
+		clusterChart:  clusterChart,
+		summary:       summary,
+		processors:    processors,
+	}, nil
+}
+
+// apps returns the settings of the generated applications that don't
+// deploy inline bundles.
+func (t *clusterTree) apps() []AppSettings {
+	apps := append(append([]AppSettings{}, t.refBundles...), t.specBundles...)
+	if t.clusterChart != nil {
+		apps = append(apps, *t.clusterChart)
+	}
+	return apps
+}
+
+// write writes the cluster's files into fsys, which is rooted at the top of
+// the repository. The mgmt and workload directories of a deployed cluster
+// are replaced rather than written over, so that the files of removed
+// bundles, chart templates and generated applications don't linger. The
+// post-processors of the cluster are applied to them once rendered.
+func (t *clusterTree) write(ctx context.Context, fsys billy.Filesystem) error {
+	clusterPath := path.Join(t.basePath, t.clusterName)
+	mgmtPath := path.Join(clusterPath, "mgmt")
+	workloadPath := path.Join(clusterPath, "workload")
+	for _, p := range []string{mgmtPath, workloadPath} {
+		if err := util.RemoveAll(fsys, p); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", p, err)
+		}
+	}
+	// an OCI cluster chart replaces the embedded chart's templates
+	err := copyManifests(fsys, ".", mgmtPath, t.clusterChart == nil)
+	if err != nil {
+		return fmt.Errorf("failed to copy embedded content: %w", err)
+	}
+	if t.clusterChart != nil {
+		err = renderBundleApps(fsys, t.clusterName, mgmtPath, []AppSettings{*t.clusterChart})
+		if err != nil {
+			return fmt.Errorf("failed to render cluster chart application: %w", err)
+		}
+	}
+	err = copyInlineBundles(fsys, t.clusterName, t.repoUrl, mgmtPath, workloadPath, t.inlineBundles)
+	if err != nil {
+		return fmt.Errorf("failed to copy inline bundles: %w", err)
+	}
+	err = renderBundleApps(fsys, t.clusterName, mgmtPath, t.refBundles)
+	if err != nil {
+		return fmt.Errorf("failed to render reference bundles: %w", err)
+	}
+	err = renderBundleApps(fsys, t.clusterName, mgmtPath, t.specBundles)
+	if err != nil {
+		return fmt.Errorf("failed to render clusterspec bundles: %w", err)
+	}
+	if len(t.processors) > 0 {
+		files, err := renderedFiles(fsys, clusterPath)
+		if err != nil {
+			return err
+		}
+		err = postProcess(ctx, fsys, t.clusterName, clusterPath, files, t.processors)
+		if err != nil {
+			return err
+		}
+	}
+	err = writeSummary(fsys, clusterPath, t.summary)
+	if err != nil {
+		return fmt.Errorf("failed to write cluster summary: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package cluster
+
+import (
+	"arlon.io/arlon/pkg/bundle"
+	"context"
+	"fmt"
+	applicationpkg "github.com/argoproj/argo-cd/v2/pkg/apiclient/application"
+	"k8s.io/client-go/kubernetes"
+	"sort"
+)
+
+// BundleHashAnnotation records the content hash of a profile bundle, as
+// returned by bundle.ContentHash, on the application generated for it.
+const BundleHashAnnotation = "arlon.io/bundle-hash"
+
+// BundleUsage describes the deployment of a bundle to a cluster.
+// DeployedHash is the content hash recorded on the bundle's application,
+// which is empty for clusters deployed before hashes were recorded.
+type BundleUsage struct {
+	ClusterName  string
+	DeployedHash string
+	CurrentHash  string
+}
+
+// Changed returns whether the cluster runs an outdated version of the
+// bundle, and is thus to be deployed again to pick up its current content.
+func (u BundleUsage) Changed() bool {
+	return u.DeployedHash != u.CurrentHash
+}
+
+// BundleWhereUsed returns the clusters that a bundle is deployed to, found
+// by the application generated for it in each cluster's mgmt chart, sorted
+// by name.
+func BundleWhereUsed(
+	ctx context.Context,
+	kubeClient kubernetes.Interface,
+	appIf applicationpkg.ApplicationServiceClient,
+	argocdNs string,
+	arlonNs string,
+	bundleName string,
+) ([]BundleUsage, error) {
+	st, err := loadStore(ctx, kubeClient.CoreV1(), argocdNs, arlonNs)
+	if err != nil {
+		return nil, err
+	}
+	bundleNs, name := bundle.ParseRef(bundleName, arlonNs)
+	secr, err := st.GetBundle(ctx, bundleNs, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get bundle %s: %w", bundleName, err)
+	}
+	currentHash := bundle.ContentHash(secr)
+	apps, err := appIf.List(ctx, &applicationpkg.ApplicationQuery{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list applications: %w", err)
+	}
+	bundleApps := make(map[string]map[string]string)
+	for _, app := range apps.Items {
+		bundleApps[app.Name] = app.Annotations
+	}
+	var usages []BundleUsage
+	for _, app := range apps.Items {
+		if app.Labels["managed-by"] != "arlon" || app.Labels["arlon-type"] != "cluster" {
+			continue
+		}
+		annotations, ok := bundleApps[app.Name+"-"+name]
+		if !ok {
+			continue
+		}
+		usages = append(usages, BundleUsage{
+			ClusterName:  app.Name,
+			DeployedHash: annotations[BundleHashAnnotation],
+			CurrentHash:  currentHash,
+		})
+	}
+	sort.Slice(usages, func(i, j int) bool {
+		return usages[i].ClusterName < usages[j].ClusterName
+	})
+	return usages, nil
+}
+
+// BundleProfiles returns the profiles that include a bundle, by name or by
+// their bundle selector, as namespace qualified references.
+func BundleProfiles(
+	ctx context.Context,
+	kubeClient kubernetes.Interface,
+	argocdNs string,
+	arlonNs string,
+	bundleName string,
+) ([]string, error) {
+	st, err := loadStore(ctx, kubeClient.CoreV1(), argocdNs, arlonNs)
+	if err != nil {
+		return nil, err
+	}
+	bundleNs, name := bundle.ParseRef(bundleName, arlonNs)
+	profiles, err := st.ListProfiles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var refs []string
+	for i := range profiles {
+		profile := &profiles[i]
+		bundleRefs, err := bundle.ProfileBundleRefs(ctx, st, profile)
+		if err != nil {
+			return nil, err
+		}
+		for _, ref := range bundleRefs {
+			if ns, n := bundle.ParseRef(ref, profile.Namespace); ns == bundleNs && n == name {
+				refs = append(refs, profile.Namespace+"/"+profile.Name)
+				break
+			}
+		}
+	}
+	return refs, nil
+}
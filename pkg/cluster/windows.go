@@ -0,0 +1,183 @@
+package cluster
+
+import (
+	"arlon.io/arlon/pkg/bundle"
+	"context"
+	"fmt"
+	argoappv1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	"github.com/go-git/go-billy/v5"
+	"gopkg.in/yaml.v2"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	corev1types "k8s.io/client-go/kubernetes/typed/core/v1"
+	"path"
+	"strings"
+)
+
+// SyncWindowsKey is the profile key holding the change windows of the
+// clusters deployed with the profile, as a YAML list of SyncWindow. Outside
+// of them, arlon refuses to push changes to those clusters' git trees.
+const SyncWindowsKey = "syncWindows"
+
+// SyncWindow is a change window with the semantics of ArgoCD's sync windows:
+// changes are refused while a deny window is open, and if there are allow
+// windows, while none of them is. A window opens at the times of its cron
+// Schedule, in TimeZone (UTC by default), for Duration. Clusters restricts
+// the window to the clusters whose name matches one of its glob patterns.
+type SyncWindow struct {
+	Kind     string   `yaml:"kind"`
+	Schedule string   `yaml:"schedule"`
+	Duration string   `yaml:"duration"`
+	TimeZone string   `yaml:"timeZone,omitempty"`
+	Clusters []string `yaml:"clusters,omitempty"`
+}
+
+// SyncWindowClosedError is returned when changes to a deployed cluster are
+// refused because its profile's change windows are closed. Nothing is
+// pushed, and the change must be made again once a window opens.
+type SyncWindowClosedError struct {
+	ClusterName string
+	Profile     string
+}
+
+func (e *SyncWindowClosedError) Error() string {
+	return fmt.Sprintf("changes to cluster %s are refused while the change windows of profile %s are closed",
+		e.ClusterName, e.Profile)
+}
+
+type ignoreSyncWindowsKey struct{}
+
+// WithIgnoreSyncWindows returns a context under which changes are pushed to
+// clusters regardless of their change windows, e.g. for emergencies.
+func WithIgnoreSyncWindows(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ignoreSyncWindowsKey{}, true)
+}
+
+func ignoreSyncWindows(ctx context.Context) bool {
+	ignore, _ := ctx.Value(ignoreSyncWindowsKey{}).(bool)
+	return ignore
+}
+
+// ParseSyncWindows parses and validates the change windows of a profile.
+func ParseSyncWindows(data string) ([]SyncWindow, error) {
+	var windows []SyncWindow
+	if err := yaml.UnmarshalStrict([]byte(data), &windows); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", SyncWindowsKey, err)
+	}
+	for i, w := range windows {
+		if err := w.argocdWindow().Validate(); err != nil {
+			return nil, fmt.Errorf("invalid sync window %d: %w", i, err)
+		}
+		for _, pattern := range w.Clusters {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid cluster pattern %s of sync window %d: %w", pattern, i, err)
+			}
+		}
+	}
+	return windows, nil
+}
+
+func (w *SyncWindow) argocdWindow() *argoappv1.SyncWindow {
+	return &argoappv1.SyncWindow{
+		Kind:     w.Kind,
+		Schedule: w.Schedule,
+		Duration: w.Duration,
+		TimeZone: w.TimeZone,
+	}
+}
+
+// appliesTo returns whether the window restricts changes to the cluster.
+func (w *SyncWindow) appliesTo(clusterName string) bool {
+	if len(w.Clusters) == 0 {
+		return true
+	}
+	for _, pattern := range w.Clusters {
+		if ok, _ := path.Match(pattern, clusterName); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSyncWindows returns a SyncWindowClosedError if the change windows
+// of any of the profiles, which may be namespace qualified or empty, are
+// closed for the cluster.
+func checkSyncWindows(
+	ctx context.Context,
+	st bundle.Store,
+	arlonNs string,
+	clusterName string,
+	profileNames ...string,
+) error {
+	if ignoreSyncWindows(ctx) {
+		return nil
+	}
+	checked := make(map[string]bool)
+	for _, profileName := range profileNames {
+		if profileName == "" {
+			continue
+		}
+		profileNs, name := bundle.ParseRef(profileName, arlonNs)
+		if checked[profileNs+"/"+name] {
+			continue
+		}
+		checked[profileNs+"/"+name] = true
+		profile, err := st.GetProfile(ctx, profileNs, name)
+		if apierr.IsNotFound(err) {
+			// a deleted profile no longer restricts its former clusters
+			continue
+		}
+		if err != nil {
+			return profileError(profileName, err)
+		}
+		if strings.TrimSpace(profile.Data[SyncWindowsKey]) == "" {
+			continue
+		}
+		windows, err := ParseSyncWindows(profile.Data[SyncWindowsKey])
+		if err != nil {
+			return fmt.Errorf("profile %s: %w", profileName, err)
+		}
+		var argocdWindows argoappv1.SyncWindows
+		for i := range windows {
+			if windows[i].appliesTo(clusterName) {
+				argocdWindows = append(argocdWindows, windows[i].argocdWindow())
+			}
+		}
+		if !argocdWindows.CanSync(false) {
+			return &SyncWindowClosedError{ClusterName: clusterName, Profile: profileName}
+		}
+	}
+	return nil
+}
+
+// checkDeployedSyncWindows checks the change windows of the profile recorded
+// by the summary of a deployed cluster in wt, and of the other profiles
+// given, before its directory is changed other than by redeploying it.
+func checkDeployedSyncWindows(
+	ctx context.Context,
+	corev1 corev1types.CoreV1Interface,
+	argocdNs string,
+	arlonNs string,
+	wt billy.Filesystem,
+	basePath string,
+	clusterName string,
+	profileNames ...string,
+) error {
+	if ignoreSyncWindows(ctx) {
+		return nil
+	}
+	summary, err := ReadSummary(wt, basePath, clusterName)
+	if err != nil {
+		return err
+	}
+	if summary != nil {
+		profileNames = append(profileNames, summary.Profile)
+	}
+	if strings.Join(profileNames, "") == "" {
+		return nil
+	}
+	st, err := loadStore(ctx, corev1, argocdNs, arlonNs)
+	if err != nil {
+		return err
+	}
+	return checkSyncWindows(ctx, st, arlonNs, clusterName, profileNames...)
+}
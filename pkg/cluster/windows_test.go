@@ -0,0 +1,61 @@
+package cluster_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"arlon.io/arlon/pkg/cluster"
+	clustertesting "arlon.io/arlon/pkg/cluster/testing"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TestSyncWindowsOnEveryPush checks that the commands changing a deployed
+// cluster are refused while a deny window of its profile is open, before
+// anything is pushed or deleted.
+func TestSyncWindowsOnEveryPush(t *testing.T) {
+	h, _ := newRollbackHarness(t)
+	ctx := context.Background()
+	profiles := h.KubeClient.CoreV1().ConfigMaps(clustertesting.ArlonNs)
+	prod, err := profiles.Get(ctx, "prod", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	prod.Data[cluster.SyncWindowsKey] = "- kind: deny\n  schedule: \"* * * * *\"\n  duration: 1h\n"
+	if _, err := profiles.Update(ctx, prod, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	commits := len(h.Git.Commits(clustertesting.RepoUrl, clustertesting.RepoBranch))
+	for name, run := range map[string]func(ctx context.Context) error{
+		"rollback": func(ctx context.Context) error {
+			_, _, err := cluster.Rollback(ctx, h.KubeClient, h.Git.NewRepo(), h.Apps,
+				clustertesting.ArgocdNs, clustertesting.ArlonNs, "c1", false)
+			return err
+		},
+		"rename": func(ctx context.Context) error {
+			_, err := cluster.Rename(ctx, h.KubeClient, h.Git.NewRepo(), h.Apps,
+				clustertesting.ArgocdNs, clustertesting.ArlonNs, "c1", "c2")
+			return err
+		},
+		"delete": func(ctx context.Context) error {
+			_, err := cluster.Delete(ctx, h.KubeClient, h.Git.NewRepo(), h.Apps,
+				clustertesting.ArgocdNs, clustertesting.ArlonNs, "c1")
+			return err
+		},
+	} {
+		var windowErr *cluster.SyncWindowClosedError
+		if err := run(ctx); !errors.As(err, &windowErr) || windowErr.Profile != "prod" {
+			t.Errorf("%s: expected a closed window of profile prod, got %v", name, err)
+		}
+	}
+	if actual := len(h.Git.Commits(clustertesting.RepoUrl, clustertesting.RepoBranch)); actual != commits {
+		t.Errorf("expected no commit to be pushed, got %d new ones", actual-commits)
+	}
+	// fails the test if the root application was deleted
+	h.RootApp("c1")
+	_, err = cluster.Delete(cluster.WithIgnoreSyncWindows(ctx), h.KubeClient, h.Git.NewRepo(), h.Apps,
+		clustertesting.ArgocdNs, clustertesting.ArlonNs, "c1")
+	if err != nil {
+		t.Errorf("delete ignoring the change windows failed: %s", err)
+	}
+}
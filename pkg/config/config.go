@@ -0,0 +1,112 @@
+// Package config manages the arlon configuration file, which holds named
+// contexts describing the management clusters an operator works with, and
+// the defaults of the options that don't depend on the management cluster.
+package config
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v2"
+	"os"
+	"path/filepath"
+)
+
+// Context holds the settings used for a management cluster. They are the
+// defaults of the command line options of the same purpose.
+type Context struct {
+	Name string `yaml:"name"`
+	// Kubeconfig is the path of the management cluster's kubeconfig file,
+	// and KubeContext the context in it
+	Kubeconfig  string `yaml:"kubeconfig,omitempty"`
+	KubeContext string `yaml:"kubeContext,omitempty"`
+	// ArgocdContext is the context of the argocd CLI configuration used to
+	// reach the cluster's ArgoCD API server
+	ArgocdContext string `yaml:"argocdContext,omitempty"`
+	ArgocdNs      string `yaml:"argocdNamespace,omitempty"`
+	ArlonNs       string `yaml:"arlonNamespace,omitempty"`
+	RepoUrl       string `yaml:"repoUrl,omitempty"`
+	RepoBranch    string `yaml:"repoBranch,omitempty"`
+	RepoPath      string `yaml:"repoPath,omitempty"`
+}
+
+type Config struct {
+	CurrentContext string    `yaml:"currentContext,omitempty"`
+	Contexts       []Context `yaml:"contexts,omitempty"`
+	Defaults       Defaults  `yaml:"defaults,omitempty"`
+}
+
+// DefaultPath returns the path of the configuration file: $ARLON_CONFIG, or
+// ~/.arlon/config.
+func DefaultPath() (string, error) {
+	if p := os.Getenv("ARLON_CONFIG"); p != "" {
+		return p, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return filepath.Join(home, ".arlon", "config"), nil
+}
+
+// Load reads the configuration file, which is empty if it doesn't exist.
+func Load(path string) (*Config, error) {
+	config := &Config{}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return config, nil
+}
+
+// Save writes the configuration file, which is only readable by its owner.
+func (c *Config) Save(path string) error {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to serialize config: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+	return nil
+}
+
+// Get returns the named context, or nil if it doesn't exist.
+func (c *Config) Get(name string) *Context {
+	for i := range c.Contexts {
+		if c.Contexts[i].Name == name {
+			return &c.Contexts[i]
+		}
+	}
+	return nil
+}
+
+// Set adds a context, or replaces the one of the same name.
+func (c *Config) Set(context Context) {
+	if existing := c.Get(context.Name); existing != nil {
+		*existing = context
+		return
+	}
+	c.Contexts = append(c.Contexts, context)
+}
+
+// Delete removes the named context, and unsets it if it was current.
+func (c *Config) Delete(name string) error {
+	for i := range c.Contexts {
+		if c.Contexts[i].Name == name {
+			c.Contexts = append(c.Contexts[:i], c.Contexts[i+1:]...)
+			if c.CurrentContext == name {
+				c.CurrentContext = ""
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("context %s not found", name)
+}
@@ -0,0 +1,86 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".arlon", "config")
+	config, err := Load(path)
+	if err != nil || !reflect.DeepEqual(config, &Config{}) {
+		t.Fatalf("expected a missing config file to be empty, got %+v (%v)", config, err)
+	}
+	config.Set(Context{Name: "prod", Kubeconfig: "/home/ops/.kube/prod", ArlonNs: "arlon"})
+	config.Set(Context{Name: "staging", RepoUrl: "https://git.example.com/staging.git"})
+	config.Set(Context{Name: "prod", Kubeconfig: "/home/ops/.kube/prod", ArlonNs: "arlon-prod"})
+	config.CurrentContext = "prod"
+	if err := config.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected the config file to only be readable by its owner, got %s", info.Mode())
+	}
+
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, config) {
+		t.Errorf("expected the saved config %+v, got %+v", config, loaded)
+	}
+	if len(loaded.Contexts) != 2 || loaded.Get("prod").ArlonNs != "arlon-prod" {
+		t.Errorf("expected setting a context to replace the one of the same name, got %+v", loaded.Contexts)
+	}
+	if loaded.Get("dev") != nil {
+		t.Error("expected a missing context not to be found")
+	}
+	if err := loaded.Delete("prod"); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.CurrentContext != "" || loaded.Get("prod") != nil {
+		t.Errorf("expected deleting the current context to unset it, got %+v", loaded)
+	}
+	if err := loaded.Delete("prod"); err == nil || !strings.Contains(err.Error(), "context prod not found") {
+		t.Errorf("expected deleting a missing context to fail, got %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte("currentContext: prod\nclusters: []\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), "failed to parse config file") {
+		t.Errorf("expected unknown fields to be rejected, got %v", err)
+	}
+}
+
+// setenv sets an environment variable until the test ends.
+func setenv(t *testing.T, key string, value string) {
+	saved, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, saved)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+func TestDefaultPath(t *testing.T) {
+	setenv(t, "ARLON_CONFIG", "/etc/arlon/config")
+	if p, err := DefaultPath(); err != nil || p != "/etc/arlon/config" {
+		t.Errorf("expected $ARLON_CONFIG to be the config path, got %s (%v)", p, err)
+	}
+	os.Unsetenv("ARLON_CONFIG")
+	setenv(t, "HOME", "/home/ops")
+	if p, err := DefaultPath(); err != nil || p != "/home/ops/.arlon/config" {
+		t.Errorf("expected the config file to be in the home directory, got %s (%v)", p, err)
+	}
+}
@@ -0,0 +1,18 @@
+package config
+
+// Defaults are the default values of the command line options shared by
+// most commands, held by the defaults section of the configuration file.
+// Unlike the settings of contexts, they don't depend on the management
+// cluster, and the current context's settings take precedence over them.
+type Defaults struct {
+	RepoUrl    string `yaml:"repoUrl,omitempty"`
+	RepoBranch string `yaml:"repoBranch,omitempty"`
+	BasePath   string `yaml:"basePath,omitempty"`
+	ArgocdNs   string `yaml:"argocdNamespace,omitempty"`
+	ArlonNs    string `yaml:"arlonNamespace,omitempty"`
+	// Output is the output format of the commands printing tables
+	Output string `yaml:"output,omitempty"`
+	// AllowCommandProcessors lets the post-processors running a command run
+	// on this machine
+	AllowCommandProcessors bool `yaml:"allowCommandProcessors,omitempty"`
+}
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadDefaults(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	err := os.WriteFile(path, []byte(`currentContext: prod
+defaults:
+  repoUrl: https://git.example.com/fleet.git
+  repoBranch: main
+  basePath: clusters
+  argocdNamespace: gitops
+  arlonNamespace: arlon-system
+  output: json
+  allowCommandProcessors: true
+`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Defaults{
+		RepoUrl:                "https://git.example.com/fleet.git",
+		RepoBranch:             "main",
+		BasePath:               "clusters",
+		ArgocdNs:               "gitops",
+		ArlonNs:                "arlon-system",
+		Output:                 "json",
+		AllowCommandProcessors: true,
+	}
+	if !reflect.DeepEqual(config.Defaults, expected) {
+		t.Errorf("expected defaults %+v, got %+v", expected, config.Defaults)
+	}
+	// saving the contexts keeps the defaults
+	config.Set(Context{Name: "prod", ArlonNs: "arlon-prod"})
+	if err := config.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	if loaded, err := Load(path); err != nil || !reflect.DeepEqual(loaded.Defaults, expected) {
+		t.Errorf("expected the saved defaults %+v, got %+v (%v)", expected, loaded, err)
+	}
+	if err := os.WriteFile(path, []byte("defaults:\n  repo-url: https://git.example.com/fleet.git\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), "failed to parse config file") {
+		t.Errorf("expected unknown options to be rejected, got %v", err)
+	}
+}
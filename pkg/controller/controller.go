@@ -9,6 +9,7 @@ import (
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	"os"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 )
@@ -25,7 +26,7 @@ func init() {
 	//+kubebuilder:scaffold:scheme
 }
 
-func StartController(metricsAddr string, probeAddr string, enableLeaderElection bool) {
+func StartController(metricsAddr string, probeAddr string, enableLeaderElection bool, arlonNs string) {
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:                 scheme,
 		MetricsBindAddress:     metricsAddr,
@@ -46,9 +47,27 @@ func StartController(metricsAddr string, probeAddr string, enableLeaderElection
 		os.Exit(1)
 	}
 
+	// the secrets of the arlon namespace are cached and watched instead, which
+	// only requires namespace-scoped access to them
+	secretCache, err := cache.New(mgr.GetConfig(), cache.Options{
+		Scheme:    mgr.GetScheme(),
+		Mapper:    mgr.GetRESTMapper(),
+		Namespace: arlonNs,
+	})
+	if err != nil {
+		setupLog.Error(err, "unable to create secret cache")
+		os.Exit(1)
+	}
+	if err = mgr.Add(secretCache); err != nil {
+		setupLog.Error(err, "unable to add secret cache")
+		os.Exit(1)
+	}
+
 	if err = (&controllers.ClusterRegistrationReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:          mgr.GetClient(),
+		Scheme:          mgr.GetScheme(),
+		SecretCache:     secretCache,
+		SecretNamespace: arlonNs,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "ClusterRegistration")
 		os.Exit(1)
@@ -0,0 +1,106 @@
+// Package diff prints unified diffs of files and of trees of files.
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/pmezard/go-difflib/difflib"
+	"io"
+	"path"
+	"sort"
+)
+
+const contextLines = 3
+
+// Unified writes the unified diff turning from into to, and reports whether
+// they differ. Nothing is written if they are the same.
+func Unified(w io.Writer, fromName string, toName string, from []byte, to []byte) (bool, error) {
+	if bytes.Equal(from, to) {
+		return false, nil
+	}
+	err := difflib.WriteUnifiedDiff(w, difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(from)),
+		B:        difflib.SplitLines(string(to)),
+		FromFile: fromName,
+		ToFile:   toName,
+		Context:  contextLines,
+	})
+	if err != nil {
+		return true, fmt.Errorf("failed to write diff: %w", err)
+	}
+	return true, nil
+}
+
+// Trees writes a git style diff turning the files of from into those of to,
+// both keyed by path, and reports whether they differ.
+func Trees(w io.Writer, from map[string][]byte, to map[string][]byte) (bool, error) {
+	paths := make(map[string]bool)
+	for p := range from {
+		paths[p] = true
+	}
+	for p := range to {
+		paths[p] = true
+	}
+	var sorted []string
+	for p := range paths {
+		sorted = append(sorted, p)
+	}
+	sort.Strings(sorted)
+	changed := false
+	for _, p := range sorted {
+		fromData, inFrom := from[p]
+		toData, inTo := to[p]
+		if inFrom && inTo && bytes.Equal(fromData, toData) {
+			continue
+		}
+		fromName, toName := "a/"+p, "b/"+p
+		if !inFrom {
+			fromName = "/dev/null"
+		}
+		if !inTo {
+			toName = "/dev/null"
+		}
+		if _, err := fmt.Fprintf(w, "diff --git a/%s b/%s\n", p, p); err != nil {
+			return true, fmt.Errorf("failed to write diff: %w", err)
+		}
+		if _, err := Unified(w, fromName, toName, fromData, toData); err != nil {
+			return true, err
+		}
+		changed = true
+	}
+	return changed, nil
+}
+
+// ReadTree reads the files below dir in fs, keyed by their path relative to
+// the root of fs. A missing dir is read as an empty tree.
+func ReadTree(fs billy.Filesystem, dir string) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	if _, err := fs.Stat(dir); err != nil {
+		return files, nil
+	}
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		items, err := fs.ReadDir(dir)
+		if err != nil {
+			return fmt.Errorf("failed to read directory %s: %w", dir, err)
+		}
+		for _, item := range items {
+			p := path.Join(dir, item.Name())
+			if item.IsDir() {
+				if err := walk(p); err != nil {
+					return err
+				}
+				continue
+			}
+			data, err := util.ReadFile(fs, p)
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", p, err)
+			}
+			files[p] = data
+		}
+		return nil
+	}
+	return files, walk(dir)
+}
@@ -0,0 +1,406 @@
+package fleet
+
+import (
+	"arlon.io/arlon/pkg/cluster"
+	"arlon.io/arlon/pkg/gitutils"
+	"arlon.io/arlon/pkg/progress"
+	"context"
+	"fmt"
+	applicationpkg "github.com/argoproj/argo-cd/v2/pkg/apiclient/application"
+	projectpkg "github.com/argoproj/argo-cd/v2/pkg/apiclient/project"
+	argoappv1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	"gopkg.in/yaml.v2"
+	"io"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Manifest declares the clusters of a fleet. Its repoUrl, repoBranch, path
+// and project are the defaults of those of its clusters.
+type Manifest struct {
+	RepoUrl    string            `yaml:"repoUrl"`
+	RepoBranch string            `yaml:"repoBranch"`
+	Path       string            `yaml:"path"`
+	Project    string            `yaml:"project"`
+	Clusters   []ClusterManifest `yaml:"clusters"`
+}
+
+type ClusterManifest struct {
+	Name        string `yaml:"name"`
+	ClusterSpec string `yaml:"clusterSpec"`
+	Profile     string `yaml:"profile"`
+	RepoUrl     string `yaml:"repoUrl"`
+	RepoBranch  string `yaml:"repoBranch"`
+	Path        string `yaml:"path"`
+	// Project is the ArgoCD project of the cluster's root application
+	Project string `yaml:"project"`
+	// DestinationServer or DestinationSelector target the workload cluster
+	// by server URL instead of by name
+	DestinationServer   string `yaml:"destinationServer"`
+	DestinationSelector string `yaml:"destinationSelector"`
+	// Labels are the cluster labels of the cluster, which replace any set
+	// on its root application otherwise
+	Labels map[string]string `yaml:"labels"`
+}
+
+// Operations of an Action
+const (
+	OpCreate    = "create"
+	OpUpdate    = "update"
+	OpDelete    = "delete"
+	OpUnchanged = "unchanged"
+)
+
+// Action is a step of the plan bringing the fleet to its manifest.
+type Action struct {
+	Op      string
+	Cluster ClusterManifest
+	// Reason details what an update changes
+	Reason string
+	// rootApp is the desired root application of created or updated clusters
+	rootApp *argoappv1.Application
+	// updateRootApp is set when the root application of an updated cluster
+	// differs from the desired one
+	updateRootApp bool
+}
+
+func (a *Action) String() string {
+	if a.Reason != "" {
+		return fmt.Sprintf("%s cluster %s (%s)", a.Op, a.Cluster.Name, a.Reason)
+	}
+	return fmt.Sprintf("%s cluster %s", a.Op, a.Cluster.Name)
+}
+
+// ReadManifest parses a fleet manifest and fills in the defaults of its
+// clusters.
+func ReadManifest(r io.Reader) (*Manifest, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fleet manifest: %w", err)
+	}
+	var manifest Manifest
+	err = yaml.UnmarshalStrict(data, &manifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse fleet manifest: %w", err)
+	}
+	if manifest.RepoBranch == "" {
+		manifest.RepoBranch = "main"
+	}
+	if manifest.Path == "" {
+		manifest.Path = "arlon"
+	}
+	seen := make(map[string]bool)
+	for i := range manifest.Clusters {
+		c := &manifest.Clusters[i]
+		if c.Name == "" {
+			return nil, fmt.Errorf("cluster %d of the fleet manifest has no name", i)
+		}
+		if seen[c.Name] {
+			return nil, fmt.Errorf("cluster %s is declared twice in the fleet manifest", c.Name)
+		}
+		seen[c.Name] = true
+		if c.RepoUrl == "" {
+			c.RepoUrl = manifest.RepoUrl
+		}
+		if c.RepoBranch == "" {
+			c.RepoBranch = manifest.RepoBranch
+		}
+		if c.Path == "" {
+			c.Path = manifest.Path
+		}
+		if c.Project == "" {
+			c.Project = manifest.Project
+		}
+		if c.RepoUrl == "" {
+			return nil, fmt.Errorf("cluster %s has no repoUrl", c.Name)
+		}
+		if err := cluster.CheckClusterLabels(c.Labels); err != nil {
+			return nil, fmt.Errorf("cluster %s: %w", c.Name, err)
+		}
+	}
+	return &manifest, nil
+}
+
+// -----------------------------------------------------------------------------
+
+// Plan compares the manifest with the deployed clusters and returns the
+// actions bringing the fleet to it, ordered by cluster name. Deployed
+// clusters missing from the manifest are deleted only if prune is set.
+// Moving a deployed cluster to another repository location is not supported,
+// nor is placing a cluster in an ArgoCD project that doesn't allow it.
+func Plan(
+	ctx context.Context,
+	kubeClient kubernetes.Interface,
+	newRepo func() gitutils.GitRepo,
+	appIf applicationpkg.ApplicationServiceClient,
+	projIf projectpkg.ProjectServiceClient,
+	argocdNs string,
+	arlonNs string,
+	manifest *Manifest,
+	prune bool,
+) ([]Action, error) {
+	apps, err := appIf.List(ctx,
+		&applicationpkg.ApplicationQuery{Selector: ClusterSelector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cluster root applications: %w", err)
+	}
+	deployed := make(map[string]*argoappv1.Application)
+	for i := range apps.Items {
+		deployed[apps.Items[i].Name] = &apps.Items[i]
+	}
+	var actions []Action
+	declared := make(map[string]bool)
+	for _, c := range manifest.Clusters {
+		declared[c.Name] = true
+		progress.Step(ctx, "planning cluster %s", c.Name)
+		destinationServer, err := cluster.ResolveDestinationServer(ctx, kubeClient.CoreV1(), argocdNs,
+			c.DestinationServer, c.DestinationSelector)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve destination of cluster %s: %w", c.Name, err)
+		}
+		rootApp, err := cluster.ConstructRootApp(ctx, kubeClient, argocdNs, arlonNs, c.Name,
+			c.RepoUrl, c.RepoBranch, c.Path, c.ClusterSpec, c.Profile, c.Project, destinationServer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to construct root app of cluster %s: %w", c.Name, err)
+		}
+		if err := cluster.SetClusterLabels(rootApp, c.Labels); err != nil {
+			return nil, fmt.Errorf("cluster %s: %w", c.Name, err)
+		}
+		if err := cluster.CheckProject(ctx, projIf, rootApp); err != nil {
+			return nil, fmt.Errorf("cluster %s: %w", c.Name, err)
+		}
+		current := deployed[c.Name]
+		if current == nil {
+			actions = append(actions, Action{Op: OpCreate, Cluster: c, rootApp: rootApp})
+			continue
+		}
+		src := current.Spec.Source
+		if src.RepoURL != c.RepoUrl || src.TargetRevision != c.RepoBranch ||
+			src.Path != path.Join(c.Path, c.Name, "mgmt") {
+			return nil, fmt.Errorf("cluster %s is deployed to %s (branch %s, path %s), moving it is not supported",
+				c.Name, src.RepoURL, src.TargetRevision, src.Path)
+		}
+		var reasons []string
+		updateRootApp := rootAppChanged(current, rootApp)
+		if updateRootApp {
+			reasons = append(reasons, "root application")
+		}
+		repo := newRepo()
+		gitChanged, err := cluster.Diff(ctx, kubeClient, repo, argocdNs, arlonNs, c.Name,
+			c.RepoUrl, c.RepoBranch, c.Path, c.Profile, c.ClusterSpec, io.Discard)
+		_ = repo.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to diff cluster %s: %w", c.Name, err)
+		}
+		if gitChanged {
+			reasons = append(reasons, "git tree")
+		}
+		if len(reasons) == 0 {
+			actions = append(actions, Action{Op: OpUnchanged, Cluster: c})
+			continue
+		}
+		// keep the identity of the deployed application when updating it
+		updated := current.DeepCopy()
+		updated.Labels = rootApp.Labels
+		updated.Spec = rootApp.Spec
+		actions = append(actions, Action{Op: OpUpdate, Cluster: c, rootApp: updated,
+			updateRootApp: updateRootApp, Reason: strings.Join(reasons, ", ")})
+	}
+	if prune {
+		for name := range deployed {
+			if !declared[name] {
+				src := deployed[name].Spec.Source
+				actions = append(actions, Action{Op: OpDelete, Cluster: ClusterManifest{
+					Name:       name,
+					RepoUrl:    src.RepoURL,
+					RepoBranch: src.TargetRevision,
+				}})
+			}
+		}
+	}
+	sort.Slice(actions, func(i, j int) bool {
+		return actions[i].Cluster.Name < actions[j].Cluster.Name
+	})
+	return actions, nil
+}
+
+// rootAppChanged returns whether the clusterspec, profile, cluster labels or
+// Helm parameters of the deployed root application differ from the desired
+// one.
+func rootAppChanged(current *argoappv1.Application, desired *argoappv1.Application) bool {
+	for _, label := range []string{cluster.ClusterSpecLabel, cluster.ClusterSpecNamespaceLabel,
+		cluster.ProfileLabel, cluster.ProfileNamespaceLabel} {
+		if current.Labels[label] != desired.Labels[label] {
+			return true
+		}
+	}
+	if !reflect.DeepEqual(cluster.ClusterLabels(current), cluster.ClusterLabels(desired)) {
+		return true
+	}
+	if current.Spec.GetProject() != desired.Spec.GetProject() {
+		return true
+	}
+	var currentParams []argoappv1.HelmParameter
+	var currentValues string
+	if current.Spec.Source.Helm != nil {
+		currentParams = current.Spec.Source.Helm.Parameters
+		currentValues = current.Spec.Source.Helm.Values
+	}
+	return currentValues != desired.Spec.Source.Helm.Values ||
+		!reflect.DeepEqual(currentParams, desired.Spec.Source.Helm.Parameters)
+}
+
+// -----------------------------------------------------------------------------
+
+// Result is the outcome of an action applied to a cluster.
+type Result struct {
+	Cluster   string
+	Op        string
+	CommitSha string
+	Err       error
+}
+
+// Apply carries out the actions of a plan and returns the result of each,
+// in the order of the actions. The clusters sharing a repository branch are
+// written in a single commit and deleted one after the other, since
+// concurrent pushes to a branch would conflict; branches are processed
+// concurrently by up to maxParallel workers.
+func Apply(
+	ctx context.Context,
+	kubeClient kubernetes.Interface,
+	newRepo func() gitutils.GitRepo,
+	appIf applicationpkg.ApplicationServiceClient,
+	argocdNs string,
+	arlonNs string,
+	actions []Action,
+	maxParallel int,
+) []Result {
+	if maxParallel < 1 {
+		maxParallel = 1
+	}
+	results := make([]Result, len(actions))
+	groups := make(map[string][]int)
+	var keys []string
+	for i, action := range actions {
+		results[i] = Result{Cluster: action.Cluster.Name, Op: action.Op}
+		if action.Op == OpUnchanged {
+			continue
+		}
+		key := action.Cluster.RepoUrl + "#" + action.Cluster.RepoBranch
+		if groups[key] == nil {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], i)
+	}
+	sem := make(chan struct{}, maxParallel)
+	var wg sync.WaitGroup
+	for _, key := range keys {
+		indexes := groups[key]
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			// each result is only written by the worker of its group
+			applyGroup(ctx, kubeClient, newRepo, appIf, argocdNs, arlonNs, actions, indexes, results)
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// applyGroup applies the actions at indexes, which share a repository
+// branch: created and updated clusters are deployed together, then deleted
+// clusters are deleted in turn.
+func applyGroup(
+	ctx context.Context,
+	kubeClient kubernetes.Interface,
+	newRepo func() gitutils.GitRepo,
+	appIf applicationpkg.ApplicationServiceClient,
+	argocdNs string,
+	arlonNs string,
+	actions []Action,
+	indexes []int,
+	results []Result,
+) {
+	var deployed []int
+	var deployments []cluster.Deployment
+	for _, i := range indexes {
+		if c := actions[i].Cluster; actions[i].Op != OpDelete {
+			deployed = append(deployed, i)
+			deployments = append(deployments, cluster.Deployment{
+				ClusterName:     c.Name,
+				BasePath:        c.Path,
+				ProfileName:     c.Profile,
+				ClusterSpecName: c.ClusterSpec,
+			})
+		}
+	}
+	if len(deployments) > 0 {
+		first := actions[deployed[0]].Cluster
+		progress.Step(ctx, "deploying %d cluster(s) to %s (branch %s)",
+			len(deployments), first.RepoUrl, first.RepoBranch)
+		repo := newRepo()
+		commitSha, err := cluster.DeployManyToGit(ctx, kubeClient, repo, argocdNs, arlonNs,
+			first.RepoUrl, first.RepoBranch, deployments, gitutils.CreateBranchNever)
+		_ = repo.Close()
+		for _, i := range deployed {
+			results[i].CommitSha = commitSha
+			if err != nil {
+				results[i].Err = fmt.Errorf("failed to deploy git tree: %w", err)
+				if actions[i].Op == OpUpdate {
+					cluster.RecordEvent(ctx, kubeClient, actions[i].rootApp, corev1.EventTypeWarning,
+						cluster.ReasonUpdateFailed, "", results[i].Err.Error())
+				}
+				continue
+			}
+			results[i].Err = applyRootApp(ctx, kubeClient, appIf, &actions[i], commitSha)
+		}
+	}
+	for _, i := range indexes {
+		if actions[i].Op != OpDelete {
+			continue
+		}
+		progress.Step(ctx, "deleting cluster %s", actions[i].Cluster.Name)
+		repo := newRepo()
+		results[i].CommitSha, results[i].Err = cluster.Delete(ctx, kubeClient, repo, appIf,
+			argocdNs, arlonNs, actions[i].Cluster.Name)
+		_ = repo.Close()
+	}
+}
+
+// applyRootApp creates or updates the root application of a deployed
+// cluster, and records the deployment on it.
+func applyRootApp(
+	ctx context.Context,
+	kubeClient kubernetes.Interface,
+	appIf applicationpkg.ApplicationServiceClient,
+	action *Action,
+	commitSha string,
+) error {
+	app := action.rootApp
+	var err error
+	if action.Op == OpCreate {
+		app, err = appIf.Create(ctx,
+			&applicationpkg.ApplicationCreateRequest{Application: *action.rootApp})
+	} else if action.updateRootApp {
+		app, err = appIf.Update(ctx,
+			&applicationpkg.ApplicationUpdateRequest{Application: action.rootApp})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to apply root application: %w", err)
+	}
+	if action.Op == OpCreate {
+		cluster.RecordEvent(ctx, kubeClient, app, corev1.EventTypeNormal, cluster.ReasonDeployed, commitSha,
+			fmt.Sprintf("cluster deployed to %s with fleet apply", action.Cluster.RepoUrl))
+	} else {
+		cluster.RecordEvent(ctx, kubeClient, app, corev1.EventTypeNormal, cluster.ReasonUpdated, commitSha,
+			fmt.Sprintf("cluster updated with fleet apply (%s)", action.Reason))
+	}
+	return nil
+}
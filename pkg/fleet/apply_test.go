@@ -0,0 +1,161 @@
+package fleet
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"arlon.io/arlon/pkg/cluster"
+	clustertesting "arlon.io/arlon/pkg/cluster/testing"
+	"arlon.io/arlon/pkg/gitutils"
+	projectpkg "github.com/argoproj/argo-cd/v2/pkg/apiclient/project"
+	argoappv1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	"google.golang.org/grpc"
+)
+
+// projectClient serves a default project allowing any source and
+// destination from Get.
+type projectClient struct {
+	projectpkg.ProjectServiceClient
+}
+
+func (c *projectClient) Get(_ context.Context, q *projectpkg.ProjectQuery, _ ...grpc.CallOption) (*argoappv1.AppProject, error) {
+	proj := &argoappv1.AppProject{Spec: argoappv1.AppProjectSpec{
+		SourceRepos:  []string{"*"},
+		Destinations: []argoappv1.ApplicationDestination{{Server: "*", Name: "*", Namespace: "*"}},
+	}}
+	proj.Name = q.Name
+	return proj, nil
+}
+
+func TestReadManifest(t *testing.T) {
+	manifest, err := ReadManifest(strings.NewReader(`repoUrl: https://git.example.com/fleet.git
+project: team-a
+clusters:
+- name: c1
+  clusterSpec: eks
+  profile: dev
+- name: c2
+  clusterSpec: eks
+  repoBranch: staging
+  path: clusters
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ClusterManifest{
+		{Name: "c1", ClusterSpec: "eks", Profile: "dev", RepoUrl: "https://git.example.com/fleet.git",
+			RepoBranch: "main", Path: "arlon", Project: "team-a"},
+		{Name: "c2", ClusterSpec: "eks", RepoUrl: "https://git.example.com/fleet.git",
+			RepoBranch: "staging", Path: "clusters", Project: "team-a"},
+	}
+	if !reflect.DeepEqual(manifest.Clusters, expected) {
+		t.Errorf("expected clusters %+v, got %+v", expected, manifest.Clusters)
+	}
+	for _, tc := range []struct {
+		manifest string
+		err      string
+	}{
+		{"repoUrl: https://git.example.com/fleet.git\nclusters:\n- clusterSpec: eks\n", "cluster 0 of the fleet manifest has no name"},
+		{"repoUrl: https://git.example.com/fleet.git\nclusters:\n- name: c1\n- name: c1\n", "cluster c1 is declared twice"},
+		{"clusters:\n- name: c1\n", "cluster c1 has no repoUrl"},
+		{"repo: https://git.example.com/fleet.git\n", "failed to parse fleet manifest"},
+	} {
+		if _, err := ReadManifest(strings.NewReader(tc.manifest)); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("expected manifest %q to be rejected with %q, got %v", tc.manifest, tc.err, err)
+		}
+	}
+}
+
+func TestPlanAndApply(t *testing.T) {
+	h := clustertesting.New(t,
+		clustertesting.ClusterSpec(clustertesting.ArlonNs, "eks", map[string]string{"region": "us-west-2"}),
+		clustertesting.InlineBundle(clustertesting.ArlonNs, "guestbook", "kind: ConfigMap\n"),
+		clustertesting.InlineBundle(clustertesting.ArlonNs, "redis", "kind: Secret\n"),
+		clustertesting.Profile(clustertesting.ArlonNs, "dev", "guestbook"),
+		clustertesting.Profile(clustertesting.ArlonNs, "prod", "guestbook", "redis"),
+	)
+	h.Deploy("c1", "dev", "eks")
+	h.Deploy("c3", "dev", "eks")
+	h.Deploy("c4", "dev", "eks")
+	manifest := &Manifest{Clusters: []ClusterManifest{
+		{Name: "c4", Profile: "prod"},
+		{Name: "c2", Profile: "dev"},
+		{Name: "c1", Profile: "dev"},
+	}}
+	for i := range manifest.Clusters {
+		c := &manifest.Clusters[i]
+		c.ClusterSpec, c.Project = "eks", "default"
+		c.RepoUrl, c.RepoBranch, c.Path = clustertesting.RepoUrl, clustertesting.RepoBranch, clustertesting.BasePath
+	}
+	ctx := context.Background()
+	newRepo := func() gitutils.GitRepo { return h.Git.NewRepo() }
+	plan := func(prune bool) []Action {
+		actions, err := Plan(ctx, h.KubeClient, newRepo, h.Apps, &projectClient{},
+			clustertesting.ArgocdNs, clustertesting.ArlonNs, manifest, prune)
+		if err != nil {
+			t.Fatalf("failed to plan: %s", err)
+		}
+		return actions
+	}
+	describe := func(actions []Action) []string {
+		var steps []string
+		for i := range actions {
+			steps = append(steps, actions[i].String())
+		}
+		return steps
+	}
+
+	expected := []string{
+		"unchanged cluster c1",
+		"create cluster c2",
+		"update cluster c4 (root application, git tree)",
+	}
+	if steps := describe(plan(false)); !reflect.DeepEqual(steps, expected) {
+		t.Errorf("expected the plan %q without pruning, got %q", expected, steps)
+	}
+	actions := plan(true)
+	expected = []string{
+		"unchanged cluster c1",
+		"create cluster c2",
+		"delete cluster c3",
+		"update cluster c4 (root application, git tree)",
+	}
+	if steps := describe(actions); !reflect.DeepEqual(steps, expected) {
+		t.Fatalf("expected the plan %q with pruning, got %q", expected, steps)
+	}
+
+	results := Apply(ctx, h.KubeClient, newRepo, h.Apps, clustertesting.ArgocdNs, clustertesting.ArlonNs, actions, 2)
+	for _, r := range results {
+		if r.Err != nil {
+			t.Errorf("failed to %s cluster %s: %s", r.Op, r.Cluster, r.Err)
+		}
+	}
+	if results[0].CommitSha != "" || results[1].CommitSha == "" || results[1].CommitSha != results[3].CommitSha {
+		t.Errorf("expected the created and updated clusters to be deployed in one commit, got %+v", results)
+	}
+	if results[2].CommitSha == "" || results[2].CommitSha == results[1].CommitSha {
+		t.Errorf("expected the deleted cluster to be removed in its own commit, got %+v", results)
+	}
+	var names []string
+	for _, app := range h.Apps.Apps() {
+		names = append(names, app.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"c1", "c2", "c4"}) {
+		t.Errorf("expected the root applications of the manifest's clusters, got %v", names)
+	}
+	if len(h.ClusterFiles("c3")) != 0 {
+		t.Error("expected the directory of the pruned cluster to be removed")
+	}
+	if _, ok := h.ClusterFiles("c4")["workload/redis/redis.yaml"]; !ok {
+		t.Errorf("expected the bundles of the prod profile to be deployed to c4, got %d files", len(h.ClusterFiles("c4")))
+	}
+	if profile := h.RootApp("c4").Labels[cluster.ProfileLabel]; profile != "prod" {
+		t.Errorf("expected the root application of c4 to have profile prod, got %s", profile)
+	}
+	if steps := describe(plan(true)); !reflect.DeepEqual(steps, []string{
+		"unchanged cluster c1", "unchanged cluster c2", "unchanged cluster c4"}) {
+		t.Errorf("expected the fleet to match its manifest once applied, got %q", steps)
+	}
+}
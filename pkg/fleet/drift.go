@@ -0,0 +1,82 @@
+package fleet
+
+import (
+	"arlon.io/arlon/pkg/cluster"
+	"arlon.io/arlon/pkg/gitutils"
+	"arlon.io/arlon/pkg/log"
+	"arlon.io/arlon/pkg/progress"
+	"context"
+	"fmt"
+	applicationpkg "github.com/argoproj/argo-cd/v2/pkg/apiclient/application"
+	"k8s.io/client-go/kubernetes"
+	"sort"
+)
+
+// Drift states of a cluster
+const (
+	DriftCurrent = "current"
+	DriftStale   = "stale"
+	// DriftUnknown is reported for clusters without a summary, or whose
+	// inputs couldn't be read
+	DriftUnknown = "unknown"
+)
+
+type ClusterDrift struct {
+	Name        string   `json:"name"`
+	State       string   `json:"state"`
+	Profile     string   `json:"profile,omitempty"`
+	ClusterSpec string   `json:"clusterSpec,omitempty"`
+	Changes     []string `json:"changes,omitempty"`
+	Error       string   `json:"error,omitempty"`
+}
+
+// GetDrift reports, for every arlon cluster, or those whose labels match
+// selector if it isn't empty, whether its clusterspec, profile or bundles
+// changed since it was last deployed, ordered by cluster name.
+func GetDrift(
+	ctx context.Context,
+	kubeClient kubernetes.Interface,
+	newRepo func() gitutils.GitRepo,
+	appIf applicationpkg.ApplicationServiceClient,
+	argocdNs string,
+	arlonNs string,
+	selector string,
+) ([]ClusterDrift, error) {
+	appSelector, err := cluster.ClusterSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	apps, err := appIf.List(ctx,
+		&applicationpkg.ApplicationQuery{Selector: appSelector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cluster root applications: %w", err)
+	}
+	var drifts []ClusterDrift
+	for i := range apps.Items {
+		app := &apps.Items[i]
+		progress.Step(ctx, "checking cluster %s", app.Name)
+		drift := ClusterDrift{Name: app.Name}
+		repo := newRepo()
+		changes, summary, err := cluster.DeployedDrift(ctx, kubeClient, repo, argocdNs, arlonNs, app)
+		_ = repo.Close()
+		switch {
+		case err != nil:
+			drift.State = DriftUnknown
+			drift.Error = log.Redact(err.Error())
+		case summary == nil:
+			drift.State = DriftUnknown
+			drift.Error = "no summary, deploy the cluster again to record one"
+		default:
+			drift.Profile, drift.ClusterSpec, drift.Changes = summary.Profile, summary.ClusterSpec, changes
+			drift.State = DriftCurrent
+			if len(changes) > 0 {
+				drift.State = DriftStale
+			}
+		}
+		drifts = append(drifts, drift)
+	}
+	sort.Slice(drifts, func(i, j int) bool {
+		return drifts[i].Name < drifts[j].Name
+	})
+	return drifts, nil
+}
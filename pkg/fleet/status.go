@@ -0,0 +1,174 @@
+package fleet
+
+import (
+	"arlon.io/arlon/pkg/cluster"
+	"arlon.io/arlon/pkg/log"
+	"context"
+	"fmt"
+	applicationpkg "github.com/argoproj/argo-cd/v2/pkg/apiclient/application"
+	clusterpkg "github.com/argoproj/argo-cd/v2/pkg/apiclient/cluster"
+	argoappv1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	"github.com/argoproj/gitops-engine/pkg/health"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+	"strconv"
+	"time"
+)
+
+// ClusterSelector selects the root applications of arlon clusters.
+const ClusterSelector = cluster.RootAppSelector
+
+// workloadClientTimeout bounds requests made to workload clusters, so that
+// an unreachable cluster doesn't stall the report for the whole fleet.
+const workloadClientTimeout = 10 * time.Second
+
+type BundleStatus struct {
+	Name   string `json:"name"`
+	Health string `json:"health"`
+	Sync   string `json:"sync"`
+}
+
+// Ready returns whether the bundle's application is healthy and synced.
+func (b *BundleStatus) Ready() bool {
+	return b.Health == string(health.HealthStatusHealthy) &&
+		b.Sync == string(argoappv1.SyncStatusCodeSynced)
+}
+
+type ClusterStatus struct {
+	Name              string         `json:"name"`
+	Health            string         `json:"health"`
+	Sync              string         `json:"sync"`
+	KubernetesVersion string         `json:"kubernetesVersion"`
+	DesiredNodes      int            `json:"desiredNodes"`
+	ReadyNodes        int            `json:"readyNodes"`
+	TotalNodes        int            `json:"totalNodes"`
+	Bundles           []BundleStatus `json:"bundles"`
+	Converged         bool           `json:"converged"`
+}
+
+// GetStatus reports the status of every arlon cluster, or of those whose
+// labels match selector if it isn't empty. A cluster is
+// converged when its root application and all of its bundle applications
+// are healthy and synced, and all of its desired nodes are ready.
+func GetStatus(
+	ctx context.Context,
+	kubeClient kubernetes.Interface,
+	appIf applicationpkg.ApplicationServiceClient,
+	clusterIf clusterpkg.ClusterServiceClient,
+	selector string,
+) ([]ClusterStatus, error) {
+	log := log.GetLogger()
+	appSelector, err := cluster.ClusterSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	apps, err := appIf.List(ctx,
+		&applicationpkg.ApplicationQuery{Selector: appSelector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cluster root applications: %w", err)
+	}
+	var statuses []ClusterStatus
+	for _, rootApp := range apps.Items {
+		status := ClusterStatus{
+			Name:   rootApp.Name,
+			Health: string(rootApp.Status.Health.Status),
+			Sync:   string(rootApp.Status.Sync.Status),
+		}
+		if rootApp.Spec.Source.Helm != nil {
+			for _, param := range rootApp.Spec.Source.Helm.Parameters {
+				switch param.Name {
+				case "kubernetesVersion":
+					status.KubernetesVersion = param.Value
+				case "nodeCount":
+					status.DesiredNodes, _ = strconv.Atoi(param.Value)
+				}
+			}
+		}
+		clust, err := clusterIf.Get(ctx,
+			&clusterpkg.ClusterQuery{Name: rootApp.Name})
+		if err != nil {
+			log.V(1).Info("cluster is not registered with argocd", "cluster", rootApp.Name)
+		} else if clust.Info.ServerVersion != "" {
+			status.KubernetesVersion = clust.Info.ServerVersion
+		}
+		status.ReadyNodes, status.TotalNodes, err = getNodeCounts(ctx, kubeClient, rootApp.Name)
+		if err != nil {
+			log.V(1).Info("failed to count nodes", "cluster", rootApp.Name, "error", err.Error())
+		}
+		status.Bundles = getBundleStatuses(ctx, appIf, &rootApp)
+		status.Converged = isConverged(&status)
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// -----------------------------------------------------------------------------
+
+// getBundleStatuses returns the status of the bundle applications generated
+// into the cluster's mgmt chart, which are resources of the root application.
+func getBundleStatuses(
+	ctx context.Context,
+	appIf applicationpkg.ApplicationServiceClient,
+	rootApp *argoappv1.Application,
+) (bundles []BundleStatus) {
+	for _, res := range rootApp.Status.Resources {
+		if res.Kind != "Application" {
+			continue
+		}
+		bundle := BundleStatus{Name: res.Name, Health: "Unknown", Sync: string(res.Status)}
+		app, err := appIf.Get(ctx, &applicationpkg.ApplicationQuery{Name: &res.Name})
+		if err == nil {
+			bundle.Health = string(app.Status.Health.Status)
+			bundle.Sync = string(app.Status.Sync.Status)
+		}
+		bundles = append(bundles, bundle)
+	}
+	return
+}
+
+// -----------------------------------------------------------------------------
+
+func getNodeCounts(ctx context.Context, kubeClient kubernetes.Interface, clusterName string) (ready int, total int, err error) {
+	data, err := cluster.GetKubeconfig(ctx, kubeClient, clusterName)
+	if err != nil {
+		return
+	}
+	config, err := clientcmd.RESTConfigFromKubeConfig(data)
+	if err != nil {
+		return
+	}
+	config.Timeout = workloadClientTimeout
+	workloadClient, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return
+	}
+	nodes, err := workloadClient.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return
+	}
+	for _, node := range nodes.Items {
+		for _, cond := range node.Status.Conditions {
+			if cond.Type == corev1.NodeReady && cond.Status == corev1.ConditionTrue {
+				ready++
+			}
+		}
+	}
+	return ready, len(nodes.Items), nil
+}
+
+// -----------------------------------------------------------------------------
+
+func isConverged(status *ClusterStatus) bool {
+	if status.Health != string(health.HealthStatusHealthy) ||
+		status.Sync != string(argoappv1.SyncStatusCodeSynced) {
+		return false
+	}
+	for _, bundle := range status.Bundles {
+		if !bundle.Ready() {
+			return false
+		}
+	}
+	return status.ReadyNodes >= status.DesiredNodes
+}
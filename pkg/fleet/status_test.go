@@ -0,0 +1,96 @@
+package fleet
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	clustertesting "arlon.io/arlon/pkg/cluster/testing"
+	clusterpkg "github.com/argoproj/argo-cd/v2/pkg/apiclient/cluster"
+	argoappv1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	"github.com/argoproj/gitops-engine/pkg/health"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+)
+
+// clusterClient serves the server versions of the clusters registered with
+// ArgoCD from Get.
+type clusterClient struct {
+	clusterpkg.ClusterServiceClient
+	versions map[string]string
+}
+
+func (c *clusterClient) Get(_ context.Context, q *clusterpkg.ClusterQuery, _ ...grpc.CallOption) (*argoappv1.Cluster, error) {
+	version, ok := c.versions[q.Name]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "cluster %s not found", q.Name)
+	}
+	return &argoappv1.Cluster{Name: q.Name, Info: argoappv1.ClusterInfo{ServerVersion: version}}, nil
+}
+
+func newApp(name string, labels map[string]string, healthStatus health.HealthStatusCode, sync argoappv1.SyncStatusCode) argoappv1.Application {
+	app := argoappv1.Application{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+	app.Status.Health.Status = healthStatus
+	app.Status.Sync.Status = sync
+	return app
+}
+
+func newRootApp(name string, nodeCount string, healthStatus health.HealthStatusCode, bundles ...string) argoappv1.Application {
+	app := newApp(name, map[string]string{"managed-by": "arlon", "arlon-type": "cluster", "team": name},
+		healthStatus, argoappv1.SyncStatusCodeSynced)
+	app.Spec.Source.Helm = &argoappv1.ApplicationSourceHelm{Parameters: []argoappv1.HelmParameter{
+		{Name: "kubernetesVersion", Value: "1.21"},
+		{Name: "nodeCount", Value: nodeCount},
+	}}
+	for _, b := range bundles {
+		app.Status.Resources = append(app.Status.Resources, argoappv1.ResourceStatus{
+			Kind:   "Application",
+			Name:   b,
+			Status: argoappv1.SyncStatusCodeOutOfSync,
+		})
+	}
+	return app
+}
+
+func TestGetStatus(t *testing.T) {
+	healthy, degraded := health.HealthStatusHealthy, health.HealthStatusDegraded
+	apps := clustertesting.NewAppClient(
+		newRootApp("c1", "0", healthy, "c1-guestbook"),
+		newApp("c1-guestbook", nil, healthy, argoappv1.SyncStatusCodeSynced),
+		newRootApp("c2", "0", healthy, "c2-guestbook", "c2-missing"),
+		newApp("c2-guestbook", nil, degraded, argoappv1.SyncStatusCodeSynced),
+		// the nodes of c3 can't be counted without its kubeconfig
+		newRootApp("c3", "3", healthy),
+		newApp("other", nil, healthy, argoappv1.SyncStatusCodeSynced),
+	)
+	clusters := &clusterClient{versions: map[string]string{"c1": "1.21.5"}}
+	statuses, err := GetStatus(context.Background(), k8sfake.NewSimpleClientset(), apps, clusters, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ClusterStatus{
+		{
+			Name: "c1", Health: "Healthy", Sync: "Synced", KubernetesVersion: "1.21.5",
+			Bundles:   []BundleStatus{{Name: "c1-guestbook", Health: "Healthy", Sync: "Synced"}},
+			Converged: true,
+		},
+		{
+			Name: "c2", Health: "Healthy", Sync: "Synced", KubernetesVersion: "1.21",
+			Bundles: []BundleStatus{
+				{Name: "c2-guestbook", Health: "Degraded", Sync: "Synced"},
+				{Name: "c2-missing", Health: "Unknown", Sync: "OutOfSync"},
+			},
+		},
+		{Name: "c3", Health: "Healthy", Sync: "Synced", KubernetesVersion: "1.21", DesiredNodes: 3},
+	}
+	if !reflect.DeepEqual(statuses, expected) {
+		t.Errorf("expected statuses\n%+v\ngot\n%+v", expected, statuses)
+	}
+	statuses, err = GetStatus(context.Background(), k8sfake.NewSimpleClientset(), apps, clusters, "team=c2")
+	if err != nil || len(statuses) != 1 || statuses[0].Name != "c2" {
+		t.Errorf("expected the selector to select c2, got %+v (%v)", statuses, err)
+	}
+}
@@ -0,0 +1,87 @@
+package fleet
+
+import (
+	"arlon.io/arlon/pkg/cluster"
+	"arlon.io/arlon/pkg/gitutils"
+	"arlon.io/arlon/pkg/progress"
+	"context"
+	"fmt"
+	applicationpkg "github.com/argoproj/argo-cd/v2/pkg/apiclient/application"
+	"k8s.io/client-go/kubernetes"
+	"sort"
+	"sync"
+)
+
+// SyncResult is the outcome of syncing the profile of a cluster.
+type SyncResult struct {
+	Cluster   string
+	Changed   bool
+	CommitSha string
+	Err       error
+}
+
+// SyncProfile renders the bundles of a profile again for every cluster
+// deployed with it, found by the profile labels of their root applications
+// and narrowed to those whose labels match selector if it isn't empty, and
+// returns the result of each ordered by cluster name. As with Apply, the
+// clusters sharing a repository branch are synced one after the other, and
+// branches concurrently by up to maxParallel workers.
+func SyncProfile(
+	ctx context.Context,
+	kubeClient kubernetes.Interface,
+	newRepo func() gitutils.GitRepo,
+	appIf applicationpkg.ApplicationServiceClient,
+	argocdNs string,
+	arlonNs string,
+	profileName string,
+	selector string,
+	dryRun bool,
+	maxParallel int,
+) ([]SyncResult, error) {
+	appSelector, err := cluster.ProfileSelector(arlonNs, profileName, selector)
+	if err != nil {
+		return nil, err
+	}
+	apps, err := appIf.List(ctx, &applicationpkg.ApplicationQuery{Selector: appSelector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cluster root applications: %w", err)
+	}
+	sort.Slice(apps.Items, func(i, j int) bool {
+		return apps.Items[i].Name < apps.Items[j].Name
+	})
+	if maxParallel < 1 {
+		maxParallel = 1
+	}
+	results := make([]SyncResult, len(apps.Items))
+	groups := make(map[string][]int)
+	var keys []string
+	for i, app := range apps.Items {
+		results[i] = SyncResult{Cluster: app.Name}
+		key := app.Spec.Source.RepoURL + "#" + app.Spec.Source.TargetRevision
+		if groups[key] == nil {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], i)
+	}
+	sem := make(chan struct{}, maxParallel)
+	var wg sync.WaitGroup
+	for _, key := range keys {
+		indexes := groups[key]
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			for _, i := range indexes {
+				r := &results[i]
+				progress.Step(ctx, "syncing profile %s of cluster %s", profileName, r.Cluster)
+				repo := newRepo()
+				r.CommitSha, r.Changed, r.Err = cluster.SyncProfile(ctx, kubeClient, repo, appIf,
+					argocdNs, arlonNs, r.Cluster, profileName, dryRun)
+				_ = repo.Close()
+			}
+		}()
+	}
+	wg.Wait()
+	return results, nil
+}
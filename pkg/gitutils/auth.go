@@ -0,0 +1,99 @@
+package gitutils
+
+import (
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Git hosting services whose token authentication needs special handling
+const (
+	HostGeneric     = "generic"
+	HostGitHub      = "github"
+	HostGitLab      = "gitlab"
+	HostBitbucket   = "bitbucket"
+	HostAzureDevOps = "azure-devops"
+)
+
+// DetectHost returns the git hosting service of a repository URL, from its
+// host name. Self-hosted GitHub Enterprise, GitLab and Bitbucket servers are
+// recognized when their host name starts with the service's name.
+func DetectHost(repoUrl string) string {
+	u, err := url.Parse(repoUrl)
+	if err != nil {
+		return HostGeneric
+	}
+	host := strings.ToLower(u.Hostname())
+	switch {
+	case host == "dev.azure.com" || host == "ssh.dev.azure.com" ||
+		strings.HasSuffix(host, ".visualstudio.com"):
+		return HostAzureDevOps
+	case host == "github.com" || strings.HasPrefix(host, "github."):
+		return HostGitHub
+	case host == "gitlab.com" || strings.HasPrefix(host, "gitlab."):
+		return HostGitLab
+	case host == "bitbucket.org" || strings.HasPrefix(host, "bitbucket."):
+		return HostBitbucket
+	}
+	return HostGeneric
+}
+
+// tokenUsernames are the usernames that the hosting services expect along
+// with an access token given without a username.
+var tokenUsernames = map[string]string{
+	HostGitHub:    "x-access-token",
+	HostGitLab:    "oauth2",
+	HostBitbucket: "x-token-auth",
+}
+
+// BasicAuth returns the HTTP basic authentication of a repository from the
+// username and password of its ArgoCD repository secret, shaped for the
+// repository's hosting service:
+//   - a token stored without a username is sent with the username the
+//     service expects for its access tokens, for e.g. oauth2 for GitLab.
+//     GitLab deploy tokens are stored with their own username, which is kept.
+//   - Azure DevOps personal access tokens are sent with an empty username,
+//     ignoring the organization name often stored as the username.
+func BasicAuth(repoUrl string, username string, password string) *http.BasicAuth {
+	host := DetectHost(repoUrl)
+	if password != "" {
+		if host == HostAzureDevOps {
+			username = ""
+		} else if username == "" {
+			username = tokenUsernames[host]
+		}
+	}
+	return &http.BasicAuth{Username: username, Password: password}
+}
+
+// capabilitiesMu guards go-git's global transport.UnsupportedCapabilities,
+// which clones read while negotiating with the server.
+var capabilitiesMu sync.RWMutex
+
+// withHostCapabilities runs fetch, which fetches from repoUrl, with the
+// capabilities the repository's hosting service needs. Azure DevOps only
+// supports clients implementing the multi_ack capabilities that go-git
+// declines by default. go-git then fails to fetch into existing repositories,
+// which arlon doesn't do since it always makes fresh clones, but also to
+// negotiate with servers that use them, such as GitHub. The setting is
+// global, so fetches from Azure DevOps exclude all other fetches.
+func withHostCapabilities(repoUrl string, fetch func() error) error {
+	if DetectHost(repoUrl) != HostAzureDevOps {
+		capabilitiesMu.RLock()
+		defer capabilitiesMu.RUnlock()
+		return fetch()
+	}
+	capabilitiesMu.Lock()
+	defer capabilitiesMu.Unlock()
+	saved := transport.UnsupportedCapabilities
+	transport.UnsupportedCapabilities = []capability.Capability{
+		capability.ThinPack,
+	}
+	defer func() {
+		transport.UnsupportedCapabilities = saved
+	}()
+	return fetch()
+}
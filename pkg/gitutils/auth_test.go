@@ -0,0 +1,82 @@
+package gitutils
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing/protocol/packp/capability"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+func TestBasicAuth(t *testing.T) {
+	for _, tc := range []struct {
+		url      string
+		username string
+		host     string
+		expected string
+	}{
+		{"https://github.com/example/fleet.git", "", HostGitHub, "x-access-token"},
+		{"https://github.example.com/example/fleet.git", "bot", HostGitHub, "bot"},
+		{"https://gitlab.com/example/fleet.git", "", HostGitLab, "oauth2"},
+		{"https://gitlab.com/example/fleet.git", "gitlab+deploy-token-42", HostGitLab, "gitlab+deploy-token-42"},
+		{"https://bitbucket.org/example/fleet.git", "", HostBitbucket, "x-token-auth"},
+		{"https://bitbucket.org/example/fleet.git", "user", HostBitbucket, "user"},
+		{"https://example@dev.azure.com/example/fleet/_git/fleet", "example", HostAzureDevOps, ""},
+		{"https://example.visualstudio.com/fleet/_git/fleet", "", HostAzureDevOps, ""},
+		{"https://git.example.com/fleet.git", "", HostGeneric, ""},
+		{"https://git.example.com/fleet.git", "user", HostGeneric, "user"},
+		{"::not a url", "", HostGeneric, ""},
+	} {
+		if host := DetectHost(tc.url); host != tc.host {
+			t.Errorf("DetectHost(%s): expected %s, got %s", tc.url, tc.host, host)
+		}
+		auth := BasicAuth(tc.url, tc.username, "token")
+		if auth.Username != tc.expected || auth.Password != "token" {
+			t.Errorf("BasicAuth(%s, %q): expected username %q, got %q", tc.url, tc.username, tc.expected,
+				auth.Username)
+		}
+	}
+	// without a password, the username is kept as is
+	if auth := BasicAuth("https://github.com/example/fleet.git", "", ""); auth.Username != "" {
+		t.Errorf("expected no username without a password, got %q", auth.Username)
+	}
+}
+
+func TestWithHostCapabilities(t *testing.T) {
+	declinesMultiACK := func() bool {
+		for _, c := range transport.UnsupportedCapabilities {
+			if c == capability.MultiACK {
+				return true
+			}
+		}
+		return false
+	}
+	var wg sync.WaitGroup
+	errs := make(chan string, 20)
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_ = withHostCapabilities("https://dev.azure.com/example/fleet/_git/fleet", func() error {
+				if declinesMultiACK() {
+					errs <- "expected multi_ack to be negotiated with Azure DevOps"
+				}
+				return nil
+			})
+		}()
+		go func() {
+			defer wg.Done()
+			_ = withHostCapabilities("https://github.com/example/fleet.git", func() error {
+				if !declinesMultiACK() {
+					errs <- "expected multi_ack to be declined with GitHub"
+				}
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
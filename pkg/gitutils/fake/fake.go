@@ -0,0 +1,422 @@
+// Package fake provides an in-memory implementation of gitutils.GitRepo
+// backed by an in-memory server, for tests and for tools embedding arlon
+// without access to a git server.
+package fake
+
+import (
+	"arlon.io/arlon/pkg/gitutils"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Commit is a commit pushed to a Server.
+type Commit struct {
+	Hash       string
+	Message    string
+	ParentHash string
+	// Files is the content of the branch after the commit
+	Files map[string][]byte
+}
+
+type branch struct {
+	commits []Commit
+}
+
+func (b *branch) files() map[string][]byte {
+	if len(b.commits) == 0 {
+		return map[string][]byte{}
+	}
+	return b.commits[len(b.commits)-1].Files
+}
+
+// Server holds in-memory repositories, each identified by its URL.
+type Server struct {
+	mu       sync.Mutex
+	branches map[string]*branch
+	// defaultBranch is the first branch created for each repository
+	defaultBranch map[string]string
+	// PushErr, if set, is returned by every push
+	PushErr error
+	// open counts the clones that weren't closed
+	open int
+}
+
+func NewServer() *Server {
+	return &Server{branches: make(map[string]*branch), defaultBranch: make(map[string]string)}
+}
+
+func branchKey(repoUrl string, repoBranch string) string {
+	return repoUrl + "#" + repoBranch
+}
+
+// CreateBranch creates a branch with an initial commit holding files. The
+// first branch created for a repository is its default branch.
+func (s *Server) CreateBranch(repoUrl string, repoBranch string, files map[string][]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.defaultBranch[repoUrl]; !ok {
+		s.defaultBranch[repoUrl] = repoBranch
+	}
+	b := &branch{}
+	b.commits = append(b.commits, newCommit("", "initial commit", copyFiles(files)))
+	s.branches[branchKey(repoUrl, repoBranch)] = b
+}
+
+// Commit pushes a commit to an existing branch as if it was made outside of
+// arlon. It updates the files with non-nil content and deletes the others.
+func (s *Server) Commit(repoUrl string, repoBranch string, msg string, files map[string][]byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := s.branches[branchKey(repoUrl, repoBranch)]
+	committed := copyFiles(b.files())
+	for name, data := range files {
+		if data == nil {
+			delete(committed, name)
+		} else {
+			committed[name] = data
+		}
+	}
+	b.commits = append(b.commits, newCommit(b.commits[len(b.commits)-1].Hash, msg, committed))
+}
+
+// Commits returns the commits of a branch, oldest first.
+func (s *Server) Commits(repoUrl string, repoBranch string) []Commit {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := s.branches[branchKey(repoUrl, repoBranch)]
+	if b == nil {
+		return nil
+	}
+	return append([]Commit(nil), b.commits...)
+}
+
+// Files returns the current content of a branch, keyed by path.
+func (s *Server) Files(repoUrl string, repoBranch string) map[string][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b := s.branches[branchKey(repoUrl, repoBranch)]
+	if b == nil {
+		return nil
+	}
+	return copyFiles(b.files())
+}
+
+// OpenClones returns the number of repositories cloned from the server that
+// weren't closed, for tests to check that none are leaked.
+func (s *Server) OpenClones() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.open
+}
+
+// NewRepo returns a GitRepo that clones from and pushes to the server.
+func (s *Server) NewRepo() *Repo {
+	return &Repo{server: s}
+}
+
+// -----------------------------------------------------------------------------
+
+// Repo is an in-memory gitutils.GitRepo.
+type Repo struct {
+	server     *Server
+	key        string
+	fs         billy.Filesystem
+	baseHead   int
+	committed  map[string][]byte
+	unpushed   []Commit
+	lastCommit string
+	// newBranch holds the commits the branch being created starts from,
+	// nil when the cloned branch already exists
+	newBranch []Commit
+}
+
+func (r *Repo) Clone(_ context.Context, repoUrl string, repoBranch string, _ transport.AuthMethod) error {
+	r.server.mu.Lock()
+	defer r.server.mu.Unlock()
+	key := branchKey(repoUrl, repoBranch)
+	b := r.server.branches[key]
+	if b == nil {
+		return fmt.Errorf("failed to clone repository: couldn't find remote ref %s of %s: %w",
+			repoBranch, repoUrl, gitutils.ErrBranchNotFound)
+	}
+	if err := r.checkout(key, b.commits); err != nil {
+		return err
+	}
+	r.newBranch = nil
+	return nil
+}
+
+func (r *Repo) CreateBranch(
+	_ context.Context,
+	repoUrl string,
+	repoBranch string,
+	_ transport.AuthMethod,
+	orphan bool,
+) error {
+	r.server.mu.Lock()
+	defer r.server.mu.Unlock()
+	defaultBranch, ok := r.server.defaultBranch[repoUrl]
+	if !ok {
+		return fmt.Errorf("failed to clone repository: repository %s not found", repoUrl)
+	}
+	var commits []Commit
+	if !orphan {
+		commits = r.server.branches[branchKey(repoUrl, defaultBranch)].commits
+	}
+	if err := r.checkout(branchKey(repoUrl, repoBranch), commits); err != nil {
+		return err
+	}
+	r.newBranch = append([]Commit{}, commits...)
+	return nil
+}
+
+func (r *Repo) checkout(key string, commits []Commit) error {
+	if r.fs == nil {
+		r.server.open++
+	}
+	r.key = key
+	r.fs = memfs.New()
+	r.committed = map[string][]byte{}
+	r.lastCommit = ""
+	if len(commits) > 0 {
+		r.committed = copyFiles(commits[len(commits)-1].Files)
+		r.lastCommit = commits[len(commits)-1].Hash
+	}
+	for name, data := range r.committed {
+		if err := util.WriteFile(r.fs, name, data, 0644); err != nil {
+			return fmt.Errorf("failed to check out %s: %w", name, err)
+		}
+	}
+	r.baseHead = len(commits)
+	r.unpushed = nil
+	return nil
+}
+
+func (r *Repo) Worktree() billy.Filesystem {
+	return r.fs
+}
+
+func (r *Repo) Close() error {
+	r.server.mu.Lock()
+	defer r.server.mu.Unlock()
+	if r.fs != nil {
+		r.server.open--
+		r.fs = nil
+	}
+	return nil
+}
+
+func (r *Repo) Commit(commitMsg string, groups ...gitutils.CommitGroup) (bool, error) {
+	files, err := snapshot(r.fs)
+	if err != nil {
+		return false, err
+	}
+	changed := changedFiles(r.committed, files)
+	if len(changed) == 0 {
+		return false, nil
+	}
+	msgs, batches := gitutils.SplitCommits(changed, groups, commitMsg)
+	for i, batch := range batches {
+		committed := copyFiles(r.committed)
+		for _, name := range batch {
+			if data, ok := files[name]; ok {
+				committed[name] = data
+			} else {
+				delete(committed, name)
+			}
+		}
+		commit := newCommit(r.lastCommit, msgs[i], committed)
+		r.unpushed = append(r.unpushed, commit)
+		r.committed = committed
+		r.lastCommit = commit.Hash
+	}
+	return true, nil
+}
+
+// Push fails like a non fast-forward push if the branch was pushed to
+// since the repository was cloned, or created if it is a new branch.
+func (r *Repo) Push(ctx context.Context) error {
+	r.server.mu.Lock()
+	defer r.server.mu.Unlock()
+	if r.server.PushErr != nil {
+		return fmt.Errorf("failed to push to remote repository: %s", r.server.PushErr)
+	}
+	b := r.server.branches[r.key]
+	if r.newBranch != nil {
+		if b != nil {
+			return fmt.Errorf("failed to push to remote repository: %w", gitutils.ErrPushConflict)
+		}
+		b = &branch{commits: r.newBranch}
+		r.server.branches[r.key] = b
+		r.newBranch = nil
+	}
+	if len(b.commits) != r.baseHead {
+		return fmt.Errorf("failed to push to remote repository: %w", gitutils.ErrPushConflict)
+	}
+	if gitutils.RecordsPushes(ctx) {
+		var base map[string][]byte
+		if r.baseHead > 0 {
+			base = b.commits[r.baseHead-1].Files
+		}
+		parts := strings.SplitN(r.key, "#", 2)
+		gitutils.RecordPush(ctx, gitutils.PushRecord{RepoUrl: parts[0], Branch: parts[1],
+			CommitSha: r.lastCommit, Files: changedFiles(base, r.committed)})
+	}
+	b.commits = append(b.commits, r.unpushed...)
+	r.baseHead = len(b.commits)
+	r.unpushed = nil
+	return nil
+}
+
+func (r *Repo) Head() (string, error) {
+	return r.lastCommit, nil
+}
+
+func (r *Repo) History(dir string) ([]gitutils.CommitInfo, error) {
+	var history []gitutils.CommitInfo
+	commits := r.commits()
+	for i := len(commits) - 1; i >= 0; i-- {
+		var parentFiles map[string][]byte
+		if i > 0 {
+			parentFiles = commits[i-1].Files
+		}
+		if !sameFiles(filesBelow(commits[i].Files, dir), filesBelow(parentFiles, dir)) {
+			history = append(history, gitutils.CommitInfo{
+				Hash:       commits[i].Hash,
+				Message:    commits[i].Message,
+				ParentHash: commits[i].ParentHash,
+			})
+		}
+	}
+	return history, nil
+}
+
+func (r *Repo) ReadTreeAt(commitSha string, dir string) (map[string][]byte, error) {
+	for _, commit := range r.commits() {
+		if commit.Hash == commitSha {
+			return copyFiles(filesBelow(commit.Files, dir)), nil
+		}
+	}
+	return nil, fmt.Errorf("failed to get commit %s: object not found", commitSha)
+}
+
+// commits returns the cloned commits followed by the unpushed ones.
+func (r *Repo) commits() []Commit {
+	r.server.mu.Lock()
+	defer r.server.mu.Unlock()
+	var commits []Commit
+	if r.newBranch != nil {
+		commits = append(commits, r.newBranch...)
+	} else if b := r.server.branches[r.key]; b != nil {
+		commits = append(commits, b.commits[:r.baseHead]...)
+	}
+	return append(commits, r.unpushed...)
+}
+
+// -----------------------------------------------------------------------------
+
+func newCommit(parentHash string, msg string, files map[string][]byte) Commit {
+	return Commit{Hash: hash(parentHash, msg, files), Message: msg, ParentHash: parentHash, Files: files}
+}
+
+func hash(parent string, msg string, files map[string][]byte) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s\n%s\n", parent, msg)
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(h, "%s\n%d\n", name, len(files[name]))
+		h.Write(files[name])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func snapshot(fs billy.Filesystem) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		items, err := fs.ReadDir(dir)
+		if err != nil {
+			return fmt.Errorf("failed to read directory %s: %w", dir, err)
+		}
+		for _, item := range items {
+			p := path.Join(dir, item.Name())
+			if item.IsDir() {
+				if err := walk(p); err != nil {
+					return err
+				}
+				continue
+			}
+			data, err := util.ReadFile(fs, p)
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", p, err)
+			}
+			files[p] = data
+		}
+		return nil
+	}
+	return files, walk("")
+}
+
+func filesBelow(files map[string][]byte, dir string) map[string][]byte {
+	below := make(map[string][]byte)
+	for name, data := range files {
+		if name == dir || strings.HasPrefix(name, dir+"/") {
+			below[name] = data
+		}
+	}
+	return below
+}
+
+// changedFiles returns the paths of the files added, changed or removed from
+// one tree to another, sorted.
+func changedFiles(from map[string][]byte, to map[string][]byte) []string {
+	var changed []string
+	for name, data := range to {
+		if prev, ok := from[name]; !ok || !bytes.Equal(prev, data) {
+			changed = append(changed, name)
+		}
+	}
+	for name := range from {
+		if _, ok := to[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+func sameFiles(a map[string][]byte, b map[string][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, data := range a {
+		other, ok := b[name]
+		if !ok || !bytes.Equal(data, other) {
+			return false
+		}
+	}
+	return true
+}
+
+func copyFiles(files map[string][]byte) map[string][]byte {
+	c := make(map[string][]byte, len(files))
+	for name, data := range files {
+		c[name] = append([]byte(nil), data...)
+	}
+	return c
+}
@@ -6,13 +6,17 @@ import (
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"os"
 	"path/filepath"
+	"sort"
 	"time"
 )
 
-func CommitChanges(tmpDir string, wt *gogit.Worktree) (changed bool, err error) {
+// CommitChanges commits the changes of the working tree: those below the
+// paths of each group in a commit with the group's message, and the others
+// in a last commit with commitMsg.
+func CommitChanges(tmpDir string, wt *gogit.Worktree, commitMsg string, groups []CommitGroup) (changed bool, err error) {
 	status, err := wt.Status()
 	if err != nil {
-		return false, fmt.Errorf("failed to get worktree status: %s", err)
+		return false, fmt.Errorf("failed to get worktree status: %w", err)
 	}
 
 	// The following was copied from flux2/internal/bootstrap/git/gogit/gogit.go:
@@ -21,9 +25,15 @@ func CommitChanges(tmpDir string, wt *gogit.Worktree) (changed bool, err error)
 	// whereby it thinks broken symlinks to absolute paths are
 	// modified. There's no circumstance in which we want to commit a
 	// change to a broken symlink: so, detect and skip those.
+	var files []string
 	for file, _ := range status {
 		abspath := filepath.Join(tmpDir, file)
 		info, err := os.Lstat(abspath)
+		if os.IsNotExist(err) {
+			// deleted file: Add() removes it from the index
+			files = append(files, file)
+			continue
+		}
 		if err != nil {
 			return false, fmt.Errorf("failed to check if %s is a symlink: %w", file, err)
 		}
@@ -35,24 +45,27 @@ func CommitChanges(tmpDir string, wt *gogit.Worktree) (changed bool, err error)
 				continue
 			}
 		}
-		_, _ = wt.Add(file)
-		changed = true
+		files = append(files, file)
 	}
 
-	if !changed {
-		return false, nil
-	}
-	commitOpts := &gogit.CommitOptions{
-		Author: &object.Signature{
-			Name:  "arlon automation",
-			Email: "arlon@arlon.io",
-			When:  time.Now(),
-		},
-	}
-	commitMsg := "add arlon manifests"
-	_, err = wt.Commit(commitMsg, commitOpts)
-	if err != nil {
-		return changed, fmt.Errorf("failed to commit change: %s", err)
+	sort.Strings(files)
+	msgs, batches := SplitCommits(files, groups, commitMsg)
+	for i, batch := range batches {
+		for _, file := range batch {
+			_, _ = wt.Add(file)
+		}
+		commitOpts := &gogit.CommitOptions{
+			Author: &object.Signature{
+				Name:  "arlon automation",
+				Email: "arlon@arlon.io",
+				When:  time.Now(),
+			},
+		}
+		_, err = wt.Commit(msgs[i], commitOpts)
+		if err != nil {
+			return changed, fmt.Errorf("failed to commit change: %w", err)
+		}
+		changed = true
 	}
 	return
 }
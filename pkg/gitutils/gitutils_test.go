@@ -0,0 +1,57 @@
+package gitutils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/util"
+	gogit "github.com/go-git/go-git/v5"
+)
+
+func TestCommitChanges(t *testing.T) {
+	dir := newOrigin(t)
+	repo, err := gogit.PlainOpen(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed, err := CommitChanges(dir, wt, "nothing to commit", nil)
+	if err != nil || changed {
+		t.Fatalf("expected a clean working tree to commit nothing, got %t (%v)", changed, err)
+	}
+	// a renamed cluster's directory is moved: the old files are deleted
+	if err := util.WriteFile(wt.Filesystem, "arlon/c2/README.md", []byte("c2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := wt.Filesystem.Remove("README.md"); err != nil {
+		t.Fatal(err)
+	}
+	changed, err = CommitChanges(dir, wt, "rename cluster c1 to c2", nil)
+	if err != nil || !changed {
+		t.Fatalf("expected the changes to be committed, got %t (%v)", changed, err)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(commit.Message, "rename cluster c1 to c2") {
+		t.Errorf("unexpected commit message %q", commit.Message)
+	}
+	if _, err := commit.File("README.md"); err == nil {
+		t.Error("expected the deleted file to be removed by the commit")
+	}
+	if _, err := commit.File("arlon/c2/README.md"); err != nil {
+		t.Errorf("expected the added file to be committed: %s", err)
+	}
+	status, err := wt.Status()
+	if err != nil || !status.IsClean() {
+		t.Errorf("expected a clean working tree after the commit, got %v (%v)", status, err)
+	}
+}
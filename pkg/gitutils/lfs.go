@@ -0,0 +1,49 @@
+package gitutils
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-git/v5/plumbing/format/gitattributes"
+	"strings"
+)
+
+type skipLFSKey struct{}
+
+// WithSkipLFS returns a context under which repositories using git LFS are
+// cloned even though go-git can't fetch their LFS objects. Their LFS files
+// are left as pointer files, and committing changes to files tracked by LFS
+// fails instead of storing them as regular git objects.
+func WithSkipLFS(ctx context.Context) context.Context {
+	return context.WithValue(ctx, skipLFSKey{}, true)
+}
+
+func skipLFS(ctx context.Context) bool {
+	skip, _ := ctx.Value(skipLFSKey{}).(bool)
+	return skip
+}
+
+// lfsMatcher returns a matcher of the files that the .gitattributes files of
+// the working tree have git LFS store, or nil if the repository doesn't use
+// LFS.
+func lfsMatcher(fsys billy.Filesystem) (gitattributes.Matcher, error) {
+	attrs, err := gitattributes.ReadPatterns(fsys, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read .gitattributes: %w", err)
+	}
+	for _, attr := range attrs {
+		for _, a := range attr.Attributes {
+			if a.Name() == "filter" && a.IsValueSet() && a.Value() == "lfs" {
+				return gitattributes.NewMatcher(attrs), nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// lfsTracked returns whether git LFS stores the file at path.
+func lfsTracked(matcher gitattributes.Matcher, path string) bool {
+	results, _ := matcher.Match(strings.Split(path, "/"), []string{"filter"})
+	filter, ok := results["filter"]
+	return ok && filter.IsValueSet() && filter.Value() == "lfs"
+}
@@ -0,0 +1,92 @@
+package gitutils
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/util"
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// newLFSOrigin creates an origin like newOrigin whose .gitattributes have
+// git LFS store binaries and chart archives.
+func newLFSOrigin(t *testing.T) string {
+	dir := newOrigin(t)
+	repo, err := gogit.PlainOpen(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		".gitattributes":        "*.bin filter=lfs diff=lfs merge=lfs -text\n*.md text\n",
+		"charts/.gitattributes": "*.tgz filter=lfs diff=lfs merge=lfs -text\n",
+		"charts/nginx.tgz":      "version https://git-lfs.github.com/spec/v1\n",
+	} {
+		if err := util.WriteFile(wt.Filesystem, name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := wt.Add(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = wt.Commit("track binaries with LFS", &gogit.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestCloneLFS(t *testing.T) {
+	origin := newLFSOrigin(t)
+	repo := NewRepo()
+	defer repo.Close()
+	err := repo.Clone(context.Background(), origin, "main", nil)
+	if err == nil || !strings.Contains(err.Error(), "uses git LFS") {
+		t.Fatalf("expected a repository using LFS to be refused, got %v", err)
+	}
+	if err := repo.Clone(WithSkipLFS(context.Background()), origin, "main", nil); err != nil {
+		t.Fatalf("expected --skip-lfs to clone the repository, got %v", err)
+	}
+	for _, name := range []string{"arlon/c1/image.bin", "charts/redis.tgz"} {
+		if err := util.WriteFile(repo.Worktree(), name, []byte("binary\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := repo.Commit("add " + name)
+		if err == nil || !strings.Contains(err.Error(), "can't commit "+name+", which is tracked by git LFS") {
+			t.Errorf("expected committing %s to be refused, got %v", name, err)
+		}
+		if err := repo.Worktree().Remove(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// files LFS doesn't store are committed, and LFS files can be deleted
+	if err := util.WriteFile(repo.Worktree(), "arlon/c1/README.md", []byte("c1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Worktree().Remove("charts/nginx.tgz"); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := repo.Commit("update c1"); err != nil || !changed {
+		t.Errorf("expected the changes to be committed, got %t (%v)", changed, err)
+	}
+}
+
+func TestLFSMatcher(t *testing.T) {
+	repo := NewRepo()
+	defer repo.Close()
+	if err := repo.Clone(context.Background(), newOrigin(t), "main", nil); err != nil {
+		t.Fatal(err)
+	}
+	matcher, err := lfsMatcher(repo.Worktree())
+	if err != nil || matcher != nil {
+		t.Errorf("expected a repository without LFS to have no matcher, got %v (%v)", matcher, err)
+	}
+}
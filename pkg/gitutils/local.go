@@ -0,0 +1,23 @@
+package gitutils
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// IsLocal returns whether a repository URL designates a repository on the
+// local filesystem, such as a bare mirror of an air-gapped site: a file://
+// URL, or an absolute path like /srv/git/fleet.git or C:\git\fleet.git.
+// Local repositories are cloned without credentials.
+func IsLocal(repoUrl string) bool {
+	return strings.HasPrefix(repoUrl, "file://") || filepath.IsAbs(repoUrl) ||
+		// a Windows path, also when running elsewhere
+		len(repoUrl) > 2 && repoUrl[1] == ':' && (repoUrl[2] == '\\' || repoUrl[2] == '/')
+}
+
+// SlashPath returns a path within a repository with forward slashes, which
+// git and ArgoCD use on all systems, e.g. for a path typed on Windows
+// as arlon\team-a.
+func SlashPath(p string) string {
+	return strings.ReplaceAll(p, `\`, "/")
+}
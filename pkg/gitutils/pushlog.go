@@ -0,0 +1,69 @@
+package gitutils
+
+import (
+	"context"
+	"sync"
+)
+
+// PushRecord describes the commits pushed to a repository branch.
+type PushRecord struct {
+	RepoUrl   string
+	Branch    string
+	CommitSha string
+	// Files lists the paths of the files changed by the pushed commits,
+	// sorted
+	Files []string
+}
+
+// PushLog collects the pushes made under a context returned by WithPushLog,
+// for e.g. to report them once a command completes.
+type PushLog struct {
+	mu      sync.Mutex
+	records []PushRecord
+}
+
+type pushLogKey struct{}
+
+// WithPushLog returns a context under which the pushes of GitRepo
+// implementations are recorded to the returned log.
+func WithPushLog(ctx context.Context) (context.Context, *PushLog) {
+	log := &PushLog{}
+	return context.WithValue(ctx, pushLogKey{}, log), log
+}
+
+// Records returns the recorded pushes, in the order they were made.
+func (l *PushLog) Records() []PushRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]PushRecord{}, l.records...)
+}
+
+// Find returns the record of the push of a commit, if it was recorded.
+func (l *PushLog) Find(commitSha string) (PushRecord, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, record := range l.records {
+		if record.CommitSha == commitSha {
+			return record, true
+		}
+	}
+	return PushRecord{}, false
+}
+
+// RecordsPushes returns whether the pushes made under ctx are recorded, so
+// that GitRepo implementations only work out the changed files if needed.
+func RecordsPushes(ctx context.Context) bool {
+	_, ok := ctx.Value(pushLogKey{}).(*PushLog)
+	return ok
+}
+
+// RecordPush records a push to the log of ctx, if it has one.
+func RecordPush(ctx context.Context, record PushRecord) {
+	log, ok := ctx.Value(pushLogKey{}).(*PushLog)
+	if !ok {
+		return
+	}
+	log.mu.Lock()
+	defer log.mu.Unlock()
+	log.records = append(log.records, record)
+}
@@ -0,0 +1,394 @@
+package gitutils
+
+import (
+	"arlon.io/arlon/pkg/log"
+	"arlon.io/arlon/pkg/progress"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/gitattributes"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// GitRepo abstracts the operations arlon performs on a git repository, so
+// that code writing to repositories can be exercised without a git server.
+// The fake subpackage provides an in-memory implementation.
+type GitRepo interface {
+	// Clone checks out a branch of the repository at repoUrl. It fails with
+	// ErrBranchNotFound if the branch does not exist.
+	Clone(ctx context.Context, repoUrl string, repoBranch string, auth transport.AuthMethod) error
+	// CreateBranch clones the default branch of the repository at repoUrl
+	// and checks out a new branch from it, or a new branch without history
+	// if orphan is set. The branch is created remotely on push.
+	CreateBranch(ctx context.Context, repoUrl string, repoBranch string, auth transport.AuthMethod, orphan bool) error
+	// Worktree returns the working tree of the cloned repository
+	Worktree() billy.Filesystem
+	// Commit commits all changes in the working tree, if there are any.
+	// The changes of each group are committed separately, in order, before
+	// the remaining ones are committed with commitMsg.
+	Commit(commitMsg string, groups ...CommitGroup) (changed bool, err error)
+	// Push pushes the commits made since the clone to the remote branch
+	Push(ctx context.Context) error
+	// Head returns the hash of the current commit
+	Head() (string, error)
+	// History returns the commits of the cloned branch that changed files
+	// below dir, newest first
+	History(dir string) ([]CommitInfo, error)
+	// ReadTreeAt returns the content of the files below dir at a commit,
+	// keyed by path. It is empty if dir did not exist.
+	ReadTreeAt(commitSha string, dir string) (map[string][]byte, error)
+	// Close removes the local clone, after which the repository can only
+	// be cloned again. Callers close every repository they create.
+	Close() error
+}
+
+// CommitInfo describes a commit returned by GitRepo.History.
+type CommitInfo struct {
+	Hash    string
+	Message string
+	// ParentHash is empty for the first commit of a branch
+	ParentHash string
+}
+
+// ErrBranchNotFound is returned when cloning a branch that doesn't exist.
+var ErrBranchNotFound = errors.New("branch not found")
+
+// ErrPushConflict is returned when a push is rejected because the remote
+// branch moved since the clone. Cloning again and redoing the change is
+// expected to succeed.
+var ErrPushConflict = errors.New("non-fast-forward update")
+
+// Branch creation modes of CloneBranch
+const (
+	CreateBranchNever       = ""
+	CreateBranchFromDefault = "default"
+	CreateBranchOrphan      = "orphan"
+)
+
+// CloneBranch clones a branch of the repository at repoUrl. If it doesn't
+// exist, it is created according to createMode: from the default branch,
+// as an orphan branch without history, or not at all.
+func CloneBranch(
+	ctx context.Context,
+	repo GitRepo,
+	repoUrl string,
+	repoBranch string,
+	auth transport.AuthMethod,
+	createMode string,
+) error {
+	switch createMode {
+	case CreateBranchNever, CreateBranchFromDefault, CreateBranchOrphan:
+	default:
+		return fmt.Errorf("invalid branch creation mode %s", createMode)
+	}
+	err := repo.Clone(ctx, repoUrl, repoBranch, auth)
+	if err == nil || !errors.Is(err, ErrBranchNotFound) || createMode == CreateBranchNever {
+		return err
+	}
+	return repo.CreateBranch(ctx, repoUrl, repoBranch, auth, createMode == CreateBranchOrphan)
+}
+
+// NewRepo returns a GitRepo that is cloned into a temporary directory.
+func NewRepo() GitRepo {
+	return &goGitRepo{}
+}
+
+type goGitRepo struct {
+	repo      *gogit.Repository
+	wt        *gogit.Worktree
+	tmpDir    string
+	auth      transport.AuthMethod
+	branchRef plumbing.ReferenceName
+	// lfs matches the files tracked by git LFS, if the repository uses it
+	lfs     gitattributes.Matcher
+	repoUrl string
+	// base is the commit the branch was cloned at, zero for an orphan
+	// branch
+	base plumbing.Hash
+}
+
+func (r *goGitRepo) Clone(ctx context.Context, repoUrl string, repoBranch string, auth transport.AuthMethod) error {
+	return r.clone(ctx, repoUrl, plumbing.NewBranchReferenceName(repoBranch), auth)
+}
+
+func (r *goGitRepo) CreateBranch(
+	ctx context.Context,
+	repoUrl string,
+	repoBranch string,
+	auth transport.AuthMethod,
+	orphan bool,
+) error {
+	// an empty reference clones the remote's default branch. This fetches
+	// all branches since go-git assumes master for single branch clones of HEAD.
+	err := r.clone(ctx, repoUrl, "", auth)
+	if err != nil {
+		return err
+	}
+	branchRef := plumbing.NewBranchReferenceName(repoBranch)
+	if orphan {
+		err = r.repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, branchRef))
+		if err != nil {
+			return fmt.Errorf("failed to create orphan branch %s: %w", repoBranch, err)
+		}
+		r.base = plumbing.ZeroHash
+		err = r.repo.Storer.SetIndex(&index.Index{Version: 2})
+		if err != nil {
+			return fmt.Errorf("failed to reset index: %w", err)
+		}
+		items, err := r.wt.Filesystem.ReadDir("")
+		if err != nil {
+			return fmt.Errorf("failed to read working tree: %w", err)
+		}
+		for _, item := range items {
+			if item.Name() == gogit.GitDirName {
+				continue
+			}
+			if err := util.RemoveAll(r.wt.Filesystem, item.Name()); err != nil {
+				return fmt.Errorf("failed to clear working tree: %w", err)
+			}
+		}
+	} else {
+		err = r.wt.Checkout(&gogit.CheckoutOptions{Branch: branchRef, Create: true})
+		if err != nil {
+			return fmt.Errorf("failed to create branch %s: %w", repoBranch, err)
+		}
+	}
+	r.branchRef = branchRef
+	return nil
+}
+
+func (r *goGitRepo) clone(
+	ctx context.Context,
+	repoUrl string,
+	branchRef plumbing.ReferenceName,
+	auth transport.AuthMethod,
+) (err error) {
+	// a repository is cloned again to create a missing branch
+	if err := r.Close(); err != nil {
+		return err
+	}
+	tmpDir, err := os.MkdirTemp("", "arlon-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = os.RemoveAll(tmpDir)
+		}
+	}()
+	var repo *gogit.Repository
+	err = withHostCapabilities(repoUrl, func() (err error) {
+		repo, err = gogit.PlainCloneContext(ctx, tmpDir, false, &gogit.CloneOptions{
+			URL:           repoUrl,
+			Auth:          auth,
+			RemoteName:    gogit.DefaultRemoteName,
+			ReferenceName: branchRef,
+			SingleBranch:  branchRef != "",
+			NoCheckout:    false,
+			Progress:      progress.Writer(ctx),
+			Tags:          gogit.NoTags,
+			CABundle:      nil,
+		})
+		return
+	})
+	if errors.Is(err, gogit.NoMatchingRefSpecError{}) {
+		return fmt.Errorf("failed to clone repository: %s: %w", branchRef.Short(), ErrBranchNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to clone repository: %w", err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("failed to get repo worktree: %w", err)
+	}
+	lfs, err := lfsMatcher(wt.Filesystem)
+	if err != nil {
+		return err
+	}
+	if lfs != nil && !skipLFS(ctx) {
+		return fmt.Errorf("repository %s uses git LFS, which arlon doesn't support "+
+			"(--skip-lfs leaves its LFS files untouched)", repoUrl)
+	}
+	r.repo, r.wt, r.tmpDir, r.auth, r.branchRef, r.lfs = repo, wt, tmpDir, auth, branchRef, lfs
+	r.repoUrl, r.base = repoUrl, plumbing.ZeroHash
+	if head, err := repo.Head(); err == nil {
+		r.base = head.Hash()
+	}
+	return nil
+}
+
+func (r *goGitRepo) Close() error {
+	if r.tmpDir == "" {
+		return nil
+	}
+	err := os.RemoveAll(r.tmpDir)
+	r.repo, r.wt, r.tmpDir = nil, nil, ""
+	if err != nil {
+		return fmt.Errorf("failed to remove clone: %w", err)
+	}
+	return nil
+}
+
+func (r *goGitRepo) Worktree() billy.Filesystem {
+	return r.wt.Filesystem
+}
+
+func (r *goGitRepo) Commit(commitMsg string, groups ...CommitGroup) (bool, error) {
+	if r.lfs != nil {
+		// LFS files would be committed as regular git objects, which
+		// repositories enforcing LFS reject on push
+		status, err := r.wt.Status()
+		if err != nil {
+			return false, fmt.Errorf("failed to get worktree status: %w", err)
+		}
+		for file, fileStatus := range status {
+			if fileStatus.Worktree != gogit.Deleted && lfsTracked(r.lfs, file) {
+				return false, fmt.Errorf("can't commit %s, which is tracked by git LFS", file)
+			}
+		}
+	}
+	return CommitChanges(r.tmpDir, r.wt, commitMsg, groups)
+}
+
+func (r *goGitRepo) Push(ctx context.Context) error {
+	refSpec := config.RefSpec(fmt.Sprintf("%s:%s", r.branchRef, r.branchRef))
+	err := r.repo.PushContext(ctx, &gogit.PushOptions{
+		RemoteName: gogit.DefaultRemoteName,
+		RefSpecs:   []config.RefSpec{refSpec},
+		Auth:       r.auth,
+		Progress:   progress.Writer(ctx),
+		CABundle:   nil,
+	})
+	if err != nil && (strings.Contains(err.Error(), "non-fast-forward") ||
+		strings.Contains(err.Error(), "fetch first")) {
+		// go-git and git servers only report the rejection as text
+		return fmt.Errorf("failed to push to remote repository: %w", ErrPushConflict)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to push to remote repository: %w", err)
+	}
+	if RecordsPushes(ctx) {
+		// the push succeeded even if its changes can't be worked out
+		if err := r.recordPush(ctx); err != nil {
+			log.GetLogger().Error(err, "failed to record push", "repoUrl", r.repoUrl)
+		}
+	}
+	return nil
+}
+
+// recordPush records the files changed since the clone to the push log of
+// ctx.
+func (r *goGitRepo) recordPush(ctx context.Context) error {
+	head, err := r.repo.Head()
+	if err != nil {
+		return fmt.Errorf("failed to resolve HEAD: %w", err)
+	}
+	var trees [2]*object.Tree
+	for i, hash := range []plumbing.Hash{r.base, head.Hash()} {
+		if hash.IsZero() {
+			continue
+		}
+		commit, err := r.repo.CommitObject(hash)
+		if err == nil {
+			trees[i], err = commit.Tree()
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get tree of commit %s: %w", hash, err)
+		}
+	}
+	changes, err := object.DiffTree(trees[0], trees[1])
+	if err != nil {
+		return fmt.Errorf("failed to diff pushed commits: %w", err)
+	}
+	var files []string
+	for _, change := range changes {
+		name := change.To.Name
+		if name == "" {
+			name = change.From.Name
+		}
+		files = append(files, name)
+	}
+	sort.Strings(files)
+	RecordPush(ctx, PushRecord{RepoUrl: r.repoUrl, Branch: r.branchRef.Short(),
+		CommitSha: head.Hash().String(), Files: files})
+	return nil
+}
+
+func (r *goGitRepo) Head() (string, error) {
+	head, err := r.repo.Head()
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve HEAD: %w", err)
+	}
+	return head.Hash().String(), nil
+}
+
+func (r *goGitRepo) History(dir string) ([]CommitInfo, error) {
+	head, err := r.repo.Head()
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve HEAD: %w", err)
+	}
+	iter, err := r.repo.Log(&gogit.LogOptions{
+		From: head.Hash(),
+		PathFilter: func(p string) bool {
+			return p == dir || strings.HasPrefix(p, dir+"/")
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	var history []CommitInfo
+	err = iter.ForEach(func(c *object.Commit) error {
+		info := CommitInfo{Hash: c.Hash.String(), Message: c.Message}
+		if len(c.ParentHashes) > 0 {
+			info.ParentHash = c.ParentHashes[0].String()
+		}
+		history = append(history, info)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	return history, nil
+}
+
+func (r *goGitRepo) ReadTreeAt(commitSha string, dir string) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	commit, err := r.repo.CommitObject(plumbing.NewHash(commitSha))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get commit %s: %w", commitSha, err)
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tree of commit %s: %w", commitSha, err)
+	}
+	subtree, err := tree.Tree(dir)
+	if err == object.ErrDirectoryNotFound {
+		return files, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get %s at commit %s: %w", dir, commitSha, err)
+	}
+	err = subtree.Files().ForEach(func(f *object.File) error {
+		content, err := f.Contents()
+		if err != nil {
+			return err
+		}
+		files[path.Join(dir, f.Name)] = []byte(content)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s at commit %s: %w", dir, commitSha, err)
+	}
+	return files, nil
+}
@@ -0,0 +1,138 @@
+package gitutils
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/util"
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// newOrigin creates a repository in a temporary directory with a single
+// commit on branch main, to be cloned by path.
+func newOrigin(t *testing.T) string {
+	dir := t.TempDir()
+	repo, err := gogit.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName("main")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := util.WriteFile(wt.Filesystem, "README.md", []byte("origin\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wt.Add("README.md"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = wt.Commit("initial commit", &gogit.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestClose(t *testing.T) {
+	origin := newOrigin(t)
+	repo := NewRepo().(*goGitRepo)
+	if err := repo.Clone(context.Background(), origin, "main", nil); err != nil {
+		t.Fatal(err)
+	}
+	tmpDir := repo.tmpDir
+	if _, err := util.ReadFile(repo.Worktree(), "README.md"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(tmpDir); !os.IsNotExist(err) {
+		t.Fatalf("clone %s not removed: %v", tmpDir, err)
+	}
+	// closing twice is harmless
+	if err := repo.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCloneMissingBranch(t *testing.T) {
+	origin := newOrigin(t)
+	repo := NewRepo().(*goGitRepo)
+	defer repo.Close()
+	err := repo.Clone(context.Background(), origin, "missing", nil)
+	if !errors.Is(err, ErrBranchNotFound) {
+		t.Fatalf("expected ErrBranchNotFound, got %v", err)
+	}
+	if repo.tmpDir != "" {
+		t.Fatalf("failed clone left %s", repo.tmpDir)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	origin := newOrigin(t)
+	repo := NewRepo()
+	defer repo.Close()
+	if err := repo.Clone(context.Background(), origin, "main", nil); err != nil {
+		t.Fatal(err)
+	}
+	initial, err := repo.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		msg   string
+		files map[string]string
+	}{
+		{"deploy c1", map[string]string{"arlon/c1/mgmt/Chart.yaml": "v1\n"}},
+		{"deploy c2", map[string]string{"arlon/c2/mgmt/Chart.yaml": "v1\n"}},
+		{"update c1", map[string]string{"arlon/c1/mgmt/Chart.yaml": "v2\n", "arlon/c1/NOTES.md": "notes\n"}},
+	} {
+		for name, content := range c.files {
+			if err := util.WriteFile(repo.Worktree(), name, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := repo.Commit(c.msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	history, err := repo.History("arlon/c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 || !strings.HasPrefix(history[0].Message, "update c1") ||
+		!strings.HasPrefix(history[1].Message, "deploy c1") {
+		t.Fatalf("expected the two commits of c1, newest first, got %+v", history)
+	}
+	if history[1].ParentHash != initial {
+		t.Errorf("expected the deployment of c1 to follow the initial commit, got parent %s", history[1].ParentHash)
+	}
+	if history, err := repo.History("arlon/c"); err != nil || len(history) != 0 {
+		t.Errorf("expected no commit below a directory prefixing others, got %+v (%v)", history, err)
+	}
+
+	previous, err := repo.ReadTreeAt(history[0].ParentHash, "arlon/c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(previous, map[string][]byte{"arlon/c1/mgmt/Chart.yaml": []byte("v1\n")}) {
+		t.Errorf("unexpected files of c1 before its update: %q", previous)
+	}
+	missing, err := repo.ReadTreeAt(initial, "arlon/c1")
+	if err != nil || len(missing) != 0 {
+		t.Errorf("expected no files before c1 was deployed, got %q (%v)", missing, err)
+	}
+}
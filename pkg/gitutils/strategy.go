@@ -0,0 +1,112 @@
+package gitutils
+
+import (
+	"context"
+	"fmt"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"strings"
+)
+
+// Commit strategies, selecting how the changes an operation makes to a
+// repository are split into commits.
+const (
+	// CommitSquash commits all the changes of an operation at once
+	CommitSquash = "squash"
+	// CommitPerBundle commits the changes of each bundle of a cluster, and
+	// those of the rest of its mgmt chart, separately for reviewability
+	CommitPerBundle = "per-bundle"
+)
+
+// CommitGroup holds the changes below any of its paths, which are committed
+// separately with its message when the per-bundle strategy is used.
+type CommitGroup struct {
+	Paths   []string
+	Message string
+}
+
+// contains returns whether file is below one of the group's paths.
+func (g *CommitGroup) contains(file string) bool {
+	for _, p := range g.Paths {
+		if file == p || strings.HasPrefix(file, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+type commitStrategyKey struct{}
+
+// WithCommitStrategy returns a context under which repositories are written
+// to with the given commit strategy, instead of CommitSquash.
+func WithCommitStrategy(ctx context.Context, strategy string) context.Context {
+	return context.WithValue(ctx, commitStrategyKey{}, strategy)
+}
+
+// CommitGroups returns the groups to pass to GitRepo.Commit under the commit
+// strategy of ctx: none if changes are squashed into a single commit.
+func CommitGroups(ctx context.Context, groups []CommitGroup) ([]CommitGroup, error) {
+	strategy, _ := ctx.Value(commitStrategyKey{}).(string)
+	switch strategy {
+	case "", CommitSquash:
+		return nil, nil
+	case CommitPerBundle:
+		return groups, nil
+	}
+	return nil, fmt.Errorf("invalid commit strategy %s", strategy)
+}
+
+// OperationTrailer is the git trailer that marks the commits made by arlon.
+// Its value identifies the GitRepo.Commit call, and so the operation, that
+// made the commit.
+const OperationTrailer = "Arlon-Operation"
+
+// Operation returns the ID recorded by the OperationTrailer of a commit
+// message, or "" if the commit wasn't made by arlon.
+func Operation(commitMsg string) string {
+	lines := strings.Split(strings.TrimRight(commitMsg, "\n"), "\n")
+	for i := len(lines) - 1; i >= 0 && lines[i] != ""; i-- {
+		if id := strings.TrimPrefix(lines[i], OperationTrailer+": "); id != lines[i] {
+			return strings.TrimSpace(id)
+		}
+	}
+	return ""
+}
+
+// SplitCommits splits the changed files of a working tree into the commits
+// GitRepo.Commit makes: the files of each group that contains any, in order,
+// then the remaining ones with commitMsg. It returns the message and files of
+// each commit. The messages end with an OperationTrailer shared by all the
+// commits.
+func SplitCommits(files []string, groups []CommitGroup, commitMsg string) (msgs []string, batches [][]string) {
+	defer func() {
+		trailer := fmt.Sprintf("\n\n%s: %s", OperationTrailer, uuid.NewUUID())
+		for i := range msgs {
+			msgs[i] += trailer
+		}
+	}()
+	assigned := make(map[string]bool)
+	for i := range groups {
+		var batch []string
+		for _, file := range files {
+			if !assigned[file] && groups[i].contains(file) {
+				batch = append(batch, file)
+				assigned[file] = true
+			}
+		}
+		if len(batch) > 0 {
+			msgs = append(msgs, groups[i].Message)
+			batches = append(batches, batch)
+		}
+	}
+	var rest []string
+	for _, file := range files {
+		if !assigned[file] {
+			rest = append(rest, file)
+		}
+	}
+	if len(rest) > 0 {
+		msgs = append(msgs, commitMsg)
+		batches = append(batches, rest)
+	}
+	return
+}
@@ -0,0 +1,47 @@
+package gitutils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitCommitsOperation(t *testing.T) {
+	groups := []CommitGroup{
+		{Paths: []string{"clusters/c1/workload/guestbook"}, Message: "add bundle guestbook to cluster c1"},
+		{Paths: []string{"clusters/c1/workload/redis"}, Message: "add bundle redis to cluster c1"},
+	}
+	msgs, batches := SplitCommits([]string{
+		"clusters/c1/mgmt/Chart.yaml",
+		"clusters/c1/workload/guestbook/cm.yaml",
+	}, groups, "add arlon manifests")
+	if len(msgs) != 2 || len(batches) != 2 {
+		t.Fatalf("expected 2 commits, got %v", batches)
+	}
+	id := Operation(msgs[0])
+	if id == "" || Operation(msgs[1]) != id {
+		t.Errorf("expected the commits to share an operation, got %q", msgs)
+	}
+	if !strings.HasPrefix(msgs[1], "add arlon manifests\n\n") {
+		t.Errorf("unexpected message %q", msgs[1])
+	}
+	next, _ := SplitCommits([]string{"README.md"}, nil, "update")
+	if Operation(next[0]) == id {
+		t.Error("expected each call to have its own operation")
+	}
+}
+
+func TestOperation(t *testing.T) {
+	for _, tc := range []struct {
+		msg      string
+		expected string
+	}{
+		{"update arlon manifests\n\nArlon-Operation: 1234\n", "1234"},
+		{"update\n\nbundle c1/guestbook: abcd\n\nArlon-Operation: 1234", "1234"},
+		{"fix typo", ""},
+		{"Arlon-Operation: 1234\n\nmentioned in the body", ""},
+	} {
+		if actual := Operation(tc.msg); actual != tc.expected {
+			t.Errorf("Operation(%q): expected %q, got %q", tc.msg, tc.expected, actual)
+		}
+	}
+}
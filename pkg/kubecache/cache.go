@@ -0,0 +1,185 @@
+package kubecache
+
+import (
+	"context"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corev1types "k8s.io/client-go/kubernetes/typed/core/v1"
+	listers "k8s.io/client-go/listers/core/v1"
+	"sort"
+	"strings"
+)
+
+// cachedTypes are the arlon-type labels of the Secrets and ConfigMaps that
+// deployments read. Other objects labeled managed-by=arlon, such as the
+// ArgoCD cluster secrets of registered clusters, aren't cached, so that their
+// credentials aren't held in memory.
+var cachedTypes = sets.NewString("config-bundle", "config-bundle-chunk", "profile", "clusterspec")
+
+// cachedSelector restricts the cache to the bundles, bundle chunks,
+// profiles and clusterspecs of arlon.
+var cachedSelector = "managed-by=arlon,arlon-type in (" + strings.Join(cachedTypes.List(), ",") + ")"
+
+// NewClient returns a client whose reads of arlon's Secrets and ConfigMaps
+// are served from shared informers watching them in all namespaces, instead
+// of being sent to the API server on every deployment. Gets of objects that
+// aren't cached, and lists that don't select managed-by=arlon and cached
+// arlon-types, go to kubeClient, as do writes and the requests for other resources. Since
+// informers are updated by watches, a read can briefly return an object's
+// previous version. The informers stop when ctx is done.
+func NewClient(ctx context.Context, kubeClient kubernetes.Interface) (kubernetes.Interface, error) {
+	factory := informers.NewSharedInformerFactoryWithOptions(kubeClient, 0,
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.LabelSelector = cachedSelector
+		}))
+	secrets := factory.Core().V1().Secrets()
+	configMaps := factory.Core().V1().ConfigMaps()
+	// informers are only started once requested
+	secrets.Informer()
+	configMaps.Informer()
+	factory.Start(ctx.Done())
+	for informerType, synced := range factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			return nil, fmt.Errorf("failed to sync the cache of %s", informerType)
+		}
+	}
+	return &client{
+		Interface: kubeClient,
+		coreV1: &coreV1{
+			CoreV1Interface: kubeClient.CoreV1(),
+			secrets:         secrets.Lister(),
+			configMaps:      configMaps.Lister(),
+		},
+	}, nil
+}
+
+type client struct {
+	kubernetes.Interface
+	coreV1 *coreV1
+}
+
+func (c *client) CoreV1() corev1types.CoreV1Interface {
+	return c.coreV1
+}
+
+type coreV1 struct {
+	corev1types.CoreV1Interface
+	secrets    listers.SecretLister
+	configMaps listers.ConfigMapLister
+}
+
+func (c *coreV1) Secrets(ns string) corev1types.SecretInterface {
+	return &secrets{SecretInterface: c.CoreV1Interface.Secrets(ns), lister: c.secrets.Secrets(ns)}
+}
+
+func (c *coreV1) ConfigMaps(ns string) corev1types.ConfigMapInterface {
+	return &configMaps{ConfigMapInterface: c.CoreV1Interface.ConfigMaps(ns), lister: c.configMaps.ConfigMaps(ns)}
+}
+
+// -----------------------------------------------------------------------------
+
+type secrets struct {
+	corev1types.SecretInterface
+	lister listers.SecretNamespaceLister
+}
+
+func (s *secrets) Get(ctx context.Context, name string, opts metav1.GetOptions) (*corev1.Secret, error) {
+	if opts.ResourceVersion == "" {
+		if secret, err := s.lister.Get(name); err == nil {
+			return secret.DeepCopy(), nil
+		}
+	}
+	return s.SecretInterface.Get(ctx, name, opts)
+}
+
+func (s *secrets) List(ctx context.Context, opts metav1.ListOptions) (*corev1.SecretList, error) {
+	selector, ok := cachedListSelector(opts)
+	if !ok {
+		return s.SecretInterface.List(ctx, opts)
+	}
+	items, err := s.lister.List(selector)
+	if err != nil {
+		return nil, err
+	}
+	list := &corev1.SecretList{}
+	for _, item := range items {
+		list.Items = append(list.Items, *item.DeepCopy())
+	}
+	// the API server lists objects by namespace and name
+	sort.Slice(list.Items, func(i, j int) bool {
+		a, b := list.Items[i], list.Items[j]
+		return a.Namespace < b.Namespace || (a.Namespace == b.Namespace && a.Name < b.Name)
+	})
+	return list, nil
+}
+
+type configMaps struct {
+	corev1types.ConfigMapInterface
+	lister listers.ConfigMapNamespaceLister
+}
+
+func (c *configMaps) Get(ctx context.Context, name string, opts metav1.GetOptions) (*corev1.ConfigMap, error) {
+	if opts.ResourceVersion == "" {
+		if cm, err := c.lister.Get(name); err == nil {
+			return cm.DeepCopy(), nil
+		}
+	}
+	return c.ConfigMapInterface.Get(ctx, name, opts)
+}
+
+func (c *configMaps) List(ctx context.Context, opts metav1.ListOptions) (*corev1.ConfigMapList, error) {
+	selector, ok := cachedListSelector(opts)
+	if !ok {
+		return c.ConfigMapInterface.List(ctx, opts)
+	}
+	items, err := c.lister.List(selector)
+	if err != nil {
+		return nil, err
+	}
+	list := &corev1.ConfigMapList{}
+	for _, item := range items {
+		list.Items = append(list.Items, *item.DeepCopy())
+	}
+	sort.Slice(list.Items, func(i, j int) bool {
+		a, b := list.Items[i], list.Items[j]
+		return a.Namespace < b.Namespace || (a.Namespace == b.Namespace && a.Name < b.Name)
+	})
+	return list, nil
+}
+
+// cachedListSelector returns the label selector of a list that the cache
+// can serve: one selecting managed-by=arlon and only cached arlon-types,
+// without paging or a field selector.
+func cachedListSelector(opts metav1.ListOptions) (labels.Selector, bool) {
+	if opts.FieldSelector != "" || opts.Limit != 0 || opts.Continue != "" || opts.ResourceVersion != "" {
+		return nil, false
+	}
+	selector, err := labels.Parse(opts.LabelSelector)
+	if err != nil {
+		return nil, false
+	}
+	requirements, _ := selector.Requirements()
+	managed, cachedType := false, false
+	for _, req := range requirements {
+		if req.Operator() != selection.Equals && req.Operator() != selection.DoubleEquals &&
+			req.Operator() != selection.In {
+			continue
+		}
+		switch req.Key() {
+		case "managed-by":
+			managed = managed || (req.Values().Len() == 1 && req.Values().Has("arlon"))
+		case "arlon-type":
+			cachedType = cachedType || cachedTypes.IsSuperset(req.Values())
+		}
+	}
+	if !managed || !cachedType {
+		return nil, false
+	}
+	return selector, true
+}
@@ -0,0 +1,63 @@
+package kubecache
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newSecret(name string, labels map[string]string) *corev1.Secret {
+	return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "arlon", Labels: labels}}
+}
+
+func TestCachedListSelector(t *testing.T) {
+	for _, tc := range []struct {
+		opts     metav1.ListOptions
+		expected bool
+	}{
+		{metav1.ListOptions{LabelSelector: "managed-by=arlon,arlon-type=config-bundle"}, true},
+		{metav1.ListOptions{LabelSelector: "managed-by==arlon,arlon-type in (profile,clusterspec)"}, true},
+		{metav1.ListOptions{LabelSelector: "managed-by=arlon,arlon-type=profile,tier=web"}, true},
+		{metav1.ListOptions{LabelSelector: "managed-by=arlon"}, false},
+		{metav1.ListOptions{LabelSelector: "arlon-type=profile"}, false},
+		{metav1.ListOptions{LabelSelector: "managed-by=arlon,arlon-type in (profile,cluster)"}, false},
+		{metav1.ListOptions{LabelSelector: "managed-by=arlon,arlon-type!=profile"}, false},
+		{metav1.ListOptions{LabelSelector: "managed-by=arlon,arlon-type=profile", Limit: 10}, false},
+	} {
+		if _, actual := cachedListSelector(tc.opts); actual != tc.expected {
+			t.Errorf("%+v: expected cached %t, got %t", tc.opts, tc.expected, actual)
+		}
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	kubeClient := fake.NewSimpleClientset(
+		newSecret("guestbook", map[string]string{"managed-by": "arlon", "arlon-type": "config-bundle"}),
+		newSecret("cluster-c1", map[string]string{"managed-by": "arlon", "argocd.argoproj.io/secret-type": "cluster"}),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cachedClient, err := NewClient(ctx, kubeClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lister := cachedClient.CoreV1().Secrets("arlon").(*secrets).lister
+	if _, err := lister.Get("guestbook"); err != nil {
+		t.Errorf("expected the bundle to be cached: %s", err)
+	}
+	if _, err := lister.Get("cluster-c1"); err == nil {
+		t.Error("expected the ArgoCD cluster secret not to be cached")
+	}
+	// objects that aren't cached are still read from the API server
+	if _, err := cachedClient.CoreV1().Secrets("arlon").Get(ctx, "cluster-c1", metav1.GetOptions{}); err != nil {
+		t.Errorf("failed to get the ArgoCD cluster secret: %s", err)
+	}
+	list, err := cachedClient.CoreV1().Secrets("arlon").List(ctx,
+		metav1.ListOptions{LabelSelector: "managed-by=arlon,arlon-type=config-bundle"})
+	if err != nil || len(list.Items) != 1 || list.Items[0].Name != "guestbook" {
+		t.Errorf("expected the cached bundle to be listed, got %v (%v)", list, err)
+	}
+}
@@ -0,0 +1,44 @@
+package kuberetry
+
+import (
+	"context"
+	"errors"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
+	"time"
+)
+
+// Backoff is the wait between the attempts of OnTransient: 200ms doubling
+// with 10% jitter, for 5 attempts in total, so that an API server briefly
+// unavailable or throttling requests is ridden out in about 3 seconds.
+var Backoff = wait.Backoff{
+	Steps:    5,
+	Duration: 200 * time.Millisecond,
+	Factor:   2,
+	Jitter:   0.1,
+}
+
+// IsTransient reports whether err is a failure of the API server, or of the
+// connection to it, that is expected to go away: timeouts, throttling, 5xx
+// statuses and refused or reset connections. Errors about the request
+// itself, such as NotFound, Forbidden or Invalid, aren't.
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	return apierr.IsServerTimeout(err) || apierr.IsTimeout(err) || apierr.IsTooManyRequests(err) ||
+		apierr.IsInternalError(err) || apierr.IsServiceUnavailable(err) ||
+		apierr.IsUnexpectedServerError(err) || utilnet.IsConnectionRefused(err) ||
+		utilnet.IsConnectionReset(err) || utilnet.IsProbableEOF(err)
+}
+
+// OnTransient calls fn until it succeeds, fails with an error that isn't
+// transient, ctx is done or the attempts of Backoff are used up, and returns
+// its last error.
+func OnTransient(ctx context.Context, fn func() error) error {
+	return retry.OnError(Backoff, func(err error) bool {
+		return ctx.Err() == nil && IsTransient(err)
+	}, fn)
+}
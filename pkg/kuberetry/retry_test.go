@@ -0,0 +1,84 @@
+package kuberetry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestIsTransient(t *testing.T) {
+	secrets := schema.GroupResource{Resource: "secrets"}
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+	for _, tc := range []struct {
+		err       error
+		transient bool
+	}{
+		{nil, false},
+		{apierr.NewServerTimeout(secrets, "get", 1), true},
+		{apierr.NewTimeoutError("request timed out", 1), true},
+		{apierr.NewTooManyRequests("throttled", 1), true},
+		{apierr.NewInternalError(errors.New("etcd unavailable")), true},
+		{apierr.NewServiceUnavailable("restarting"), true},
+		{fmt.Errorf("failed to get secret: %w", refused), true},
+		{io.ErrUnexpectedEOF, true},
+		{apierr.NewNotFound(secrets, "repo-creds"), false},
+		{apierr.NewForbidden(secrets, "repo-creds", errors.New("no RBAC")), false},
+		{apierr.NewBadRequest("invalid selector"), false},
+		{context.Canceled, false},
+		{fmt.Errorf("failed to get secret: %w", context.DeadlineExceeded), false},
+	} {
+		if transient := IsTransient(tc.err); transient != tc.transient {
+			t.Errorf("expected IsTransient(%v) to be %t", tc.err, tc.transient)
+		}
+	}
+}
+
+func TestOnTransient(t *testing.T) {
+	saved := Backoff
+	defer func() { Backoff = saved }()
+	Backoff.Duration = time.Millisecond
+
+	unavailable := apierr.NewServiceUnavailable("restarting")
+	for _, tc := range []struct {
+		name     string
+		errs     []error
+		attempts int
+	}{
+		{"ridden out", []error{unavailable, unavailable, nil}, 3},
+		{"not transient", []error{apierr.NewNotFound(schema.GroupResource{Resource: "secrets"}, "s")}, 1},
+		{"attempts used up", []error{unavailable, unavailable, unavailable, unavailable, unavailable, nil}, 5},
+	} {
+		attempts := 0
+		err := OnTransient(context.Background(), func() error {
+			err := tc.errs[attempts]
+			attempts++
+			return err
+		})
+		if attempts != tc.attempts {
+			t.Errorf("%s: expected %d attempts, got %d", tc.name, tc.attempts, attempts)
+		}
+		if expected := tc.errs[attempts-1]; err != expected {
+			t.Errorf("%s: expected the last error %v, got %v", tc.name, expected, err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	attempts := 0
+	err := OnTransient(ctx, func() error {
+		attempts++
+		cancel()
+		return unavailable
+	})
+	if attempts != 1 || err != unavailable {
+		t.Errorf("expected no retry once the context is done, got %d attempts (%v)", attempts, err)
+	}
+}
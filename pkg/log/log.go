@@ -1,10 +1,51 @@
 package log
 
 import (
+	"flag"
+	"fmt"
 	"github.com/go-logr/logr"
+	"os"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 )
 
+// Config selects the level, format and destination of the logs.
+type Config struct {
+	// Level is debug, info or error, or an integer verbosity where higher
+	// values enable more verbose logs
+	Level string
+	// Format is console or json
+	Format string
+	// File is the file logs are appended to, stderr if empty
+	File string
+}
+
 func GetLogger() logr.Logger {
 	return ctrl.Log
 }
+
+// Setup configures the logger returned by GetLogger. Registered secrets and
+// URL passwords are redacted from all logged messages and values.
+func Setup(config Config) error {
+	opts := zap.Options{
+		Development: true,
+	}
+	// reuse the parsing of zap's own flags for the level and encoder
+	fs := flag.NewFlagSet("log", flag.ContinueOnError)
+	opts.BindFlags(fs)
+	if err := fs.Set("zap-log-level", config.Level); err != nil {
+		return fmt.Errorf("invalid log level %s: %w", config.Level, err)
+	}
+	if err := fs.Set("zap-encoder", config.Format); err != nil {
+		return fmt.Errorf("invalid log format %s: %w", config.Format, err)
+	}
+	if config.File != "" {
+		f, err := os.OpenFile(config.File, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to open log file: %w", err)
+		}
+		opts.DestWriter = f
+	}
+	ctrl.SetLogger(&redactingLogger{zap.New(zap.UseFlagOptions(&opts))})
+	return nil
+}
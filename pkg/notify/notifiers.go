@@ -0,0 +1,76 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// defaultSlackTemplate renders the text of the default Slack message, which
+// is then encoded as the JSON payload.
+const defaultSlackTemplate = `arlon: {{.Type}} for cluster *{{.ClusterName}}*` +
+	`{{if .CommitSha}} (commit {{.CommitSha}}){{end}}: {{.Message}}`
+
+const defaultEmailTemplate = `Cluster: {{.ClusterName}}
+Event: {{.Type}}
+{{- if .CommitSha}}
+Commit: {{.CommitSha}}
+{{- end}}
+Time: {{.Time}}
+
+{{.Message}}
+`
+
+const httpTimeout = 10 * time.Second
+
+// webhookNotifier POSTs the payload to a URL, which covers both Slack
+// incoming webhooks and generic HTTP endpoints.
+type webhookNotifier struct {
+	url string
+}
+
+func (n *webhookNotifier) send(payload []byte, _ *Event) error {
+	client := http.Client{Timeout: httpTimeout}
+	resp, err := client.Post(n.url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to post notification: %w", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("notification endpoint returned %s", resp.Status)
+	}
+	return nil
+}
+
+type emailNotifier struct {
+	server   string
+	from     string
+	to       []string
+	username string
+	password string
+}
+
+func (n *emailNotifier) send(payload []byte, event *Event) error {
+	var auth smtp.Auth
+	if n.username != "" {
+		host, _, err := net.SplitHostPort(n.server)
+		if err != nil {
+			return fmt.Errorf("invalid smtp server %s: %w", n.server, err)
+		}
+		auth = smtp.PlainAuth("", n.username, n.password, host)
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", n.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(n.to, ", "))
+	fmt.Fprintf(&msg, "Subject: [arlon] %s: %s\r\n\r\n", event.Type, event.ClusterName)
+	msg.Write(payload)
+	err := smtp.SendMail(n.server, auth, n.from, n.to, msg.Bytes())
+	if err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+package notify
+
+import (
+	"arlon.io/arlon/pkg/log"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"gopkg.in/yaml.v2"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"text/template"
+	"time"
+)
+
+// ConfigMapName is the name of the ConfigMap in the arlon namespace that
+// configures notifications. Its "notifiers" key holds a YAML list of
+// NotifierConfig. Credentials are read from the Secret of the same name.
+const ConfigMapName = "arlon-notifications"
+
+type EventType string
+
+const (
+	EventClusterDeployed   EventType = "cluster-deployed"
+	EventDeployFailed      EventType = "deploy-failed"
+	EventClusterRenamed    EventType = "cluster-renamed"
+	EventClusterRolledBack EventType = "cluster-rolled-back"
+	EventClusterDeleted    EventType = "cluster-deleted"
+)
+
+// Event describes a significant arlon operation. It is the data passed to
+// notification templates.
+type Event struct {
+	Type        EventType `json:"type"`
+	ClusterName string    `json:"clusterName"`
+	CommitSha   string    `json:"commitSha,omitempty"`
+	Message     string    `json:"message"`
+	Time        time.Time `json:"time"`
+}
+
+type NotifierConfig struct {
+	Name string `yaml:"name"`
+	// Type is one of slack, webhook or email
+	Type string `yaml:"type"`
+	// Events restricts the notifier to these event types, or all if empty
+	Events []EventType `yaml:"events"`
+	// Template overrides the default payload (or email body) template. The
+	// json function encodes a value as JSON, for e.g. {"text": {{json .Message}}}
+	Template string `yaml:"template"`
+	// URL is the slack or generic webhook URL
+	URL string `yaml:"url"`
+	// SMTP settings for the email type; the password is read from the
+	// "<name>-password" key of the notifications Secret
+	SmtpServer string   `yaml:"smtpServer"`
+	From       string   `yaml:"from"`
+	To         []string `yaml:"to"`
+	Username   string   `yaml:"username"`
+}
+
+type notifier interface {
+	send(payload []byte, event *Event) error
+}
+
+type subscription struct {
+	config   NotifierConfig
+	tmpl     *template.Template
+	notifier notifier
+	// slackText is set when tmpl renders the text of a Slack message
+	// rather than its payload
+	slackText bool
+}
+
+// Dispatcher sends events to the configured notifiers. A nil Dispatcher
+// sends nothing.
+type Dispatcher struct {
+	subscriptions []subscription
+}
+
+// -----------------------------------------------------------------------------
+
+// LoadDispatcher reads the notification configuration from the arlon
+// namespace. A missing ConfigMap disables notifications.
+func LoadDispatcher(ctx context.Context, kubeClient kubernetes.Interface, arlonNs string) (*Dispatcher, error) {
+	corev1 := kubeClient.CoreV1()
+	cm, err := corev1.ConfigMaps(arlonNs).Get(ctx, ConfigMapName, metav1.GetOptions{})
+	if apierr.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get notifications configmap: %w", err)
+	}
+	var configs []NotifierConfig
+	err = yaml.Unmarshal([]byte(cm.Data["notifiers"]), &configs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse notifiers: %w", err)
+	}
+	var secretData map[string][]byte
+	secret, err := corev1.Secrets(arlonNs).Get(ctx, ConfigMapName, metav1.GetOptions{})
+	if err == nil {
+		secretData = secret.Data
+	} else if !apierr.IsNotFound(err) {
+		return nil, fmt.Errorf("failed to get notifications secret: %w", err)
+	}
+	d := &Dispatcher{}
+	for i, config := range configs {
+		if config.Name == "" {
+			config.Name = fmt.Sprintf("%s-%d", config.Type, i)
+		}
+		sub, err := newSubscription(config, secretData)
+		if err != nil {
+			return nil, fmt.Errorf("invalid notifier %s: %w", config.Name, err)
+		}
+		d.subscriptions = append(d.subscriptions, *sub)
+	}
+	return d, nil
+}
+
+func newSubscription(config NotifierConfig, secretData map[string][]byte) (*subscription, error) {
+	sub := subscription{config: config}
+	tmplText := config.Template
+	switch config.Type {
+	case "slack":
+		if tmplText == "" {
+			tmplText = defaultSlackTemplate
+			sub.slackText = true
+		}
+		sub.notifier = &webhookNotifier{url: config.URL}
+	case "webhook":
+		sub.notifier = &webhookNotifier{url: config.URL}
+	case "email":
+		if tmplText == "" {
+			tmplText = defaultEmailTemplate
+		}
+		sub.notifier = &emailNotifier{
+			server:   config.SmtpServer,
+			from:     config.From,
+			to:       config.To,
+			username: config.Username,
+			password: string(secretData[config.Name+"-password"]),
+		}
+	default:
+		return nil, fmt.Errorf("unknown notifier type %s", config.Type)
+	}
+	if config.Type != "email" && config.URL == "" {
+		return nil, fmt.Errorf("missing url")
+	}
+	if tmplText != "" {
+		tmpl, err := template.New(config.Name).Funcs(template.FuncMap{"json": jsonValue}).Parse(tmplText)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse template: %w", err)
+		}
+		sub.tmpl = tmpl
+	}
+	return &sub, nil
+}
+
+// -----------------------------------------------------------------------------
+
+// Notify sends the event to every notifier subscribed to its type. Secrets
+// are redacted from its message, which often carries an error. Failing to
+// notify doesn't fail the operation, so errors are only logged.
+func (d *Dispatcher) Notify(event Event) {
+	if d == nil {
+		return
+	}
+	event.Message = log.Redact(event.Message)
+	log := log.GetLogger()
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	for _, sub := range d.subscriptions {
+		if !sub.subscribed(event.Type) {
+			continue
+		}
+		payload, err := sub.render(&event)
+		if err == nil {
+			err = sub.notifier.send(payload, &event)
+		}
+		if err != nil {
+			log.Error(err, "failed to send notification", "notifier", sub.config.Name,
+				"event", event.Type)
+		}
+	}
+}
+
+func (s *subscription) subscribed(eventType EventType) bool {
+	if len(s.config.Events) == 0 {
+		return true
+	}
+	for _, t := range s.config.Events {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// render produces the payload for an event. Without a template, the event
+// is encoded as JSON.
+func (s *subscription) render(event *Event) ([]byte, error) {
+	if s.tmpl == nil {
+		return json.Marshal(event)
+	}
+	var buf bytes.Buffer
+	if err := s.tmpl.Execute(&buf, event); err != nil {
+		return nil, fmt.Errorf("failed to render template: %w", err)
+	}
+	if s.slackText {
+		return json.Marshal(map[string]string{"text": buf.String()})
+	}
+	return buf.Bytes(), nil
+}
+
+// jsonValue encodes v as JSON, for templates to embed event fields, which
+// may hold quotes or newlines, in JSON payloads.
+func jsonValue(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,33 @@
+// Package progress reports the steps of long running operations, and the
+// progress of git transfers, to a writer carried by the context.
+package progress
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+type writerKey struct{}
+
+// WithWriter returns a context whose operations report progress to w.
+func WithWriter(ctx context.Context, w io.Writer) context.Context {
+	return context.WithValue(ctx, writerKey{}, w)
+}
+
+// Writer returns the writer progress is reported to, or nil if progress
+// reporting is disabled.
+func Writer(ctx context.Context) io.Writer {
+	w, _ := ctx.Value(writerKey{}).(io.Writer)
+	return w
+}
+
+// Step reports the start of a step of an operation.
+func Step(ctx context.Context, format string, args ...interface{}) {
+	w := Writer(ctx)
+	if w == nil {
+		return
+	}
+	fmt.Fprintf(w, "%s %s\n", time.Now().Format("15:04:05"), fmt.Sprintf(format, args...))
+}
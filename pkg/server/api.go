@@ -0,0 +1,110 @@
+// Package server exposes arlon operations over gRPC and a REST gateway.
+//
+// Messages are encoded as JSON on both transports, so the gRPC service needs
+// no generated code: clients must use the "json" codec, as Client does.
+package server
+
+import (
+	"arlon.io/arlon/pkg/fleet"
+	"encoding/json"
+)
+
+// ServiceName is the name of the gRPC service.
+const ServiceName = "arlon.v1.Arlon"
+
+// ListRequest lists the resources of a namespace, the arlon namespace if
+// empty, or of all namespaces.
+type ListRequest struct {
+	Namespace     string `json:"namespace,omitempty"`
+	AllNamespaces bool   `json:"allNamespaces,omitempty"`
+}
+
+type Bundle struct {
+	Namespace   string `json:"namespace"`
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Tags        string `json:"tags,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+type BundleList struct {
+	Items []Bundle `json:"items"`
+}
+
+type Profile struct {
+	Namespace string   `json:"namespace"`
+	Name      string   `json:"name"`
+	Bundles   []string `json:"bundles"`
+	// BundleSelector selects bundles of the profile's namespace by label
+	BundleSelector string `json:"bundleSelector,omitempty"`
+	Tags           string `json:"tags,omitempty"`
+	Description    string `json:"description,omitempty"`
+}
+
+type ProfileList struct {
+	Items []Profile `json:"items"`
+}
+
+type ClusterSpec struct {
+	Namespace string            `json:"namespace"`
+	Name      string            `json:"name"`
+	Data      map[string]string `json:"data"`
+}
+
+type ClusterSpecList struct {
+	Items []ClusterSpec `json:"items"`
+}
+
+// DeployClusterRequest mirrors the options of arlon cluster deploy.
+type DeployClusterRequest struct {
+	Name         string `json:"name"`
+	RepoUrl      string `json:"repoUrl"`
+	RepoBranch   string `json:"repoBranch,omitempty"`
+	Path         string `json:"path,omitempty"`
+	Profile      string `json:"profile,omitempty"`
+	ClusterSpec  string `json:"clusterSpec,omitempty"`
+	CreateBranch string `json:"createBranch,omitempty"`
+	Project      string `json:"project,omitempty"`
+	// DestinationServer or DestinationSelector target the workload cluster
+	// by server URL instead of by name
+	DestinationServer   string `json:"destinationServer,omitempty"`
+	DestinationSelector string `json:"destinationSelector,omitempty"`
+	// Labels group the cluster for the selectors of fleet operations
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+type DeleteClusterRequest struct {
+	Name string `json:"name"`
+}
+
+// ClusterResult is the outcome of a cluster operation.
+type ClusterResult struct {
+	Name      string `json:"name"`
+	CommitSha string `json:"commitSha,omitempty"`
+}
+
+type FleetStatusRequest struct {
+	// Selector restricts the status to the clusters whose labels match it
+	Selector string `json:"selector,omitempty"`
+}
+
+type FleetStatus struct {
+	Clusters []fleet.ClusterStatus `json:"clusters"`
+}
+
+// -----------------------------------------------------------------------------
+
+// jsonCodec encodes gRPC messages as JSON.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
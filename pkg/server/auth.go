@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	authv1 "k8s.io/api/authentication/v1"
+	authzv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Authenticator authenticates API callers by their Kubernetes bearer token,
+// such as a service account token, with a TokenReview, and authorizes their
+// calls with SubjectAccessReviews.
+type Authenticator struct {
+	kubeClient kubernetes.Interface
+}
+
+func NewAuthenticator(kubeClient kubernetes.Interface) *Authenticator {
+	return &Authenticator{kubeClient: kubeClient}
+}
+
+// Authenticate returns the user the token belongs to.
+func (a *Authenticator) Authenticate(ctx context.Context, token string) (authv1.UserInfo, error) {
+	if token == "" {
+		return authv1.UserInfo{}, fmt.Errorf("missing bearer token")
+	}
+	review, err := a.kubeClient.AuthenticationV1().TokenReviews().Create(ctx,
+		&authv1.TokenReview{Spec: authv1.TokenReviewSpec{Token: token}}, metav1.CreateOptions{})
+	if err != nil {
+		return authv1.UserInfo{}, fmt.Errorf("failed to review token: %w", err)
+	}
+	if !review.Status.Authenticated {
+		if review.Status.Error != "" {
+			return authv1.UserInfo{}, fmt.Errorf("invalid token: %s", review.Status.Error)
+		}
+		return authv1.UserInfo{}, fmt.Errorf("invalid token")
+	}
+	return review.Status.User, nil
+}
+
+// ForbiddenError is returned when a user isn't allowed to call a method,
+// since Kubernetes RBAC doesn't grant them one of the accesses it needs.
+type ForbiddenError struct {
+	User   string
+	Access authzv1.ResourceAttributes
+}
+
+func (e *ForbiddenError) Error() string {
+	resource := e.Access.Resource
+	if e.Access.Group != "" {
+		resource += "." + e.Access.Group
+	}
+	if e.Access.Namespace == "" {
+		return fmt.Sprintf("user %s cannot %s %s in all namespaces", e.User, e.Access.Verb, resource)
+	}
+	return fmt.Sprintf("user %s cannot %s %s in namespace %s", e.User, e.Access.Verb, resource,
+		e.Access.Namespace)
+}
+
+// Authorize checks with a SubjectAccessReview that the user has each of the
+// accesses, failing with a ForbiddenError on the first one denied.
+func (a *Authenticator) Authorize(ctx context.Context, user authv1.UserInfo, accesses []authzv1.ResourceAttributes) error {
+	extra := make(map[string]authzv1.ExtraValue)
+	for key, value := range user.Extra {
+		extra[key] = authzv1.ExtraValue(value)
+	}
+	for i := range accesses {
+		review, err := a.kubeClient.AuthorizationV1().SubjectAccessReviews().Create(ctx,
+			&authzv1.SubjectAccessReview{Spec: authzv1.SubjectAccessReviewSpec{
+				ResourceAttributes: &accesses[i],
+				User:               user.Username,
+				Groups:             user.Groups,
+				UID:                user.UID,
+				Extra:              extra,
+			}}, metav1.CreateOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to review access: %w", err)
+		}
+		if !review.Status.Allowed {
+			return &ForbiddenError{User: user.Username, Access: accesses[i]}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// Client calls the operations of an arlon API server.
+type Client struct {
+	conn *grpc.ClientConn
+}
+
+// NewClient connects to the API server at addr, authenticating with a
+// Kubernetes bearer token. plaintext disables TLS, for e.g. port forwards.
+func NewClient(ctx context.Context, addr string, token string, plaintext bool) (*Client, error) {
+	opts := []grpc.DialOption{
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(jsonCodec{})),
+		grpc.WithPerRPCCredentials(tokenCredentials{token: token, secure: !plaintext}),
+	}
+	if plaintext {
+		opts = append(opts, grpc.WithInsecure())
+	} else {
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{})))
+	}
+	conn, err := grpc.DialContext(ctx, addr, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to arlon server %s: %w", addr, err)
+	}
+	return &Client{conn: conn}, nil
+}
+
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Client) invoke(ctx context.Context, method string, req interface{}, resp interface{}) error {
+	return c.conn.Invoke(ctx, "/"+ServiceName+"/"+method, req, resp)
+}
+
+func (c *Client) ListBundles(ctx context.Context, req *ListRequest) (*BundleList, error) {
+	resp := &BundleList{}
+	return resp, c.invoke(ctx, "ListBundles", req, resp)
+}
+
+func (c *Client) ListProfiles(ctx context.Context, req *ListRequest) (*ProfileList, error) {
+	resp := &ProfileList{}
+	return resp, c.invoke(ctx, "ListProfiles", req, resp)
+}
+
+func (c *Client) ListClusterSpecs(ctx context.Context, req *ListRequest) (*ClusterSpecList, error) {
+	resp := &ClusterSpecList{}
+	return resp, c.invoke(ctx, "ListClusterSpecs", req, resp)
+}
+
+func (c *Client) DeployCluster(ctx context.Context, req *DeployClusterRequest) (*ClusterResult, error) {
+	resp := &ClusterResult{}
+	return resp, c.invoke(ctx, "DeployCluster", req, resp)
+}
+
+func (c *Client) DeleteCluster(ctx context.Context, req *DeleteClusterRequest) (*ClusterResult, error) {
+	resp := &ClusterResult{}
+	return resp, c.invoke(ctx, "DeleteCluster", req, resp)
+}
+
+func (c *Client) GetFleetStatus(ctx context.Context, req *FleetStatusRequest) (*FleetStatus, error) {
+	resp := &FleetStatus{}
+	return resp, c.invoke(ctx, "GetFleetStatus", req, resp)
+}
+
+// tokenCredentials passes the bearer token with every call.
+type tokenCredentials struct {
+	token  string
+	secure bool
+}
+
+func (t tokenCredentials) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + t.token}, nil
+}
+
+func (t tokenCredentials) RequireTransportSecurity() bool {
+	return t.secure
+}
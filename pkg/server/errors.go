@@ -0,0 +1,78 @@
+package server
+
+import (
+	"arlon.io/arlon/pkg/cluster"
+	"arlon.io/arlon/pkg/gitutils"
+	"arlon.io/arlon/pkg/log"
+	"errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	"net/http"
+)
+
+// errInvalidRequest is wrapped by the errors of requests missing required
+// fields.
+var errInvalidRequest = errors.New("invalid request")
+
+// errorCode returns the gRPC code of an error of the service, so that
+// callers can tell missing resources and refused operations from failures.
+func errorCode(err error) codes.Code {
+	var forbidden *ForbiddenError
+	var inUse *cluster.InUseError
+	var windowClosed *cluster.SyncWindowClosedError
+	var violation *cluster.PolicyViolationError
+	// errors of the ArgoCD API server
+	var grpcErr interface{ GRPCStatus() *status.Status }
+	switch {
+	case errors.As(err, &forbidden), apierr.IsForbidden(err):
+		return codes.PermissionDenied
+	case errors.Is(err, errInvalidRequest), errors.Is(err, cluster.ErrBundleEmpty),
+		apierr.IsInvalid(err), apierr.IsBadRequest(err):
+		return codes.InvalidArgument
+	case errors.Is(err, cluster.ErrProfileNotFound), errors.Is(err, cluster.ErrRepoCredsNotFound),
+		errors.Is(err, gitutils.ErrBranchNotFound), apierr.IsNotFound(err):
+		return codes.NotFound
+	case apierr.IsAlreadyExists(err):
+		return codes.AlreadyExists
+	case errors.As(err, &inUse), errors.As(err, &windowClosed), errors.As(err, &violation):
+		return codes.FailedPrecondition
+	case errors.Is(err, cluster.ErrPushConflict), apierr.IsConflict(err):
+		return codes.Aborted
+	case errors.As(err, &grpcErr):
+		return grpcErr.GRPCStatus().Code()
+	}
+	return codes.Internal
+}
+
+// grpcError returns the gRPC status error of an error of the service, with
+// secrets redacted from its message.
+func grpcError(err error) error {
+	if _, ok := err.(interface{ GRPCStatus() *status.Status }); ok {
+		return err
+	}
+	return status.Error(errorCode(err), log.Redact(err.Error()))
+}
+
+// httpStatus returns the HTTP status of the REST gateway for a gRPC code.
+func httpStatus(code codes.Code) int {
+	switch code {
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.FailedPrecondition:
+		return http.StatusPreconditionFailed
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,202 @@
+package server
+
+import (
+	"arlon.io/arlon/pkg/cluster"
+	"arlon.io/arlon/pkg/log"
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	authzv1 "k8s.io/api/authorization/v1"
+	"strings"
+)
+
+// method is an operation of the service, shared by the gRPC server, the
+// REST gateway and the client.
+type method struct {
+	name       string
+	newRequest func() interface{}
+	// accesses are the Kubernetes accesses callers need to make the call
+	accesses func(s *Service, req interface{}) []authzv1.ResourceAttributes
+	call     func(s *Service, ctx context.Context, req interface{}) (interface{}, error)
+}
+
+// access is a verb on the resources of a namespace, or of all namespaces
+// if namespace is empty.
+func access(verb string, group string, resource string, namespace string) authzv1.ResourceAttributes {
+	return authzv1.ResourceAttributes{Verb: verb, Group: group, Resource: resource, Namespace: namespace}
+}
+
+const argoprojGroup = "argoproj.io"
+
+var methods = []method{
+	{
+		name:       "ListBundles",
+		newRequest: func() interface{} { return &ListRequest{} },
+		accesses: func(s *Service, req interface{}) []authzv1.ResourceAttributes {
+			return []authzv1.ResourceAttributes{access("list", "", "secrets", s.listNamespace(req.(*ListRequest)))}
+		},
+		call: func(s *Service, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.ListBundles(ctx, req.(*ListRequest))
+		},
+	},
+	{
+		name:       "ListProfiles",
+		newRequest: func() interface{} { return &ListRequest{} },
+		accesses: func(s *Service, req interface{}) []authzv1.ResourceAttributes {
+			return []authzv1.ResourceAttributes{access("list", "", "configmaps", s.listNamespace(req.(*ListRequest)))}
+		},
+		call: func(s *Service, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.ListProfiles(ctx, req.(*ListRequest))
+		},
+	},
+	{
+		name:       "ListClusterSpecs",
+		newRequest: func() interface{} { return &ListRequest{} },
+		accesses: func(s *Service, req interface{}) []authzv1.ResourceAttributes {
+			return []authzv1.ResourceAttributes{access("list", "", "configmaps", s.listNamespace(req.(*ListRequest)))}
+		},
+		call: func(s *Service, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.ListClusterSpecs(ctx, req.(*ListRequest))
+		},
+	},
+	{
+		name:       "DeployCluster",
+		newRequest: func() interface{} { return &DeployClusterRequest{} },
+		accesses: func(s *Service, req interface{}) []authzv1.ResourceAttributes {
+			// the profile, its bundles and the clusterspec are read in the
+			// namespaces they're referenced in
+			r := req.(*DeployClusterRequest)
+			var accesses []authzv1.ResourceAttributes
+			for _, ns := range s.refNamespaces(r.Profile, r.ClusterSpec) {
+				accesses = append(accesses,
+					access("get", "", "configmaps", ns),
+					access("get", "", "secrets", ns))
+			}
+			return append(accesses,
+				access("create", argoprojGroup, "applications", s.argocdNs),
+				access("update", argoprojGroup, "applications", s.argocdNs))
+		},
+		call: func(s *Service, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.DeployCluster(ctx, req.(*DeployClusterRequest))
+		},
+	},
+	{
+		name:       "DeleteCluster",
+		newRequest: func() interface{} { return &DeleteClusterRequest{} },
+		accesses: func(s *Service, req interface{}) []authzv1.ResourceAttributes {
+			return []authzv1.ResourceAttributes{access("delete", argoprojGroup, "applications", s.argocdNs)}
+		},
+		call: func(s *Service, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.DeleteCluster(ctx, req.(*DeleteClusterRequest))
+		},
+	},
+	{
+		name:       "GetFleetStatus",
+		newRequest: func() interface{} { return &FleetStatusRequest{} },
+		accesses: func(s *Service, req interface{}) []authzv1.ResourceAttributes {
+			return []authzv1.ResourceAttributes{access("list", argoprojGroup, "applications", s.argocdNs)}
+		},
+		call: func(s *Service, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.GetFleetStatus(ctx, req.(*FleetStatusRequest))
+		},
+	},
+}
+
+// serviceDesc describes the service to grpc in place of generated code.
+func serviceDesc() *grpc.ServiceDesc {
+	desc := &grpc.ServiceDesc{
+		ServiceName: ServiceName,
+		HandlerType: (*interface{})(nil),
+	}
+	for _, m := range methods {
+		m := m
+		desc.Methods = append(desc.Methods, grpc.MethodDesc{
+			MethodName: m.name,
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error,
+				interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				req := m.newRequest()
+				if err := dec(req); err != nil {
+					return nil, err
+				}
+				handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+					resp, err := m.call(srv.(*Service), ctx, req)
+					if err != nil {
+						return nil, grpcError(err)
+					}
+					return resp, nil
+				}
+				if interceptor == nil {
+					return handler(ctx, req)
+				}
+				info := &grpc.UnaryServerInfo{
+					Server:     srv,
+					FullMethod: "/" + ServiceName + "/" + m.name,
+				}
+				return interceptor(ctx, req, info, handler)
+			},
+		})
+	}
+	return desc
+}
+
+// NewGRPCServer returns a gRPC server serving the service to callers
+// authenticated and authorized by auth.
+func NewGRPCServer(service *Service, auth *Authenticator, opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts,
+		grpc.ForceServerCodec(jsonCodec{}),
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo,
+			handler grpc.UnaryHandler) (interface{}, error) {
+			var token string
+			md, _ := metadata.FromIncomingContext(ctx)
+			if values := md.Get("authorization"); len(values) > 0 {
+				token = strings.TrimPrefix(values[0], "Bearer ")
+			}
+			name := strings.TrimPrefix(info.FullMethod, "/"+ServiceName+"/")
+			for _, m := range methods {
+				if m.name == name {
+					ctx, cancel, err := authorize(ctx, service, auth, m, token, req)
+					if err != nil {
+						return nil, grpcError(err)
+					}
+					defer cancel()
+					return handler(ctx, req)
+				}
+			}
+			return nil, status.Errorf(codes.Unimplemented, "unknown method %s", info.FullMethod)
+		}),
+	)
+	server := grpc.NewServer(opts...)
+	server.RegisterService(serviceDesc(), service)
+	return server
+}
+
+// authorize authenticates the caller of a method by their token and checks
+// that they have the Kubernetes accesses the call needs, before it is made.
+// It returns the context to make the call with, bounded by the call timeout
+// of the service, and the function to cancel it with once the call is done.
+func authorize(
+	ctx context.Context,
+	service *Service,
+	auth *Authenticator,
+	m method,
+	token string,
+	req interface{},
+) (context.Context, context.CancelFunc, error) {
+	user, err := auth.Authenticate(ctx, token)
+	if err != nil {
+		return nil, nil, status.Error(codes.Unauthenticated, err.Error())
+	}
+	log.GetLogger().Info("api call", "method", m.name, "user", user.Username)
+	if err := auth.Authorize(ctx, user, m.accesses(service, req)); err != nil {
+		return nil, nil, err
+	}
+	ctx = cluster.WithActor(ctx, user.Username)
+	if service.callTimeout > 0 {
+		ctx, cancel := context.WithTimeout(ctx, service.callTimeout)
+		return ctx, cancel, nil
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	return ctx, cancel, nil
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"encoding/json"
+	"google.golang.org/grpc/status"
+	"net/http"
+	"strings"
+)
+
+// route maps a REST request to a method of the service. GET requests take
+// the namespace and allNamespaces list options from the query string, other
+// requests a JSON body; a trailing path element is the resource name.
+type route struct {
+	httpMethod string
+	path       string
+	method     string
+}
+
+var routes = []route{
+	{http.MethodGet, "/api/v1/bundles", "ListBundles"},
+	{http.MethodGet, "/api/v1/profiles", "ListProfiles"},
+	{http.MethodGet, "/api/v1/clusterspecs", "ListClusterSpecs"},
+	{http.MethodPost, "/api/v1/clusters", "DeployCluster"},
+	{http.MethodDelete, "/api/v1/clusters/", "DeleteCluster"},
+	{http.MethodGet, "/api/v1/fleet/status", "GetFleetStatus"},
+}
+
+// NewRESTHandler returns the REST gateway to the service, serving callers
+// authenticated and authorized by auth.
+func NewRESTHandler(service *Service, auth *Authenticator) http.Handler {
+	byName := make(map[string]method)
+	for _, m := range methods {
+		byName[m.name] = m
+	}
+	mux := http.NewServeMux()
+	for _, r := range routes {
+		r, m := r, byName[r.method]
+		mux.HandleFunc(r.path, func(w http.ResponseWriter, req *http.Request) {
+			if req.Method != r.httpMethod {
+				writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+				return
+			}
+			body := m.newRequest()
+			switch b := body.(type) {
+			case *ListRequest:
+				b.Namespace = req.URL.Query().Get("namespace")
+				b.AllNamespaces = req.URL.Query().Get("allNamespaces") == "true"
+			case *DeleteClusterRequest:
+				b.Name = strings.TrimPrefix(req.URL.Path, r.path)
+			case *FleetStatusRequest:
+				b.Selector = req.URL.Query().Get("selector")
+			default:
+				if req.Method == http.MethodPost {
+					if err := json.NewDecoder(req.Body).Decode(body); err != nil {
+						writeError(w, http.StatusBadRequest, err.Error())
+						return
+					}
+				}
+			}
+			token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+			ctx, cancel, err := authorize(req.Context(), service, auth, m, token, body)
+			if err != nil {
+				writeStatus(w, grpcError(err))
+				return
+			}
+			defer cancel()
+			resp, err := m.call(service, ctx, body)
+			if err != nil {
+				writeStatus(w, grpcError(err))
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(resp)
+		})
+	}
+	return mux
+}
+
+// writeStatus writes the error response for a gRPC status error.
+func writeStatus(w http.ResponseWriter, err error) {
+	st := status.Convert(err)
+	writeError(w, httpStatus(st.Code()), st.Message())
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
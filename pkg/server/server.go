@@ -0,0 +1,74 @@
+package server
+
+import (
+	"arlon.io/arlon/pkg/log"
+	"context"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Options of Run. The server uses TLS with TLSCertFile and TLSKeyFile. Since
+// callers send bearer tokens, it only serves plaintext if Insecure is set.
+// Each call is bounded by CallTimeout if it is not 0.
+type Options struct {
+	GRPCAddr    string
+	HTTPAddr    string
+	TLSCertFile string
+	TLSKeyFile  string
+	Insecure    bool
+	CallTimeout time.Duration
+}
+
+// Run serves the service over gRPC and REST until ctx is done.
+func Run(ctx context.Context, service *Service, auth *Authenticator, opts Options) error {
+	log := log.GetLogger()
+	useTLS := opts.TLSCertFile != "" && opts.TLSKeyFile != ""
+	if (opts.TLSCertFile != "") != (opts.TLSKeyFile != "") {
+		return fmt.Errorf("a TLS certificate needs both --tls-cert and --tls-key")
+	}
+	if !useTLS && !opts.Insecure {
+		return fmt.Errorf("refusing to serve bearer tokens in plaintext: " +
+			"give a TLS certificate with --tls-cert and --tls-key, or use --insecure")
+	}
+	service.callTimeout = opts.CallTimeout
+	var grpcOpts []grpc.ServerOption
+	if useTLS {
+		creds, err := credentials.NewServerTLSFromFile(opts.TLSCertFile, opts.TLSKeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load TLS certificate: %w", err)
+		}
+		grpcOpts = append(grpcOpts, grpc.Creds(creds))
+	}
+	grpcServer := NewGRPCServer(service, auth, grpcOpts...)
+	lis, err := net.Listen("tcp", opts.GRPCAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", opts.GRPCAddr, err)
+	}
+	httpServer := &http.Server{Addr: opts.HTTPAddr, Handler: NewRESTHandler(service, auth)}
+	errs := make(chan error, 2)
+	go func() {
+		log.Info("serving gRPC", "addr", opts.GRPCAddr)
+		errs <- grpcServer.Serve(lis)
+	}()
+	go func() {
+		log.Info("serving REST", "addr", opts.HTTPAddr)
+		if useTLS {
+			errs <- httpServer.ListenAndServeTLS(opts.TLSCertFile, opts.TLSKeyFile)
+		} else {
+			errs <- httpServer.ListenAndServe()
+		}
+	}()
+	select {
+	case <-ctx.Done():
+		grpcServer.GracefulStop()
+		return httpServer.Shutdown(context.Background())
+	case err := <-errs:
+		grpcServer.Stop()
+		_ = httpServer.Close()
+		return fmt.Errorf("server failed: %w", err)
+	}
+}
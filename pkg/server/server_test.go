@@ -0,0 +1,230 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"arlon.io/arlon/pkg/cluster"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	authv1 "k8s.io/api/authentication/v1"
+	authzv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const testToken = "token-of-alice"
+
+// newTestService returns a service whose only valid token is testToken,
+// for user alice, who may only list the secrets of the arlon namespace.
+func newTestService() (*Service, *Authenticator) {
+	kubeClient := fake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "guestbook",
+			Namespace: "arlon",
+			Labels:    map[string]string{"managed-by": "arlon", "arlon-type": "config-bundle", "bundle-type": "static"},
+		},
+	})
+	kubeClient.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authv1.TokenReview)
+		if review.Spec.Token == testToken {
+			review.Status = authv1.TokenReviewStatus{
+				Authenticated: true,
+				User:          authv1.UserInfo{Username: "alice", Groups: []string{"devs"}},
+			}
+		}
+		return true, review, nil
+	})
+	kubeClient.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authzv1.SubjectAccessReview)
+		attrs := review.Spec.ResourceAttributes
+		review.Status.Allowed = review.Spec.User == "alice" && attrs.Verb == "list" &&
+			attrs.Resource == "secrets" && attrs.Namespace == "arlon"
+		return true, review, nil
+	})
+	return NewService(kubeClient, nil, "argocd", "arlon"), NewAuthenticator(kubeClient)
+}
+
+func TestRESTAuthorization(t *testing.T) {
+	service, auth := newTestService()
+	server := httptest.NewServer(NewRESTHandler(service, auth))
+	defer server.Close()
+	for _, tc := range []struct {
+		path     string
+		token    string
+		expected int
+	}{
+		{"/api/v1/bundles", "", http.StatusUnauthorized},
+		{"/api/v1/bundles", "invalid", http.StatusUnauthorized},
+		{"/api/v1/bundles", testToken, http.StatusOK},
+		{"/api/v1/bundles?allNamespaces=true", testToken, http.StatusForbidden},
+		{"/api/v1/bundles?namespace=kube-system", testToken, http.StatusForbidden},
+		{"/api/v1/profiles", testToken, http.StatusForbidden},
+	} {
+		req, err := http.NewRequest(http.MethodGet, server.URL+tc.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tc.expected {
+			t.Errorf("GET %s with token %q: expected status %d, got %d", tc.path, tc.token, tc.expected,
+				resp.StatusCode)
+		}
+		if resp.StatusCode == http.StatusOK {
+			var list BundleList
+			if err := json.NewDecoder(resp.Body).Decode(&list); err != nil || len(list.Items) != 1 {
+				t.Errorf("expected the guestbook bundle, got %v (%v)", list, err)
+			}
+		}
+		resp.Body.Close()
+	}
+}
+
+func TestGRPCAuthorization(t *testing.T) {
+	service, auth := newTestService()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewGRPCServer(service, auth)
+	go func() { _ = server.Serve(lis) }()
+	defer server.Stop()
+	ctx := context.Background()
+	for _, tc := range []struct {
+		token    string
+		req      *ListRequest
+		expected codes.Code
+	}{
+		{testToken, &ListRequest{}, codes.OK},
+		{testToken, &ListRequest{AllNamespaces: true}, codes.PermissionDenied},
+		{"invalid", &ListRequest{}, codes.Unauthenticated},
+	} {
+		client, err := NewClient(ctx, lis.Addr().String(), tc.token, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		list, err := client.ListBundles(ctx, tc.req)
+		if status.Code(err) != tc.expected {
+			t.Errorf("ListBundles(%+v) with token %q: expected %s, got %v", tc.req, tc.token, tc.expected, err)
+		}
+		if err == nil && len(list.Items) != 1 {
+			t.Errorf("expected the guestbook bundle, got %v", list)
+		}
+		client.Close()
+	}
+}
+
+func TestDeployClusterAccesses(t *testing.T) {
+	service, _ := newTestService()
+	var deploy method
+	for _, m := range methods {
+		if m.name == "DeployCluster" {
+			deploy = m
+		}
+	}
+	for _, tc := range []struct {
+		req      *DeployClusterRequest
+		expected []string
+	}{
+		{&DeployClusterRequest{}, []string{"arlon"}},
+		{&DeployClusterRequest{Profile: "dev", ClusterSpec: "eks"}, []string{"arlon"}},
+		{&DeployClusterRequest{Profile: "team-a/dev", ClusterSpec: "eks"}, []string{"team-a", "arlon"}},
+		{&DeployClusterRequest{Profile: "team-a/dev", ClusterSpec: "team-b/eks"}, []string{"team-a", "team-b"}},
+	} {
+		var namespaces []string
+		for _, a := range deploy.accesses(service, tc.req) {
+			if a.Verb == "get" && a.Resource == "configmaps" {
+				namespaces = append(namespaces, a.Namespace)
+			}
+		}
+		if !reflect.DeepEqual(namespaces, tc.expected) {
+			t.Errorf("expected %+v to need access to namespaces %v, got %v", tc.req, tc.expected, namespaces)
+		}
+	}
+}
+
+func TestRunRequiresTLS(t *testing.T) {
+	service, auth := newTestService()
+	for _, tc := range []struct {
+		opts Options
+		err  string
+	}{
+		{Options{}, "refusing to serve bearer tokens in plaintext"},
+		{Options{TLSCertFile: "tls.crt"}, "needs both --tls-cert and --tls-key"},
+	} {
+		tc.opts.GRPCAddr, tc.opts.HTTPAddr = "127.0.0.1:0", "127.0.0.1:0"
+		err := Run(context.Background(), service, auth, tc.opts)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("expected the server not to start with %+v, got %v", tc.opts, err)
+		}
+	}
+}
+
+func TestCallTimeout(t *testing.T) {
+	service, auth := newTestService()
+	var list method
+	for _, m := range methods {
+		if m.name == "ListBundles" {
+			list = m
+		}
+	}
+	for _, timeout := range []time.Duration{0, time.Minute} {
+		service.callTimeout = timeout
+		ctx, cancel, err := authorize(context.Background(), service, auth, list, testToken, &ListRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		deadline, ok := ctx.Deadline()
+		if ok != (timeout > 0) || (ok && time.Until(deadline) > timeout) {
+			t.Errorf("expected the calls to be bounded by a timeout of %s, got deadline %v (%v)", timeout, deadline, ok)
+		}
+		cancel()
+		if ctx.Err() == nil {
+			t.Errorf("expected the context of the call to be cancelled once it is done")
+		}
+	}
+}
+
+func TestErrorCode(t *testing.T) {
+	gr := schema.GroupResource{Resource: "configmaps"}
+	for _, tc := range []struct {
+		err      error
+		expected codes.Code
+	}{
+		{fmt.Errorf("failed: %w", cluster.ErrProfileNotFound), codes.NotFound},
+		{fmt.Errorf("failed to get clusterspec: %w", apierr.NewNotFound(gr, "eks")), codes.NotFound},
+		{fmt.Errorf("failed to get root application: %w", status.Error(codes.NotFound, "not found")), codes.NotFound},
+		{&cluster.InUseError{Kind: "profile", Name: "dev", Clusters: []string{"c1"}}, codes.FailedPrecondition},
+		{fmt.Errorf("failed: %w", &cluster.PolicyViolationError{ClusterName: "c1"}), codes.FailedPrecondition},
+		{fmt.Errorf("failed: %w", &cluster.SyncWindowClosedError{ClusterName: "c1", Profile: "prod"}),
+			codes.FailedPrecondition},
+		{fmt.Errorf("failed to push: %w", cluster.ErrPushConflict), codes.Aborted},
+		{fmt.Errorf("%w: name and repoUrl are required", errInvalidRequest), codes.InvalidArgument},
+		{&ForbiddenError{User: "alice"}, codes.PermissionDenied},
+		{errors.New("boom"), codes.Internal},
+	} {
+		if actual := errorCode(tc.err); actual != tc.expected {
+			t.Errorf("%v: expected %s, got %s", tc.err, tc.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package server
+
+import (
+	"arlon.io/arlon/pkg/bundle"
+	"arlon.io/arlon/pkg/cluster"
+	"arlon.io/arlon/pkg/fleet"
+	"arlon.io/arlon/pkg/gitutils"
+	"arlon.io/arlon/pkg/notify"
+	"context"
+	"fmt"
+	"github.com/argoproj/argo-cd/v2/pkg/apiclient"
+	"github.com/argoproj/argo-cd/v2/util/io"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"strings"
+	"time"
+)
+
+// Service implements the arlon operations served by the API server. They
+// run with the server's own Kubernetes and ArgoCD credentials, once the
+// caller is authorized to make them.
+type Service struct {
+	kubeClient   kubernetes.Interface
+	argocdClient apiclient.Client
+	argocdNs     string
+	arlonNs      string
+	callTimeout  time.Duration
+}
+
+func NewService(
+	kubeClient kubernetes.Interface,
+	argocdClient apiclient.Client,
+	argocdNs string,
+	arlonNs string,
+) *Service {
+	return &Service{
+		kubeClient:   kubeClient,
+		argocdClient: argocdClient,
+		argocdNs:     argocdNs,
+		arlonNs:      arlonNs,
+	}
+}
+
+func (s *Service) listNamespace(req *ListRequest) string {
+	if req.AllNamespaces {
+		return metav1.NamespaceAll
+	}
+	if req.Namespace != "" {
+		return req.Namespace
+	}
+	return s.arlonNs
+}
+
+// refNamespaces returns the namespaces of references to bundles, profiles
+// or clusterspecs, in order and without duplicates. Unqualified references,
+// and missing ones, are in the arlon namespace.
+func (s *Service) refNamespaces(refs ...string) []string {
+	var namespaces []string
+	seen := make(map[string]bool)
+	for _, ref := range refs {
+		ns, _ := bundle.ParseRef(ref, s.arlonNs)
+		if !seen[ns] {
+			seen[ns] = true
+			namespaces = append(namespaces, ns)
+		}
+	}
+	return namespaces
+}
+
+func (s *Service) ListBundles(ctx context.Context, req *ListRequest) (*BundleList, error) {
+	secrets, err := s.kubeClient.CoreV1().Secrets(s.listNamespace(req)).List(ctx, metav1.ListOptions{
+		LabelSelector: "managed-by=arlon,arlon-type=config-bundle",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list secrets: %w", err)
+	}
+	list := &BundleList{Items: []Bundle{}}
+	for _, secret := range secrets.Items {
+		list.Items = append(list.Items, Bundle{
+			Namespace:   secret.Namespace,
+			Name:        secret.Name,
+			Type:        secret.Labels["bundle-type"],
+			Tags:        string(secret.Data["tags"]),
+			Description: string(secret.Data["description"]),
+		})
+	}
+	return list, nil
+}
+
+func (s *Service) ListProfiles(ctx context.Context, req *ListRequest) (*ProfileList, error) {
+	configMaps, err := s.kubeClient.CoreV1().ConfigMaps(s.listNamespace(req)).List(ctx, metav1.ListOptions{
+		LabelSelector: "managed-by=arlon,arlon-type=profile",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list configMaps: %w", err)
+	}
+	list := &ProfileList{Items: []Profile{}}
+	for _, configMap := range configMaps.Items {
+		list.Items = append(list.Items, Profile{
+			Namespace:      configMap.Namespace,
+			Name:           configMap.Name,
+			Bundles:        strings.Split(configMap.Data["bundles"], ","),
+			BundleSelector: configMap.Data[bundle.BundleSelectorKey],
+			Tags:           configMap.Data["tags"],
+			Description:    configMap.Data["description"],
+		})
+	}
+	return list, nil
+}
+
+func (s *Service) ListClusterSpecs(ctx context.Context, req *ListRequest) (*ClusterSpecList, error) {
+	configMaps, err := s.kubeClient.CoreV1().ConfigMaps(s.listNamespace(req)).List(ctx, metav1.ListOptions{
+		LabelSelector: "managed-by=arlon,arlon-type=clusterspec",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list configMaps: %w", err)
+	}
+	list := &ClusterSpecList{Items: []ClusterSpec{}}
+	for _, configMap := range configMaps.Items {
+		list.Items = append(list.Items, ClusterSpec{
+			Namespace: configMap.Namespace,
+			Name:      configMap.Name,
+			Data:      configMap.Data,
+		})
+	}
+	return list, nil
+}
+
+// DeployCluster deploys a cluster like arlon cluster deploy.
+func (s *Service) DeployCluster(ctx context.Context, req *DeployClusterRequest) (*ClusterResult, error) {
+	if req.Name == "" || req.RepoUrl == "" {
+		return nil, fmt.Errorf("%w: name and repoUrl are required", errInvalidRequest)
+	}
+	if req.RepoBranch == "" {
+		req.RepoBranch = "main"
+	}
+	if req.Path == "" {
+		req.Path = "arlon"
+	}
+	notifier, err := notify.LoadDispatcher(ctx, s.kubeClient, s.arlonNs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load notification settings: %w", err)
+	}
+	destinationServer, err := cluster.ResolveDestinationServer(ctx, s.kubeClient.CoreV1(), s.argocdNs,
+		req.DestinationServer, req.DestinationSelector)
+	if err != nil {
+		return nil, err
+	}
+	rootApp, err := cluster.ConstructRootApp(ctx, s.kubeClient, s.argocdNs, s.arlonNs, req.Name,
+		req.RepoUrl, req.RepoBranch, req.Path, req.ClusterSpec, req.Profile, req.Project, destinationServer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct root app: %w", err)
+	}
+	if err := cluster.SetClusterLabels(rootApp, req.Labels); err != nil {
+		return nil, err
+	}
+	conn, appIf, err := s.argocdClient.NewApplicationClient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ArgoCD application client: %w", err)
+	}
+	defer io.Close(conn)
+	if err := cluster.CheckRootApp(ctx, appIf, rootApp); err != nil {
+		return nil, err
+	}
+	projConn, projIf, err := s.argocdClient.NewProjectClient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ArgoCD project client: %w", err)
+	}
+	defer io.Close(projConn)
+	if err := cluster.CheckProject(ctx, projIf, rootApp); err != nil {
+		return nil, err
+	}
+	repo := gitutils.NewRepo()
+	defer repo.Close()
+	commitSha, err := cluster.DeployToGit(ctx, s.kubeClient, repo, s.argocdNs, s.arlonNs,
+		req.Name, req.RepoUrl, req.RepoBranch, req.Path, req.Profile, req.ClusterSpec, req.CreateBranch)
+	if err == nil {
+		_, err = cluster.ApplyRootApp(ctx, s.kubeClient, appIf, rootApp, commitSha)
+	} else {
+		err = fmt.Errorf("failed to deploy git tree: %w", err)
+	}
+	if err != nil {
+		notifier.Notify(notify.Event{
+			Type:        notify.EventDeployFailed,
+			ClusterName: req.Name,
+			CommitSha:   commitSha,
+			Message:     err.Error(),
+		})
+		return nil, err
+	}
+	notifier.Notify(notify.Event{
+		Type:        notify.EventClusterDeployed,
+		ClusterName: req.Name,
+		CommitSha:   commitSha,
+		Message:     fmt.Sprintf("cluster deployed to %s", req.RepoUrl),
+	})
+	return &ClusterResult{Name: req.Name, CommitSha: commitSha}, nil
+}
+
+func (s *Service) DeleteCluster(ctx context.Context, req *DeleteClusterRequest) (*ClusterResult, error) {
+	notifier, err := notify.LoadDispatcher(ctx, s.kubeClient, s.arlonNs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load notification settings: %w", err)
+	}
+	conn, appIf, err := s.argocdClient.NewApplicationClient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ArgoCD application client: %w", err)
+	}
+	defer io.Close(conn)
+	repo := gitutils.NewRepo()
+	defer repo.Close()
+	commitSha, err := cluster.Delete(ctx, s.kubeClient, repo, appIf, s.argocdNs, s.arlonNs, req.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete cluster: %w", err)
+	}
+	notifier.Notify(notify.Event{
+		Type:        notify.EventClusterDeleted,
+		ClusterName: req.Name,
+		CommitSha:   commitSha,
+		Message:     fmt.Sprintf("cluster %s deleted", req.Name),
+	})
+	return &ClusterResult{Name: req.Name, CommitSha: commitSha}, nil
+}
+
+func (s *Service) GetFleetStatus(ctx context.Context, req *FleetStatusRequest) (*FleetStatus, error) {
+	appConn, appIf, err := s.argocdClient.NewApplicationClient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ArgoCD application client: %w", err)
+	}
+	defer io.Close(appConn)
+	clusterConn, clusterIf, err := s.argocdClient.NewClusterClient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create ArgoCD cluster client: %w", err)
+	}
+	defer io.Close(clusterConn)
+	statuses, err := fleet.GetStatus(ctx, s.kubeClient, appIf, clusterIf, req.Selector)
+	if err != nil {
+		return nil, err
+	}
+	return &FleetStatus{Clusters: statuses}, nil
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestServiceLists(t *testing.T) {
+	arlonLabels := func(arlonType string) map[string]string {
+		return map[string]string{"managed-by": "arlon", "arlon-type": arlonType}
+	}
+	kubeClient := fake.NewSimpleClientset(
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "guestbook", Namespace: "arlon",
+				Labels: map[string]string{"managed-by": "arlon", "arlon-type": "config-bundle", "bundle-type": "static"}},
+			Data: map[string][]byte{"tags": []byte("demo"), "description": []byte("the guestbook app")},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "nginx", Namespace: "team-a",
+				Labels: map[string]string{"managed-by": "arlon", "arlon-type": "config-bundle", "bundle-type": "dynamic"}},
+		},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "unmanaged", Namespace: "arlon"}},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "dev", Namespace: "arlon", Labels: arlonLabels("profile")},
+			Data:       map[string]string{"bundles": "guestbook,team-a/nginx", "tags": "dev"},
+		},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "eks", Namespace: "arlon", Labels: arlonLabels("clusterspec")},
+			Data:       map[string]string{"region": "us-west-2"},
+		},
+	)
+	s := NewService(kubeClient, nil, "argocd", "arlon")
+	ctx := context.Background()
+
+	for _, tc := range []struct {
+		req      ListRequest
+		expected []Bundle
+	}{
+		{ListRequest{}, []Bundle{{Namespace: "arlon", Name: "guestbook", Type: "static", Tags: "demo",
+			Description: "the guestbook app"}}},
+		{ListRequest{Namespace: "team-a"}, []Bundle{{Namespace: "team-a", Name: "nginx", Type: "dynamic"}}},
+		{ListRequest{Namespace: "team-b"}, []Bundle{}},
+	} {
+		bundles, err := s.ListBundles(ctx, &tc.req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(bundles.Items, tc.expected) {
+			t.Errorf("expected bundles %+v for %+v, got %+v", tc.expected, tc.req, bundles.Items)
+		}
+	}
+	bundles, err := s.ListBundles(ctx, &ListRequest{AllNamespaces: true, Namespace: "team-a"})
+	if err != nil || len(bundles.Items) != 2 {
+		t.Errorf("expected the bundles of all namespaces, got %+v (%v)", bundles, err)
+	}
+
+	profiles, err := s.ListProfiles(ctx, &ListRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedProfiles := []Profile{{Namespace: "arlon", Name: "dev", Bundles: []string{"guestbook", "team-a/nginx"},
+		Tags: "dev"}}
+	if !reflect.DeepEqual(profiles.Items, expectedProfiles) {
+		t.Errorf("expected profiles %+v, got %+v", expectedProfiles, profiles.Items)
+	}
+
+	specs, err := s.ListClusterSpecs(ctx, &ListRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedSpecs := []ClusterSpec{{Namespace: "arlon", Name: "eks", Data: map[string]string{"region": "us-west-2"}}}
+	if !reflect.DeepEqual(specs.Items, expectedSpecs) {
+		t.Errorf("expected clusterspecs %+v, got %+v", expectedSpecs, specs.Items)
+	}
+}
+
+func TestDeployClusterInvalidRequest(t *testing.T) {
+	s := NewService(fake.NewSimpleClientset(), nil, "argocd", "arlon")
+	for _, req := range []DeployClusterRequest{
+		{RepoUrl: "https://git.example.com/fleet.git"},
+		{Name: "c1"},
+	} {
+		_, err := s.DeployCluster(context.Background(), &req)
+		if !errors.Is(err, errInvalidRequest) {
+			t.Errorf("expected request %+v to be invalid, got %v", req, err)
+		}
+	}
+}
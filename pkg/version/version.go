@@ -0,0 +1,5 @@
+package version
+
+// Version is the arlon version, set at build time with
+// -ldflags "-X arlon.io/arlon/pkg/version.Version=<version>"
+var Version = "dev"